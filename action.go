@@ -2,6 +2,7 @@ package main
 
 import (
 	"strings"
+	"time"
 
 	"github.com/osm/irc"
 )
@@ -9,6 +10,21 @@ import (
 // privmsgAction is a structure that IRC PRIVMSG messages can be parsed into
 // so that command handling becomes easier.
 type privmsgAction struct {
+	network *network
+
+	// account is the services account that the user is logged in to,
+	// it's empty when the account is unknown.
+	account string
+
+	// time is when the message was sent according to the server, or
+	// when it was received if the server doesn't support server-time.
+	time time.Time
+
+	// replyMode is how the replies to the action are sent and replies
+	// holds the replies while the command runs, see reply.go.
+	replyMode string
+	replies   *replyBuffer
+
 	args         []string
 	cmd          string
 	host         string
@@ -21,6 +37,7 @@ type privmsgAction struct {
 // joinAction is a structure that IRC JOIN messages can be parsed into so that
 // command handling becomes easier.
 type joinAction struct {
+	network      *network
 	channel      string
 	host         string
 	nick         string
@@ -34,12 +51,34 @@ type joinAction struct {
 // target will contain the message target, this can either be a channel or the
 // current name of the bot.  The first word in a message will be parsed into
 // the cmd property.  Remaining words will be placed within the args property.
-func (b *bot) parseAction(m *irc.Message) interface{} {
+func (b *bot) parseAction(n *network, m *irc.Message) interface{} {
 	switch m.Command {
 	case "PRIVMSG":
 		// A PRIVMSG should look similar to this:
 		// :the_nick!~bar@172.17.0.1 PRIVMSG #channel :foo bar baz
+		// If the target is one of our channels we'll use the name
+		// from the configuration so that the casing is consistent.
+		target := m.ParamsArray[0]
+		c := n.channel(target)
+		if c != nil {
+			target = c.Name
+		}
+
+		// The tags are stripped from the line before it's parsed, so
+		// we'll have to look them up in the tag store.
+		tags := n.tags.get(m)
+
 		return &privmsgAction{
+			// network is the network that the message was
+			// received on.
+			network: n,
+
+			// account is set from the account tag.
+			account: tags["account"],
+
+			// time is set from the server-time tag.
+			time: parseServerTime(tags["time"]),
+
 			// args will be set to [bar, baz] if the example is
 			// used.
 			args: m.ParamsArray[2:],
@@ -58,18 +97,26 @@ func (b *bot) parseAction(m *irc.Message) interface{} {
 			nick: m.Name,
 
 			// target can be either a channel or a nick.
-			target: m.ParamsArray[0],
+			target: target,
 
-			// validChannel will be true if channel is equal to
-			// the channel in the config.
-			validChannel: m.ParamsArray[0] == b.IRC.Channel,
+			// validChannel will be true if the target is one of
+			// the channels in the config.
+			validChannel: c != nil,
 		}
 	case "JOIN":
 		// A JOIN message should look similar to this:
 		// :the_nick!~bar@172.17.0.1 JOIN :#foo
 		ch := m.Raw[strings.LastIndex(m.Raw, "#"):]
+		c := n.channel(ch)
+		if c != nil {
+			ch = c.Name
+		}
 
 		return &joinAction{
+			// network is the network that the message was
+			// received on.
+			network: n,
+
 			// channel will be set to #foo, the initial ':' will
 			// be stripped.
 			channel: ch,
@@ -80,9 +127,9 @@ func (b *bot) parseAction(m *irc.Message) interface{} {
 			// nick will contain the_nick
 			nick: m.Name,
 
-			// validChannel will be true if channel is equal to
-			// the channel in the config.
-			validChannel: ch == b.IRC.Channel,
+			// validChannel will be true if the channel is one of
+			// the channels in the config.
+			validChannel: c != nil,
 		}
 	default:
 		return nil
@@ -98,3 +145,14 @@ func parseHost(m *irc.Message) string {
 	end := strings.Index(m.Raw, " ")
 	return m.Raw[start+1 : end]
 }
+
+// replyTarget returns the target that replies to the action should be sent
+// to, which is the channel or the nick if the message was sent as a private
+// message.
+func (a *privmsgAction) replyTarget() string {
+	if a.validChannel {
+		return a.target
+	}
+
+	return a.nick
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiTokenConfig holds the configuration of the API token command. The
+// tokens are stored in the database and are used to authenticate the
+// requests to the REST API.
+type apiTokenConfig struct {
+	APITokenCmd          string `json:"apiTokenCmd"`
+	APITokenSubCmdAdd    string `json:"apiTokenSubCmdAdd"`
+	APITokenSubCmdDelete string `json:"apiTokenSubCmdDelete"`
+	APITokenSubCmdList   string `json:"apiTokenSubCmdList"`
+
+	APITokenMsgAdd      string `json:"apiTokenMsgAdd"`
+	APITokenMsgToken    string `json:"apiTokenMsgToken"`
+	APITokenMsgDelete   string `json:"apiTokenMsgDelete"`
+	APITokenMsgList     string `json:"apiTokenMsgList"`
+	APITokenErrNotFound string `json:"apiTokenErrNotFound"`
+}
+
+// initAPITokenDefaults sets default values for the API token command and
+// messages.
+func (b *bot) initAPITokenDefaults() {
+	if b.IRC.APITokenCmd == "" {
+		b.IRC.APITokenCmd = "apitoken"
+	}
+	if b.IRC.APITokenSubCmdAdd == "" {
+		b.IRC.APITokenSubCmdAdd = "add"
+	}
+	if b.IRC.APITokenSubCmdDelete == "" {
+		b.IRC.APITokenSubCmdDelete = "delete"
+	}
+	if b.IRC.APITokenSubCmdList == "" {
+		b.IRC.APITokenSubCmdList = "list"
+	}
+	if b.IRC.APITokenMsgAdd == "" {
+		b.IRC.APITokenMsgAdd = "token <name> added (<id>), it was sent to <nick> as a private message"
+	}
+	if b.IRC.APITokenMsgToken == "" {
+		b.IRC.APITokenMsgToken = "the <name> token is <token>, it can't be shown again"
+	}
+	if b.IRC.APITokenMsgDelete == "" {
+		b.IRC.APITokenMsgDelete = "token deleted"
+	}
+	if b.IRC.APITokenMsgList == "" {
+		b.IRC.APITokenMsgList = "<id>: <name> added by <author> <timestamp>"
+	}
+	if b.IRC.APITokenErrNotFound == "" {
+		b.IRC.APITokenErrNotFound = "there is no token with id <id>"
+	}
+}
+
+// apiTokenCommand returns the API token command, which is used to manage
+// the tokens of the REST API.
+func (b *bot) apiTokenCommand() *botCommand {
+	return &botCommand{
+		name: b.IRC.APITokenCmd,
+		subCommands: []*botCommand{
+			{name: b.IRC.APITokenSubCmdAdd, usage: "<name>", minArgs: 1, maxArgs: 1, role: roleAdmin, fn: (*bot).apiTokenAdd},
+			{name: b.IRC.APITokenSubCmdDelete, usage: "<id>", minArgs: 1, maxArgs: 1, role: roleAdmin, fn: (*bot).apiTokenDelete},
+			{name: b.IRC.APITokenSubCmdList, role: roleAdmin, fn: (*bot).apiTokenList},
+		},
+	}
+}
+
+// newAPIToken returns a new random token.
+func newAPIToken() string {
+	token := make([]byte, 32)
+	io.ReadFull(rand.Reader, token)
+	return hex.EncodeToString(token)
+}
+
+// hashAPIToken returns the hash of the token, only the hashes are stored in
+// the database.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiTokenAdd creates a new token with the given name. The token is sent to
+// the user as a private message since it can't be retrieved later.
+func (b *bot) apiTokenAdd(a *privmsgAction) {
+	name := a.args[0]
+	token := newAPIToken()
+
+	stmt, err := b.prepare("INSERT INTO api_token (id, name, token_hash, author, inserted_at, is_deleted) VALUES($1, $2, $3, $4, $5, false)")
+	if err != nil {
+		b.log("apiToken").Errorf("apiTokenAdd: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer stmt.Close()
+
+	id := newUUID()
+	_, err = stmt.Exec(id, name, hashAPIToken(token), a.nick, newTimestamp())
+	if err != nil {
+		b.log("apiToken").Errorf("apiTokenAdd: %v", err)
+		b.replyDBError(a)
+		return
+	}
+
+	b.log("apiToken").Infof("apiTokenAdd: %s added the %s token (%s)", a.nick, name, id)
+	a.network.urgentph(a.nick, b.IRC.APITokenMsgToken, map[string]string{
+		"<name>":  name,
+		"<token>": token,
+	})
+	a.replyUrgentph(b.IRC.APITokenMsgAdd, map[string]string{
+		"<id>":   id,
+		"<name>": name,
+		"<nick>": a.nick,
+	})
+}
+
+// apiTokenDelete deletes the token with the given id.
+func (b *bot) apiTokenDelete(a *privmsgAction) {
+	id := a.args[0]
+
+	notFound := func() {
+		a.replyUrgentph(b.IRC.APITokenErrNotFound, map[string]string{
+			"<id>": id,
+		})
+	}
+
+	// We expect a valid UUID to be sent.
+	if !isUUID(id) {
+		notFound()
+		return
+	}
+
+	stmt, err := b.prepare("UPDATE api_token SET is_deleted = true WHERE id = $1 AND is_deleted = false")
+	if err != nil {
+		b.log("apiToken").Errorf("apiTokenDelete: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(id)
+	if err != nil {
+		b.log("apiToken").Errorf("apiTokenDelete: %v", err)
+		b.replyDBError(a)
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		notFound()
+		return
+	}
+
+	b.log("apiToken").Infof("apiTokenDelete: %s deleted token %s", a.nick, id)
+	a.replyUrgent(b.IRC.APITokenMsgDelete)
+}
+
+// apiTokenList lists the tokens, the tokens themselves can't be shown since
+// only their hashes are stored.
+func (b *bot) apiTokenList(a *privmsgAction) {
+	rows, err := b.query("SELECT id, name, author, inserted_at FROM api_token WHERE is_deleted = false ORDER BY inserted_at")
+	if err != nil {
+		b.log("apiToken").Errorf("apiTokenList: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, name, author string
+		var timestamp time.Time
+		rows.Scan(&id, &name, &author, dbTime{&timestamp})
+
+		a.replyUrgentph(b.IRC.APITokenMsgList, map[string]string{
+			"<id>":        id,
+			"<name>":      name,
+			"<author>":    author,
+			"<timestamp>": b.formatTime(timestamp),
+		})
+	}
+}
+
+// apiTokenName returns the name of the token that the request is
+// authenticated with, the token is given as a bearer token in the
+// Authorization header. False is returned if the token is missing or
+// unknown, and an error if the database can't be queried.
+func (b *bot) apiTokenName(r *http.Request) (string, bool, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", false, nil
+	}
+
+	var name string
+	err := b.queryRow("SELECT name FROM api_token WHERE token_hash = $1 AND is_deleted = false", hashAPIToken(token)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		return "", false, err
+	}
+
+	return name, true, nil
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"os"
+	"net"
+	"net/http"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/osm/irc"
 	"github.com/osm/jsonc"
 )
 
@@ -26,8 +26,44 @@ type bot struct {
 	// to function correctly.
 	mainWG sync.WaitGroup
 
-	// Logger for all bot related errors.
-	logger *log.Logger
+	// ctx is the root context of the bot, it is cancelled when the bot
+	// shuts down. The background work of the modules are derived from
+	// it.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// configMu is held for writing while the configuration is reloaded,
+	// the event handlers holds it for reading.
+	configMu sync.RWMutex
+
+	// stopCh is closed by stop when the bot should shut down, stopErr
+	// is the error that start returns.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	stopErr  error
+
+	// metrics holds the metrics that are exposed on the metrics route.
+	metrics *botMetrics
+
+	// health holds the state that is reported by the health checks.
+	health healthState
+
+	// echoLimits holds the rate limits of the echo clients.
+	echoLimits echoLimiter
+
+	// dialer opens the connections to the IRC servers, they are opened
+	// over TCP if it's nil. The tests use it to connect the bot to an
+	// in-memory server.
+	dialer func(n *network) (net.Conn, error)
+
+	// HTTPClient contains the settings of the HTTP client that is used
+	// by the integrations.
+	HTTPClient httpClientConfig `json:"httpClient"`
+
+	// Log contains the log settings, logger is the logger of the bot
+	// and the loggers of the modules are derived from it.
+	Log    logConfig `json:"log"`
+	logger *logger
 
 	// configFile keeps a copy of the configuration file path.
 	configFile string
@@ -37,6 +73,51 @@ type bot struct {
 	// things in the future.
 	cron *cron
 
+	// Modules contains the configuration sections of the modules, the
+	// key is the name of the module. The sections are decoded into the
+	// configuration of each module in config.
+	Modules map[string]json.RawMessage `json:"modules"`
+
+	// config contains the configuration section of each module, it's
+	// decoded from the modules section of the configuration file. The
+	// irc section is decoded into it as well, since that's where the
+	// settings of the modules were given before the modules section
+	// was added.
+	config struct {
+		updateNotifier  updateNotifierConfig
+		supernytt       supernyttConfig
+		quiz            quizConfig
+		lyssnar         lyssnarConfig
+		giphy           giphyConfig
+		tenor           tenorConfig
+		chattistik      chattistikConfig
+		logging         loggingConfig
+		cron            cronConfig
+		command         commandConfig
+		weather         weatherConfig
+		urlCheck        urlCheckConfig
+		urlMeta         urlMetaConfig
+		march           marchConfig
+		floodProt       floodProtConfig
+		factoid         factoidConfig
+		dictionary      dictionaryConfig
+		smhi            smhiConfig
+		parcelTracking  parcelTrackingConfig
+		week            weekConfig
+		googleSearch    googleSearchConfig
+		outgoingWebhook outgoingWebhookConfig
+		retention       retentionConfig
+	}
+
+	// modules holds the runtime state of the registered modules.
+	modules struct {
+		mu    sync.Mutex
+		state map[string]*moduleState
+
+		// wg keeps track of the background work of the modules.
+		wg sync.WaitGroup
+	}
+
 	// Timezone will contain the timezone which the bot is operating in.
 	Timezone string
 	timezone *time.Location
@@ -55,11 +136,24 @@ type bot struct {
 
 		// A generic error to display in case of database problems.
 		Err string `json:"err"`
+
+		// MigrationTimezone is the zone of the timestamps that were
+		// stored in the local time of the server, before they were
+		// stored in UTC. It defaults to the local zone of the server
+		// that runs the migration, which is where they were written.
+		MigrationTimezone string `json:"migrationTimezone"`
 	}
 
+	// store holds the typed stores of the database tables, it's set up by
+	// initDB.
+	store *stores
+
 	HTTP struct {
 		// Logger for all HTTP related things.
-		logger *log.Logger
+		logger *logger
+
+		// server is the running HTTP server.
+		server *http.Server
 
 		// Toggle the HTTP server on/off.
 		EnableHTTP bool `json:"enableHTTP"`
@@ -69,30 +163,82 @@ type bot struct {
 		Port    string `json:"port"`
 
 		// EchoRoute defines the echo route, which can be used to post
-		// message to the IRC channel.
-		EnableEcho bool   `json:"enableEcho"`
-		EchoRoute  string `json:"echoRoute"`
-		EchoMethod string `json:"echoMethod"`
+		// message to the IRC channel. The requests must be
+		// authenticated as one of the EchoClients, which are rate
+		// limited to EchoRateLimit lines per minute and EchoMaxLines
+		// lines per request unless the client has its own limits.
+		EnableEcho    bool          `json:"enableEcho"`
+		EchoRoute     string        `json:"echoRoute"`
+		EchoMethod    string        `json:"echoMethod"`
+		EchoClients   []*echoClient `json:"echoClients"`
+		EchoRateLimit int           `json:"echoRateLimit"`
+		EchoMaxLines  int           `json:"echoMaxLines"`
+
+		// ReloadRoute reloads the configuration file when it's
+		// requested with POST. The Authorization header must contain
+		// the ReloadToken as a bearer token, which is required.
+		EnableReload bool   `json:"enableReload"`
+		ReloadRoute  string `json:"reloadRoute"`
+		ReloadToken  string `json:"reloadToken"`
+
+		// MetricsRoute exposes the metrics of the bot in the
+		// Prometheus text format.
+		EnableMetrics bool   `json:"enableMetrics"`
+		MetricsRoute  string `json:"metricsRoute"`
+
+		// HealthRoute and ReadyRoute reports the state of the bot
+		// as JSON, the status code is 503 if the bot isn't healthy
+		// or ready.
+		EnableHealth bool   `json:"enableHealth"`
+		HealthRoute  string `json:"healthRoute"`
+		ReadyRoute   string `json:"readyRoute"`
+
+		// APIRoute is the prefix of the REST API, the requests must
+		// have an "Authorization: Bearer <token>" header with a
+		// token that has been added with the API token command.
+		EnableAPI bool   `json:"enableAPI"`
+		APIRoute  string `json:"apiRoute"`
+
+		// WebUIRoute serves a web UI for browsing the channel log
+		// and the chattistik rankings. The requests must be
+		// authenticated with the basic auth credentials of one of
+		// the WebUIUsers, which maps user names to passwords, or
+		// with one of the WebUITokens.
+		EnableWebUI bool              `json:"enableWebUI"`
+		WebUIRoute  string            `json:"webUIRoute"`
+		WebUIUsers  map[string]string `json:"webUIUsers"`
+		WebUITokens []string          `json:"webUITokens"`
+
+		// Webhooks are routes that receive the signed events of Git
+		// forges and CI and announces them, the messages defaults to
+		// the webhook messages.
+		EnableWebhooks bool       `json:"enableWebhooks"`
+		Webhooks       []*webhook `json:"webhooks"`
+		webhookConfig
 	}
 
 	IRC struct {
-		client *irc.Client
+		// Networks contains all networks that the bot should connect
+		// to. If it's empty a network is created from the connection
+		// settings below, which is how the bot was configured before
+		// multi network support was added.
+		Networks []*network `json:"networks"`
 
-		PostConnectMessages []struct {
-			Target  string `json:"target"`
-			Message string `json:"message"`
-		} `json:"postConnectMessages"`
+		// Network is the name of the network that is created from the
+		// connection settings below, it defaults to "default".
+		Network string `json:"network"`
 
-		PostConnectModes []string `json:"postConnectModes"`
+		PostConnectMessages []postConnectMessage `json:"postConnectMessages"`
+		PostConnectModes    []string             `json:"postConnectModes"`
 
-		// This is set to time.Now() each time a message is sent. We
-		// will check that time.Now() - lastSentMessage is greater
-		// than the value defined in "GracePeriod".
-		lastSentMessage time.Time
+		// IRCv3 capabilities and SASL settings of the network that
+		// is created from the connection settings below.
+		Capabilities []string   `json:"capabilities"`
+		SASL         saslConfig `json:"sasl"`
 
-		// lastSentMessageMu holds the mutex for the last sent
-		// message.
-		lastSentMessageMu sync.Mutex
+		// TLS settings of the network that is created from the
+		// connection settings below.
+		TLS tlsSettings `json:"tls"`
 
 		// Grace period is how long we should wait before we are
 		// allowed to send a new message to the server. The value is
@@ -104,137 +250,37 @@ type bot struct {
 		// application is started.
 		gracePeriod time.Duration
 
+		// SendBurst is how many messages that can be sent at once
+		// before the grace period kicks in. When more than SendBacklog
+		// messages are waiting to be sent, announcements that have
+		// waited longer than SendStaleAfter seconds are dropped.
+		SendBurst      int `json:"sendBurst"`
+		SendBacklog    int `json:"sendBacklog"`
+		SendStaleAfter int `json:"sendStaleAfter"`
+
 		// Rejoin the channel if the bot is kicked.
 		RejoinOnKick bool `json:"rejoinOnKick"`
 
-		// Connection related settings.
+		// Connection related settings. Nick, RealName and User are
+		// also used as defaults for the configured networks.
 		Address  string `json:"address"`
-		Channel  string `json:"channel"`
 		Nick     string `json:"nick"`
 		RealName string `json:"realName"`
 		User     string `json:"user"`
 		Version  string `json:"version"`
 
-		// names contains all the nicks in the channel.  The map is
-		// updated on JOIN and PART messages.
-		names map[string]bool
-
-		// namesMu holds the mutex for the names map.
-		namesMu sync.Mutex
-
-		// Update notifier.
-		EnableUpdateNotifier bool     `json:"enableUpdateNotifier"`
-		UpdateNotifierMsg    string   `json:"updateNotifierMsg"`
-		UpdateNotifierNames  []string `json:"updateNotifierNames"`
-
-		// Supernytt - news in Swedish from aftonbladet.
-		EnableSupernytt         bool   `json:"enableSupernytt"`
-		SupernyttGrammarMessage string `json:"supernyttGrammarMessage"`
-
-		// Quiz.
-		EnableQuiz       bool              `json:"enableQuiz"`
-		QuizSources      map[string]string `json:"quizSources"`
-		quizSourcesCache map[string][]QuizQuestion
-
-		QuizCmd         string `json:"quizCmd"`
-		QuizSubCmdStart string `json:"quizSubCmdStart"`
-		QuizSubCmdStop  string `json:"quizSubCmdStop"`
-		QuizSubCmdStats string `json:"quizSubCmdStats"`
-
-		QuizHintInterval time.Duration `json:"quizHintInterval"`
-
-		QuizMsgNameDoesNotExist string `json:"quizMsgNameDoesNotExist"`
-		QuizMsgLoadError        string `json:"quizMsgLoadError"`
-		QuizMsgAlreadyStarted   string `json:"quizMsgAlreadyStarted"`
-		QuizMsgQuestion         string `json:"quizMsgQuestion"`
-		QuizMsgHint             string `json:"quizMsgHint"`
-		QuizMsgAnswer           string `json:"quizMsgAnswer"`
-		QuizMsgCorrect          string `json:"quizMsgCorrect"`
-		QuizMsgQuizEnd          string `json:"quizMsgQuizEnd"`
-
-		quizRound *quizRound
-
-		EnableLyssnar                bool              `json:"enableLyssnar"`
-		LyssnarCmd                   string            `json:"lyssnarcmd"`
-		Lyssnare                     map[string]string `json:"lyssnare"`
-		LyssnarErr                   string            `json:"lyssnarErr"`
-		LyssnarErrUserNotConfigured  string            `json:"lyssnarErrUserNotConfigured"`
-		LyssnarMsg                   string            `json:"lyssnarMsg"`
-		LyssnarMsgUserIsNotListening string            `json:"lyssnarMsgUserIsNotListening"`
-
-		EnableGiphy          bool   `json:"enableGiphy"`
-		GiphyCmd             string `json:"giphyCmd"`
-		GiphyLang            string `json:"giphyLang"`
-		GiphyAPIKey          string `json:"giphyAPIKey"`
-		GiphyMsgNothingFound string `json:"giphyMsgNothingFound"`
-
-		EnableTenor          bool   `json:"enableTenor"`
-		TenorCmd             string `json:"tenorCmd"`
-		TenorLang            string `json:"tenorLang"`
-		TenorAPIKey          string `json:"tenorAPIKey"`
-		TenorMsgNothingFound string `json:"tenorMsgNothingFound"`
-
-		ChattistikCmd          string `json:"chattistikCmd"`
-		ChattistikCmdToday     string `json:"chattistikCmdToday"`
-		ChattistikCmdYesterday string `json:"chattistikCmdYesterday"`
-		ChattistikMsgNoStats   string `json:"chattistikMsgNoStats"`
-		EnableChattistik       bool   `json:"enableChattistik"`
-
-		EnableLogging bool `json:"enableLogging"`
-
-		SeenCmd         string `json:"seenCmd"`
-		SeenMsgFound    string `json:"seenMsgFound"`
-		SeenMsgNotFound string `json:"seenMsgNotFound"`
-
-		EnableCron bool `json:"enableCron"`
-
-		CronCmd            string `json:"cronCmd"`
-		CronSubCmdAdd      string `json:"cronSubCmdAdd"`
-		CronSubCmdAddLimit string `json:"cronSubCmdAddLimit"`
-		CronSubCmdDelete   string `json:"cronSubCmdDelete"`
-		CronSubCmdList     string `json:"cronSubCmdList"`
-		CronSubCmdUpdate   string `json:"cronSubCmdUpdate"`
-
-		CronErr       string `json:"cronErr"`
-		CronMsgAdd    string `json:"cronMsgAdd"`
-		CronMsgDelete string `json:"cronMsgDelete"`
-		CronMsgList   string `json:"cronMsgList"`
-		CronMsgUpdate string `json:"cronMsgUpdate"`
-
-		CronGrammarMsgExecCount string `json:"cronGrammarMsgExecCount"`
-		CronGrammarMsgExecLimit string `json:"cronGrammarMsgExecLimit"`
-		CronGrammarMsgIsLimited string `json:"cronGrammarMsgIsLimited"`
-		CronGrammarMsgRandomWho string `json:"cronGrammarMsgRandomWho"`
-		CronGrammarWeek         string `json:"cronGrammarWeek"`
-		CronGrammarGiphy        string `json:"cronGrammarGiphy"`
-		CronGrammarGiphySearch  string `json:"cronGrammarGiphySearch"`
-		CronGrammarTenorSearch  string `json:"cronGrammarTenorSearch"`
-
-		CommandErrExec string            `json:"commandErrExec"`
-		Commands       map[string]string `json:"commands"`
-		CommandsStatic map[string]string `json:"commandsStatic"`
-		EnableCommands bool              `json:"enableCommands"`
-		commands       map[string]command
-
-		EnableWeather bool   `json:"enableWeather"`
-		WeatherAPIKey string `json:"weatherAPIKey"`
-		WeatherCmd    string `json:"weatherCmd"`
-		WeatherErr    string `json:"weatherErr"`
-		WeatherMsg    string `json:"weatherMsg"`
-
-		EnableURLCheck bool   `json:"enableURLCheck"`
-		URLCheckMsg    string `json:"urlCheckMsg"`
-
-		EnableURLMeta     bool     `json:"enableURLMeta"`
-		URLMetaMsg        string   `json:"urlMetaMsg"`
-		URLMetaURLs       []string `json:"urlMetaURLs"`
-		URLMetaIgnoreURLs []string `json:"urlMetaIgnoreURLs"`
-
-		EnableMarch      bool     `json:"enableMarch"`
-		MarchURL         string   `json:"marchURL"`
-		MarchCredentials string   `json:"marchCredentials"`
-		MarchURLRegexps  []string `json:"marchURLRegexps"`
-		marchURLRegexps  []*regexp.Regexp
+		// QuitMessage is sent to the networks when the bot shuts
+		// down.
+		QuitMessage string `json:"quitMessage"`
+
+		// Channel is kept for backwards compatibility, it is merged
+		// into Channels when the bot is started.
+		Channel string `json:"channel"`
+
+		// Channels contains all channels the bot should join
+		// together with the features that are allowed in each of
+		// them.
+		Channels []channelConfig `json:"channels"`
 
 		operators []*regexp.Regexp
 		Operators []string `json:"operators"`
@@ -244,83 +290,28 @@ type bot struct {
 		ignorePerm  []*regexp.Regexp
 		Ignore      []string `json:"ignore"`
 
-		EnableFloodProt        bool   `json:"enableFloodProt"`
-		FloodProtTimeThreshold int    `json:"floodProtTimeThreshold"`
-		FloodProtCmdThreshold  int    `json:"floodProtCmdThreshold"`
-		FloodProtIgnoreTime    int    `json:"floodProtIgnoreTime"`
-		FloodProtMsgIgnore     string `json:"floodProtMsgIgnore"`
-		FloodProtMsgUnignore   string `json:"floodProtMsgUnignore"`
-
-		EnableFactoid bool `json:"enableFactoid"`
-		FactoidRate   int  `json:"factoidRate"`
-
-		FactoidCmd                string `json:"factoidCmd"`
-		FactoidSubCmdAdd          string `json:"factoidSubCmdAdd"`
-		FactoidSubCmdAddDelimiter string `json:"factoidSubCmdAddDelimiter"`
-		FactoidSubCmdDelete       string `json:"factoidSubCmdDelete"`
-		FactoidSubCmdSnoop        string `json:"factoidSubCmdSnoop"`
-		FactoidSubCmdSnoopAuthor  string `json:"factoidSubCmdSnoopAuthor"`
-		FactoidSubCmdSnoopReply   string `json:"factoidSubCmdSnoopReply"`
-		FactoidSubCmdCount        string `json:"factoidSubCmdCount"`
-
-		FactoidGrammarAction      string `json:"factoidGrammarAction"`
-		FactoidGrammarRandomWho   string `json:"factoidGrammarRandomWho"`
-		FactoidGrammarRandomWord  string `json:"factoidGrammarRandomWord"`
-		FactoidGrammarReply       string `json:"factoidGrammarReply"`
-		FactoidGrammarWho         string `json:"factoidGrammarWho"`
-		FactoidGrammarGiphy       string `json:"factoidGrammarGiphy"`
-		FactoidGrammarGiphySearch string `json:"factoidGrammarGiphySearch"`
-		FactoidGrammarTenorSearch string `json:"factoidGrammarTenorSearch"`
-
-		FactoidMsgAdd    string `json:"factoidMsgAdd"`
-		FactoidMsgDelete string `json:"factoidMsgDelete"`
-		FactoidMsgIs     string `json:"factoidMsgIs"`
-		FactoidMsgSnoop  string `json:"factoidMsgSnoop"`
-		FactoidMsgCount  string `json:"factoidMsgCount"`
-
 		PastebinAPIKey string `json:"pastebinApiKey"`
 		EnableDumpinen bool   `json:"enableDumpinen"`
 
-		Dictionaries []struct {
-			Trigger     string `json:"trigger"`
-			Dictionary  string `json:"dictionary"`
-			FoundMsg    string `json:"foundMsg"`
-			NotFoundMsg string `json:"notFoundMsg"`
-		} `json:"dictionaries"`
-
-		EnableSMHI            bool   `json:"enableSMHI"`
-		SMHILanguage          string `json:"smhiLanguage"`
-		SMHICmdWeather        string `json:"smhiCmdWeather"`
-		SMHIMsgWeatherError   string `json:"smhiMsgWeatherError"`
-		SMHIMsgWeather        string `json:"smhiMsgWeather"`
-		SMHIMsgWeatherFull    string `json:"smhiMsgWeatherFull"`
-		SMHIMsgSun            string `json:"smhiMsgSun"`
-		SMHIForecastLocations map[string]struct {
-			Alias     string  `json:"alias"`
-			Latitude  float64 `json:"latitude"`
-			Longitude float64 `json:"longitude"`
-		}
-
-		EnableParcelTracking               bool   `json:"enableParcelTracking"`
-		ParcelTrackingPostNordAPIKey       string `json:"parcelTrackingPostNordAPIKey"`
-		ParcelTrackingLocale               string `json:"parcelTrackingLocale"`
-		ParcelTrackingMsgInfo              string `json:"parcelTrackingMsgInfo"`
-		ParcelTrackingMsgAliasRemoved      string `json:"parcelTrackingMsgAliasRemoved"`
-		ParcelTrackingMsgAliasDoesNotExist string `json:"parcelTrackingMsgAliasDoesNotExist"`
-		ParcelTrackingErrNoData            string `json:"parcelTrackingErrNoData"`
-		ParcelTrackingErrDuplicateAlias    string `json:"parcelTrackingErrDuplicateAlias"`
-		ParcelTrackingCmd                  string `json:"parcelTrackingCmd"`
-		ParcelTrackingCmdAdd               string `json:"parcelTrackingCmdAdd"`
-		ParcelTrackingCmdRemove            string `json:"parcelTrackingCmdRemove"`
-		ParcelTrackingCmdInfo              string `json:"parcelTrackingCmdInfo"`
-		ParcelTrackingCmdFull              string `json:"parcelTrackingCmdFull"`
-		ParcelTrackingCmdList              string `json:"parcelTrackingCmdList"`
-
-		EnableWeek bool   `json:"enableWeek"`
-		WeekCmd    string `json:"weekCmd"`
-
-		EnableGoogleSearch bool   `json:"enableGoogleSearch"`
-		GoogleSearchCmd    string `json:"googleSearchCmd"`
+		// The module command is used to list, enable and disable
+		// modules from IRC.
+		ModuleCmd           string `json:"moduleCmd"`
+		ModuleSubCmdList    string `json:"moduleSubCmdList"`
+		ModuleSubCmdEnable  string `json:"moduleSubCmdEnable"`
+		ModuleSubCmdDisable string `json:"moduleSubCmdDisable"`
+		ModuleMsgList       string `json:"moduleMsgList"`
+		ModuleMsgEnabled    string `json:"moduleMsgEnabled"`
+		ModuleMsgDisabled   string `json:"moduleMsgDisabled"`
+		ModuleErrUnknown    string `json:"moduleErrUnknown"`
+
+		// The configuration of the command dispatcher, the roles
+		// that are required to use the commands and how they are
+		// replied to.
+		dispatcherConfig
+		roleConfig
+		apiTokenConfig
+		reloadConfig
+		replyConfig
 	}
 }
 
@@ -329,81 +320,130 @@ type bot struct {
 // configuration. If something goes wrong here it means that the config has
 // some incorrect value and the caller of this function should fail loudly.
 func newBotFromConfig(c string) (*bot, error) {
+	bot, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
+	bot.stopCh = make(chan struct{})
+
+	// Set up the configured networks, or the default network if the
+	// legacy connection settings are used.
+	bot.initNetworks()
+
+	// Initialize the loggers.
+	out, err := newLogOutput(bot.Log)
+	if err != nil {
+		return nil, fmt.Errorf("error: can't open log, %v", err)
+	}
+	bot.logger = newLogger(out)
+	bot.HTTP.logger = bot.log("http")
+
+	bot.metrics = newBotMetrics()
+
+	return bot, nil
+}
+
+// loadConfig reads and decodes the configuration file from the given path
+// into a new bot structure. The values that are derived from the
+// configuration, such as the operator regexps, are set up as well. The
+// returned bot isn't started, it's also used when the configuration is
+// reloaded.
+func loadConfig(c string) (*bot, error) {
 	file, err := ioutil.ReadFile(c)
 	if err != nil {
 		return nil, fmt.Errorf("error: can't open config, %v", err)
 	}
 
+	data := jsonc.ToJSON(string(file))
 	bot := bot{}
-	decoder := json.NewDecoder(strings.NewReader(jsonc.ToJSON(string(file))))
+	decoder := json.NewDecoder(strings.NewReader(data))
 	if err = decoder.Decode(&bot); err != nil {
 		return nil, fmt.Errorf("error: can't decode config, %v", err)
 	}
 
-	// Make sure there's a DB error present.
-	if bot.DB.Err == "" {
-		bot.DB.Err = "a database error occured, contact admin"
+	bot.configFile = c
+
+	// Decode the configuration of each module.
+	if err = bot.decodeModulesConfig([]byte(data)); err != nil {
+		return nil, err
 	}
 
-	bot.configFile = c
+	if err = bot.initConfig(); err != nil {
+		return nil, err
+	}
+
+	return &bot, nil
+}
+
+// initConfig sets up the values that are derived from the configuration, all
+// problems that are found are returned at once.
+func (b *bot) initConfig() error {
+	var errs configErrors
+
+	// Make sure there's a DB error present.
+	if b.DB.Err == "" {
+		b.DB.Err = "a database error occured, contact admin"
+	}
 
 	// Always default to Europe/Stockholm.
-	if bot.Timezone == "" {
-		bot.Timezone = "Europe/Stockholm"
+	if b.Timezone == "" {
+		b.Timezone = "Europe/Stockholm"
 	}
-	if bot.timezone, err = time.LoadLocation(bot.Timezone); err != nil {
-		return nil, fmt.Errorf("error: can't load timezone %s, %v", bot.Timezone, err)
+	var err error
+	if b.timezone, err = time.LoadLocation(b.Timezone); err != nil {
+		errs = append(errs, configError{"timezone", fmt.Sprintf("can't load timezone %s, %v", b.Timezone, err)})
+	}
+	if b.DB.MigrationTimezone != "" {
+		if _, err := time.LoadLocation(b.DB.MigrationTimezone); err != nil {
+			errs = append(errs, configError{"db.migrationTimezone", fmt.Sprintf("can't load timezone %s, %v", b.DB.MigrationTimezone, err)})
+		}
 	}
-
-	// Initialize the names map.
-	bot.IRC.names = make(map[string]bool)
 
 	// Convert the Operators array into a map so lookups will be
 	// efficient.
-	if len(bot.IRC.Operators) > 0 {
-		for _, o := range bot.IRC.Operators {
-			bot.IRC.operators = append(bot.IRC.operators, regexp.MustCompile(o))
+	for i, o := range b.IRC.Operators {
+		r, err := regexp.Compile(o)
+		if err != nil {
+			errs = append(errs, configError{fmt.Sprintf("irc.operators[%d]", i), err.Error()})
+			continue
 		}
+		b.IRC.operators = append(b.IRC.operators, r)
 	}
 
-	// Create a map for the dynamic ignores and if there are permanent
-	// ignores we'll add them as regexps to the ignorePerm array.
-	if bot.IRC.EnableFloodProt {
-		bot.IRC.ignoreDyn = make(map[string]bool)
-	}
-	if len(bot.IRC.Ignore) > 0 {
-		for _, r := range bot.IRC.Ignore {
-			bot.IRC.ignorePerm = append(bot.IRC.ignorePerm, regexp.MustCompile(r))
+	// If there are permanent ignores we'll add them as regexps to the
+	// ignorePerm array.
+	for i, o := range b.IRC.Ignore {
+		r, err := regexp.Compile(o)
+		if err != nil {
+			errs = append(errs, configError{fmt.Sprintf("irc.ignore[%d]", i), err.Error()})
+			continue
 		}
+		b.IRC.ignorePerm = append(b.IRC.ignorePerm, r)
 	}
 
+	// Parse the log levels.
+	errs = append(errs, b.initLogConfig()...)
+
+	// Create the HTTP client of the integrations.
+	errs = append(errs, b.initHTTPClient()...)
+
 	// Convert Commands and CommandsStatic to the internal command
 	// structure.
-	bot.IRC.commands = make(map[string]command)
-	if len(bot.IRC.Commands) > 0 {
-		for k, v := range bot.IRC.Commands {
-			bot.IRC.commands[k] = parseCommand(true, v)
+	b.config.command.commands = make(map[string]command)
+	if len(b.config.command.Commands) > 0 {
+		for k, v := range b.config.command.Commands {
+			b.config.command.commands[k] = parseCommand(true, v)
 		}
 	}
-	if len(bot.IRC.CommandsStatic) > 0 {
-		for k, v := range bot.IRC.CommandsStatic {
-			bot.IRC.commands[k] = parseCommand(false, v)
+	if len(b.config.command.CommandsStatic) > 0 {
+		for k, v := range b.config.command.CommandsStatic {
+			b.config.command.commands[k] = parseCommand(false, v)
 		}
 	}
 
-	// Set the lastSentMessage to time.Now().
-	bot.IRC.lastSentMessageMu.Lock()
-	defer bot.IRC.lastSentMessageMu.Unlock()
-	bot.IRC.lastSentMessage = time.Now()
-
-	// Convert the integer grace period to time.Duration.
-	bot.IRC.gracePeriod = time.Duration(bot.IRC.GracePeriod) * time.Millisecond
-
-	// Initialize the loggers.
-	bot.logger = log.New(os.Stdout, "BOT: ", log.LstdFlags)
-	bot.HTTP.logger = log.New(os.Stdout, "HTTP: ", log.LstdFlags)
-
-	return &bot, nil
+	return errs.err()
 }
 
 // start fires up a new instance of the bot, it connects to the IRC server and
@@ -421,13 +461,13 @@ func (b *bot) start() error {
 		return err
 	}
 
-	// Initialize all the cron related objects and start processing of the
-	// existing cron jobs.
+	// Initialize all the cron related objects, the existing cron jobs
+	// are started by the cron module.
 	b.cron = newCron()
-	b.initCron()
 
-	// Calculate how many wait groups to wait for.
-	wgs := 1
+	// Calculate how many wait groups to wait for, there's one for each
+	// network.
+	wgs := len(b.IRC.Networks)
 	if b.HTTP.EnableHTTP {
 		wgs = wgs + 1
 	}
@@ -435,14 +475,26 @@ func (b *bot) start() error {
 	// The HTTP and IRC main loops runs in a goroutine So we'll add gw to
 	// our wait group and wait until it completes.
 	b.mainWG.Add(wgs)
-	b.initIRC()
+	b.initHTTPDefaults()
+	if err := b.initIRC(); err != nil {
+		b.DB.client.Close()
+		return err
+	}
 
 	// Start the HTTP server.
 	if b.HTTP.EnableHTTP {
 		b.initHTTP()
 	}
 
+	// Shut down the bot on SIGINT and SIGTERM.
+	go b.handleSignals()
+	go b.shutdown()
+
 	b.mainWG.Wait()
 
-	return nil
+	if err := b.DB.client.Close(); err != nil {
+		b.logger.Errorf("start: %v", err)
+	}
+
+	return b.stopErr
 }
@@ -0,0 +1,64 @@
+package main
+
+// Feature names that can be used to enable or disable functionality on a
+// per channel basis. The names are used in the features and
+// disabledFeatures lists of each channel in the configuration.
+const (
+	featureChattistik       = "chattistik"
+	featureCommands         = "commands"
+	featureCron             = "cron"
+	featureDictionaries     = "dictionaries"
+	featureFactoid          = "factoid"
+	featureGiphy            = "giphy"
+	featureGoogleSearch     = "googleSearch"
+	featureLogging          = "logging"
+	featureLyssnar          = "lyssnar"
+	featureMarch            = "march"
+	featureOperators        = "operators"
+	featureOutgoingWebhooks = "outgoingWebhooks"
+	featureParcelTracking   = "parcelTracking"
+	featureQuiz             = "quiz"
+	featureRetention        = "retention"
+	featureSMHI             = "smhi"
+	featureSupernytt        = "supernytt"
+	featureTenor            = "tenor"
+	featureURLCheck         = "urlCheck"
+	featureURLMeta          = "urlMeta"
+	featureWeather          = "weather"
+	featureWeek             = "week"
+)
+
+// channelConfig holds the configuration of a single channel.
+type channelConfig struct {
+	// Name of the channel, including the leading #.
+	Name string `json:"name"`
+
+	// Features contains the features that are allowed in the channel.
+	// All globally enabled features are allowed if the list is empty.
+	Features []string `json:"features"`
+
+	// DisabledFeatures contains features that are disabled in the
+	// channel, it takes precedence over the Features list.
+	DisabledFeatures []string `json:"disabledFeatures"`
+}
+
+// hasFeature returns true if the feature is allowed in the channel.
+func (c *channelConfig) hasFeature(feature string) bool {
+	for _, f := range c.DisabledFeatures {
+		if f == feature {
+			return false
+		}
+	}
+
+	if len(c.Features) == 0 {
+		return true
+	}
+
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelHasFeature(t *testing.T) {
+	tests := []struct {
+		channel  channelConfig
+		feature  string
+		expected bool
+	}{
+		{channelConfig{}, featureQuiz, true},
+		{channelConfig{Features: []string{featureFactoid}}, featureFactoid, true},
+		{channelConfig{Features: []string{featureFactoid}}, featureQuiz, false},
+		{channelConfig{DisabledFeatures: []string{featureQuiz}}, featureQuiz, false},
+		{channelConfig{DisabledFeatures: []string{featureQuiz}}, featureFactoid, true},
+		{channelConfig{Features: []string{featureQuiz}, DisabledFeatures: []string{featureQuiz}}, featureQuiz, false},
+	}
+
+	for _, test := range tests {
+		if actual := test.channel.hasFeature(test.feature); actual != test.expected {
+			t.Errorf("%+v: expected %s to be %v, got %v", test.channel, test.feature, test.expected, actual)
+		}
+	}
+}
+
+func TestMultipleChannels(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"enableFactoid": true,
+		"enableLogging": true,
+		"channels": []map[string]interface{}{
+			{"name": "#bot"},
+			{"name": "#quiet", "disabledFeatures": []string{featureFactoid}},
+		},
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!factoid add coffee _is_ hot")
+	s.expect("PRIVMSG #bot :noted")
+
+	// The factoids are only replied to in the channels where they are
+	// enabled, and the reply goes to the channel that asked.
+	s.privmsg("bob", "#quiet", "coffee")
+	s.expectNo("PRIVMSG #quiet :", 200*time.Millisecond)
+	s.privmsg("bob", "#bot", "coffee")
+	s.expect("PRIVMSG #bot :coffee is hot")
+
+	// Channels that aren't configured are ignored.
+	s.privmsg("bob", "#other", "coffee")
+	s.expectNo("PRIVMSG #other :", 200*time.Millisecond)
+
+	// The log records the channel of each message.
+	tb.waitFor("the messages to be logged", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM log WHERE nick = 'bob'") == "2"
+	})
+	for _, channel := range []string{"#bot", "#quiet"} {
+		if n := tb.queryString("SELECT COUNT(*) FROM log WHERE nick = 'bob' AND network = 'test' AND channel = $1", channel); n != "1" {
+			t.Errorf("expected one message to be logged in %s, got %s", channel, n)
+		}
+	}
+}
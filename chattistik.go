@@ -5,93 +5,127 @@ import (
 	"regexp"
 	"sort"
 	"strings"
-	"time"
-
-	"github.com/osm/irc"
 )
 
+// chattistikConfig holds the configuration of the chattistik module.
+type chattistikConfig struct {
+	ChattistikCmd          string `json:"chattistikCmd"`
+	ChattistikCmdToday     string `json:"chattistikCmdToday"`
+	ChattistikCmdYesterday string `json:"chattistikCmdYesterday"`
+	ChattistikMsgNoStats   string `json:"chattistikMsgNoStats"`
+	EnableChattistik       bool   `json:"enableChattistik"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureChattistik,
+		config:  func(b *bot) interface{} { return &b.config.chattistik },
+		enabled: func(b *bot) bool { return b.config.chattistik.EnableChattistik },
+		init:    (*bot).initChattistikDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.chattistik.ChattistikCmd,
+				usage:   fmt.Sprintf("<%s|%s|yyyy-mm-dd|word>", b.config.chattistik.ChattistikCmdToday, b.config.chattistik.ChattistikCmdYesterday),
+				minArgs: 1,
+				maxArgs: 1,
+				feature: featureChattistik,
+				fn:      (*bot).chattistikCommand,
+			}}
+		},
+	})
+}
+
 // initChattistikDefaults sets default values for all settings.
 func (b *bot) initChattistikDefaults() {
-	if b.IRC.ChattistikCmd == "" {
-		b.IRC.ChattistikCmdToday = "!chattistik"
+	if b.config.chattistik.ChattistikCmd == "" {
+		b.config.chattistik.ChattistikCmd = "chattistik"
 	}
-	if b.IRC.ChattistikCmdToday == "" {
-		b.IRC.ChattistikCmdToday = "today"
+	if b.config.chattistik.ChattistikCmdToday == "" {
+		b.config.chattistik.ChattistikCmdToday = "today"
 	}
-	if b.IRC.ChattistikCmdYesterday == "" {
-		b.IRC.ChattistikCmdYesterday = "yesterday"
+	if b.config.chattistik.ChattistikCmdYesterday == "" {
+		b.config.chattistik.ChattistikCmdYesterday = "yesterday"
 	}
-	if b.IRC.ChattistikMsgNoStats == "" {
-		b.IRC.ChattistikMsgNoStats = "There are no stats for the date"
+	if b.config.chattistik.ChattistikMsgNoStats == "" {
+		b.config.chattistik.ChattistikMsgNoStats = "There are no stats for the date"
 	}
 }
 
 // chattistikDateRegexp defines a iso 8601 regexp.
 var chattistikDateRegexp = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}$")
 
-// chattistikHandler adds IRC chat statistic commands. The chattistik commands
-// will only be available when IRC logging has been enabled.  The bot is
-// written to support one and only one channel, so the channel information is
-// NOT stored in the database.
-func (b *bot) chattistikHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
-		return
+// chattistikCommand adds IRC chat statistic commands. The chattistik commands
+// will only be available when IRC logging has been enabled. The statistics
+// are calculated for the channel that the command was issued in.
+func (b *bot) chattistikCommand(a *privmsgAction) {
+	arg := a.args[0]
+	if arg == b.config.chattistik.ChattistikCmdToday {
+		b.chattistik(a, b.today(0), "")
+	} else if arg == b.config.chattistik.ChattistikCmdYesterday {
+		b.chattistik(a, b.today(-1), "")
+	} else if m := chattistikDateRegexp.FindStringSubmatch(arg); len(m) == 1 {
+		b.chattistik(a, arg, "")
+	} else {
+		b.chattistik(a, b.today(0), arg)
 	}
+}
 
-	if a.cmd != b.IRC.ChattistikCmd {
+// chattistik replies with the word count of each nick that has been active in
+// the channel of the action during the given date, if word is set only that
+// word is counted.
+func (b *bot) chattistik(a *privmsgAction, date, word string) {
+	ranking, err := b.chattistikRanking(a.network.Name, a.target, date, word)
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureChattistik).Errorf("chattistik: %v", err)
 		return
 	}
 
-	if len(a.args) != 1 {
+	if len(ranking) == 0 {
+		a.reply(b.config.chattistik.ChattistikMsgNoStats)
 		return
 	}
 
-	if b.shouldIgnore(m) {
-		return
+	for _, r := range ranking {
+		a.reply(fmt.Sprintf("%d: %s", r.Count, r.Nicks))
 	}
+}
 
-	arg := a.args[0]
-	if arg == b.IRC.ChattistikCmdToday {
-		b.chattistik(time.Now().Format("2006-01-02"), "")
-	} else if arg == b.IRC.ChattistikCmdYesterday {
-		b.chattistik(time.Now().AddDate(0, 0, -1).Format("2006-01-02"), "")
-	} else if m := chattistikDateRegexp.FindStringSubmatch(arg); len(m) == 1 {
-		b.chattistik(arg, "")
-	} else {
-		b.chattistik(time.Now().Format("2006-01-02"), arg)
-	}
+// chattistikRank is a place in the chattistik ranking, nicks contains all
+// nicks that has the same count.
+type chattistikRank struct {
+	Count int
+	Nicks string
 }
 
-// chattistik compiles a map of the nick and word count for all nicks that has
-// been active during the from and to date range.
-func (b *bot) chattistik(date, word string) {
-	query := "SELECT message, nick FROM log WHERE substr(timestamp, 0, 11) = $1"
-	if b.DB.Engine == "postgres" {
-		query = "SELECT message, nick FROM log WHERE substr(timestamp::text, 0, 11) = $1"
+// chattistikRanking compiles a map of the nick and word count for all nicks
+// that has been active in the channel during the given date, in the timezone
+// of the bot, and returns the counts in descending order.
+func (b *bot) chattistikRanking(network, channel, date, word string) ([]chattistikRank, error) {
+	from, to, err := dayRange(date, b.timezone)
+	if err != nil {
+		return nil, err
 	}
-	rows, err := b.query(query, date)
+
+	entries, err := b.store.log.between(network, channel, from, to)
 	if err != nil {
-		b.logger.Printf("chattistik: %v", err)
-		return
+		return nil, err
 	}
-	defer rows.Close()
 
 	// Count how many words there has been for each nick. We split on
 	// spaces, so each space separated string is considered to be a word.
 	stats := make(map[string]int)
-	for rows.Next() {
-		var msg, nick string
-		rows.Scan(&msg, &nick)
-
+	for _, e := range entries {
+		nick := e.Nick
 		if _, ok := stats[nick]; !ok {
 			stats[nick] = 0
 		}
 
 		if word == "" {
-			stats[nick] += len(strings.Split(msg, " "))
+			stats[nick] += len(strings.Split(e.Message, " "))
 		} else {
-			for _, w := range strings.Split(msg, " ") {
+			for _, w := range strings.Split(e.Message, " ") {
 				if strings.ToLower(w) == strings.ToLower(word) {
 					stats[nick] += 1
 				}
@@ -99,11 +133,6 @@ func (b *bot) chattistik(date, word string) {
 		}
 	}
 
-	if len(stats) == 0 {
-		b.privmsg(b.IRC.ChattistikMsgNoStats)
-		return
-	}
-
 	// Construct a stats hash where all the different casings of the nicks
 	// has been merged into one map.
 	statsHash := make(map[string]map[string]int)
@@ -155,7 +184,10 @@ func (b *bot) chattistik(date, word string) {
 	}
 	sort.Sort(sort.Reverse(sort.IntSlice(sortedKeys)))
 
+	var ranking []chattistikRank
 	for _, k := range sortedKeys {
-		b.privmsg(fmt.Sprintf("%d: %s", k, count[k]))
+		ranking = append(ranking, chattistikRank{k, count[k]})
 	}
+
+	return ranking, nil
 }
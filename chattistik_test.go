@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestChattistik(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"enableLogging":    true,
+		"enableChattistik": true,
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "coffee coffee coffee is good")
+	s.privmsg("bob", "#bot", "coffee coffee please")
+	tb.waitFor("the messages to be logged", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM log") == "2"
+	})
+
+	// The commands are logged as well, which is why they are sent by
+	// someone with fewer words than the others.
+	s.privmsg("carol", "#bot", "!chattistik today")
+	s.expect("PRIVMSG #bot :5: alice")
+	s.expect("PRIVMSG #bot :3: bob")
+
+	s.privmsg("carol", "#bot", "!chattistik coffee")
+	s.expect("PRIVMSG #bot :3: alice")
+	s.expect("PRIVMSG #bot :2: bob")
+
+	s.privmsg("carol", "#bot", "!chattistik 2001-01-01")
+	s.expect("PRIVMSG #bot :There are no stats for the date")
+}
@@ -4,10 +4,28 @@ import (
 	"os/exec"
 	"regexp"
 	"strings"
-
-	"github.com/osm/irc"
 )
 
+// commandConfig holds the configuration of the commands module.
+type commandConfig struct {
+	CommandErrExec string            `json:"commandErrExec"`
+	Commands       map[string]string `json:"commands"`
+	CommandsStatic map[string]string `json:"commandsStatic"`
+	EnableCommands bool              `json:"enableCommands"`
+	commands       map[string]command
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:     featureCommands,
+		config:   func(b *bot) interface{} { return &b.config.command },
+		enabled:  func(b *bot) bool { return b.config.command.EnableCommands },
+		init:     (*bot).initCommandDefaults,
+		commands: (*bot).execCommands,
+	})
+}
+
 // command holds the information needed to process a command.
 type command struct {
 	acceptArgs bool
@@ -33,8 +51,8 @@ func parseCommand(acceptArgs bool, data string) command {
 
 // initFactoidDefaults sets default values for all settings.
 func (b *bot) initCommandDefaults() {
-	if b.IRC.CommandErrExec == "" {
-		b.IRC.CommandErrExec = "command execution error"
+	if b.config.command.CommandErrExec == "" {
+		b.config.command.CommandErrExec = "command execution error"
 	}
 }
 
@@ -43,23 +61,36 @@ func (b *bot) initCommandDefaults() {
 // executed.
 var commandArgumentRegexp = regexp.MustCompile("^[a-zA-Z0-9åäöÅÄÖ0-9+*-\\/?=#_.\"' ]*$")
 
-// commandHandler exposes external commands that are defined in the Commands
-// secion of the configuration file. If the IRC message matches a key of the
-// defined command it executes it and returns the output to the user. If the
-// message was seen in a channel it will return it back to the channel,
-// otherwise it will be sent back to the user.
-func (b *bot) commandHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+// execCommands returns a command for each of the external commands that are
+// defined in the Commands and CommandsStatic sections of the configuration
+// file, the keys are used as the command names.
+func (b *bot) execCommands() []*botCommand {
+	var cmds []*botCommand
+
+	for name, c := range b.config.command.commands {
+		c := c
+		usage := ""
+		if c.acceptArgs {
+			usage = "[args]"
+		}
 
-	c, ok := b.IRC.commands[a.cmd]
-	if !ok {
-		return
+		cmds = append(cmds, &botCommand{
+			name:    name,
+			usage:   usage,
+			maxArgs: unlimitedArgs,
+			feature: featureCommands,
+			fn: func(b *bot, a *privmsgAction) {
+				b.execCommand(c, a)
+			},
+		})
 	}
 
-	if b.shouldIgnore(m) {
-		return
-	}
+	return cmds
+}
 
+// execCommand executes the external command and returns the output to the
+// user. Arguments are only passed on to commands that accepts them.
+func (b *bot) execCommand(c command, a *privmsgAction) {
 	if c.acceptArgs && len(a.args) > 0 && !commandArgumentRegexp.MatchString(strings.Join(a.args, " ")) {
 		return
 	}
@@ -74,8 +105,8 @@ func (b *bot) commandHandler(m *irc.Message) {
 	cmd := exec.Command(c.bin, args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		b.logger.Printf("commandHandler: %v", err)
-		b.privmsg(b.IRC.CommandErrExec)
+		b.log(featureCommands).Errorf("execCommand: %v", err)
+		a.replyUrgent(b.config.command.CommandErrExec)
 		return
 	}
 
@@ -88,6 +119,6 @@ func (b *bot) commandHandler(m *irc.Message) {
 			continue
 		}
 
-		b.privmsg(o)
+		a.reply(o)
 	}
 }
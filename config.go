@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/osm/jsonc"
+)
+
+// configError describes a problem with a setting in the configuration file,
+// path is the JSON path of the setting, e.g. irc.networks[0].address.
+type configError struct {
+	path   string
+	reason string
+}
+
+// configErrors contains all problems that were found in the configuration.
+type configErrors []configError
+
+// Error returns one line for each problem.
+func (e configErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, p := range e {
+		lines[i] = p.path + ": " + p.reason
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// err returns nil if there are no problems, which makes it possible to
+// return the problems as an error.
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// checkConfigFile loads the configuration file and returns all problems that
+// are found in it, nil is returned if the configuration is valid. In addition
+// to the checks that are done when the bot starts it reports unknown keys,
+// which are ignored by the JSON decoder, and values of the wrong type.
+func checkConfigFile(path string) error {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error: can't open config, %v", err)
+	}
+
+	// The comments are replaced by nothing but the newlines are kept, so
+	// the line numbers of the stripped JSON are the same as in the file.
+	data := jsonc.ToJSON(string(file))
+	var v interface{}
+	if err = json.Unmarshal([]byte(data), &v); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line := strings.Count(data[:serr.Offset], "\n") + 1
+			return configErrors{{fmt.Sprintf("line %d", line), serr.Error()}}
+		}
+		return err
+	}
+
+	var errs configErrors
+	obj, _ := v.(map[string]interface{})
+	errs = append(errs, checkIRCModulesJSON(obj)...)
+	errs = append(errs, checkJSON(v, reflect.TypeOf(bot{}), "")...)
+	errs = append(errs, checkModulesJSON(obj)...)
+	if len(errs) > 0 {
+		// The values of the wrong type makes the decoder fail, so
+		// there's no point in continuing.
+		return errs
+	}
+
+	// The configuration is decoded the same way as when the bot starts,
+	// but the problems of initConfig and checkConfig are reported
+	// together.
+	b := &bot{configFile: path}
+	if err = json.Unmarshal([]byte(data), b); err != nil {
+		return err
+	}
+	if err = b.decodeModulesConfig([]byte(data)); err != nil {
+		return err
+	}
+
+	var problems configErrors
+	if err = b.initConfig(); err != nil {
+		problems = append(problems, err.(configErrors)...)
+	}
+	b.initNetworks()
+	if err = b.checkConfig(); err != nil {
+		problems = append(problems, err.(configErrors)...)
+	}
+
+	return problems.err()
+}
+
+// checkIRCModulesJSON checks the settings of the modules that are given in
+// the irc section, which is where they were given before the modules had
+// sections of their own. The settings are removed from the irc section so
+// that the rest of it can be checked against the irc settings.
+func checkIRCModulesJSON(obj map[string]interface{}) configErrors {
+	var errs configErrors
+
+	irc, _ := reflect.TypeOf(bot{}).FieldByName("IRC")
+	ircFields := jsonFields(irc.Type)
+
+	for _, key := range sortedKeys(obj) {
+		if !strings.EqualFold(key, "irc") {
+			continue
+		}
+
+		section, ok := obj[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, name := range sortedKeys(section) {
+			if _, ok := findJSONField(ircFields, name); ok {
+				continue
+			}
+
+			for _, m := range modules {
+				if m.config == nil {
+					continue
+				}
+
+				t := reflect.TypeOf(m.config(&bot{})).Elem()
+				if f, ok := findJSONField(jsonFields(t), name); ok {
+					errs = append(errs, checkJSON(section[name], f.Type, key+"."+name)...)
+					delete(section, name)
+					break
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkModulesJSON checks the sections of the modules section against the
+// configuration of each module.
+func checkModulesJSON(obj map[string]interface{}) configErrors {
+	var errs configErrors
+
+	for _, key := range sortedKeys(obj) {
+		if !strings.EqualFold(key, "modules") {
+			continue
+		}
+
+		sections, ok := obj[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, name := range sortedKeys(sections) {
+			m := findModule(name)
+			if m == nil {
+				errs = append(errs, configError{key + "." + name, "unknown module"})
+				continue
+			}
+			if m.config == nil {
+				errs = append(errs, configError{key + "." + name, "the module has no configuration"})
+				continue
+			}
+
+			t := reflect.TypeOf(m.config(&bot{})).Elem()
+			errs = append(errs, checkJSON(sections[name], t, key+"."+name)...)
+		}
+	}
+
+	return errs
+}
+
+// rawMessageType is the type of json.RawMessage, the values are kept as they
+// are so anything is accepted.
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// checkJSON compares the decoded JSON value with the type that it's decoded
+// into. Keys that doesn't match a field and values of the wrong type are
+// returned as problems.
+func checkJSON(v interface{}, t reflect.Type, path string) configErrors {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// null is accepted for all types.
+	if v == nil || t == rawMessageType || t.Kind() == reflect.Interface {
+		return nil
+	}
+
+	var errs configErrors
+	wrongType := func(expected string) configErrors {
+		return configErrors{{path, "expected " + expected}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return wrongType("an object")
+		}
+
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			p := joinPath(path, key)
+
+			f, ok := findJSONField(fields, key)
+			if !ok {
+				errs = append(errs, configError{p, "unknown key"})
+				continue
+			}
+
+			errs = append(errs, checkJSON(obj[key], f.Type, p)...)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return wrongType("an object")
+		}
+
+		for _, key := range sortedKeys(obj) {
+			errs = append(errs, checkJSON(obj[key], t.Elem(), joinPath(path, key))...)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := v.([]interface{})
+		if !ok {
+			return wrongType("a list")
+		}
+
+		for i, e := range list {
+			errs = append(errs, checkJSON(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			return wrongType("a string")
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			return wrongType("true or false")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return wrongType("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			return wrongType("a number")
+		}
+	}
+
+	return errs
+}
+
+// jsonField is a field of a struct and the name that it has in JSON.
+type jsonField struct {
+	name string
+	reflect.StructField
+}
+
+// jsonFields returns the fields of the struct that the JSON decoder decodes
+// into, the fields of embedded structs are promoted. The index of each field
+// is relative to t, so it can be used with FieldByIndex.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for _, e := range jsonFields(f.Type) {
+				e.Index = append([]int{i}, e.Index...)
+				fields = append(fields, e)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, jsonField{name, f})
+	}
+
+	return fields
+}
+
+// findJSONField returns the field with the given JSON name, the names are
+// matched without regard to case just like the JSON decoder does.
+func findJSONField(fields []jsonField, name string) (jsonField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+
+	return jsonField{}, false
+}
+
+// joinPath appends the key to the JSON path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// sortedKeys returns the keys of the object in sorted order, so that the
+// problems are reported in the same order every time.
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// checkConfig validates the configuration and returns all problems that are
+// found. The configuration of the modules that are enabled are checked as
+// well.
+func (b *bot) checkConfig() error {
+	var errs configErrors
+	add := func(path, reason string) {
+		errs = append(errs, configError{path, reason})
+	}
+
+	// Make sure that all the below options are set in the configuration.
+	if b.IRC.Version == "" {
+		add("irc.version", "is missing")
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range b.IRC.Networks {
+		if n.Name == "" {
+			add(n.configPath+".name", "is missing")
+		} else if seen[n.Name] {
+			add(n.configPath+".name", fmt.Sprintf("%q is used by more than one network", n.Name))
+		}
+		seen[n.Name] = true
+		if n.Address == "" {
+			add(n.configPath+".address", "is missing")
+		}
+		if len(n.Channels) == 0 {
+			add(n.configPath+".channels", "is missing")
+		}
+		for i, c := range n.Channels {
+			if c.Name == "" {
+				add(fmt.Sprintf("%s.channels[%d].name", n.configPath, i), "is missing")
+			}
+		}
+		if n.Nick == "" {
+			add(n.configPath+".nick", "is missing")
+		}
+		if n.RealName == "" {
+			add(n.configPath+".realName", "is missing")
+		}
+		if n.User == "" {
+			add(n.configPath+".user", "is missing")
+		}
+		if n.TLS.Enabled && n.Address != "" {
+			if _, err := n.TLS.clientConfig(n.Address); err != nil {
+				add(n.configPath+".tls", err.Error())
+			}
+		}
+	}
+
+	for _, cmd := range sortedStringKeys(b.IRC.CommandRoles) {
+		if _, ok := roleLevels[b.IRC.CommandRoles[cmd]]; !ok {
+			add("irc.commandRoles."+cmd, fmt.Sprintf("%s is not a role", b.IRC.CommandRoles[cmd]))
+		}
+	}
+
+	errs = append(errs, b.checkReplyConfig()...)
+	errs = append(errs, b.checkEchoConfig()...)
+	errs = append(errs, b.checkWebhookConfig()...)
+
+	// The web UI exposes the whole channel log, so it can't be enabled
+	// without credentials.
+	if b.HTTP.EnableHTTP && b.HTTP.EnableWebUI && len(b.HTTP.WebUIUsers) == 0 && len(b.HTTP.WebUITokens) == 0 {
+		add("http.webUIUsers", "web UI requires webUIUsers or webUITokens")
+	}
+
+	// The reload route replaces the whole configuration, so it can't be
+	// enabled without a token.
+	if b.HTTP.EnableHTTP && b.HTTP.EnableReload && b.HTTP.ReloadToken == "" {
+		add("http.reloadToken", "reload route requires reloadToken")
+	}
+
+	// The same alias can't be used for more than one command. The
+	// prefix might not have been set to its default value yet. The
+	// commands are sorted so that the problems are reported in the same
+	// order each time.
+	prefix := b.IRC.CommandPrefix
+	if prefix == "" {
+		prefix = "!"
+	}
+	var commands []string
+	for name := range b.IRC.CommandAliases {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	aliases := make(map[string]string)
+	for _, command := range commands {
+		name := strings.TrimPrefix(command, prefix)
+		for _, alias := range b.IRC.CommandAliases[command] {
+			alias = strings.TrimPrefix(alias, prefix)
+			if c, ok := aliases[alias]; ok && c != name {
+				add("irc.commandAliases", fmt.Sprintf("%s is an alias of both %s and %s", alias, c, name))
+			}
+			aliases[alias] = name
+		}
+	}
+
+	// The problems of the modules are given relative to the section of
+	// the module.
+	for _, m := range modules {
+		if m.check == nil || !m.enabled(b) {
+			continue
+		}
+
+		err := m.check(b)
+		if merrs, ok := err.(configErrors); ok {
+			for _, e := range merrs {
+				add(b.modulePath(m.name, e.path), e.reason)
+			}
+		} else if err != nil {
+			add(b.modulePath(m.name, ""), err.Error())
+		}
+	}
+
+	return errs.err()
+}
+
+// sortedStringKeys returns the keys of the map in sorted order.
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// modulePath returns the JSON path of a setting of the module. The setting
+// is either given in the section of the module in the modules section or in
+// the irc section.
+func (b *bot) modulePath(name, key string) string {
+	if key == "" {
+		return "modules." + name
+	}
+
+	// The key might refer to an element of a list, such as
+	// dictionaries[0].dictionary, so we'll look for the first part.
+	base := key
+	if i := strings.IndexAny(base, ".["); i != -1 {
+		base = base[:i]
+	}
+
+	if raw, ok := b.Modules[name]; ok {
+		var section map[string]json.RawMessage
+		if json.Unmarshal(raw, &section) == nil {
+			for k := range section {
+				if strings.EqualFold(k, base) {
+					return "modules." + name + "." + key
+				}
+			}
+		}
+	}
+
+	return "irc." + key
+}
+
+// checkRegexps makes sure that the regexps compiles, the key of the map is
+// the name of the setting. Empty regexps are skipped since they are replaced
+// by the defaults.
+func checkRegexps(regexps map[string]string) error {
+	var errs configErrors
+	for _, name := range sortedStringKeys(regexps) {
+		if regexps[name] == "" {
+			continue
+		}
+		if _, err := regexp.Compile(regexps[name]); err != nil {
+			errs = append(errs, configError{name, err.Error()})
+		}
+	}
+
+	return errs.err()
+}
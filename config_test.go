@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testIRCSection returns an irc section with the required settings and
+// the given settings.
+func testIRCSection(settings map[string]interface{}) map[string]interface{} {
+	irc := map[string]interface{}{
+		"address":  "irc.example.com:6667",
+		"nick":     "bot",
+		"user":     "bot",
+		"realName": "bot",
+		"version":  "bot",
+		"channels": []map[string]interface{}{{"name": "#bot"}},
+	}
+	for k, v := range settings {
+		irc[k] = v
+	}
+	return irc
+}
+
+// writeTestConfig writes a minimal configuration, with the sections merged
+// into it, to bot.conf in dir and returns the path of it.
+func writeTestConfig(t *testing.T, dir string, sections map[string]interface{}) string {
+	t.Helper()
+
+	c := map[string]interface{}{
+		"db":  map[string]interface{}{"path": filepath.Join(dir, "bot.db")},
+		"log": map[string]interface{}{"file": filepath.Join(dir, "bot.log")},
+		"irc": testIRCSection(nil),
+	}
+	for k, v := range sections {
+		c[k] = v
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bot.conf")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkTestConfig returns the problems that checkConfigFile reports for the
+// configuration that writeTestConfig writes.
+func checkTestConfig(t *testing.T, sections map[string]interface{}) configErrors {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "bot-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = checkConfigFile(writeTestConfig(t, dir, sections))
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(configErrors)
+	if !ok {
+		t.Fatalf("expected configErrors, got %v", err)
+	}
+	return errs
+}
+
+// paths returns the paths of the problems.
+func (e configErrors) paths() []string {
+	var paths []string
+	for _, err := range e {
+		paths = append(paths, err.path)
+	}
+	return paths
+}
+
+func TestCheckConfigValid(t *testing.T) {
+	if errs := checkTestConfig(t, nil); errs != nil {
+		t.Errorf("expected no problems, got %v", errs)
+	}
+}
+
+func TestCheckConfigReloadToken(t *testing.T) {
+	errs := checkTestConfig(t, map[string]interface{}{
+		"http": map[string]interface{}{"enableHTTP": true, "enableReload": true},
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "http.reloadToken" {
+		t.Errorf("expected a problem with http.reloadToken, got %v", errs)
+	}
+
+	errs = checkTestConfig(t, map[string]interface{}{
+		"http": map[string]interface{}{"enableHTTP": true, "enableReload": true, "reloadToken": "secret"},
+	})
+	if errs != nil {
+		t.Errorf("expected no problems, got %v", errs)
+	}
+}
+
+func TestCheckConfigModulePath(t *testing.T) {
+	// The problems are reported at the section the setting is given in.
+	errs := checkTestConfig(t, map[string]interface{}{
+		"modules": map[string]interface{}{
+			"retention": map[string]interface{}{"enableRetention": true, "retentionLog": -1},
+		},
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "modules.retention.retentionLog" {
+		t.Errorf("expected a problem with modules.retention.retentionLog, got %v", errs)
+	}
+
+	errs = checkTestConfig(t, map[string]interface{}{
+		"irc": testIRCSection(map[string]interface{}{"enableRetention": true, "retentionURLCheck": -1}),
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "irc.retentionURLCheck" {
+		t.Errorf("expected a problem with irc.retentionURLCheck, got %v", errs)
+	}
+}
+
+func TestStartInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The bot doesn't exit when the configuration is invalid, start
+	// returns the problems so that main can report them.
+	b, err := newBotFromConfig(writeTestConfig(t, dir, map[string]interface{}{
+		"http": map[string]interface{}{"enableHTTP": true, "enableReload": true},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.start(); err == nil || !strings.Contains(err.Error(), "http.reloadToken") {
+		t.Errorf("expected start to fail with a problem with http.reloadToken, got %v", err)
+	}
+}
+
+func TestCheckConfigAliases(t *testing.T) {
+	sections := map[string]interface{}{
+		"irc": testIRCSection(map[string]interface{}{
+			"commandAliases": map[string][]string{
+				"weather":  {"w", "v"},
+				"factoid":  {"f", "w"},
+				"!lyssnar": {"l", "v", "f"},
+			},
+		}),
+	}
+
+	want := []string{
+		"f is an alias of both lyssnar and factoid",
+		"w is an alias of both factoid and weather",
+		"v is an alias of both lyssnar and weather",
+	}
+	for i := 0; i < 10; i++ {
+		var got []string
+		for _, e := range checkTestConfig(t, sections) {
+			got = append(got, e.reason)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestModuleConfigSections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The settings of the modules are still accepted in the irc
+	// section, but the modules section takes precedence.
+	b, err := loadConfig(writeTestConfig(t, dir, map[string]interface{}{
+		"irc": testIRCSection(map[string]interface{}{"enableWeek": true, "weekCmd": "v", "googleSearchCmd": "g"}),
+		"modules": map[string]interface{}{
+			"week": map[string]interface{}{"weekCmd": "week"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.config.week.EnableWeek || b.config.week.WeekCmd != "week" {
+		t.Errorf("expected the week module to be enabled with the week command, got %+v", b.config.week)
+	}
+	if b.config.googleSearch.GoogleSearchCmd != "g" {
+		t.Errorf("expected the google search command to be g, got %q", b.config.googleSearch.GoogleSearchCmd)
+	}
+
+	found := false
+	for _, s := range b.configSettings() {
+		if s.path == "modules.week.weekCmd" {
+			found = s.value.String() == "week"
+		}
+	}
+	if !found {
+		t.Error("expected the week command to be a setting of the week module")
+	}
+}
+
+func TestCheckConfigIRCModuleSettings(t *testing.T) {
+	errs := checkTestConfig(t, map[string]interface{}{
+		"irc": testIRCSection(map[string]interface{}{"weekCmd": 1, "enableWeek": true, "noSuchSetting": true}),
+	})
+
+	want := configErrors{
+		{"irc.weekCmd", "expected a string"},
+		{"irc.noSuchSetting", "unknown key"},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("expected %v, got %v", want, errs)
+	}
+}
+
+func TestCheckConfigOutgoingWebhookPath(t *testing.T) {
+	errs := checkTestConfig(t, map[string]interface{}{
+		"modules": map[string]interface{}{
+			"outgoingWebhooks": map[string]interface{}{
+				"enableOutgoingWebhooks": true,
+				"outgoingWebhooks": []map[string]interface{}{
+					{"name": "ci", "url": "ftp://example.com", "events": []string{"message"}},
+				},
+			},
+		},
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "modules.outgoingWebhooks.outgoingWebhooks[0].url" {
+		t.Errorf("expected a problem with modules.outgoingWebhooks.outgoingWebhooks[0].url, got %v", errs)
+	}
+}
@@ -34,7 +34,7 @@ type cron struct {
 }
 
 // add adds a new entry to the cron runner.
-func (c *cron) add(id, expression, message string, execCount, execLimit int, isLimited bool, bot *bot) error {
+func (c *cron) add(id string, n *network, channel, expression, message string, execCount, execLimit int, isLimited bool, bot *bot) error {
 	// Acquire a lock and release it when we return.
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -45,7 +45,7 @@ func (c *cron) add(id, expression, message string, execCount, execLimit int, isL
 	}
 
 	// Add it with the corresponding ID received by the cron lob.
-	cronJob := newCronJob(bot, id, message, execCount, execLimit, isLimited)
+	cronJob := newCronJob(bot, id, n, channel, message, execCount, execLimit, isLimited)
 	entryID, err := c.cron.AddJob(expression, cronJob)
 	if err != nil {
 		return err
@@ -70,10 +70,12 @@ func (c *cron) delete(id string) {
 }
 
 // newCronJob returns a new cron job.
-func newCronJob(bot *bot, id, message string, execCount, execLimit int, isLimited bool) *cronJob {
+func newCronJob(bot *bot, id string, n *network, channel, message string, execCount, execLimit int, isLimited bool) *cronJob {
 	return &cronJob{
 		bot:       bot,
 		id:        id,
+		network:   n,
+		channel:   channel,
 		message:   message,
 		execCount: execCount,
 		execLimit: execLimit,
@@ -89,6 +91,12 @@ type cronJob struct {
 	// id is the internal id of the bot (the database id).
 	id string
 
+	// network is the network that the message is sent to.
+	network *network
+
+	// channel is the channel that the message is sent to.
+	channel string
+
 	// message holds the message that will be sent back to the channel
 	// when the cron job is executed.
 	message string
@@ -111,47 +119,46 @@ type cronJob struct {
 
 // Run implements the Job interface.
 func (cj *cronJob) Run() {
+	cj.bot.metrics.cronRuns.inc(cj.network.Name)
+
+	// The job runs in a goroutine of the cron scheduler, so the
+	// configuration is locked for reading while the settings are used.
+	cj.bot.configMu.RLock()
+	defer cj.bot.configMu.RUnlock()
+
 	// Acquire a lock, increment the execution count and release it.
 	cj.mu.Lock()
 	cj.execCount = cj.execCount + 1
 	cj.mu.Unlock()
 
 	// Increment the exec_count in the database as well.
-	stmt, err := cj.bot.prepare("UPDATE cron SET exec_count = $1 WHERE id = $2")
-	if err != nil {
-		cj.bot.logger.Printf("cronJobRun: %v", err)
-		return
-	}
-	defer stmt.Close()
-
-	// Execute the UPDATE statement.
-	_, err = stmt.Exec(cj.execCount, cj.id)
-	if err != nil {
-		cj.bot.logger.Printf("cronJobRun: %v", err)
+	if err := cj.bot.store.cron.setExecCount(cj.id, cj.execCount); err != nil {
+		cj.bot.metrics.dbErrors.inc()
+		cj.bot.log(featureCron).Errorf("cronJobRun: %v", err)
 		return
 	}
 
 	message := cj.message
-	i := strings.Index(message, cj.bot.IRC.CronGrammarMsgRandomWho)
+	i := strings.Index(message, cj.bot.config.cron.CronGrammarMsgRandomWho)
 	for i != -1 {
-		message = message[0:i] + cj.bot.rndName() + message[i+len(cj.bot.IRC.CronGrammarMsgRandomWho):]
-		i = strings.Index(message, cj.bot.IRC.CronGrammarMsgRandomWho)
+		message = message[0:i] + cj.network.rndName(cj.channel) + message[i+len(cj.bot.config.cron.CronGrammarMsgRandomWho):]
+		i = strings.Index(message, cj.bot.config.cron.CronGrammarMsgRandomWho)
 	}
 
 	// Replace all occurences of <week> with the current week number.
-	i = strings.Index(message, cj.bot.IRC.CronGrammarWeek)
+	i = strings.Index(message, cj.bot.config.cron.CronGrammarWeek)
 	for i != -1 {
-		message = message[0:i] + getWeek("") + message[i+len(cj.bot.IRC.CronGrammarWeek):]
-		i = strings.Index(message, cj.bot.IRC.CronGrammarWeek)
+		message = message[0:i] + getWeek("") + message[i+len(cj.bot.config.cron.CronGrammarWeek):]
+		i = strings.Index(message, cj.bot.config.cron.CronGrammarWeek)
 	}
 
 	// Replace all occurences of <giphy> with a gif from giphy.
-	i = strings.Index(message, cj.bot.IRC.CronGrammarGiphy)
+	i = strings.Index(message, cj.bot.config.cron.CronGrammarGiphy)
 	for i != -1 {
 		if url, _ := cj.bot.giphyRandom(); url != "" {
-			message = message[0:i] + url + message[i+len(cj.bot.IRC.CronGrammarGiphy):]
+			message = message[0:i] + url + message[i+len(cj.bot.config.cron.CronGrammarGiphy):]
 		}
-		i = strings.Index(message, cj.bot.IRC.CronGrammarGiphy)
+		i = strings.Index(message, cj.bot.config.cron.CronGrammarGiphy)
 	}
 
 	// Replace all <giphy search="<query>"> with replies from the giphy API.
@@ -174,10 +181,17 @@ func (cj *cronJob) Run() {
 
 	// Send message to the channel and replace the placeholders with the
 	// actual values.
-	cj.bot.privmsgph(message, map[string]string{
-		cj.bot.IRC.CronGrammarMsgIsLimited: strconv.FormatBool(cj.isLimited),
-		cj.bot.IRC.CronGrammarMsgExecCount: strconv.FormatInt(int64(cj.execCount), 10),
-		cj.bot.IRC.CronGrammarMsgExecLimit: strconv.FormatInt(int64(cj.execLimit), 10),
+	message = replacePlaceholders(message, map[string]string{
+		cj.bot.config.cron.CronGrammarMsgIsLimited: strconv.FormatBool(cj.isLimited),
+		cj.bot.config.cron.CronGrammarMsgExecCount: strconv.FormatInt(int64(cj.execCount), 10),
+		cj.bot.config.cron.CronGrammarMsgExecLimit: strconv.FormatInt(int64(cj.execLimit), 10),
+	})
+	cj.network.announce(cj.channel, message)
+
+	cj.bot.emitEvent(cj.network, cj.channel, "", outgoingEventCronFired, map[string]interface{}{
+		"id":        cj.id,
+		"message":   message,
+		"execCount": cj.execCount,
 	})
 
 	// Execution count has reached the limit, terminate the job.
@@ -1,15 +1,65 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/osm/irc"
 )
 
+// cronConfig holds the configuration of the cron module.
+type cronConfig struct {
+	EnableCron bool `json:"enableCron"`
+
+	CronCmd            string `json:"cronCmd"`
+	CronSubCmdAdd      string `json:"cronSubCmdAdd"`
+	CronSubCmdAddLimit string `json:"cronSubCmdAddLimit"`
+	CronSubCmdDelete   string `json:"cronSubCmdDelete"`
+	CronSubCmdList     string `json:"cronSubCmdList"`
+	CronSubCmdUpdate   string `json:"cronSubCmdUpdate"`
+
+	CronErr       string `json:"cronErr"`
+	CronMsgAdd    string `json:"cronMsgAdd"`
+	CronMsgDelete string `json:"cronMsgDelete"`
+	CronMsgList   string `json:"cronMsgList"`
+	CronMsgUpdate string `json:"cronMsgUpdate"`
+
+	CronGrammarMsgExecCount string `json:"cronGrammarMsgExecCount"`
+	CronGrammarMsgExecLimit string `json:"cronGrammarMsgExecLimit"`
+	CronGrammarMsgIsLimited string `json:"cronGrammarMsgIsLimited"`
+	CronGrammarMsgRandomWho string `json:"cronGrammarMsgRandomWho"`
+	CronGrammarWeek         string `json:"cronGrammarWeek"`
+	CronGrammarGiphy        string `json:"cronGrammarGiphy"`
+	CronGrammarGiphySearch  string `json:"cronGrammarGiphySearch"`
+	CronGrammarTenorSearch  string `json:"cronGrammarTenorSearch"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureCron,
+		config:  func(b *bot) interface{} { return &b.config.cron },
+		enabled: func(b *bot) bool { return b.config.cron.EnableCron },
+		check:   (*bot).checkCronConfig,
+		init:    (*bot).initCronDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.cron.CronCmd,
+				feature: featureCron,
+				subCommands: []*botCommand{
+					{name: b.config.cron.CronSubCmdAdd, usage: "<expression> <message>", minArgs: 6, maxArgs: unlimitedArgs, role: roleTrusted, fn: (*bot).cronAddCommand},
+					{name: b.config.cron.CronSubCmdDelete, usage: "<id>", minArgs: 1, maxArgs: 1, role: roleTrusted, fn: (*bot).cronDeleteCommand},
+					{name: b.config.cron.CronSubCmdList, fn: (*bot).cronListCommand},
+					{name: b.config.cron.CronSubCmdUpdate, usage: "<id> <expression> <message>", minArgs: 7, maxArgs: unlimitedArgs, role: roleTrusted, fn: (*bot).cronUpdateCommand},
+				},
+			}}
+		},
+		run: (*bot).runCron,
+	})
+}
+
 // cronAddLimitRegexp contains the regular expression used to add cron jobs
 // with an execution limit.
 var cronAddLimitRegexp *regexp.Regexp
@@ -22,101 +72,116 @@ var cronGrammarGiphySearchRegexp *regexp.Regexp
 // <tenor search"xxx"> tags.
 var cronGrammarTenorSearchRegexp *regexp.Regexp
 
+// checkCronConfig makes sure that the configured grammar regexps compiles.
+func (b *bot) checkCronConfig() error {
+	limit := ""
+	if b.config.cron.CronSubCmdAddLimit != "" {
+		limit = "^" + b.config.cron.CronSubCmdAddLimit + ":([0-9]+) "
+	}
+
+	return checkRegexps(map[string]string{
+		"cronSubCmdAddLimit":     limit,
+		"cronGrammarGiphySearch": b.config.cron.CronGrammarGiphySearch,
+		"cronGrammarTenorSearch": b.config.cron.CronGrammarTenorSearch,
+	})
+}
+
 // initCronDefaults sets default values for all settings.
 func (b *bot) initCronDefaults() {
 	// Command and sub commands.
-	if b.IRC.CronCmd == "" {
-		b.IRC.CronCmd = "!cron"
+	if b.config.cron.CronCmd == "" {
+		b.config.cron.CronCmd = "cron"
 	}
-	if b.IRC.CronSubCmdAdd == "" {
-		b.IRC.CronSubCmdAdd = "add"
+	if b.config.cron.CronSubCmdAdd == "" {
+		b.config.cron.CronSubCmdAdd = "add"
 	}
-	if b.IRC.CronSubCmdAddLimit == "" {
-		b.IRC.CronSubCmdAddLimit = "limit"
+	if b.config.cron.CronSubCmdAddLimit == "" {
+		b.config.cron.CronSubCmdAddLimit = "limit"
 	}
-	cronAddLimitRegexp = regexp.MustCompile("^" + b.IRC.CronSubCmdAddLimit + ":([0-9]+) ")
-	if b.IRC.CronSubCmdDelete == "" {
-		b.IRC.CronSubCmdDelete = "delete"
+	cronAddLimitRegexp = regexp.MustCompile("^" + b.config.cron.CronSubCmdAddLimit + ":([0-9]+) ")
+	if b.config.cron.CronSubCmdDelete == "" {
+		b.config.cron.CronSubCmdDelete = "delete"
 	}
-	if b.IRC.CronSubCmdList == "" {
-		b.IRC.CronSubCmdList = "list"
+	if b.config.cron.CronSubCmdList == "" {
+		b.config.cron.CronSubCmdList = "list"
 	}
-	if b.IRC.CronSubCmdUpdate == "" {
-		b.IRC.CronSubCmdUpdate = "update"
+	if b.config.cron.CronSubCmdUpdate == "" {
+		b.config.cron.CronSubCmdUpdate = "update"
 	}
 
 	// Messages
-	if b.IRC.CronErr == "" {
-		b.IRC.CronErr = "check your syntax"
+	if b.config.cron.CronErr == "" {
+		b.config.cron.CronErr = "check your syntax"
 	}
-	if b.IRC.CronMsgAdd == "" {
-		b.IRC.CronMsgAdd = "cron job added"
+	if b.config.cron.CronMsgAdd == "" {
+		b.config.cron.CronMsgAdd = "cron job added"
 	}
-	if b.IRC.CronMsgDelete == "" {
-		b.IRC.CronMsgDelete = "cron job deleted"
+	if b.config.cron.CronMsgDelete == "" {
+		b.config.cron.CronMsgDelete = "cron job deleted"
 	}
-	if b.IRC.CronMsgList == "" {
-		b.IRC.CronMsgList = "id: <id> expression: <expression> message: <message> limited: <is_limited> count: <exec_count>/<exec_limit>"
+	if b.config.cron.CronMsgList == "" {
+		b.config.cron.CronMsgList = "id: <id> expression: <expression> message: <message> limited: <is_limited> count: <exec_count>/<exec_limit>"
 	}
-	if b.IRC.CronMsgUpdate == "" {
-		b.IRC.CronMsgUpdate = "cron job updated"
+	if b.config.cron.CronMsgUpdate == "" {
+		b.config.cron.CronMsgUpdate = "cron job updated"
 	}
 
 	// Grammar
-	if b.IRC.CronGrammarMsgExecCount == "" {
-		b.IRC.CronGrammarMsgExecCount = "<exec_count>"
+	if b.config.cron.CronGrammarMsgExecCount == "" {
+		b.config.cron.CronGrammarMsgExecCount = "<exec_count>"
 	}
-	if b.IRC.CronGrammarMsgExecLimit == "" {
-		b.IRC.CronGrammarMsgExecLimit = "<exec_limit>"
+	if b.config.cron.CronGrammarMsgExecLimit == "" {
+		b.config.cron.CronGrammarMsgExecLimit = "<exec_limit>"
 	}
-	if b.IRC.CronGrammarMsgIsLimited == "" {
-		b.IRC.CronGrammarMsgIsLimited = "<is_limited>"
+	if b.config.cron.CronGrammarMsgIsLimited == "" {
+		b.config.cron.CronGrammarMsgIsLimited = "<is_limited>"
 	}
-	if b.IRC.CronGrammarMsgRandomWho == "" {
-		b.IRC.CronGrammarMsgRandomWho = "<random_who>"
+	if b.config.cron.CronGrammarMsgRandomWho == "" {
+		b.config.cron.CronGrammarMsgRandomWho = "<random_who>"
 	}
-	if b.IRC.CronGrammarWeek == "" {
-		b.IRC.CronGrammarGiphy = "<week>"
+	if b.config.cron.CronGrammarWeek == "" {
+		b.config.cron.CronGrammarWeek = "<week>"
 	}
-	if b.IRC.CronGrammarGiphy == "" {
-		b.IRC.CronGrammarGiphy = "<giphy>"
+	if b.config.cron.CronGrammarGiphy == "" {
+		b.config.cron.CronGrammarGiphy = "<giphy>"
 	}
-	if b.IRC.CronGrammarGiphySearch == "" {
-		b.IRC.CronGrammarGiphySearch = `<giphy search="([a-zåäöA-ZÅÄÖ0-9 ]+)"[^>]*>"`
+	if b.config.cron.CronGrammarGiphySearch == "" {
+		b.config.cron.CronGrammarGiphySearch = `<giphy search="([a-zåäöA-ZÅÄÖ0-9 ]+)"[^>]*>"`
 	}
-	cronGrammarGiphySearchRegexp = regexp.MustCompile(b.IRC.CronGrammarGiphySearch)
-	if b.IRC.CronGrammarTenorSearch == "" {
-		b.IRC.CronGrammarTenorSearch = `<tenor search="([a-zåäöüA-ZÅÄÖÜ0-9 ]+)"[^>]*>"`
+	cronGrammarGiphySearchRegexp = regexp.MustCompile(b.config.cron.CronGrammarGiphySearch)
+	if b.config.cron.CronGrammarTenorSearch == "" {
+		b.config.cron.CronGrammarTenorSearch = `<tenor search="([a-zåäöüA-ZÅÄÖÜ0-9 ]+)"[^>]*>"`
 	}
-	cronGrammarTenorSearchRegexp = regexp.MustCompile(b.IRC.CronGrammarTenorSearch)
+	cronGrammarTenorSearchRegexp = regexp.MustCompile(b.config.cron.CronGrammarTenorSearch)
 }
 
 // initCron initializes the cron jobs.
 func (b *bot) initCron() {
 	// Fetch all active cron jobs from the database.
-	rows, err := b.query("SELECT id, expression, message, is_limited, exec_count, exec_limit FROM cron WHERE is_deleted = false")
+	jobs, err := b.store.cron.active()
 	if err != nil {
-		b.logger.Printf("initCron: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureCron).Errorf("initCron: %v", err)
 		return
 	}
-	defer rows.Close()
 
 	// Iterate over the results and add new cron jobs for each job.
-	for rows.Next() {
-		var id, expression, message string
-		var isLimited bool
-		var execCount, execLimit int
-		rows.Scan(&id, &expression, &message, &isLimited, &execCount, &execLimit)
+	for _, j := range jobs {
+		// The job belongs to a network that no longer is configured,
+		// so there's nowhere to send the message.
+		n := b.network(j.Network)
+		if n == nil {
+			b.log(featureCron).Warnf("initCron: job %s belongs to unknown network %s", j.ID, j.Network)
+			continue
+		}
 
 		// Add a new cron job for the given expression, the message
 		// that is defined will be sent back to the channel when the
 		// cron job is triggered. We don't add limited jobs where the
 		// execution count has reached its limit.
-		if isLimited == false || (isLimited == true && execCount < execLimit) {
-			err = b.cron.add(id, expression, message, execCount, execLimit, isLimited, b)
+		if !j.exhausted() {
+			err = b.cron.add(j.ID, n, j.Channel, j.Expression, j.Message, j.ExecCount, j.ExecLimit, j.IsLimited, b)
 			if err != nil {
-				b.logger.Printf("initCron: %v", err)
+				b.log(featureCron).Errorf("initCron: %v", err)
 			}
 		}
 	}
@@ -125,50 +190,41 @@ func (b *bot) initCron() {
 	b.cron.cron.Start()
 }
 
-// cronHandler handles the IRC integration of the cron job scheduler.
-func (b *bot) cronHandler(m *irc.Message) {
-	// Parse the action
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
-		return
-	}
-
-	// Not a valid cron cmd, return early.
-	if a.cmd != b.IRC.CronCmd || len(a.args) < 1 {
-		return
-	}
+// cronAddCommand handles the cron add command.
+func (b *bot) cronAddCommand(a *privmsgAction) {
+	// The cron expression should be within position 0 to 4 in the args
+	// slice, the rest of the args is the message to send when the cron
+	// expression is evaluated and hit.
+	b.cronAdd(a, strings.Join(a.args[0:5], " "), strings.Join(a.args[5:], " "))
+}
 
-	if b.shouldIgnore(m) {
-		return
-	}
+// cronDeleteCommand handles the cron delete command.
+func (b *bot) cronDeleteCommand(a *privmsgAction) {
+	b.cronDelete(a, a.args[0])
+}
 
-	subCmd := a.args[0]
-	if subCmd == b.IRC.CronSubCmdAdd && len(a.args) >= 7 {
-		// The cron expression should be within position 1 to 6 in the
-		// args slice, the rest of the args is the message to send
-		// when the cron expression is evaluated and hit.
-		b.cronAdd(strings.Join(a.args[1:6], " "), strings.Join(a.args[6:], " "))
-	} else if subCmd == b.IRC.CronSubCmdDelete && len(a.args) == 2 {
-		b.cronDelete(a.args[1])
-	} else if subCmd == b.IRC.CronSubCmdList && len(a.args) == 1 {
-		b.cronList()
-	} else if subCmd == b.IRC.CronSubCmdUpdate && len(a.args) >= 8 {
-		// The first argument should be the id of the cron job to
-		// update. The cron expression should be within position 2 to
-		// 7 in the args slice, the rest of the args is the message to
-		// send when the cron expression is evaluated and hit.
-		b.cronUpdate(a.args[1], strings.Join(a.args[2:7], " "), strings.Join(a.args[7:], " "))
-	}
+// cronListCommand handles the cron list command.
+func (b *bot) cronListCommand(a *privmsgAction) {
+	b.cronList(a)
+}
 
+// cronUpdateCommand handles the cron update command.
+func (b *bot) cronUpdateCommand(a *privmsgAction) {
+	// The first argument should be the id of the cron job to update. The
+	// cron expression should be within position 1 to 5 in the args
+	// slice, the rest of the args is the message to send when the cron
+	// expression is evaluated and hit.
+	b.cronUpdate(a, a.args[0], strings.Join(a.args[1:6], " "), strings.Join(a.args[6:], " "))
 }
 
-// cronAdd adds the given expression and message to the database.
-func (b *bot) cronAdd(expression, message string) {
+// cronAdd adds the given expression and message to the database, the message
+// will be sent to the channel of the action.
+func (b *bot) cronAdd(a *privmsgAction, expression, message string) {
 	// Make sure that the expression is valid.
 	schedule, err := b.cron.parser.Parse(expression)
 	if err != nil {
-		b.logger.Printf("cronAdd: %v", err)
-		b.privmsg(b.IRC.CronErr)
+		b.log(featureCron).Errorf("cronAdd: %v", err)
+		a.replyUrgent(b.config.cron.CronErr)
 		return
 	}
 
@@ -190,57 +246,53 @@ func (b *bot) cronAdd(expression, message string) {
 		message = strings.Replace(message, matches[0], "", 1)
 	}
 
-	// Prepare the INSERT statement.
-	stmt, err := b.prepare("INSERT INTO cron (id, expression, message, is_limited, exec_limit, is_deleted, inserted_at) VALUES($1, $2, $3, $4, $5, false, $6)")
-	if err != nil {
-		b.logger.Printf("cronAdd: %v", err)
-		b.privmsg(b.DB.Err)
-		return
-	}
-	defer stmt.Close()
-
-	// Execute it.
 	id := newUUID()
-	_, err = stmt.Exec(id, expression, message, isLimited, execLimit, newTimestamp())
+	err = b.store.cron.insert(cronEntry{
+		ID:         id,
+		Network:    a.network.Name,
+		Channel:    a.target,
+		Expression: expression,
+		Message:    message,
+		IsLimited:  isLimited,
+		ExecLimit:  execLimit,
+		InsertedAt: time.Now(),
+	})
 	if err != nil {
-		b.logger.Printf("cronAdd: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureCron).Errorf("cronAdd: %v", err)
+		b.replyDBError(a)
 		return
 	}
 
 	// Add the job
-	err = b.cron.add(id, expression, message, 0, execLimit, isLimited, b)
+	err = b.cron.add(id, a.network, a.target, expression, message, 0, execLimit, isLimited, b)
 	if err != nil {
-		b.logger.Printf("cronAdd: %v", err)
-		b.privmsg(b.IRC.CronErr)
+		b.log(featureCron).Errorf("cronAdd: %v", err)
+		a.replyUrgent(b.config.cron.CronErr)
 	}
 
 	// ... and send a notice that the cron job has been stored.
-	b.privmsgph(b.IRC.CronMsgAdd, map[string]string{
+	a.replyph(b.config.cron.CronMsgAdd, map[string]string{
 		"<next_execution>": schedule.Next(time.Now()).Format("2006-01-02 15:04"),
 	})
 }
 
-// cronDelete deletes the cron job.
-func (b *bot) cronDelete(id string) {
+// cronDelete deletes the cron job, only jobs that belongs to the channel of
+// the action can be deleted.
+func (b *bot) cronDelete(a *privmsgAction, id string) {
 	// We expect a valid UUID to be sent.
 	if !isUUID(id) {
 		return
 	}
 
-	stmt, err := b.prepare("UPDATE cron SET is_deleted = true WHERE id = $1")
+	deleted, err := b.store.cron.delete(id, a.network.Name, a.target)
 	if err != nil {
-		b.logger.Printf("cronDelete: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureCron).Errorf("cronDelete: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer stmt.Close()
 
-	// Execute the UPDATE statement.
-	_, err = stmt.Exec(id)
-	if err != nil {
-		b.logger.Printf("cronDelete: %v", err)
-		b.privmsg(b.DB.Err)
+	// The job doesn't exist in the channel, return early.
+	if !deleted {
 		return
 	}
 
@@ -248,44 +300,22 @@ func (b *bot) cronDelete(id string) {
 	b.cron.delete(id)
 
 	// Send a notice that the cron job was removed.
-	b.privmsg(b.IRC.CronMsgDelete)
+	a.reply(b.config.cron.CronMsgDelete)
 }
 
-// cronList lists all the cron jobs.
-func (b *bot) cronList() {
-	rows, err := b.query("SELECT id, expression, message, is_limited, exec_count, exec_limit FROM cron WHERE is_deleted = false")
+// cronList lists all the cron jobs of the channel of the action.
+func (b *bot) cronList(a *privmsgAction) {
+	jobs, err := b.store.cron.inChannel(a.network.Name, a.target)
 	if err != nil {
-		b.logger.Printf("cronList: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureCron).Errorf("cronList: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer rows.Close()
-
-	type cronjob struct {
-		id         string
-		expression string
-		message    string
-		isLimited  bool
-		execCount  int
-		execLimit  int
-	}
-
-	var cronjobs []cronjob
-	for rows.Next() {
-		var id, expression, message string
-		var isLimited bool
-		var execCount, execLimit int
-		rows.Scan(&id, &expression, &message, &isLimited, &execCount, &execLimit)
-
-		if isLimited == false || (isLimited == true && execCount < execLimit) {
-			cronjobs = append(cronjobs, cronjob{
-				id,
-				expression,
-				message,
-				isLimited,
-				execCount,
-				execLimit,
-			})
+
+	var cronjobs []cronEntry
+	for _, j := range jobs {
+		if !j.exhausted() {
+			cronjobs = append(cronjobs, j)
 		}
 	}
 
@@ -294,7 +324,7 @@ func (b *bot) cronList() {
 	if len(cronjobs) > 5 {
 		target = "pastebin"
 	} else {
-		target = b.IRC.Channel
+		target = a.target
 	}
 
 	// Send the information back to the given target.
@@ -302,23 +332,23 @@ func (b *bot) cronList() {
 
 	for _, c := range cronjobs {
 		var nextExecution string
-		schedule, err := b.cron.parser.Parse(c.expression)
+		schedule, err := b.cron.parser.Parse(c.Expression)
 		if err == nil {
 			nextExecution = schedule.Next(time.Now()).Format("2006-01-02 15:04")
 		}
 
 		data := map[string]string{
-			"<id>":             c.id,
-			"<expression>":     c.expression,
-			"<message>":        c.message,
-			"<is_limited>":     strconv.FormatBool(c.isLimited),
-			"<exec_count>":     strconv.FormatInt(int64(c.execCount), 10),
-			"<exec_limit>":     strconv.FormatInt(int64(c.execLimit), 10),
+			"<id>":             c.ID,
+			"<expression>":     c.Expression,
+			"<message>":        c.Message,
+			"<is_limited>":     strconv.FormatBool(c.IsLimited),
+			"<exec_count>":     strconv.FormatInt(int64(c.ExecCount), 10),
+			"<exec_limit>":     strconv.FormatInt(int64(c.ExecLimit), 10),
 			"<next_execution>": nextExecution,
 		}
 
 		if target == "pastebin" {
-			code := b.IRC.CronMsgList
+			code := b.config.cron.CronMsgList
 			for k, v := range data {
 				code = strings.ReplaceAll(code, k, v)
 			}
@@ -330,17 +360,18 @@ func (b *bot) cronList() {
 			}
 
 		} else {
-			b.privmsgph(b.IRC.CronMsgList, data)
+			a.replyph(b.config.cron.CronMsgList, data)
 		}
 	}
 
 	if target == "pastebin" {
-		b.newPaste("cron job", pastebinCode)
+		b.newPaste(a, "cron job", pastebinCode)
 	}
 }
 
-// cronUpdate updates the cron job.
-func (b *bot) cronUpdate(id, expression, message string) {
+// cronUpdate updates the cron job, only jobs that belongs to the channel of
+// the action can be updated.
+func (b *bot) cronUpdate(a *privmsgAction, id, expression, message string) {
 	// We expect a valid UUID to be sent.
 	if !isUUID(id) {
 		return
@@ -349,35 +380,38 @@ func (b *bot) cronUpdate(id, expression, message string) {
 	// Make sure that the expression is valid.
 	_, err := b.cron.parser.Parse(expression)
 	if err != nil {
-		b.logger.Printf("cronUpdate: %v", err)
-		b.privmsg(b.IRC.CronErr)
+		b.log(featureCron).Errorf("cronUpdate: %v", err)
+		a.replyUrgent(b.config.cron.CronErr)
 	}
 
-	// Prepare the update query.
-	stmt, err := b.prepare("UPDATE cron SET expression = $1, message = $2, updated_at = $3 WHERE id = $4 AND is_deleted = false")
+	updated, err := b.store.cron.update(id, a.network.Name, a.target, expression, message, time.Now())
 	if err != nil {
-		b.logger.Printf("cronUpdate: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureCron).Errorf("cronUpdate: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer stmt.Close()
 
-	// Execute the UPDATE statement.
-	_, err = stmt.Exec(expression, message, newTimestamp(), id)
-	if err != nil {
-		b.logger.Printf("cronUpdate: %v", err)
-		b.privmsg(b.DB.Err)
+	// The job doesn't exist in the channel, return early.
+	if !updated {
 		return
 	}
 
 	// Delete the old job and re-add it as a new.
 	b.cron.delete(id)
-	err = b.cron.add(id, expression, message, 0, 0, false, b)
+	err = b.cron.add(id, a.network, a.target, expression, message, 0, 0, false, b)
 	if err != nil {
-		b.logger.Printf("cronUpdate: %v", err)
-		b.privmsg(b.IRC.CronErr)
+		b.log(featureCron).Errorf("cronUpdate: %v", err)
+		a.replyUrgent(b.config.cron.CronErr)
 	}
 
 	// Send a notice that the cron job was updated.
-	b.privmsg(b.IRC.CronMsgUpdate)
+	a.reply(b.config.cron.CronMsgUpdate)
+}
+
+// runCron loads the cron jobs from the database and runs them until the
+// module is stopped.
+func (b *bot) runCron(ctx context.Context) {
+	b.initCron()
+	<-ctx.Done()
+	b.cron.cron.Stop()
 }
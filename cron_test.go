@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// runCronJob runs the cron job with the given id as if it was scheduled,
+// false is returned if the job isn't in the runner.
+func runCronJob(tb *testBot, id string) bool {
+	tb.cron.mu.Lock()
+	entryID, ok := tb.cron.jobs[id]
+	tb.cron.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	tb.cron.cron.Entry(entryID).Job.Run()
+	return true
+}
+
+func TestCron(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableCron": true})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!cron add 0 12 1 1 * happy new year")
+	s.expect("PRIVMSG #bot :you need the trusted role to use !cron add")
+
+	s.privmsg("admin", "#bot", "!cron add 0 12 1 1 * happy new year")
+	s.expect("PRIVMSG #bot :cron job added")
+
+	s.privmsg("admin", "#bot", "!cron add 61 12 1 1 * bad expression")
+	s.expect("PRIVMSG #bot :check your syntax")
+
+	// The job is limited to two executions, it's removed from the
+	// runner when the limit has been reached.
+	s.privmsg("admin", "#bot", "!cron add 0 12 1 1 * limit:2 lunch <exec_count>/<exec_limit>")
+	s.expect("PRIVMSG #bot :cron job added")
+
+	id := tb.queryString("SELECT id FROM cron WHERE message = 'lunch <exec_count>/<exec_limit>'")
+	if !runCronJob(tb, id) {
+		t.Fatal("the job isn't in the runner")
+	}
+	s.expect("PRIVMSG #bot :lunch 1/2")
+	if !runCronJob(tb, id) {
+		t.Fatal("the job isn't in the runner")
+	}
+	s.expect("PRIVMSG #bot :lunch 2/2")
+	if runCronJob(tb, id) {
+		t.Error("the job is still in the runner after the limit was reached")
+	}
+	if count := tb.queryString("SELECT exec_count FROM cron WHERE id = $1", id); count != "2" {
+		t.Errorf("expected the exec count to be 2, got %s", count)
+	}
+
+	// The jobs that has reached their limit aren't listed.
+	s.privmsg("alice", "#bot", "!cron list")
+	s.expectNo("PRIVMSG #bot :id: "+id, 200*time.Millisecond)
+
+	id = tb.queryString("SELECT id FROM cron WHERE message = 'happy new year'")
+	s.privmsg("alice", "#bot", "!cron list")
+	s.expect("PRIVMSG #bot :id: " + id + " expression: 0 12 1 1 * message: happy new year limited: false count: 0/0")
+
+	s.privmsg("admin", "#bot", "!cron delete "+id)
+	s.expect("PRIVMSG #bot :cron job deleted")
+	if runCronJob(tb, id) {
+		t.Error("the job is still in the runner after it was deleted")
+	}
+}
@@ -4,26 +4,75 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // initDB opens a new connection to the database, it will also run all
 // migrations to make sure that the schema is migrated to the latest version
 // possible. An error will be returned if there's any.
 func (b *bot) initDB() error {
+	dbPath, err := b.dbPath()
+	if err != nil {
+		return err
+	}
+
+	if b.DB.Engine != "postgres" {
+		err = b.initDBSqlite(dbPath)
+	} else {
+		err = b.initDBPostgres(dbPath)
+	}
+	if err != nil {
+		return err
+	}
+	b.store = newStores(b.DB.client, newDialect(b.DB.Engine))
+
+	return nil
+}
+
+// dbPath returns the path of the database, the BOT_DB_PATH environment
+// variable takes precedence over the configuration.
+func (b *bot) dbPath() (string, error) {
 	dbPath := b.DB.Path
 	if p := os.Getenv("BOT_DB_PATH"); p != "" {
 		dbPath = p
 	}
 
 	if dbPath == "" {
-		return fmt.Errorf("database path can't be empty")
+		return "", fmt.Errorf("database path can't be empty")
 	}
 
-	if b.DB.Engine != "postgres" {
-		return b.initDBSqlite(dbPath)
-	} else {
-		return b.initDBPostgres(dbPath)
+	return dbPath, nil
+}
+
+// migrationTimezone returns the timezone of the timestamps that were stored
+// in the local time of the server, before they were stored in UTC. It's the
+// configured migration timezone, or "Local" for the local zone of the server
+// if it isn't set. The configured timezone of the bot isn't used, since it
+// only decides how the times are shown.
+func (b *bot) migrationTimezone() string {
+	if b.DB.MigrationTimezone == "" {
+		return "Local"
 	}
+
+	return b.DB.MigrationTimezone
+}
+
+// sqlString returns s quoted as an SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// migrationNetwork returns the network and the channel that the rows that
+// were stored before the bot supported multiple networks and channels are
+// moved to, which is the default network and its default channel. Empty
+// strings are returned if there is no network.
+func (b *bot) migrationNetwork() (string, string) {
+	n := b.defaultNetwork()
+	if n == nil {
+		return "", ""
+	}
+
+	return n.Name, n.defaultChannel()
 }
 
 // query sends the given query to the database, it returns the rows and an
@@ -42,3 +91,10 @@ func (b *bot) queryRow(query string, args ...interface{}) *sql.Row {
 func (b *bot) prepare(query string) (*sql.Stmt, error) {
 	return b.DB.client.Prepare(query)
 }
+
+// replyDBError counts the database error in the metrics and replies with the
+// generic database error message.
+func (b *bot) replyDBError(a *privmsgAction) {
+	b.metrics.dbErrors.inc()
+	a.replyUrgent(b.DB.Err)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	_ "github.com/lib/pq"
 	"github.com/osm/migrator"
@@ -16,7 +17,8 @@ func (b *bot) initDBPostgres(dbPath string) error {
 		return fmt.Errorf("can't initialize database connection: %v", err)
 	}
 
-	return migrator.ToLatest(b.DB.client, getDatabaseRepositoryPostgres())
+	network, channel := b.migrationNetwork()
+	return migrator.ToLatest(b.DB.client, getDatabaseRepositoryPostgres(b.migrationTimezone(), network, channel))
 }
 
 // getDatabaseRepositoryPostgres returns an in memory repository with the
@@ -24,7 +26,16 @@ func (b *bot) initDBPostgres(dbPath string) error {
 // should only do this if you are prepared to wipe your already existing
 // database. If you need to alter an existing table you have to write a new
 // migration entry that contains the SQL alters the table in the way you want.
-func getDatabaseRepositoryPostgres() repository.Source {
+// The timezone is the zone of the timestamps that were stored before they
+// were moved to UTC, "Local" means the timezone of the session. The network
+// and the channel are given to the rows that were stored before the bot
+// supported multiple networks and channels.
+func getDatabaseRepositoryPostgres(timezone, network, channel string) repository.Source {
+	zone := sqlString(timezone)
+	if timezone == "Local" {
+		zone = "current_setting('TimeZone')"
+	}
+
 	return repository.FromMemory(map[int]string{
 		1: `
 			CREATE TABLE migration (
@@ -178,5 +189,149 @@ func getDatabaseRepositoryPostgres() repository.Source {
 		`,
 		20: `ALTER TABLE parcel_tracking
 			ADD COLUMN nick text;`,
+		21: `
+			ALTER TABLE log
+				ADD COLUMN channel text NOT NULL DEFAULT '';
+			ALTER TABLE url_check
+				ADD COLUMN channel text NOT NULL DEFAULT '';
+			ALTER TABLE factoid
+				ADD COLUMN channel text NOT NULL DEFAULT '';
+			ALTER TABLE cron
+				ADD COLUMN channel text NOT NULL DEFAULT '';
+			ALTER TABLE quiz_stat
+				ADD COLUMN channel text NOT NULL DEFAULT '';
+			CREATE INDEX log_channel_timestamp ON log(channel, timestamp);
+			CREATE INDEX log_channel_nick_timestamp ON log(channel, nick, timestamp);
+			CREATE INDEX url_check_channel_url ON url_check(channel, url);
+			CREATE INDEX factoid_channel ON factoid(channel);
+			CREATE INDEX cron_channel ON cron(channel);
+			CREATE INDEX quiz_stat_channel ON quiz_stat(channel);
+		`,
+		22: `
+			ALTER TABLE log
+				ADD COLUMN network text NOT NULL DEFAULT '';
+			ALTER TABLE url_check
+				ADD COLUMN network text NOT NULL DEFAULT '';
+			ALTER TABLE factoid
+				ADD COLUMN network text NOT NULL DEFAULT '';
+			ALTER TABLE cron
+				ADD COLUMN network text NOT NULL DEFAULT '';
+			ALTER TABLE quiz_stat
+				ADD COLUMN network text NOT NULL DEFAULT '';
+			CREATE INDEX log_network_channel_timestamp ON log(network, channel, timestamp);
+			CREATE INDEX log_network_channel_nick_timestamp ON log(network, channel, nick, timestamp);
+			CREATE INDEX url_check_network_channel_url ON url_check(network, channel, url);
+			CREATE INDEX cron_network_channel ON cron(network, channel);
+		`,
+		23: `
+			CREATE TABLE user_role (
+				id uuid NOT NULL PRIMARY KEY,
+				network text NOT NULL,
+				mask text NOT NULL,
+				role text NOT NULL,
+				author text NOT NULL,
+				inserted_at timestamp NOT NULL,
+				is_deleted boolean NOT NULL
+			);
+			CREATE INDEX user_role_network ON user_role(network);
+		`,
+		24: `
+			CREATE TABLE api_token (
+				id uuid NOT NULL PRIMARY KEY,
+				name text NOT NULL,
+				token_hash text NOT NULL,
+				author text NOT NULL,
+				inserted_at timestamp NOT NULL,
+				is_deleted boolean NOT NULL
+			);
+			CREATE INDEX api_token_token_hash ON api_token(token_hash);
+		`,
+		25: `
+			CREATE TABLE webhook_delivery (
+				id uuid NOT NULL PRIMARY KEY,
+				webhook text NOT NULL,
+				event text NOT NULL,
+				payload text NOT NULL,
+				status text NOT NULL,
+				attempts integer NOT NULL,
+				last_error text NOT NULL,
+				next_attempt_at timestamp NOT NULL,
+				inserted_at timestamp NOT NULL,
+				delivered_at timestamp
+			);
+			CREATE INDEX webhook_delivery_status_next_attempt_at ON webhook_delivery(status, next_attempt_at);
+		`,
+		// The inserted_at column of smhi_forecast defaults to
+		// CURRENT_TIMESTAMP, which was stored in the timezone of the
+		// session, so it's converted without USING.
+		26: fmt.Sprintf(`
+			ALTER TABLE log
+				ALTER COLUMN timestamp TYPE timestamptz USING timestamp AT TIME ZONE %[1]s;
+			ALTER TABLE url_check
+				ALTER COLUMN timestamp TYPE timestamptz USING timestamp AT TIME ZONE %[1]s;
+			ALTER TABLE factoid
+				ALTER COLUMN timestamp TYPE timestamptz USING timestamp AT TIME ZONE %[1]s;
+			ALTER TABLE cron
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s,
+				ALTER COLUMN updated_at TYPE timestamptz USING updated_at AT TIME ZONE %[1]s;
+			ALTER TABLE quiz_stat
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s;
+			ALTER TABLE supernytt
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s;
+			ALTER TABLE march
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s;
+			ALTER TABLE smhi_forecast
+				ALTER COLUMN inserted_at TYPE timestamptz,
+				ALTER COLUMN updated_at TYPE timestamptz USING updated_at AT TIME ZONE %[1]s,
+				ALTER COLUMN timestamp TYPE timestamptz USING timestamp AT TIME ZONE %[1]s;
+			ALTER TABLE parcel_tracking
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at::timestamp AT TIME ZONE %[1]s;
+			ALTER TABLE user_role
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s;
+			ALTER TABLE api_token
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s;
+			ALTER TABLE webhook_delivery
+				ALTER COLUMN next_attempt_at TYPE timestamptz USING next_attempt_at AT TIME ZONE %[1]s,
+				ALTER COLUMN inserted_at TYPE timestamptz USING inserted_at AT TIME ZONE %[1]s,
+				ALTER COLUMN delivered_at TYPE timestamptz USING delivered_at AT TIME ZONE %[1]s;
+		`, zone),
+		// The rows that were stored before the bot supported multiple
+		// networks and channels belong to the default network and its
+		// default channel.
+		27: fmt.Sprintf(`
+			UPDATE log SET network = %[1]s WHERE network = '';
+			UPDATE log SET channel = %[2]s WHERE channel = '';
+			UPDATE url_check SET network = %[1]s WHERE network = '';
+			UPDATE url_check SET channel = %[2]s WHERE channel = '';
+			UPDATE factoid SET network = %[1]s WHERE network = '';
+			UPDATE factoid SET channel = %[2]s WHERE channel = '';
+			UPDATE cron SET network = %[1]s WHERE network = '';
+			UPDATE cron SET channel = %[2]s WHERE channel = '';
+			UPDATE quiz_stat SET network = %[1]s WHERE network = '';
+			UPDATE quiz_stat SET channel = %[2]s WHERE channel = '';
+		`, sqlString(network), sqlString(channel)),
 	})
 }
+
+// postgresDialect is the dialect of PostgreSQL.
+type postgresDialect struct{}
+
+// ilike implements the dialect interface.
+func (postgresDialect) ilike(column, param string) string {
+	return fmt.Sprintf("%s ILIKE %s", column, param)
+}
+
+// round implements the dialect interface.
+func (postgresDialect) round(column string) string {
+	return fmt.Sprintf("round(%s, 1)", column)
+}
+
+// upsert implements the dialect interface.
+func (postgresDialect) upsert(table string, columns []string, key string) string {
+	var set []string
+	for _, c := range columns {
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s", table, strings.Join(columns, ", "), placeholders(len(columns)), key, strings.Join(set, ", "))
+}
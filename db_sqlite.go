@@ -3,20 +3,77 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/osm/migrator"
 	"github.com/osm/migrator/repository"
 )
 
+// sqliteDriver is the name of the SQLite driver that has the functions of
+// the bot registered.
+const sqliteDriver = "sqlite3_bot"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("utc_timestamp", sqliteUTCTimestamp, true)
+		},
+	})
+}
+
+// sqliteLocations caches the locations that are loaded by
+// sqliteUTCTimestamp, so that they aren't loaded once for each row.
+var sqliteLocations sync.Map
+
+// sqliteUTCTimestamp converts a timestamp that was stored as the local time
+// of the zone, without an offset, into the format of formatTimestamp. The
+// zone "Local" is the local zone of the server. It's
+// registered as the utc_timestamp SQL function, which is used by the
+// migration that moved the timestamps to UTC. NULL is returned as is.
+func sqliteUTCTimestamp(value interface{}, zone string) (interface{}, error) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		s = string(v)
+	default:
+		return nil, fmt.Errorf("can't convert %v to a timestamp", value)
+	}
+
+	loc, ok := sqliteLocations.Load(zone)
+	if !ok {
+		l, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, err
+		}
+		loc, _ = sqliteLocations.LoadOrStore(zone, l)
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.ParseInLocation(layout, s, loc.(*time.Location)); err == nil {
+			return formatTimestamp(t), nil
+		}
+	}
+
+	return nil, fmt.Errorf("can't convert %s to a timestamp", s)
+}
+
 // initSqlDBSqlite initializes a sqlite database.
 func (b *bot) initDBSqlite(dbPath string) error {
 	var err error
-	if b.DB.client, err = sql.Open("sqlite3", dbPath); err != nil {
+	if b.DB.client, err = sql.Open(sqliteDriver, dbPath); err != nil {
 		return fmt.Errorf("can't initialize database connection: %v", err)
 	}
 
-	return migrator.ToLatest(b.DB.client, getDatabaseRepositorySqlite())
+	network, channel := b.migrationNetwork()
+	return migrator.ToLatest(b.DB.client, getDatabaseRepositorySqlite(b.migrationTimezone(), network, channel))
 }
 
 // getDatabaseRepositorySqlite returns an in memory repository with the
@@ -24,7 +81,10 @@ func (b *bot) initDBSqlite(dbPath string) error {
 // should only do this if you are prepared to wipe your already existing
 // database. If you need to alter an existing table you have to write a new
 // migration entry that contains the SQL alters the table in the way you want.
-func getDatabaseRepositorySqlite() repository.Source {
+// The timezone is the zone of the timestamps that were stored before they
+// were moved to UTC, the network and the channel are given to the rows that
+// were stored before the bot supported multiple networks and channels.
+func getDatabaseRepositorySqlite(timezone, network, channel string) repository.Source {
 	return repository.FromMemory(map[int]string{
 		1:  "CREATE TABLE migration (version TEXT NOT NULL PRIMARY KEY);",
 		2:  "CREATE TABLE log (id VARCHAR(36) NOT NULL PRIMARY KEY, timestamp TEXT NOT NULL, nick TEXT NOT NULL, message TEXT NOT NULL); CREATE INDEX log_timestamp ON log(timestamp); CREATE INDEX log_nick_timestamp ON log(nick, timestamp);",
@@ -86,5 +146,127 @@ func getDatabaseRepositorySqlite() repository.Source {
 			CREATE INDEX parcel_tracking_alias_id ON parcel_tracking(alias, parcel_tracking_id);
 		`,
 		20: `ALTER TABLE parcel_tracking ADD COLUMN nick TEXT;`,
+		21: `
+			ALTER TABLE log ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+			ALTER TABLE url_check ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+			ALTER TABLE factoid ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+			ALTER TABLE cron ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+			ALTER TABLE quiz_stat ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+			CREATE INDEX log_channel_timestamp ON log(channel, timestamp);
+			CREATE INDEX log_channel_nick_timestamp ON log(channel, nick, timestamp);
+			CREATE INDEX url_check_channel_url ON url_check(channel, url);
+			CREATE INDEX factoid_channel ON factoid(channel);
+			CREATE INDEX cron_channel ON cron(channel);
+			CREATE INDEX quiz_stat_channel ON quiz_stat(channel);
+		`,
+		22: `
+			ALTER TABLE log ADD COLUMN network TEXT NOT NULL DEFAULT '';
+			ALTER TABLE url_check ADD COLUMN network TEXT NOT NULL DEFAULT '';
+			ALTER TABLE factoid ADD COLUMN network TEXT NOT NULL DEFAULT '';
+			ALTER TABLE cron ADD COLUMN network TEXT NOT NULL DEFAULT '';
+			ALTER TABLE quiz_stat ADD COLUMN network TEXT NOT NULL DEFAULT '';
+			CREATE INDEX log_network_channel_timestamp ON log(network, channel, timestamp);
+			CREATE INDEX log_network_channel_nick_timestamp ON log(network, channel, nick, timestamp);
+			CREATE INDEX url_check_network_channel_url ON url_check(network, channel, url);
+			CREATE INDEX cron_network_channel ON cron(network, channel);
+		`,
+		23: `
+			CREATE TABLE user_role (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				network TEXT NOT NULL,
+				mask TEXT NOT NULL,
+				role TEXT NOT NULL,
+				author TEXT NOT NULL,
+				inserted_at TEXT NOT NULL,
+				is_deleted BOOLEAN NOT NULL
+			);
+			CREATE INDEX user_role_network ON user_role(network);
+		`,
+		24: `
+			CREATE TABLE api_token (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL,
+				author TEXT NOT NULL,
+				inserted_at TEXT NOT NULL,
+				is_deleted BOOLEAN NOT NULL
+			);
+			CREATE INDEX api_token_token_hash ON api_token(token_hash);
+		`,
+		25: `
+			CREATE TABLE webhook_delivery (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				webhook TEXT NOT NULL,
+				event TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL,
+				last_error TEXT NOT NULL,
+				next_attempt_at TEXT NOT NULL,
+				inserted_at TEXT NOT NULL,
+				delivered_at TEXT
+			);
+			CREATE INDEX webhook_delivery_status_next_attempt_at ON webhook_delivery(status, next_attempt_at);
+		`,
+		// SQLite has no time type, the timestamps are rewritten as UTC
+		// in the format of formatTimestamp instead. The inserted_at
+		// column of smhi_forecast defaults to CURRENT_TIMESTAMP, which
+		// is already in UTC.
+		26: fmt.Sprintf(`
+			UPDATE log SET timestamp = utc_timestamp(timestamp, %[1]s);
+			UPDATE url_check SET timestamp = utc_timestamp(timestamp, %[1]s);
+			UPDATE factoid SET timestamp = utc_timestamp(timestamp, %[1]s);
+			UPDATE cron SET inserted_at = utc_timestamp(inserted_at, %[1]s), updated_at = utc_timestamp(updated_at, %[1]s);
+			UPDATE quiz_stat SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE supernytt SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE march SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE smhi_forecast SET
+				inserted_at = utc_timestamp(inserted_at, 'UTC'),
+				updated_at = utc_timestamp(updated_at, %[1]s),
+				timestamp = utc_timestamp(timestamp, %[1]s);
+			UPDATE parcel_tracking SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE user_role SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE api_token SET inserted_at = utc_timestamp(inserted_at, %[1]s);
+			UPDATE webhook_delivery SET
+				next_attempt_at = utc_timestamp(next_attempt_at, %[1]s),
+				inserted_at = utc_timestamp(inserted_at, %[1]s),
+				delivered_at = utc_timestamp(delivered_at, %[1]s);
+		`, sqlString(timezone)),
+		// The rows that were stored before the bot supported multiple
+		// networks and channels belong to the default network and its
+		// default channel.
+		27: fmt.Sprintf(`
+			UPDATE log SET network = %[1]s WHERE network = '';
+			UPDATE log SET channel = %[2]s WHERE channel = '';
+			UPDATE url_check SET network = %[1]s WHERE network = '';
+			UPDATE url_check SET channel = %[2]s WHERE channel = '';
+			UPDATE factoid SET network = %[1]s WHERE network = '';
+			UPDATE factoid SET channel = %[2]s WHERE channel = '';
+			UPDATE cron SET network = %[1]s WHERE network = '';
+			UPDATE cron SET channel = %[2]s WHERE channel = '';
+			UPDATE quiz_stat SET network = %[1]s WHERE network = '';
+			UPDATE quiz_stat SET channel = %[2]s WHERE channel = '';
+		`, sqlString(network), sqlString(channel)),
 	})
 }
+
+// sqliteDialect is the dialect of SQLite, where the timestamps are stored
+// as text.
+type sqliteDialect struct{}
+
+// ilike implements the dialect interface, SQLite doesn't have ILIKE so both
+// sides are lower cased instead.
+func (sqliteDialect) ilike(column, param string) string {
+	return fmt.Sprintf("LOWER(%s) LIKE LOWER(%s)", column, param)
+}
+
+// round implements the dialect interface, the values are stored as REAL so
+// they are returned as they are.
+func (sqliteDialect) round(column string) string {
+	return column
+}
+
+// upsert implements the dialect interface.
+func (sqliteDialect) upsert(table string, columns []string, key string) string {
+	return fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders(len(columns)))
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dbCommandUsage describes the database maintenance commands.
+const dbCommandUsage = `database commands:
+  backup <file>  copy the SQLite database to file, it's consistent even if the bot is running
+  export <file>  export all tables as JSON Lines to file, - is stdout
+  import <file>  import tables that have been exported from file, - is stdin
+  prune          delete the rows that are older than the configured retention`
+
+// exportTables are the tables that are exported and imported, the migration
+// table is left out since the schema is created by the migrations.
+var exportTables = []string{
+	"log",
+	"url_check",
+	"factoid",
+	"cron",
+	"quiz_stat",
+	"supernytt",
+	"march",
+	"smhi_forecast",
+	"parcel_tracking",
+	"user_role",
+	"api_token",
+	"webhook_delivery",
+}
+
+// exportRow is a line of an export, it holds a row of a table.
+type exportRow struct {
+	Table string                 `json:"table"`
+	Row   map[string]interface{} `json:"row"`
+}
+
+// runDBCommand runs the database maintenance command in args, the output of
+// the command is written to stdout and stdin is read by import.
+func (b *bot) runDBCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", dbCommandUsage)
+	}
+
+	cmd, args := args[0], args[1:]
+	nargs, ok := map[string]int{"backup": 1, "export": 1, "import": 1, "prune": 0}[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %s\n%s", cmd, dbCommandUsage)
+	}
+	if len(args) != nargs {
+		return fmt.Errorf("wrong number of arguments to %s\n%s", cmd, dbCommandUsage)
+	}
+
+	if cmd == "backup" {
+		return b.backupDB(args[0])
+	}
+
+	if err := b.initDB(); err != nil {
+		return err
+	}
+	defer b.DB.client.Close()
+
+	switch cmd {
+	case "export":
+		return b.exportFile(args[0], stdout)
+	case "import":
+		return b.importFile(args[0], stdin, stdout)
+	}
+
+	result, err := b.prune(time.Now())
+	for _, p := range result {
+		fmt.Fprintf(stdout, "%s: deleted %d rows\n", p.Table, p.Rows)
+	}
+	return err
+}
+
+// exportFile exports the database to the file at path, - means stdout.
+func (b *bot) exportFile(path string, stdout io.Writer) error {
+	if path == "-" {
+		return b.exportDB(stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := b.exportDB(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// importFile imports the file at path, - means stdin, and writes how many
+// rows that were imported into each table to stdout.
+func (b *bot) importFile(path string, stdin io.Reader, stdout io.Writer) error {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, err := b.importDB(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range exportTables {
+		if n, ok := imported[t]; ok {
+			fmt.Fprintf(stdout, "%s: imported %d rows\n", t, n)
+		}
+	}
+
+	return nil
+}
+
+// backupDB writes a copy of the SQLite database to path. VACUUM INTO reads
+// the database in a single transaction, so the copy is consistent even if
+// the bot is writing to the database at the same time. The migrations
+// aren't run, so the copy is of the database as it is.
+func (b *bot) backupDB(path string) error {
+	if b.DB.Engine == "postgres" {
+		return fmt.Errorf("backup only supports sqlite, use pg_dump to back up postgres")
+	}
+
+	dbPath, err := b.dbPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	db, err := sql.Open(sqliteDriver, dbPath)
+	if err != nil {
+		return fmt.Errorf("can't initialize database connection: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("VACUUM INTO $1", path)
+	return err
+}
+
+// exportDB writes the rows of the exportTables to w as JSON Lines, one row
+// per line. The timestamps are exported in the format of formatTimestamp, so
+// the export can be imported into either engine.
+func (b *bot) exportDB(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, t := range exportTables {
+		if err := b.exportTable(enc, t); err != nil {
+			return fmt.Errorf("can't export %s: %v", t, err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// exportTable encodes the rows of the table with enc.
+func (b *bot) exportTable(enc *json.Encoder, table string) error {
+	types, err := b.columnTypes(table)
+	if err != nil {
+		return err
+	}
+
+	// The SQLite driver parses the columns that are declared as times
+	// on its own, and returns the zero time if it can't. They are read
+	// as text instead, since the id of smhi_forecast is declared as a
+	// timestamp even though it isn't one.
+	var columns, exprs []string
+	isTime := make(map[string]bool)
+	for _, ct := range types {
+		c := ct.Name()
+		columns = append(columns, c)
+
+		switch strings.ToUpper(ct.DatabaseTypeName()) {
+		case "TIMESTAMP", "DATETIME", "DATE":
+			if b.DB.Engine != "postgres" {
+				isTime[c] = true
+				exprs = append(exprs, "CAST("+c+" AS TEXT)")
+				continue
+			}
+		}
+		exprs = append(exprs, c)
+	}
+
+	rows, err := b.DB.client.Query("SELECT " + strings.Join(exprs, ", ") + " FROM " + table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{})
+		for i, c := range columns {
+			row[c] = exportValue(values[i], isTime[c])
+		}
+		if err := enc.Encode(exportRow{table, row}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// columnTypes returns the columns of the table.
+func (b *bot) columnTypes(table string) ([]*sql.ColumnType, error) {
+	rows, err := b.DB.client.Query("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.ColumnTypes()
+}
+
+// exportValue converts a value that has been read from the database to a
+// value that is encoded as JSON the same way regardless of the engine.
+// Times, and texts that are times if isTime is set, are formatted with
+// formatTimestamp.
+func exportValue(v interface{}, isTime bool) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return formatTimestamp(v)
+	case []byte:
+		return exportValue(string(v), isTime)
+	case string:
+		if !isTime {
+			return v
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return formatTimestamp(t)
+			}
+		}
+		return v
+	}
+
+	return v
+}
+
+// importDB inserts the rows that has been exported by exportDB into the
+// database, it returns how many rows that were imported into each table.
+// All rows are imported in a single transaction, so nothing is imported
+// if one of them can't be.
+func (b *bot) importDB(r io.Reader) (map[string]int, error) {
+	tables := make(map[string]bool)
+	for _, t := range exportTables {
+		tables[t] = true
+	}
+
+	tx, err := b.DB.client.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	imported := make(map[string]int)
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for line := 1; ; line++ {
+		var e exportRow
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if !tables[e.Table] {
+			return nil, fmt.Errorf("line %d: unknown table %q", line, e.Table)
+		}
+
+		var columns []string
+		for c := range e.Row {
+			if !isIdentifier(c) {
+				return nil, fmt.Errorf("line %d: invalid column %q", line, c)
+			}
+			columns = append(columns, c)
+		}
+		sort.Strings(columns)
+
+		args := make([]interface{}, len(columns))
+		for i, c := range columns {
+			args[i] = importValue(e.Row[c])
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES(%s)", e.Table, strings.Join(columns, ", "), placeholders(len(columns)))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, fmt.Errorf("line %d: can't import into %s: %v", line, e.Table, err)
+		}
+		imported[e.Table]++
+	}
+
+	return imported, tx.Commit()
+}
+
+// importValue converts a value of an export to a value that can be passed
+// to the database, the numbers are passed as text and converted by the
+// database to the type of the column.
+func importValue(v interface{}) interface{} {
+	if n, ok := v.(json.Number); ok {
+		return n.String()
+	}
+
+	return v
+}
+
+// isIdentifier returns true if s only contains lower case letters, digits
+// and underscores.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/smhi"
+)
+
+// newDBCommandBot returns a bot with a new SQLite database at path.
+func newDBCommandBot(t *testing.T, path string) *bot {
+	b := &bot{logger: newLogger(&logOutput{w: ioutil.Discard})}
+	b.DB.Path = path
+	if err := b.initDB(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// count returns the number of rows in the table of the SQLite database.
+func count(t *testing.T, path, table string) int {
+	b := newDBCommandBot(t, path)
+	defer b.DB.client.Close()
+
+	var n int
+	if err := b.DB.client.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDBCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-dbcmd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bot.db")
+	b := newDBCommandBot(t, path)
+	now := time.Now().UTC().Truncate(time.Hour)
+	for _, e := range []logEntry{
+		{newUUID(), now.AddDate(0, 0, -40), "test", "#bot", "alice", "old"},
+		{newUUID(), now, "test", "#bot", "alice", "new"},
+	} {
+		if err := b.store.log.insert(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.store.cron.insert(cronEntry{newUUID(), "test", "#bot", "0 12 * * *", "lunch", false, 0, 0, now}); err != nil {
+		t.Fatal(err)
+	}
+	smhiID := fmt.Sprintf("%s-%s", formatTimestamp(now), "stockholm")
+	if err := b.store.smhiForecast.upsert(smhiID, smhiID+"|hash", now, now, "stockholm", "en-US", smhi.Forecast{AirTemperature: 12.5}); err != nil {
+		t.Fatal(err)
+	}
+	b.DB.client.Close()
+
+	run := func(b *bot, args ...string) (string, error) {
+		var out bytes.Buffer
+		err := b.runDBCommand(args, strings.NewReader(""), &out)
+		return out.String(), err
+	}
+
+	backup := filepath.Join(dir, "backup.db")
+	if _, err := run(b, "backup", backup); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, backup, "log"); n != 2 {
+		t.Errorf("expected two messages in the backup, got %d", n)
+	}
+	if _, err := run(b, "backup", backup); err == nil {
+		t.Error("expected an error when the backup already exists")
+	}
+
+	export := filepath.Join(dir, "export.jsonl")
+	if _, err := run(b, "export", export); err != nil {
+		t.Fatal(err)
+	}
+
+	// The export is imported into a new database, which is what is done
+	// when moving to another engine.
+	imported := &bot{logger: b.logger}
+	imported.DB.Path = filepath.Join(dir, "imported.db")
+	out, err := run(imported, "import", export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "log: imported 2 rows\ncron: imported 1 rows\nsmhi_forecast: imported 1 rows\n"; out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	i := newDBCommandBot(t, imported.DB.Path)
+	entries, err := i.store.log.between("test", "#bot", now, now.Add(time.Hour))
+	if err != nil || len(entries) != 1 || entries[0].Message != "new" || !entries[0].Timestamp.Equal(now) {
+		t.Errorf("expected the new message to be imported, got %v, %v", entries, err)
+	}
+	jobs, err := i.store.cron.active()
+	if err != nil || len(jobs) != 1 || jobs[0].IsLimited || !jobs[0].InsertedAt.Equal(now) {
+		t.Errorf("expected the cron job to be imported, got %v, %v", jobs, err)
+	}
+	forecasts, err := i.store.smhiForecast.between("stockholm", now, now.Add(time.Hour))
+	if err != nil || len(forecasts) != 1 || !strings.HasPrefix(forecasts[0].AirTemperature, "12.5") {
+		t.Errorf("expected the forecast to be imported, got %v, %v", forecasts, err)
+	}
+	var id string
+	if err := i.DB.client.QueryRow("SELECT CAST(id AS TEXT) FROM smhi_forecast").Scan(&id); err != nil || id != smhiID {
+		t.Errorf("expected the id of the forecast to be %s, got %s, %v", smhiID, id, err)
+	}
+	i.DB.client.Close()
+
+	// Nothing is imported if a row can't be imported.
+	if _, err := run(imported, "import", export); err == nil {
+		t.Error("expected an error when the rows already exist")
+	}
+	if n := count(t, imported.DB.Path, "log"); n != 2 {
+		t.Errorf("expected the failed import to be rolled back, got %d messages", n)
+	}
+
+	b.config.retention.RetentionLog = 30
+	out, err = run(b, "prune")
+	if err != nil || out != "log: deleted 1 rows\n" {
+		t.Errorf("expected the old message to be pruned, got %q, %v", out, err)
+	}
+	if n := count(t, path, "log"); n != 1 {
+		t.Errorf("expected one message to be left, got %d", n)
+	}
+
+	for _, args := range [][]string{{}, {"restore", "file"}, {"export"}, {"prune", "all"}} {
+		if _, err := run(b, args...); err == nil {
+			t.Errorf("expected an error when running %v", args)
+		}
+	}
+}
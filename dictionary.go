@@ -4,12 +4,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"strings"
-
-	"github.com/osm/irc"
 )
 
+// dictionaryConfig holds the configuration of the dictionaries module.
+type dictionaryConfig struct {
+	Dictionaries []struct {
+		Trigger     string `json:"trigger"`
+		Dictionary  string `json:"dictionary"`
+		FoundMsg    string `json:"foundMsg"`
+		NotFoundMsg string `json:"notFoundMsg"`
+	} `json:"dictionaries"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:     featureDictionaries,
+		config:   func(b *bot) interface{} { return &b.config.dictionary },
+		enabled:  func(b *bot) bool { return len(b.config.dictionary.Dictionaries) > 0 },
+		check:    (*bot).checkDictionaries,
+		init:     (*bot).initDictionaries,
+		commands: (*bot).dictionaryCommands,
+	})
+}
+
 type dictionary struct {
 	dictionary  map[string]string
 	foundMsg    string
@@ -18,24 +37,44 @@ type dictionary struct {
 
 var dictionaries map[string]dictionary
 
+// readDictionary reads and decodes the dictionary file.
+func readDictionary(path string) (map[string]string, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open dictionary %s", path)
+	}
+
+	var di map[string]string
+	if err = json.Unmarshal(file, &di); err != nil {
+		return nil, fmt.Errorf("can't decode dictionary %s", path)
+	}
+
+	return di, nil
+}
+
+// checkDictionaries makes sure that all dictionaries can be read.
+func (b *bot) checkDictionaries() error {
+	var errs configErrors
+	for i, d := range b.config.dictionary.Dictionaries {
+		if _, err := readDictionary(d.Dictionary); err != nil {
+			errs = append(errs, configError{fmt.Sprintf("dictionaries[%d].dictionary", i), err.Error()})
+		}
+	}
+
+	return errs.err()
+}
+
 // initDictionaries initializes the dictionaries.
 func (b *bot) initDictionaries() {
 	dictionaries = make(map[string]dictionary)
 
 	// Iterate over the dictionaries, read the dictionary into memory and
 	// store it in the global dictionary map.
-	for _, d := range b.IRC.Dictionaries {
-		file, err := ioutil.ReadFile(d.Dictionary)
+	for _, d := range b.config.dictionary.Dictionaries {
+		di, err := readDictionary(d.Dictionary)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dictionary: cant open dictionary %s\n", d.Dictionary)
-			os.Exit(1)
-		}
-
-		var di map[string]string
-		err = json.Unmarshal(file, &di)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "dictionary: cant decode dictionary %s\n", d.Dictionary)
-			os.Exit(1)
+			b.log(featureDictionaries).Errorf("initDictionaries: %v", err)
+			continue
 		}
 
 		dictionaries[d.Trigger] = dictionary{
@@ -46,32 +85,41 @@ func (b *bot) initDictionaries() {
 	}
 }
 
-func (b *bot) dictionaryHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+// dictionaryCommands returns a command for each configured dictionary, the
+// trigger of the dictionary is used as the command name.
+func (b *bot) dictionaryCommands() []*botCommand {
+	var cmds []*botCommand
 
-	if !a.validChannel {
-		return
+	for _, d := range b.config.dictionary.Dictionaries {
+		entry := dictionaries[d.Trigger]
+		cmds = append(cmds, &botCommand{
+			name:    d.Trigger,
+			usage:   "<key>",
+			maxArgs: unlimitedArgs,
+			feature: featureDictionaries,
+			fn: func(b *bot, a *privmsgAction) {
+				b.dictionaryLookup(entry, a)
+			},
+		})
 	}
 
-	if b.shouldIgnore(m) {
-		return
-	}
+	return cmds
+}
 
-	entry, hasEntry := dictionaries[a.cmd]
-	if !hasEntry {
-		return
-	}
+// dictionaryLookup looks up the arguments of the action in the dictionary
+// and sends the result to the channel.
+func (b *bot) dictionaryLookup(entry dictionary, a *privmsgAction) {
 
 	key := strings.Join(a.args, " ")
 	value, hasValue := entry.dictionary[key]
 	if !hasValue {
-		b.privmsgph(entry.notFoundMsg, map[string]string{
+		a.replyph(entry.notFoundMsg, map[string]string{
 			"<key>": key,
 		})
 		return
 	}
 
-	b.privmsgph(entry.foundMsg, map[string]string{
+	a.replyph(entry.foundMsg, map[string]string{
 		"<key>":   key,
 		"<value>": value,
 	})
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/osm/irc"
+)
+
+// unlimitedArgs can be used as the maxArgs value of a command that accepts
+// any number of arguments.
+const unlimitedArgs = -1
+
+// dispatcherConfig holds the configuration of the command dispatcher.
+type dispatcherConfig struct {
+	// CommandPrefix is the prefix that all commands starts with, it
+	// defaults to "!". The prefix is stripped from the configured
+	// command names, so "!factoid" and "factoid" are the same command.
+	CommandPrefix string `json:"commandPrefix"`
+
+	// CommandAliases maps a command name to a list of aliases that can
+	// be used instead of the name.
+	CommandAliases map[string][]string `json:"commandAliases"`
+	commandAliases map[string]string
+
+	// DisableCommandSuggestions turns off the "did you mean" replies
+	// that are sent when an unknown command is used.
+	DisableCommandSuggestions bool `json:"disableCommandSuggestions"`
+
+	CommandMsgUsage      string `json:"commandMsgUsage"`
+	CommandMsgDidYouMean string `json:"commandMsgDidYouMean"`
+
+	HelpCmd         string `json:"helpCmd"`
+	HelpMsgCommands string `json:"helpMsgCommands"`
+	HelpErrUnknown  string `json:"helpErrUnknown"`
+
+	// coreCommands contains the commands that doesn't belong to a module.
+	coreCommands []*botCommand
+}
+
+// botCommand is an IRC command that is handled by the command dispatcher.
+// The dispatcher makes sure that the command is allowed in the channel, that
+// the user isn't ignored and that the number of arguments are correct before
+// fn is called.
+type botCommand struct {
+	// name of the command, without the prefix.
+	name string
+
+	// usage describes the arguments of the command, it's used when the
+	// help and usage messages are constructed.
+	usage string
+
+	// minArgs and maxArgs limits the number of arguments that the
+	// command accepts, maxArgs can be set to unlimitedArgs.
+	minArgs int
+	maxArgs int
+
+	// feature must be allowed in the channel for the command to be
+	// available, commands without a feature can be used everywhere,
+	// including private messages.
+	feature string
+
+	// role is the role that the user needs to use the command, empty
+	// means that everyone is allowed to use it.
+	role string
+
+	// subCommands contains the sub commands of the command. The first
+	// argument selects the sub command and the remaining arguments are
+	// passed on to it. fn is not used if there are sub commands.
+	subCommands []*botCommand
+
+	// fn executes the command. The args of the action contains the
+	// arguments after the command, and sub command, names.
+	fn func(b *bot, a *privmsgAction)
+}
+
+// initDispatcher sets the default values of the dispatcher and creates the
+// commands that doesn't belong to a module.
+func (b *bot) initDispatcher() {
+	if b.IRC.CommandPrefix == "" {
+		b.IRC.CommandPrefix = "!"
+	}
+	if b.IRC.CommandMsgUsage == "" {
+		b.IRC.CommandMsgUsage = "usage: <usage>"
+	}
+	if b.IRC.CommandMsgDidYouMean == "" {
+		b.IRC.CommandMsgDidYouMean = "did you mean <command>?"
+	}
+	if b.IRC.HelpCmd == "" {
+		b.IRC.HelpCmd = "help"
+	}
+	if b.IRC.HelpMsgCommands == "" {
+		b.IRC.HelpMsgCommands = "commands: <commands>"
+	}
+	if b.IRC.HelpErrUnknown == "" {
+		b.IRC.HelpErrUnknown = "<command> is not a command"
+	}
+
+	// Build a map from alias to command name, checkConfig makes sure
+	// that the same alias isn't used for more than one command.
+	b.IRC.commandAliases = make(map[string]string)
+	for name, aliases := range b.IRC.CommandAliases {
+		for _, alias := range aliases {
+			b.IRC.commandAliases[b.commandName(alias)] = b.commandName(name)
+		}
+	}
+
+	b.IRC.coreCommands = []*botCommand{
+		b.helpCommand(),
+		b.moduleCommand(),
+		b.roleCommand(),
+		b.apiTokenCommand(),
+		b.reloadCommand(),
+	}
+	b.normalizeCommands(b.IRC.coreCommands)
+}
+
+// commandName returns the name of the command without the prefix.
+func (b *bot) commandName(cmd string) string {
+	return strings.TrimPrefix(cmd, b.IRC.CommandPrefix)
+}
+
+// normalizeCommands strips the prefix from the names of the given commands,
+// this makes it possible to keep the prefix in the configured command names.
+func (b *bot) normalizeCommands(cmds []*botCommand) {
+	for _, c := range cmds {
+		c.name = b.commandName(c.name)
+	}
+}
+
+// parseCommandName returns the name of the command that the action invokes,
+// aliases are resolved to the name of the command. False is returned if the
+// message doesn't start with the command prefix.
+func (b *bot) parseCommandName(a *privmsgAction) (string, bool) {
+	if !strings.HasPrefix(a.cmd, b.IRC.CommandPrefix) {
+		return "", false
+	}
+
+	name := b.commandName(a.cmd)
+	if name == "" {
+		return "", false
+	}
+
+	if c, ok := b.IRC.commandAliases[name]; ok {
+		name = c
+	}
+
+	return name, true
+}
+
+// isCommand returns true if the action invokes the command with the given
+// name, either by its name or by one of its aliases.
+func (b *bot) isCommand(a *privmsgAction, cmd string) bool {
+	name, ok := b.parseCommandName(a)
+	return ok && name == b.commandName(cmd)
+}
+
+// availableCommands returns the commands that can be used in the target of
+// the action, that is the commands of all enabled modules that are allowed
+// in the channel.
+func (b *bot) availableCommands(a *privmsgAction) []*botCommand {
+	cmds := append([]*botCommand{}, b.IRC.coreCommands...)
+
+	b.modules.mu.Lock()
+	for _, m := range modules {
+		if s, ok := b.modules.state[m.name]; ok && s.enabled {
+			cmds = append(cmds, s.commands...)
+		}
+	}
+	b.modules.mu.Unlock()
+
+	var available []*botCommand
+	for _, c := range cmds {
+		if c.name == "" {
+			continue
+		}
+		if c.feature != "" && !a.network.hasFeature(a.target, c.feature) {
+			continue
+		}
+		available = append(available, c)
+	}
+
+	return available
+}
+
+// findCommand returns the command with the given name if it's available in
+// the target of the action, nil is returned otherwise.
+func (b *bot) findCommand(a *privmsgAction, name string) *botCommand {
+	for _, c := range b.availableCommands(a) {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// dispatchHandler is the single entry point for all commands. It looks up the
+// command, verifies that the user isn't ignored and executes it.
+func (b *bot) dispatchHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
+	b.metrics.messagesReceived.inc(n.Name)
+
+	name, ok := b.parseCommandName(a)
+	if !ok {
+		return
+	}
+
+	c := b.findCommand(a, name)
+	if c == nil {
+		b.suggestCommand(a, m, name)
+		return
+	}
+
+	if b.shouldIgnore(n, m) {
+		return
+	}
+
+	b.runCommand(a, c, b.IRC.CommandPrefix+name)
+}
+
+// runCommand checks that the user has the role that the command requires,
+// selects the sub command, if there are any, checks the number of arguments
+// and executes the command. A usage message is sent if the
+// arguments are invalid. path is the command as it was invoked, including
+// the names of the parent commands.
+func (b *bot) runCommand(a *privmsgAction, c *botCommand, path string) {
+	a.replyMode = b.commandReplyMode(path)
+	if !b.checkRole(a, c, path) {
+		return
+	}
+
+	if len(c.subCommands) > 0 {
+		if len(a.args) > 0 {
+			for _, s := range c.subCommands {
+				if s.name == "" || s.name != a.args[0] {
+					continue
+				}
+
+				// The sub command gets a copy of the action
+				// without the sub command name in its args.
+				sub := *a
+				sub.args = a.args[1:]
+				b.runCommand(&sub, s, path+" "+s.name)
+				return
+			}
+		}
+
+		b.sendUsage(a, commandUsage(path, c))
+		return
+	}
+
+	if len(a.args) < c.minArgs || (c.maxArgs != unlimitedArgs && len(a.args) > c.maxArgs) {
+		b.sendUsage(a, commandUsage(path, c))
+		return
+	}
+
+	b.metrics.commands.inc(b.commandName(path))
+
+	// The replies are held back until the command returns, so that long
+	// replies can be sent as a private message instead.
+	a.bufferReplies()
+	c.fn(b, a)
+	b.flushReplies(a)
+}
+
+// sendUsage sends the usage message to the target of the action.
+func (b *bot) sendUsage(a *privmsgAction, usage string) {
+	a.replyph(b.IRC.CommandMsgUsage, map[string]string{
+		"<usage>": usage,
+	})
+}
+
+// commandUsage returns the usage string of the command, the names of the sub
+// commands are listed if it has any.
+func commandUsage(path string, c *botCommand) string {
+	if len(c.subCommands) > 0 {
+		var names []string
+		for _, s := range c.subCommands {
+			if s.name != "" {
+				names = append(names, s.name)
+			}
+		}
+		return path + " " + strings.Join(names, "|")
+	}
+
+	return strings.TrimSpace(path + " " + c.usage)
+}
+
+// commandUsages returns the usage strings of the command and all its sub
+// commands.
+func commandUsages(path string, c *botCommand) []string {
+	if len(c.subCommands) == 0 {
+		return []string{commandUsage(path, c)}
+	}
+
+	var usages []string
+	for _, s := range c.subCommands {
+		if s.name != "" {
+			usages = append(usages, commandUsages(path+" "+s.name, s)...)
+		}
+	}
+
+	return usages
+}
+
+// helpCommand returns the help command, which lists all available commands
+// or prints the usage of the given command.
+func (b *bot) helpCommand() *botCommand {
+	return &botCommand{
+		name:    b.IRC.HelpCmd,
+		usage:   "[command]",
+		maxArgs: 1,
+		fn:      (*bot).help,
+	}
+}
+
+// help executes the help command.
+func (b *bot) help(a *privmsgAction) {
+	if len(a.args) == 0 {
+		var names []string
+		for _, c := range b.availableCommands(a) {
+			names = append(names, b.IRC.CommandPrefix+c.name)
+		}
+		sort.Strings(names)
+
+		a.replyph(b.IRC.HelpMsgCommands, map[string]string{
+			"<commands>": strings.Join(names, ", "),
+		})
+		return
+	}
+
+	// The command can be given with or without the prefix.
+	name := b.commandName(a.args[0])
+	if c, ok := b.IRC.commandAliases[name]; ok {
+		name = c
+	}
+
+	c := b.findCommand(a, name)
+	if c == nil {
+		a.replyUrgentph(b.IRC.HelpErrUnknown, map[string]string{
+			"<command>": a.args[0],
+		})
+		return
+	}
+
+	for _, u := range commandUsages(b.IRC.CommandPrefix+c.name, c) {
+		b.sendUsage(a, u)
+	}
+}
+
+// suggestCommand sends a "did you mean" message if there is an available
+// command with a name that is close to the given name.
+func (b *bot) suggestCommand(a *privmsgAction, m *irc.Message, name string) {
+	if b.IRC.DisableCommandSuggestions {
+		return
+	}
+
+	// Short names only allows one edit, longer names allows two. The
+	// distance must also be shorter than the name itself, otherwise
+	// every single character command would be suggested.
+	maxDistance := 1
+	if len(name) > 4 {
+		maxDistance = 2
+	}
+
+	suggestion := ""
+	for _, c := range b.availableCommands(a) {
+		d := levenshtein(name, c.name)
+		if d > 0 && d <= maxDistance && d < len(name) {
+			suggestion = c.name
+			maxDistance = d - 1
+		}
+	}
+
+	if suggestion == "" || b.shouldIgnore(a.network, m) {
+		return
+	}
+
+	// Factoid triggers can start with the command prefix too, the
+	// factoid module replies to them so there's nothing to suggest.
+	if b.isFactoidTrigger(a) {
+		return
+	}
+
+	a.replyph(b.IRC.CommandMsgDidYouMean, map[string]string{
+		"<command>": b.IRC.CommandPrefix + suggestion,
+	})
+}
+
+// levenshtein returns the edit distance between the two strings.
+func levenshtein(s, t string) int {
+	a, b := []rune(s), []rune(t)
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = prev[j] + 1
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if prev[j-1]+cost < curr[j] {
+				curr[j] = prev[j-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuggestCommand(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableFactoid": true})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!factoiq")
+	s.expect("PRIVMSG #bot :did you mean !factoid?")
+
+	// Names that are too far from every command aren't suggested.
+	s.privmsg("alice", "#bot", "!coffee")
+	s.expectNo("PRIVMSG #bot :did you mean", 200*time.Millisecond)
+
+	// A factoid trigger that starts with the prefix only gets the reply
+	// of the factoid, which might be sent before or after a suggestion.
+	s.privmsg("alice", "#bot", "!factoid add !factoix _is_ a typo")
+	s.expect("PRIVMSG #bot :noted")
+	s.privmsg("bob", "#bot", "!factoix")
+	s.expectNo("PRIVMSG #bot :did you mean", 300*time.Millisecond)
+}
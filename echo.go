@@ -1,12 +1,85 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// echoClient is a client of the echo route. The requests of the client are
+// authenticated with the token as a bearer token, or with an HMAC-SHA256
+// signature of the body that is made with the secret.
+type echoClient struct {
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+	Secret string `json:"secret"`
+
+	// RateLimit is the number of lines per minute that the client can
+	// send and MaxLines is the maximum number of lines of a request,
+	// they default to the echo settings.
+	RateLimit int `json:"rateLimit"`
+	MaxLines  int `json:"maxLines"`
+}
+
+// echoResponse is the JSON that is returned when the lines are queued.
+type echoResponse struct {
+	Queued  int    `json:"queued"`
+	Network string `json:"network"`
+	Target  string `json:"target"`
+	Command string `json:"command"`
+}
+
+// echoLimiter holds the rate limits of the echo clients. Each client has a
+// bucket that holds at most the rate limit of the client and that is
+// refilled with the rate limit each minute, each line takes one from the
+// bucket. The buckets are kept by name so that they survive a reload.
+type echoLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*echoBucket
+}
+
+// echoBucket is the rate limit bucket of a client.
+type echoBucket struct {
+	lines  float64
+	filled time.Time
+}
+
+// take takes the lines from the bucket of the client, false is returned
+// together with how long the client has to wait if there aren't enough
+// lines left.
+func (l *echoLimiter) take(name string, rate, lines int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*echoBucket)
+	}
+
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &echoBucket{lines: float64(rate), filled: now}
+		l.buckets[name] = b
+	}
+
+	perSecond := float64(rate) / 60
+	b.lines = math.Min(float64(rate), b.lines+now.Sub(b.filled).Seconds()*perSecond)
+	b.filled = now
+
+	if b.lines < float64(lines) {
+		return false, time.Duration((float64(lines) - b.lines) / perSecond * float64(time.Second))
+	}
+
+	b.lines -= float64(lines)
+	return true, 0
+}
+
 // initEcho initializes the default values for the echo route.
 func (b *bot) initEcho() {
 	if b.HTTP.EchoRoute == "" {
@@ -15,30 +88,168 @@ func (b *bot) initEcho() {
 	if b.HTTP.EchoMethod == "" {
 		b.HTTP.EchoMethod = "POST"
 	}
+	if b.HTTP.EchoRateLimit == 0 {
+		b.HTTP.EchoRateLimit = 30
+	}
+	if b.HTTP.EchoMaxLines == 0 {
+		b.HTTP.EchoMaxLines = 10
+	}
+}
+
+// checkEchoConfig makes sure that the echo route can only be used by
+// clients that has a token or a secret.
+func (b *bot) checkEchoConfig() configErrors {
+	var errs configErrors
+	if !b.HTTP.EnableHTTP || !b.HTTP.EnableEcho {
+		return errs
+	}
+
+	if len(b.HTTP.EchoClients) == 0 {
+		errs = append(errs, configError{"http.echoClients", "echo requires at least one client"})
+	}
+
+	names := make(map[string]bool)
+	for i, c := range b.HTTP.EchoClients {
+		path := fmt.Sprintf("http.echoClients[%d]", i)
+		if c.Name == "" {
+			errs = append(errs, configError{path + ".name", "is missing"})
+		} else if names[c.Name] {
+			errs = append(errs, configError{path + ".name", fmt.Sprintf("%q is used by more than one client", c.Name)})
+		}
+		names[c.Name] = true
+
+		if c.Token == "" && c.Secret == "" {
+			errs = append(errs, configError{path, "token or secret is required"})
+		}
+		if c.RateLimit < 0 {
+			errs = append(errs, configError{path + ".rateLimit", "can't be negative"})
+		}
+		if c.MaxLines < 0 {
+			errs = append(errs, configError{path + ".maxLines", "can't be negative"})
+		}
+	}
+
+	return errs
 }
 
-// echoHandler takes the POST-data and sends it back to the IRC channel as a
-// regular IRC message.
+// echoClient returns the client that the request is authenticated as, the
+// bearer token is tried first and then the signature of the body. Nil is
+// returned if the request can't be authenticated.
+func (b *bot) echoClient(r *http.Request, body []byte) *echoClient {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for _, c := range b.HTTP.EchoClients {
+			if c.Token != "" && subtle.ConstantTimeCompare(token, []byte(c.Token)) == 1 {
+				return c
+			}
+		}
+	}
+
+	if sig := r.Header.Get("X-Signature-256"); sig != "" {
+		sig = strings.TrimPrefix(sig, "sha256=")
+		for _, c := range b.HTTP.EchoClients {
+			if c.Secret != "" && verifyHMAC(c.Secret, body, sig) {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// echoHandler takes the body of the request and sends each line of it to
+// IRC. The request must be authenticated as one of the echo clients. The
+// target, network and command query parameters sets where the lines are
+// sent and how, they default to the default channel of the default network
+// and PRIVMSG. A channel target must be one of the channels of the network.
+// The number of lines that were queued, after the body has been split into
+// IRC lines, is returned as JSON.
 func (b *bot) echoHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
 	if err != nil {
-		b.HTTP.logger.Printf("echo: unable to read body, %v", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		b.HTTP.logger.Errorf("echo: unable to read body, %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "error reading request body")
+		return
 	}
 
-	data := string(body)
-	if data == "" {
+	c := b.echoClient(r, body)
+	if c == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "a valid token or signature is required")
 		return
 	}
 
-	rows := strings.Split(data, "\n")
-	for _, o := range rows {
-		if o == "" {
-			continue
-		}
+	q := r.URL.Query()
+	n := b.defaultNetwork()
+	if name := q.Get("network"); name != "" {
+		n = b.network(name)
+	}
+	if n == nil {
+		writeAPIError(w, http.StatusBadRequest, "unknown network %s", q.Get("network"))
+		return
+	}
 
-		b.privmsg(o)
+	target := q.Get("target")
+	if target == "" {
+		target = n.defaultChannel()
+	}
+	if strings.ContainsAny(target, " ,\r\n") {
+		writeAPIError(w, http.StatusBadRequest, "invalid target %s", target)
+		return
+	}
+	if strings.IndexAny(target, "#&") == 0 && n.channel(target) == nil {
+		writeAPIError(w, http.StatusBadRequest, "%s is not a channel of %s", target, n.Name)
+		return
 	}
 
-	fmt.Fprintf(w, data)
+	command := strings.ToUpper(q.Get("command"))
+	if command == "" {
+		command = "PRIVMSG"
+	}
+	if command != "PRIVMSG" && command != "NOTICE" {
+		writeAPIError(w, http.StatusBadRequest, "command must be privmsg or notice")
+		return
+	}
+
+	// The limits apply to the lines that are sent to IRC, so the body is
+	// split the same way as any other message.
+	lines := splitMessage(string(body), n.maxMessageLength(command, target))
+
+	maxLines, rate := b.HTTP.EchoMaxLines, b.HTTP.EchoRateLimit
+	if c.MaxLines > 0 {
+		maxLines = c.MaxLines
+	}
+	if c.RateLimit > 0 {
+		rate = c.RateLimit
+	}
+
+	// A request can't have more lines than what fits in the bucket.
+	if maxLines > rate {
+		maxLines = rate
+	}
+	if len(lines) > maxLines {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "at most %d lines can be sent", maxLines)
+		return
+	}
+
+	if len(lines) > 0 {
+		if ok, wait := b.echoLimits.take(c.Name, rate, len(lines), time.Now()); !ok {
+			b.HTTP.logger.Warnf("echo: %s is rate limited", c.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, "rate limit of %d lines per minute exceeded", rate)
+			return
+		}
+	}
+
+	// The lines are reported as queued, so they can't use the background
+	// priority since those messages might be dropped by the queue.
+	n.queue.push(priorityReply, command, target, lines)
+
+	b.HTTP.logger.Infof("echo: %s queued %d lines to %s on %s", c.Name, len(lines), target, n.Name)
+	writeJSON(w, http.StatusOK, echoResponse{
+		Queued:  len(lines),
+		Network: n.Name,
+		Target:  target,
+		Command: command,
+	})
 }
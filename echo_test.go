@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newEchoRequest returns a request to the echo route with the body that is
+// authenticated with the token.
+func newEchoRequest(body, token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestEchoLines(t *testing.T) {
+	tests := []struct {
+		body  string
+		lines []string
+	}{
+		{"hello\r\nworld\n", []string{"hello", "world"}},
+
+		// An embedded CR must not end up in the middle of a line,
+		// since it would let the client send any command.
+		{"hi\rQUIT :bye", []string{"hi", "QUIT :bye"}},
+		{"hi\x00\rJOIN #secret\x00", []string{"hi", "JOIN #secret"}},
+	}
+
+	for _, test := range tests {
+		b, n := newWebhookTestBot()
+		b.initEcho()
+		b.HTTP.EchoClients = []*echoClient{{Name: "ci", Token: "token"}}
+
+		w := httptest.NewRecorder()
+		b.echoHandler(w, newEchoRequest(test.body, "token"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status %d, got %d", test.body, http.StatusOK, w.Code)
+		}
+
+		var lines []string
+		for _, m := range n.queue.queues[priorityReply] {
+			lines = append(lines, m.line)
+		}
+		if strings.Join(lines, "|") != strings.Join(test.lines, "|") {
+			t.Errorf("%q: expected %q, got %q", test.body, test.lines, lines)
+		}
+	}
+
+	// The number of queued lines is the number of lines that are sent
+	// to IRC, a long line is sent as several.
+	b, n := newWebhookTestBot()
+	b.initEcho()
+	b.HTTP.EchoClients = []*echoClient{{Name: "ci", Token: "token"}}
+
+	w := httptest.NewRecorder()
+	b.echoHandler(w, newEchoRequest(strings.Repeat("word ", 200)+"\nshort", "token"))
+	var res echoResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if l := n.queue.length(); l != 4 || res.Queued != l {
+		t.Errorf("expected 4 queued lines, got %d and %d in the queue", res.Queued, l)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"math/rand"
 	"regexp"
@@ -11,6 +10,64 @@ import (
 	"github.com/osm/irc"
 )
 
+// factoidConfig holds the configuration of the factoid module.
+type factoidConfig struct {
+	EnableFactoid bool `json:"enableFactoid"`
+	FactoidRate   int  `json:"factoidRate"`
+
+	FactoidCmd                string `json:"factoidCmd"`
+	FactoidSubCmdAdd          string `json:"factoidSubCmdAdd"`
+	FactoidSubCmdAddDelimiter string `json:"factoidSubCmdAddDelimiter"`
+	FactoidSubCmdDelete       string `json:"factoidSubCmdDelete"`
+	FactoidSubCmdSnoop        string `json:"factoidSubCmdSnoop"`
+	FactoidSubCmdSnoopAuthor  string `json:"factoidSubCmdSnoopAuthor"`
+	FactoidSubCmdSnoopReply   string `json:"factoidSubCmdSnoopReply"`
+	FactoidSubCmdCount        string `json:"factoidSubCmdCount"`
+
+	FactoidGrammarAction      string `json:"factoidGrammarAction"`
+	FactoidGrammarRandomWho   string `json:"factoidGrammarRandomWho"`
+	FactoidGrammarRandomWord  string `json:"factoidGrammarRandomWord"`
+	FactoidGrammarReply       string `json:"factoidGrammarReply"`
+	FactoidGrammarWho         string `json:"factoidGrammarWho"`
+	FactoidGrammarGiphy       string `json:"factoidGrammarGiphy"`
+	FactoidGrammarGiphySearch string `json:"factoidGrammarGiphySearch"`
+	FactoidGrammarTenorSearch string `json:"factoidGrammarTenorSearch"`
+
+	FactoidMsgAdd    string `json:"factoidMsgAdd"`
+	FactoidMsgDelete string `json:"factoidMsgDelete"`
+	FactoidMsgIs     string `json:"factoidMsgIs"`
+	FactoidMsgSnoop  string `json:"factoidMsgSnoop"`
+	FactoidMsgCount  string `json:"factoidMsgCount"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureFactoid,
+		config:  func(b *bot) interface{} { return &b.config.factoid },
+		enabled: func(b *bot) bool { return b.config.factoid.EnableFactoid },
+		check:   (*bot).checkFactoidConfig,
+		init:    (*bot).initFactoidDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).factoidHandler},
+		},
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.factoid.FactoidCmd,
+				feature: featureFactoid,
+				subCommands: []*botCommand{
+					{name: b.config.factoid.FactoidSubCmdAdd, usage: "<trigger>" + b.config.factoid.FactoidSubCmdAddDelimiter + "<reply>", minArgs: 3, maxArgs: unlimitedArgs, fn: (*bot).factoidAddCommand},
+					{name: b.config.factoid.FactoidSubCmdDelete, usage: "<id>", minArgs: 1, maxArgs: 1, role: roleTrusted, fn: (*bot).factoidDeleteCommand},
+					{name: b.config.factoid.FactoidSubCmdSnoop, usage: "<trigger>", minArgs: 1, maxArgs: unlimitedArgs, fn: (*bot).factoidSnoopCommand},
+					{name: b.config.factoid.FactoidSubCmdSnoopAuthor, usage: "<author>", minArgs: 1, maxArgs: unlimitedArgs, fn: (*bot).factoidSnoopAuthorCommand},
+					{name: b.config.factoid.FactoidSubCmdSnoopReply, usage: "<reply>", minArgs: 1, maxArgs: unlimitedArgs, fn: (*bot).factoidSnoopReplyCommand},
+					{name: b.config.factoid.FactoidSubCmdCount, usage: "<trigger>", minArgs: 1, maxArgs: unlimitedArgs, fn: (*bot).factoidCountCommand},
+				},
+			}}
+		},
+	})
+}
+
 // factoidRandom initializes the random source.
 var factoidRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
 
@@ -26,251 +83,217 @@ var factoidGrammarTenorSearchRegexp *regexp.Regexp
 // <randomword words="xxx"> tags.
 var factoidGrammarRandomWord *regexp.Regexp
 
+// checkFactoidConfig makes sure that the configured grammar regexps compiles.
+func (b *bot) checkFactoidConfig() error {
+	return checkRegexps(map[string]string{
+		"factoidGrammarRandomWord":  b.config.factoid.FactoidGrammarRandomWord,
+		"factoidGrammarGiphySearch": b.config.factoid.FactoidGrammarGiphySearch,
+		"factoidGrammarTenorSearch": b.config.factoid.FactoidGrammarTenorSearch,
+	})
+}
+
 // initFactoidDefaults sets default values for all settings.
 func (b *bot) initFactoidDefaults() {
 	// Commands
-	if b.IRC.FactoidCmd == "" {
-		b.IRC.FactoidCmd = "!factoid"
+	if b.config.factoid.FactoidCmd == "" {
+		b.config.factoid.FactoidCmd = "factoid"
 	}
-	if b.IRC.FactoidSubCmdAdd == "" {
-		b.IRC.FactoidSubCmdAdd = "add"
+	if b.config.factoid.FactoidSubCmdAdd == "" {
+		b.config.factoid.FactoidSubCmdAdd = "add"
 	}
-	if b.IRC.FactoidSubCmdAddDelimiter == "" {
-		b.IRC.FactoidSubCmdAddDelimiter = " _is_ "
+	if b.config.factoid.FactoidSubCmdAddDelimiter == "" {
+		b.config.factoid.FactoidSubCmdAddDelimiter = " _is_ "
 	}
-	if b.IRC.FactoidSubCmdDelete == "" {
-		b.IRC.FactoidSubCmdDelete = "forget"
+	if b.config.factoid.FactoidSubCmdDelete == "" {
+		b.config.factoid.FactoidSubCmdDelete = "forget"
 	}
-	if b.IRC.FactoidSubCmdSnoop == "" {
-		b.IRC.FactoidSubCmdSnoop = "snoop"
+	if b.config.factoid.FactoidSubCmdSnoop == "" {
+		b.config.factoid.FactoidSubCmdSnoop = "snoop"
 	}
-	if b.IRC.FactoidSubCmdSnoopAuthor == "" {
-		b.IRC.FactoidSubCmdSnoopAuthor = "snoop-author"
+	if b.config.factoid.FactoidSubCmdSnoopAuthor == "" {
+		b.config.factoid.FactoidSubCmdSnoopAuthor = "snoop-author"
 	}
-	if b.IRC.FactoidSubCmdSnoopReply == "" {
-		b.IRC.FactoidSubCmdSnoopReply = "snoop-reply"
+	if b.config.factoid.FactoidSubCmdSnoopReply == "" {
+		b.config.factoid.FactoidSubCmdSnoopReply = "snoop-reply"
 	}
-	if b.IRC.FactoidSubCmdCount == "" {
-		b.IRC.FactoidSubCmdCount = "count"
+	if b.config.factoid.FactoidSubCmdCount == "" {
+		b.config.factoid.FactoidSubCmdCount = "count"
 	}
 
 	// Messages
-	if b.IRC.FactoidMsgAdd == "" {
-		b.IRC.FactoidMsgAdd = "noted"
+	if b.config.factoid.FactoidMsgAdd == "" {
+		b.config.factoid.FactoidMsgAdd = "noted"
 	}
-	if b.IRC.FactoidMsgDelete == "" {
-		b.IRC.FactoidMsgDelete = "*removed*"
+	if b.config.factoid.FactoidMsgDelete == "" {
+		b.config.factoid.FactoidMsgDelete = "*removed*"
 	}
-	if b.IRC.FactoidMsgSnoop == "" {
-		b.IRC.FactoidMsgSnoop = "<id>: <author> taught me that <trigger> is <reply> <timestamp>"
+	if b.config.factoid.FactoidMsgSnoop == "" {
+		b.config.factoid.FactoidMsgSnoop = "<id>: <author> taught me that <trigger> is <reply> <timestamp>"
 	}
-	if b.IRC.FactoidMsgCount == "" {
-		b.IRC.FactoidMsgCount = "<trigger> has <count> occurrences"
+	if b.config.factoid.FactoidMsgCount == "" {
+		b.config.factoid.FactoidMsgCount = "<trigger> has <count> occurrences"
 	}
-	if b.IRC.FactoidMsgIs == "" {
-		b.IRC.FactoidMsgIs = "is"
+	if b.config.factoid.FactoidMsgIs == "" {
+		b.config.factoid.FactoidMsgIs = "is"
 	}
 
 	// Grammar
-	if b.IRC.FactoidGrammarAction == "" {
-		b.IRC.FactoidGrammarAction = "<action>"
+	if b.config.factoid.FactoidGrammarAction == "" {
+		b.config.factoid.FactoidGrammarAction = "<action>"
 	}
-	if b.IRC.FactoidGrammarRandomWho == "" {
-		b.IRC.FactoidGrammarRandomWho = "<randomwho>"
+	if b.config.factoid.FactoidGrammarRandomWho == "" {
+		b.config.factoid.FactoidGrammarRandomWho = "<randomwho>"
 	}
-	if b.IRC.FactoidGrammarRandomWord == "" {
-		b.IRC.FactoidGrammarRandomWord = "<randomword words=\"([a-zåäöüA-ZÅÄÖÜ0-9 ]+)\"[^>]*>"
+	if b.config.factoid.FactoidGrammarRandomWord == "" {
+		b.config.factoid.FactoidGrammarRandomWord = "<randomword words=\"([a-zåäöüA-ZÅÄÖÜ0-9 ]+)\"[^>]*>"
 	}
-	factoidGrammarRandomWord = regexp.MustCompile(b.IRC.FactoidGrammarRandomWord)
-	if b.IRC.FactoidGrammarReply == "" {
-		b.IRC.FactoidGrammarReply = "<reply>"
+	factoidGrammarRandomWord = regexp.MustCompile(b.config.factoid.FactoidGrammarRandomWord)
+	if b.config.factoid.FactoidGrammarReply == "" {
+		b.config.factoid.FactoidGrammarReply = "<reply>"
 	}
-	if b.IRC.FactoidGrammarWho == "" {
-		b.IRC.FactoidGrammarWho = "<who>"
+	if b.config.factoid.FactoidGrammarWho == "" {
+		b.config.factoid.FactoidGrammarWho = "<who>"
 	}
-	if b.IRC.FactoidGrammarGiphy == "" {
-		b.IRC.FactoidGrammarGiphy = "<giphy>"
+	if b.config.factoid.FactoidGrammarGiphy == "" {
+		b.config.factoid.FactoidGrammarGiphy = "<giphy>"
 	}
-	if b.IRC.FactoidGrammarGiphySearch == "" {
-		b.IRC.FactoidGrammarGiphySearch = `<giphy search="([a-zåäöA-ZÅÄÖ0-9 ]+)"[^>]*>"`
+	if b.config.factoid.FactoidGrammarGiphySearch == "" {
+		b.config.factoid.FactoidGrammarGiphySearch = `<giphy search="([a-zåäöA-ZÅÄÖ0-9 ]+)"[^>]*>"`
 	}
-	factoidGrammarGiphySearchRegexp = regexp.MustCompile(b.IRC.FactoidGrammarGiphySearch)
-	if b.IRC.FactoidGrammarTenorSearch == "" {
-		b.IRC.FactoidGrammarTenorSearch = `<giphy search="([a-zåäöüA-ZÅÄÖÜ0-9 ]+)"[^>]*>"`
+	factoidGrammarGiphySearchRegexp = regexp.MustCompile(b.config.factoid.FactoidGrammarGiphySearch)
+	if b.config.factoid.FactoidGrammarTenorSearch == "" {
+		b.config.factoid.FactoidGrammarTenorSearch = `<giphy search="([a-zåäöüA-ZÅÄÖÜ0-9 ]+)"[^>]*>"`
 	}
-	factoidGrammarTenorSearchRegexp = regexp.MustCompile(b.IRC.FactoidGrammarTenorSearch)
+	factoidGrammarTenorSearchRegexp = regexp.MustCompile(b.config.factoid.FactoidGrammarTenorSearch)
 }
 
-// factoidHandler is the main entry point for all factoid related commands.
-func (b *bot) factoidHandler(m *irc.Message) {
-	// Parse the action
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
+// factoidHandler replies with a factoid if the message is a known trigger.
+func (b *bot) factoidHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
+	if !n.hasFeature(a.target, featureFactoid) {
 		return
 	}
 
-	// Determine which action to take.
-	subCmd := ""
-	if len(a.args) > 0 {
-		subCmd = a.args[0]
+	// The factoid commands are handled by the command dispatcher.
+	if b.isCommand(a, b.config.factoid.FactoidCmd) {
+		return
 	}
 
-	// First we'll prioritize commands, if the message isn't a command
-	// we'll check if it's a factoid and if we should send a reply to the
-	// channel.
-	if a.cmd == b.IRC.FactoidCmd && subCmd == b.IRC.FactoidSubCmdAdd && len(a.args) >= 4 {
-		if b.shouldIgnore(m) {
-			return
-		}
+	b.factoidHandleFact(a)
+}
 
-		// Remove the factoid cmd and sub cmd from the message.
-		msg := strings.Replace(
-			a.msg,
-			fmt.Sprintf("%s %s ", b.IRC.FactoidCmd, b.IRC.FactoidSubCmdAdd),
-			"",
-			1,
-		)
-
-		// Make sure that the delimiter word is present
-		dpos := strings.Index(msg, b.IRC.FactoidSubCmdAddDelimiter)
-		if dpos == -1 {
-			return
-		}
+// isFactoidTrigger returns true if the message of the action is the trigger
+// of a factoid that the factoid module replies to.
+func (b *bot) isFactoidTrigger(a *privmsgAction) bool {
+	if !b.moduleEnabled(featureFactoid) || !a.network.hasFeature(a.target, featureFactoid) {
+		return false
+	}
 
-		// Insert the factoid.
-		b.factoidHandleInsertFact(
-			a.nick,
-			msg[0:dpos],
-			msg[dpos+len(b.IRC.FactoidSubCmdAddDelimiter):],
-		)
-	} else if a.cmd == b.IRC.FactoidCmd && subCmd == b.IRC.FactoidSubCmdDelete && len(a.args) == 2 {
-		if b.shouldIgnore(m) {
-			return
-		}
-		b.factoidHandleDelete(a.args[1])
-	} else if a.cmd == b.IRC.FactoidCmd && (subCmd == b.IRC.FactoidSubCmdSnoop ||
-		subCmd == b.IRC.FactoidSubCmdSnoopAuthor ||
-		subCmd == b.IRC.FactoidSubCmdSnoopReply) && len(a.args) >= 2 {
-		if b.shouldIgnore(m) {
-			return
-		}
+	count, err := b.store.factoid.count(a.msg)
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureFactoid).Errorf("isFactoidTrigger: %v", err)
+		return false
+	}
 
-		if subCmd == b.IRC.FactoidSubCmdSnoop {
-			b.factoidHandleSnoop(strings.Replace(
-				a.msg,
-				fmt.Sprintf("%s %s ", b.IRC.FactoidCmd, b.IRC.FactoidSubCmdSnoop),
-				"",
-				1,
-			), "default")
-		} else if subCmd == b.IRC.FactoidSubCmdSnoopAuthor {
-			b.factoidHandleSnoop(strings.Replace(
-				a.msg,
-				fmt.Sprintf("%s %s ", b.IRC.FactoidCmd, b.IRC.FactoidSubCmdSnoopAuthor),
-				"",
-				1,
-			), "author")
-		} else {
-			b.factoidHandleSnoop(strings.Replace(
-				a.msg,
-				fmt.Sprintf("%s %s ", b.IRC.FactoidCmd, b.IRC.FactoidSubCmdSnoopReply),
-				"",
-				1,
-			), "reply")
-		}
-	} else if a.cmd == b.IRC.FactoidCmd && subCmd == b.IRC.FactoidSubCmdCount && len(a.args) >= 2 {
-		if b.shouldIgnore(m) {
-			return
-		}
-		b.factoidHandleCount(
-			strings.Replace(
-				a.msg,
-				fmt.Sprintf("%s %s ", b.IRC.FactoidCmd, b.IRC.FactoidSubCmdCount),
-				"",
-				1,
-			),
-		)
-	} else {
-		b.factoidHandleFact(a)
+	return count > 0
+}
+
+// factoidAddCommand adds a new factoid, the trigger and reply are separated
+// by the add delimiter.
+func (b *bot) factoidAddCommand(a *privmsgAction) {
+	// Make sure that the delimiter word is present
+	msg := strings.Join(a.args, " ")
+	dpos := strings.Index(msg, b.config.factoid.FactoidSubCmdAddDelimiter)
+	if dpos == -1 {
+		return
 	}
+
+	// Insert the factoid.
+	b.factoidHandleInsertFact(
+		a,
+		a.nick,
+		msg[0:dpos],
+		msg[dpos+len(b.config.factoid.FactoidSubCmdAddDelimiter):],
+	)
+}
+
+// factoidDeleteCommand deletes the factoid with the given id.
+func (b *bot) factoidDeleteCommand(a *privmsgAction) {
+	b.factoidHandleDelete(a, a.args[0])
+}
+
+// factoidSnoopCommand snoops on factoids by trigger.
+func (b *bot) factoidSnoopCommand(a *privmsgAction) {
+	b.factoidHandleSnoop(a, strings.Join(a.args, " "), "default")
+}
+
+// factoidSnoopAuthorCommand snoops on factoids by author.
+func (b *bot) factoidSnoopAuthorCommand(a *privmsgAction) {
+	b.factoidHandleSnoop(a, strings.Join(a.args, " "), "author")
+}
+
+// factoidSnoopReplyCommand snoops on factoids by reply.
+func (b *bot) factoidSnoopReplyCommand(a *privmsgAction) {
+	b.factoidHandleSnoop(a, strings.Join(a.args, " "), "reply")
+}
+
+// factoidCountCommand counts the factoids of the given trigger.
+func (b *bot) factoidCountCommand(a *privmsgAction) {
+	b.factoidHandleCount(a, strings.Join(a.args, " "))
 }
 
 // factoidHandleDelete deletes the given factoid if the id exists. If the id
 // doesn't exist it will silently ignore the message.
-func (b *bot) factoidHandleDelete(id string) {
+func (b *bot) factoidHandleDelete(a *privmsgAction, id string) {
 	// We expect a valid UUID to be sent.
 	if !isUUID(id) {
 		return
 	}
 
-	// Prepare the UPDATE statement. We are not actually deleting the
-	// factoid, we'll just hide it so that it can be restored if we have
-	// someone deleting things we want to keep.
-	stmt, err := b.prepare("UPDATE factoid SET is_deleted = true WHERE id = $1")
+	// We are not actually deleting the factoid, we'll just hide it so
+	// that it can be restored if we have someone deleting things we want
+	// to keep.
+	deleted, err := b.store.factoid.setDeleted(id, true)
 	if err != nil {
-		b.logger.Printf("factoidHandleDelete: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureFactoid).Errorf("factoidHandleDelete: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer stmt.Close()
 
-	// Execute the UPDATE statement.
-	_, err = stmt.Exec(id)
-	if err != nil {
-		b.logger.Printf("factoidHandleDelete: %v", err)
-		b.privmsg(b.DB.Err)
-		return
+	if deleted {
+		b.emitEvent(a.network, a.target, a.nick, outgoingEventFactoidDeleted, map[string]interface{}{
+			"id": id,
+		})
 	}
 
 	// Send a notice that the factoid was removed.
-	b.privmsg(b.IRC.FactoidMsgDelete)
+	a.reply(b.config.factoid.FactoidMsgDelete)
 }
 
 // factoidHandleSnoop finds information about the given factoid. If there are
-// more than five factoids found for the given trigger it'll send the message
-// as a private message instead so we don't flood the channel.
-func (b *bot) factoidHandleSnoop(ss, t string) {
-	var query string
+// more than five factoids found for the given trigger it'll upload the
+// result to a pastebin instead so we don't flood the channel.
+func (b *bot) factoidHandleSnoop(a *privmsgAction, ss, t string) {
+	column := t
 	if t == "default" {
-		query = "SELECT id, author, timestamp, reply, trigger FROM factoid WHERE trigger ILIKE $1 AND is_deleted = false"
-	} else if t == "author" {
-		query = "SELECT id, author, timestamp, reply, trigger FROM factoid WHERE author ILIKE $1 AND is_deleted = false"
-	} else {
-		query = "SELECT id, author, timestamp, reply, trigger FROM factoid WHERE reply ILIKE $1 AND is_deleted = false"
+		column = "trigger"
 	}
 
 	// Get all the relevant factoid information
-	rows, err := b.query(query, ss)
+	facts, err := b.store.factoid.match(column, ss)
 	if err != nil {
-		b.logger.Printf("factoidHandleSnoop: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureFactoid).Errorf("factoidHandleSnoop: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer rows.Close()
-
-	// We'll setup a struct so that we more easily can iterate over all
-	// the factoids.
-	type fact struct {
-		id        string
-		author    string
-		timestamp string
-		reply     string
-		trigger   string
-	}
-
-	// Fetch the facts.
-	var facts []fact
-	for rows.Next() {
-		var i, a, t, r, tr string
-		rows.Scan(&i, &a, &t, &r, &tr)
-		facts = append(facts, fact{i, a, t, r, tr})
-	}
 
 	// Determine the target of the information.
 	var target string
 	if len(facts) > 5 {
 		target = "pastebin"
 	} else {
-		target = b.IRC.Channel
+		target = a.target
 	}
 
 	// Send the information back to the given target.
@@ -278,15 +301,15 @@ func (b *bot) factoidHandleSnoop(ss, t string) {
 
 	for _, f := range facts {
 		data := map[string]string{
-			"<id>":        f.id,
-			"<author>":    f.author,
-			"<trigger>":   f.trigger,
-			"<reply>":     f.reply,
-			"<timestamp>": f.timestamp,
+			"<id>":        f.ID,
+			"<author>":    f.Author,
+			"<trigger>":   f.Trigger,
+			"<reply>":     f.Reply,
+			"<timestamp>": b.formatTime(f.Timestamp),
 		}
 
 		if target == "pastebin" {
-			code := b.IRC.FactoidMsgSnoop
+			code := b.config.factoid.FactoidMsgSnoop
 			for k, v := range data {
 				code = strings.ReplaceAll(code, k, v)
 			}
@@ -298,54 +321,61 @@ func (b *bot) factoidHandleSnoop(ss, t string) {
 			}
 
 		} else {
-			b.privmsgph(b.IRC.FactoidMsgSnoop, data)
+			a.replyph(b.config.factoid.FactoidMsgSnoop, data)
 		}
 	}
 
 	if target == "pastebin" {
-		b.newPaste(ss, pastebinCode)
+		b.newPaste(a, ss, pastebinCode)
 	}
 }
 
 // factoidHandleCount returns the number of occurrences the given trigger has.
-func (b *bot) factoidHandleCount(trigger string) {
+func (b *bot) factoidHandleCount(a *privmsgAction, trigger string) {
 	// Get the count for the given trigger.
-	var count int
-	err := b.queryRow("SELECT COUNT(*) FROM factoid WHERE trigger = $1 AND is_deleted = false", trigger).Scan(&count)
-	if err != nil && err != sql.ErrNoRows {
-		b.logger.Printf("factoidHandleCount: %v", err)
-		b.privmsg(b.DB.Err)
+	count, err := b.store.factoid.count(trigger)
+	if err != nil {
+		b.log(featureFactoid).Errorf("factoidHandleCount: %v", err)
+		b.replyDBError(a)
 		return
 	}
 
 	// Return it to the channel.
-	b.privmsgph(b.IRC.FactoidMsgCount, map[string]string{
+	a.replyph(b.config.factoid.FactoidMsgCount, map[string]string{
 		"<trigger>": trigger,
 		"<count>":   fmt.Sprintf("%d", count),
 	})
 }
 
-// factoidHandleInsertFact inserts a new factoid into the database.
-func (b *bot) factoidHandleInsertFact(author, trigger, reply string) {
-	// Prepare the INSERT statement.
-	stmt, err := b.prepare("INSERT INTO factoid (id, timestamp, author, trigger, reply, is_deleted) VALUES($1, $2, $3, $4, $5, false)")
+// factoidHandleInsertFact inserts a new factoid into the database, the
+// network and channel where the factoid was added is stored together with
+// the factoid.
+func (b *bot) factoidHandleInsertFact(a *privmsgAction, author, trigger, reply string) {
+	id := newUUID()
+	err := b.store.factoid.insert(factoidEntry{
+		ID:        id,
+		Timestamp: time.Now(),
+		Network:   a.network.Name,
+		Channel:   a.target,
+		Author:    author,
+		Trigger:   trigger,
+		Reply:     reply,
+	})
 	if err != nil {
-		b.logger.Printf("factoidHandleInsertFact: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureFactoid).Errorf("factoidHandleInsertFact: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer stmt.Close()
 
-	// Execute it.
-	_, err = stmt.Exec(newUUID(), newTimestamp(), author, trigger, reply)
-	if err != nil {
-		b.logger.Printf("factoidHandleInsertFact: %v", err)
-		b.privmsg(b.DB.Err)
-		return
-	}
+	b.emitEvent(a.network, a.target, a.nick, outgoingEventFactoidAdded, map[string]interface{}{
+		"id":      id,
+		"author":  author,
+		"trigger": trigger,
+		"reply":   reply,
+	})
 
 	// ... and send a notice that the fact has been stored.
-	b.privmsg(b.IRC.FactoidMsgAdd)
+	a.reply(b.config.factoid.FactoidMsgAdd)
 }
 
 // factoidHandleFact checks whether the message in the action is a known
@@ -353,27 +383,13 @@ func (b *bot) factoidHandleInsertFact(author, trigger, reply string) {
 // channel.
 func (b *bot) factoidHandleFact(a *privmsgAction) {
 	// Let's check whether the message is a known trigger.
-	rows, err := b.query("SELECT reply, rate FROM factoid WHERE trigger = $1 AND is_deleted = false", a.msg)
+	// There can be more than one factoid for a trigger.
+	factoids, err := b.store.factoid.byTrigger(a.msg)
 	if err != nil {
-		b.logger.Printf("factoidHandleFact: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureFactoid).Errorf("factoidHandleFact: %v", err)
+		b.replyDBError(a)
 		return
 	}
-	defer rows.Close()
-
-	// There can be more than one factoid for a trigger. So let's store
-	// all of them.
-	type entry struct {
-		fact string
-		rate *int
-	}
-	var factoids []entry
-	for rows.Next() {
-		var f string
-		var r *int
-		rows.Scan(&f, &r)
-		factoids = append(factoids, entry{f, r})
-	}
 
 	// No factoids, return early.
 	if len(factoids) < 1 {
@@ -382,8 +398,8 @@ func (b *bot) factoidHandleFact(a *privmsgAction) {
 
 	// Get a random fact and rate from the slice of factoids.
 	idx := rand.Intn(len(factoids))
-	factoid := factoids[idx].fact
-	rate := factoids[idx].rate
+	factoid := factoids[idx].Reply
+	rate := factoids[idx].Rate
 
 	// If factoid rate is set, we'll only reply with the found factoid if
 	// the random number is greater than the defined value on the fact, if
@@ -391,32 +407,32 @@ func (b *bot) factoidHandleFact(a *privmsgAction) {
 	// defined in the configuration file.
 	if rate != nil && *rate >= factoidRandom.Intn(100) {
 		return
-	} else if rate == nil && b.IRC.FactoidRate > 0 && b.IRC.FactoidRate >= factoidRandom.Intn(100) {
+	} else if rate == nil && b.config.factoid.FactoidRate > 0 && b.config.factoid.FactoidRate >= factoidRandom.Intn(100) {
 		return
 	}
 
 	// Replace all occurences of <who> with the senders nick.
-	i := strings.Index(factoid, b.IRC.FactoidGrammarWho)
+	i := strings.Index(factoid, b.config.factoid.FactoidGrammarWho)
 	for i != -1 {
-		factoid = factoid[0:i] + a.nick + factoid[i+len(b.IRC.FactoidGrammarWho):]
-		i = strings.Index(factoid, b.IRC.FactoidGrammarWho)
+		factoid = factoid[0:i] + a.nick + factoid[i+len(b.config.factoid.FactoidGrammarWho):]
+		i = strings.Index(factoid, b.config.factoid.FactoidGrammarWho)
 	}
 
 	// Replace all occurences of <randomwho> with a random nick from the
 	// names map.
-	i = strings.Index(factoid, b.IRC.FactoidGrammarRandomWho)
+	i = strings.Index(factoid, b.config.factoid.FactoidGrammarRandomWho)
 	for i != -1 {
-		factoid = factoid[0:i] + b.rndName() + factoid[i+len(b.IRC.FactoidGrammarRandomWho):]
-		i = strings.Index(factoid, b.IRC.FactoidGrammarRandomWho)
+		factoid = factoid[0:i] + a.network.rndName(a.target) + factoid[i+len(b.config.factoid.FactoidGrammarRandomWho):]
+		i = strings.Index(factoid, b.config.factoid.FactoidGrammarRandomWho)
 	}
 
 	// Replace all occurences of <giphy> with a gif from giphy.
-	i = strings.Index(factoid, b.IRC.FactoidGrammarGiphy)
+	i = strings.Index(factoid, b.config.factoid.FactoidGrammarGiphy)
 	for i != -1 {
 		if url, _ := b.giphyRandom(); url != "" {
-			factoid = factoid[0:i] + url + factoid[i+len(b.IRC.FactoidGrammarGiphy):]
+			factoid = factoid[0:i] + url + factoid[i+len(b.config.factoid.FactoidGrammarGiphy):]
 		}
-		i = strings.Index(factoid, b.IRC.FactoidGrammarGiphy)
+		i = strings.Index(factoid, b.config.factoid.FactoidGrammarGiphy)
 	}
 
 	// Replace all <giphy search="<query>"> with replies from the giphy API.
@@ -446,11 +462,11 @@ func (b *bot) factoidHandleFact(a *privmsgAction) {
 	}
 
 	// Handle replies.
-	if strings.HasPrefix(factoid, b.IRC.FactoidGrammarReply) {
-		b.privmsg(factoid[len(b.IRC.FactoidGrammarReply)+1:])
-	} else if strings.HasPrefix(factoid, b.IRC.FactoidGrammarAction) {
-		b.action(factoid[len(b.IRC.FactoidGrammarAction)+1:])
+	if strings.HasPrefix(factoid, b.config.factoid.FactoidGrammarReply) {
+		a.reply(factoid[len(b.config.factoid.FactoidGrammarReply)+1:])
+	} else if strings.HasPrefix(factoid, b.config.factoid.FactoidGrammarAction) {
+		a.network.action(a.replyTarget(), factoid[len(b.config.factoid.FactoidGrammarAction)+1:])
 	} else {
-		b.privmsg(fmt.Sprintf("%s %s %s", a.msg, b.IRC.FactoidMsgIs, factoid))
+		a.reply(fmt.Sprintf("%s %s %s", a.msg, b.config.factoid.FactoidMsgIs, factoid))
 	}
 }
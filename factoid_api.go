@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The default and maximum number of factoids per page of the factoid API.
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 500
+)
+
+// apiFactoid is a factoid as it's represented in the REST API, it has the
+// same fields as factoidEntry.
+type apiFactoid struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Network   string    `json:"network"`
+	Channel   string    `json:"channel"`
+	Author    string    `json:"author"`
+	Trigger   string    `json:"trigger"`
+	Reply     string    `json:"reply"`
+	Rate      *int      `json:"rate"`
+	Deleted   bool      `json:"deleted"`
+}
+
+// apiFactoidPage is a page of factoids, total is the number of factoids
+// that matches the search.
+type apiFactoidPage struct {
+	Factoids []apiFactoid `json:"factoids"`
+	Page     int          `json:"page"`
+	PerPage  int          `json:"perPage"`
+	Total    int          `json:"total"`
+}
+
+// writeJSON writes the value as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes the error message as JSON with the given status
+// code.
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// apiHandler authenticates the request and routes it to the handler of the
+// resource. The factoid routes are:
+//
+//	GET    <apiRoute>/factoids              lists and searches factoids
+//	POST   <apiRoute>/factoids              creates a factoid
+//	GET    <apiRoute>/factoids/<id>         returns a factoid
+//	DELETE <apiRoute>/factoids/<id>         deletes a factoid
+//	POST   <apiRoute>/factoids/<id>/restore restores a deleted factoid
+func (b *bot) apiHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok, err := b.apiTokenName(r)
+	if err != nil {
+		b.HTTP.logger.Errorf("api: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "a valid API token is required")
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, b.HTTP.APIRoute), "/"), "/")
+	if path[0] != "factoids" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		b.apiListFactoids(w, r)
+	case len(path) == 1 && r.Method == http.MethodPost:
+		b.apiCreateFactoid(w, r, token)
+	case len(path) == 2 && r.Method == http.MethodGet:
+		b.apiGetFactoid(w, path[1])
+	case len(path) == 2 && r.Method == http.MethodDelete:
+		b.apiSetFactoidDeleted(w, path[1], true, token)
+	case len(path) == 3 && path[2] == "restore" && r.Method == http.MethodPost:
+		b.apiSetFactoidDeleted(w, path[1], false, token)
+	case len(path) <= 3:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// apiListFactoids lists the factoids that matches the query parameters. The
+// trigger, author and reply parameters matches the factoids that contains
+// the value, q matches any of them. network and channel must match exactly.
+// Deleted factoids are only listed if deleted is true or all. The result is
+// paginated with page and perPage.
+func (b *bot) apiListFactoids(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := factoidFilter{
+		Trigger: q.Get("trigger"),
+		Author:  q.Get("author"),
+		Reply:   q.Get("reply"),
+		Any:     q.Get("q"),
+		Network: q.Get("network"),
+		Channel: q.Get("channel"),
+	}
+	switch d := q.Get("deleted"); d {
+	case "", "false", "true":
+		deleted := d == "true"
+		filter.Deleted = &deleted
+	case "all":
+	default:
+		writeAPIError(w, http.StatusBadRequest, "deleted must be true, false or all")
+		return
+	}
+
+	page, err := queryInt(q.Get("page"), 1)
+	if err != nil || page < 1 {
+		writeAPIError(w, http.StatusBadRequest, "page must be a positive number")
+		return
+	}
+	perPage, err := queryInt(q.Get("perPage"), apiDefaultPerPage)
+	if err != nil || perPage < 1 || perPage > apiMaxPerPage {
+		writeAPIError(w, http.StatusBadRequest, "perPage must be between 1 and %d", apiMaxPerPage)
+		return
+	}
+
+	factoids, total, err := b.store.factoid.list(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		b.apiDBError(w, "apiListFactoids", err)
+		return
+	}
+
+	result := apiFactoidPage{Factoids: []apiFactoid{}, Page: page, PerPage: perPage, Total: total}
+	for _, f := range factoids {
+		result.Factoids = append(result.Factoids, apiFactoid(f))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// queryInt parses the query parameter, def is returned if it's empty.
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
+
+// apiCreateFactoid creates a factoid from the JSON body. The trigger and
+// reply are required, the author defaults to the name of the token and the
+// network and channel defaults to the default network and its default
+// channel. The channel has to be one of the channels of the network.
+func (b *bot) apiCreateFactoid(w http.ResponseWriter, r *http.Request, token string) {
+	var f apiFactoid
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON, %v", err)
+		return
+	}
+
+	if strings.TrimSpace(f.Trigger) == "" || strings.TrimSpace(f.Reply) == "" {
+		writeAPIError(w, http.StatusBadRequest, "trigger and reply are required")
+		return
+	}
+	if f.Author == "" {
+		f.Author = token
+	}
+
+	var n *network
+	if f.Network == "" {
+		n = b.defaultNetwork()
+	} else {
+		n = b.network(f.Network)
+	}
+	if n == nil {
+		writeAPIError(w, http.StatusBadRequest, "unknown network %s", f.Network)
+		return
+	}
+	f.Network = n.Name
+	if f.Channel == "" {
+		f.Channel = n.defaultChannel()
+	}
+	if n.channel(f.Channel) == nil {
+		writeAPIError(w, http.StatusBadRequest, "unknown channel %s", f.Channel)
+		return
+	}
+
+	f.ID = newUUID()
+	f.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
+	f.Deleted = false
+
+	if err := b.store.factoid.insert(factoidEntry(f)); err != nil {
+		b.apiDBError(w, "apiCreateFactoid", err)
+		return
+	}
+
+	b.HTTP.logger.Infof("apiCreateFactoid: the %s token added factoid %s", token, f.ID)
+	b.emitEvent(n, f.Channel, "", outgoingEventFactoidAdded, map[string]interface{}{
+		"id":      f.ID,
+		"author":  f.Author,
+		"trigger": f.Trigger,
+		"reply":   f.Reply,
+	})
+	writeJSON(w, http.StatusCreated, f)
+}
+
+// apiFactoid returns the factoid with the given id, false is returned if it
+// doesn't exist.
+func (b *bot) apiFactoid(id string) (apiFactoid, bool, error) {
+	if !isUUID(id) {
+		return apiFactoid{}, false, nil
+	}
+
+	f, ok, err := b.store.factoid.get(id)
+	return apiFactoid(f), ok, err
+}
+
+// apiGetFactoid writes the factoid with the given id.
+func (b *bot) apiGetFactoid(w http.ResponseWriter, id string) {
+	f, ok, err := b.apiFactoid(id)
+	if err != nil {
+		b.apiDBError(w, "apiGetFactoid", err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "there is no factoid with id %s", id)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f)
+}
+
+// apiSetFactoidDeleted deletes or restores the factoid with the given id,
+// the factoid is only hidden when it's deleted so that it can be restored.
+func (b *bot) apiSetFactoidDeleted(w http.ResponseWriter, id string, deleted bool, token string) {
+	// changed is false if the factoid already was deleted or restored,
+	// so the event is only emitted once.
+	changed := false
+	if isUUID(id) {
+		var err error
+		if changed, err = b.store.factoid.setDeleted(id, deleted); err != nil {
+			b.apiDBError(w, "apiSetFactoidDeleted", err)
+			return
+		}
+	}
+
+	f, ok, err := b.apiFactoid(id)
+	if err != nil {
+		b.apiDBError(w, "apiSetFactoidDeleted", err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "there is no factoid with id %s", id)
+		return
+	}
+
+	b.HTTP.logger.Infof("apiSetFactoidDeleted: the %s token set deleted of factoid %s to %v", token, id, deleted)
+	if n := b.network(f.Network); n != nil && deleted && changed {
+		b.emitEvent(n, f.Channel, "", outgoingEventFactoidDeleted, map[string]interface{}{
+			"id": f.ID,
+		})
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+// apiDBError logs the database error and tells the client about it.
+func (b *bot) apiDBError(w http.ResponseWriter, name string, err error) {
+	b.metrics.dbErrors.inc()
+	b.HTTP.logger.Errorf("%s: %v", name, err)
+	writeAPIError(w, http.StatusInternalServerError, "database error")
+}
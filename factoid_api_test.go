@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAPITestBot starts a bot with the factoid module and an outgoing webhook
+// that receives the factoid events, so that the events that the API emits
+// can be found in the delivery log. The webhook server has to be closed
+// after the bot.
+func newAPITestBot(t *testing.T) (*testBot, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	return newTestBotWithConfig(t, map[string]interface{}{"enableFactoid": true}, map[string]interface{}{
+		"modules": map[string]interface{}{
+			"outgoingWebhooks": map[string]interface{}{
+				"enableOutgoingWebhooks": true,
+				"outgoingWebhooks": []map[string]interface{}{
+					{"name": "ci", "url": srv.URL, "events": []string{outgoingEventFactoidAdded, outgoingEventFactoidDeleted}},
+				},
+			},
+		},
+	}), srv
+}
+
+// addAPIToken stores a token with the given name and returns its id.
+func addAPIToken(tb *testBot, name, token string) string {
+	tb.t.Helper()
+
+	stmt, err := tb.prepare("INSERT INTO api_token (id, name, token_hash, author, inserted_at, is_deleted) VALUES($1, $2, $3, 'admin', $4, false)")
+	if err != nil {
+		tb.t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	id := newUUID()
+	if _, err := stmt.Exec(id, name, hashAPIToken(token), newTimestamp()); err != nil {
+		tb.t.Fatal(err)
+	}
+	return id
+}
+
+// apiRequest makes a request to the API with the token as a bearer token,
+// the Authorization header is left out if the token is empty.
+func apiRequest(tb *testBot, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, tb.HTTP.APIRoute+path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	tb.apiHandler(w, r)
+	return w
+}
+
+// decodeAPIResponse decodes the JSON body of the response into v.
+func decodeAPIResponse(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("can't decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestFactoidAPIAuth(t *testing.T) {
+	tb, srv := newAPITestBot(t)
+	defer srv.Close()
+	defer tb.close()
+
+	id := addAPIToken(tb, "ci", "secret")
+	if w := apiRequest(tb, http.MethodGet, "/factoids", "secret", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a valid token, got %d %s", w.Code, w.Body)
+	}
+
+	for _, test := range []struct {
+		name   string
+		header string
+	}{
+		{"missing", ""},
+		{"wrong", "Bearer wrong"},
+		{"empty", "Bearer "},
+		{"not a bearer token", "secret"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tb.HTTP.APIRoute+"/factoids", nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		tb.apiHandler(w, r)
+
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: expected 401 with a bearer challenge, got %d %q", test.name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	// A revoked token is no longer accepted.
+	tb.server.privmsg("admin", "#bot", "!apitoken delete "+id)
+	tb.server.expect("PRIVMSG #bot :token deleted")
+	if w := apiRequest(tb, http.MethodGet, "/factoids", "secret", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a revoked token, got %d %s", w.Code, w.Body)
+	}
+	if w := apiRequest(tb, http.MethodPost, "/factoids", "secret", `{"trigger": "coffee", "reply": "hot"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 when creating with a revoked token, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestFactoidAPIPagination(t *testing.T) {
+	tb, srv := newAPITestBot(t)
+	defer srv.Close()
+	defer tb.close()
+	addAPIToken(tb, "ci", "secret")
+
+	for _, trigger := range []string{"a", "b", "c"} {
+		if w := apiRequest(tb, http.MethodPost, "/factoids", "secret", `{"trigger": "`+trigger+`", "reply": "x"}`); w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"?perPage=2", 2},
+		{"?perPage=2&page=2", 1},
+		{"?perPage=2&page=3", 0},
+		{"?perPage=500", 3},
+	} {
+		w := apiRequest(tb, http.MethodGet, "/factoids"+test.query, "secret", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: expected 200, got %d %s", test.query, w.Code, w.Body)
+			continue
+		}
+
+		var page apiFactoidPage
+		decodeAPIResponse(t, w, &page)
+		if len(page.Factoids) != test.want || page.Total != 3 {
+			t.Errorf("%q: expected %d of 3 factoids, got %d of %d", test.query, test.want, len(page.Factoids), page.Total)
+		}
+	}
+
+	for _, query := range []string{"?page=0", "?page=-1", "?page=x", "?perPage=0", "?perPage=501", "?perPage=x", "?deleted=maybe"} {
+		if w := apiRequest(tb, http.MethodGet, "/factoids"+query, "secret", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d %s", query, w.Code, w.Body)
+		}
+	}
+}
+
+func TestFactoidAPICreateDeleteRestore(t *testing.T) {
+	tb, srv := newAPITestBot(t)
+	defer srv.Close()
+	defer tb.close()
+	addAPIToken(tb, "ci", "secret")
+
+	for _, body := range []string{
+		`{"trigger": "coffee"`,
+		`{"trigger": "coffee", "reply": " "}`,
+		`{"trigger": "coffee", "reply": "hot", "network": "other"}`,
+		`{"trigger": "coffee", "reply": "hot", "channel": "#other"}`,
+	} {
+		if w := apiRequest(tb, http.MethodPost, "/factoids", "secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", body, w.Code, w.Body)
+		}
+	}
+	if n := tb.queryString("SELECT COUNT(*) FROM factoid"); n != "0" {
+		t.Fatalf("expected no factoids to be created, got %s", n)
+	}
+
+	w := apiRequest(tb, http.MethodPost, "/factoids", "secret", `{"trigger": "coffee", "reply": "hot"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+	}
+	var f apiFactoid
+	decodeAPIResponse(t, w, &f)
+	if f.Author != "ci" || f.Network != "test" || f.Channel != "#bot" || f.Deleted {
+		t.Errorf("expected the defaults to be set, got %+v", f)
+	}
+
+	tb.server.privmsg("bob", "#bot", "coffee")
+	tb.server.expect("PRIVMSG #bot :coffee is hot")
+
+	events := func(event string) string {
+		return tb.queryString("SELECT COUNT(*) FROM webhook_delivery WHERE event = $1", event)
+	}
+	if n := events(outgoingEventFactoidAdded); n != "1" {
+		t.Errorf("expected one %s event, got %s", outgoingEventFactoidAdded, n)
+	}
+
+	// The event is only emitted the first time the factoid is deleted.
+	for i := 0; i < 2; i++ {
+		w = apiRequest(tb, http.MethodDelete, "/factoids/"+f.ID, "secret", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+		}
+		decodeAPIResponse(t, w, &f)
+		if !f.Deleted {
+			t.Errorf("expected the factoid to be deleted, got %+v", f)
+		}
+	}
+	if n := events(outgoingEventFactoidDeleted); n != "1" {
+		t.Errorf("expected one %s event, got %s", outgoingEventFactoidDeleted, n)
+	}
+
+	w = apiRequest(tb, http.MethodPost, "/factoids/"+f.ID+"/restore", "secret", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	decodeAPIResponse(t, w, &f)
+	if f.Deleted {
+		t.Errorf("expected the factoid to be restored, got %+v", f)
+	}
+	if n := events(outgoingEventFactoidDeleted); n != "1" {
+		t.Errorf("expected no %s event when restoring, got %s", outgoingEventFactoidDeleted, n)
+	}
+
+	for _, path := range []string{"/factoids/" + newUUID(), "/factoids/x"} {
+		if w := apiRequest(tb, http.MethodDelete, path, "secret", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d %s", path, w.Code, w.Body)
+		}
+	}
+}
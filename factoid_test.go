@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFactoid(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableFactoid": true})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!factoid add coffee _is_ hot")
+	s.expect("PRIVMSG #bot :noted")
+
+	s.privmsg("bob", "#bot", "coffee")
+	s.expect("PRIVMSG #bot :coffee is hot")
+
+	// The <reply> grammar replies with the factoid as it is and <who> is
+	// replaced with the nick of the sender.
+	s.privmsg("alice", "#bot", "!factoid add hello _is_ <reply> hi <who>")
+	s.expect("PRIVMSG #bot :noted")
+	s.privmsg("bob", "#bot", "hello")
+	s.expect("PRIVMSG #bot :hi bob")
+
+	s.privmsg("bob", "#bot", "!factoid count coffee")
+	s.expect("PRIVMSG #bot :coffee has 1 occurrences")
+
+	// Only trusted users can make the bot forget a factoid.
+	id := tb.queryString("SELECT id FROM factoid WHERE trigger = 'coffee'")
+	s.privmsg("bob", "#bot", "!factoid forget "+id)
+	s.expect("PRIVMSG #bot :you need the trusted role to use !factoid forget")
+
+	s.privmsg("admin", "#bot", "!factoid forget "+id)
+	s.expect("PRIVMSG #bot :*removed*")
+
+	s.privmsg("bob", "#bot", "coffee")
+	s.expectNo("PRIVMSG #bot :", 200*time.Millisecond)
+}
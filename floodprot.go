@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"sync"
 	"time"
@@ -8,22 +9,49 @@ import (
 	"github.com/osm/irc"
 )
 
+// floodProtConfig holds the configuration of the flood protection module.
+type floodProtConfig struct {
+	EnableFloodProt        bool   `json:"enableFloodProt"`
+	FloodProtTimeThreshold int    `json:"floodProtTimeThreshold"`
+	FloodProtCmdThreshold  int    `json:"floodProtCmdThreshold"`
+	FloodProtIgnoreTime    int    `json:"floodProtIgnoreTime"`
+	FloodProtMsgIgnore     string `json:"floodProtMsgIgnore"`
+	FloodProtMsgUnignore   string `json:"floodProtMsgUnignore"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    "floodProt",
+		config:  func(b *bot) interface{} { return &b.config.floodProt },
+		enabled: func(b *bot) bool { return b.config.floodProt.EnableFloodProt },
+		init: func(b *bot) {
+			b.initFloodProtDefaults()
+			b.initFloodProt()
+		},
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).floodProtHandler},
+		},
+		run: (*bot).floodProtUnignore,
+	})
+}
+
 // initFloodProtDefaults initializes the flood protection defaults.
 func (b *bot) initFloodProtDefaults() {
-	if b.IRC.FloodProtTimeThreshold == 0 {
-		b.IRC.FloodProtTimeThreshold = 5
+	if b.config.floodProt.FloodProtTimeThreshold == 0 {
+		b.config.floodProt.FloodProtTimeThreshold = 5
 	}
-	if b.IRC.FloodProtCmdThreshold == 0 {
-		b.IRC.FloodProtCmdThreshold = 3
+	if b.config.floodProt.FloodProtCmdThreshold == 0 {
+		b.config.floodProt.FloodProtCmdThreshold = 3
 	}
-	if b.IRC.FloodProtIgnoreTime == 0 {
-		b.IRC.FloodProtIgnoreTime = 60
+	if b.config.floodProt.FloodProtIgnoreTime == 0 {
+		b.config.floodProt.FloodProtIgnoreTime = 60
 	}
-	if b.IRC.FloodProtMsgIgnore == "" {
-		b.IRC.FloodProtMsgIgnore = "<nick> is ignored"
+	if b.config.floodProt.FloodProtMsgIgnore == "" {
+		b.config.floodProt.FloodProtMsgIgnore = "<nick> is ignored"
 	}
-	if b.IRC.FloodProtMsgUnignore == "" {
-		b.IRC.FloodProtMsgUnignore = "<nick> is unignored"
+	if b.config.floodProt.FloodProtMsgUnignore == "" {
+		b.config.floodProt.FloodProtMsgUnignore = "<nick> is unignored"
 	}
 }
 
@@ -34,9 +62,17 @@ type floodProtInfo struct {
 	// ignored.
 	isIgnored bool
 
+	// network is the network that the user is connected to.
+	network *network
+
 	// nick contains the current nick of the user.
 	nick string
 
+	// target is where the ignore and unignore messages are sent, it is
+	// the channel where the last command was issued or the nick of the
+	// user if the command was sent as a private message.
+	target string
+
 	// commandCount contains the number of commands the user has issued
 	// within the last x seconds.
 	commandCount int
@@ -45,71 +81,96 @@ type floodProtInfo struct {
 	lastTimestamp int
 }
 
+// floodProtKey returns the key of the host in the flood protection and dynamic
+// ignore maps, hosts are tracked separately for each network.
+func floodProtKey(n *network, host string) string {
+	return n.Name + " " + host
+}
+
 // Keep track of the command rate for all users within the channel.
 var (
 	floodProt   map[string]*floodProtInfo
 	floodProtMu sync.Mutex
 )
 
-// initFloodProt initializes the flood protection.
+// initFloodProt initializes the flood protection and the dynamic ignore
+// maps. The maps are kept if the configuration is reloaded, so that ignored
+// users stays ignored.
 func (b *bot) initFloodProt() {
-	floodProt = make(map[string]*floodProtInfo)
-
-	// This goroutine is responsible for the unignore phase of the flood
-	// protection system.
-	// It is executed once every minute and iterates over the map of flood
-	// protection info, for every user that has been ignored a check is
-	// made to determine if the user should be unignored or not.
-	go func(b *bot) {
-		for {
-			// Get the current timestamp.
-			timestamp := newUnixTimestamp()
-
-			// Acquire a lock for the flood prot map.
-			floodProtMu.Lock()
-
-			// Iterate over the flood protection info and check
-			// if enough time has passed to remove the ignore for
-			// the user.
-			for k, v := range floodProt {
-				if v.isIgnored && timestamp-v.lastTimestamp > b.IRC.FloodProtIgnoreTime {
-					v.isIgnored = false
-
-					// Time to remove the ignore from the
-					// ignore map, so we need to acquire a
-					// lock to prevent a race condition.
-					b.IRC.ignoreDynMu.Lock()
-					delete(b.IRC.ignoreDyn, k)
-					b.IRC.ignoreDynMu.Unlock()
-
-					b.privmsgph(b.IRC.FloodProtMsgUnignore, map[string]string{
-						"<nick>": v.nick,
-					})
-				}
-			}
+	floodProtMu.Lock()
+	if floodProt == nil {
+		floodProt = make(map[string]*floodProtInfo)
+	}
+	floodProtMu.Unlock()
 
-			// Release the lock.
-			floodProtMu.Unlock()
+	b.IRC.ignoreDynMu.Lock()
+	if b.IRC.ignoreDyn == nil {
+		b.IRC.ignoreDyn = make(map[string]bool)
+	}
+	b.IRC.ignoreDynMu.Unlock()
+}
 
-			// We'll sleep the goroutine for 60 seconds before we
-			// run the check again to see if the users that has
-			// been ignored due to flood protection should be
-			// unignored.
-			time.Sleep(time.Duration(60 * time.Second))
+// floodProtUnignore is responsible for the unignore phase of the flood
+// protection system. It is executed once every minute and iterates over the
+// map of flood protection info, for every user that has been ignored a check
+// is made to determine if the user should be unignored or not.
+func (b *bot) floodProtUnignore(ctx context.Context) {
+	for {
+		// Get the current timestamp.
+		timestamp := newUnixTimestamp()
+
+		// Acquire a lock for the configuration, since a reload might
+		// replace the settings, and for the flood prot map.
+		b.configMu.RLock()
+		floodProtMu.Lock()
+
+		// Iterate over the flood protection info and check if enough
+		// time has passed to remove the ignore for the user.
+		for k, v := range floodProt {
+			if v.isIgnored && timestamp-v.lastTimestamp > b.config.floodProt.FloodProtIgnoreTime {
+				v.isIgnored = false
+
+				// Time to remove the ignore from the ignore
+				// map, so we need to acquire a lock to
+				// prevent a race condition.
+				b.IRC.ignoreDynMu.Lock()
+				delete(b.IRC.ignoreDyn, k)
+				b.IRC.ignoreDynMu.Unlock()
+
+				v.network.announceph(v.target, b.config.floodProt.FloodProtMsgUnignore, map[string]string{
+					"<nick>": v.nick,
+				})
+			}
 		}
-	}(b)
+
+		// Release the locks.
+		floodProtMu.Unlock()
+		b.configMu.RUnlock()
+
+		// We'll sleep for 60 seconds before we run the check again to
+		// see if the users that has been ignored due to flood
+		// protection should be unignored.
+		select {
+		case <-time.After(60 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-func (b *bot) floodProtHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+func (b *bot) floodProtHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
 
 	// We are not intrested in preventing flood for anything but commands,
-	// so unless the message starts with a ! we'll just ignore the
-	// message.
-	if !strings.HasPrefix(a.msg, "!") {
+	// so unless the message starts with the command prefix we'll just
+	// ignore the message.
+	if !strings.HasPrefix(a.msg, b.IRC.CommandPrefix) {
 		return
 	}
 
+	// Determine where the ignore notifications should be sent.
+	target := a.replyTarget()
+
 	// Acquire a lock for the flood prot map and release it as soon as we
 	// return.
 	floodProtMu.Lock()
@@ -117,10 +178,13 @@ func (b *bot) floodProtHandler(m *irc.Message) {
 
 	// Check whether or not the host has an entry in the flood prot map.
 	// If it doesn't we'll insert a new entry and return.
-	info, ok := floodProt[a.host]
+	key := floodProtKey(n, a.host)
+	info, ok := floodProt[key]
 	if !ok {
-		floodProt[a.host] = &floodProtInfo{
+		floodProt[key] = &floodProtInfo{
+			network:       n,
 			nick:          a.nick,
+			target:        target,
 			lastTimestamp: newUnixTimestamp(),
 		}
 		return
@@ -133,7 +197,7 @@ func (b *bot) floodProtHandler(m *irc.Message) {
 	// The current timestamp subtracted with the last timestamp was less
 	// than the configured time threshold, so we need to increment the
 	// command count by one.
-	if timestamp-info.lastTimestamp < b.IRC.FloodProtTimeThreshold {
+	if timestamp-info.lastTimestamp < b.config.floodProt.FloodProtTimeThreshold {
 		// Increment the command count if it was executed prior to the cmd
 		// threshold.
 		info.commandCount += 1
@@ -142,14 +206,15 @@ func (b *bot) floodProtHandler(m *irc.Message) {
 		// value in cmd threshold we'll mark the user as ignored so
 		// that the shouldIgnore method can ignore the command by the
 		// user.
-		if !info.isIgnored && info.commandCount >= b.IRC.FloodProtCmdThreshold {
+		if !info.isIgnored && info.commandCount >= b.config.floodProt.FloodProtCmdThreshold {
 			info.isIgnored = true
 
 			b.IRC.ignoreDynMu.Lock()
-			b.IRC.ignoreDyn[a.host] = true
+			b.IRC.ignoreDyn[key] = true
 			b.IRC.ignoreDynMu.Unlock()
+			b.metrics.floodIgnores.inc(n.Name)
 
-			b.privmsgph(b.IRC.FloodProtMsgIgnore, map[string]string{
+			n.privmsgph(target, b.config.floodProt.FloodProtMsgIgnore, map[string]string{
 				"<nick>": a.nick,
 			})
 		}
@@ -159,8 +224,9 @@ func (b *bot) floodProtHandler(m *irc.Message) {
 		info.commandCount = 0
 	}
 
-	// And finally, update the nick and set the last timestamp to the
-	// current timestamp.
+	// And finally, update the nick and target and set the last timestamp
+	// to the current timestamp.
 	info.nick = a.nick
+	info.target = target
 	info.lastTimestamp = timestamp
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFloodProt(t *testing.T) {
+	// The flood protection state is global, so it's reset to make sure
+	// that the test starts from scratch.
+	floodProtMu.Lock()
+	floodProt = nil
+	floodProtMu.Unlock()
+
+	tb := newTestBot(t, map[string]interface{}{
+		"enableFactoid":   true,
+		"enableFloodProt": true,
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!factoid add coffee _is_ hot")
+	s.expect("PRIVMSG #bot :noted")
+
+	// The fourth command within five seconds gets the user ignored.
+	for i := 0; i < 4; i++ {
+		s.privmsg("mallory", "#bot", "!factoid count coffee")
+	}
+	s.expect("PRIVMSG #bot :mallory is ignored")
+
+	// The commands of the ignored user are dropped, other users are
+	// unaffected.
+	s.privmsg("mallory", "#bot", "!factoid count tea")
+	s.expectNo("PRIVMSG #bot :tea", 200*time.Millisecond)
+
+	s.privmsg("alice", "#bot", "!factoid count tea")
+	s.expect("PRIVMSG #bot :tea has 0 occurrences")
+}
@@ -6,12 +6,38 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"strings"
-
-	"github.com/osm/irc"
+	"time"
 )
 
+// giphyConfig holds the configuration of the giphy module.
+type giphyConfig struct {
+	EnableGiphy          bool   `json:"enableGiphy"`
+	GiphyCmd             string `json:"giphyCmd"`
+	GiphyLang            string `json:"giphyLang"`
+	GiphyAPIKey          string `json:"giphyAPIKey"`
+	GiphyMsgNothingFound string `json:"giphyMsgNothingFound"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureGiphy,
+		config:  func(b *bot) interface{} { return &b.config.giphy },
+		enabled: func(b *bot) bool { return b.config.giphy.EnableGiphy },
+		init:    (*bot).initGiphyDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.giphy.GiphyCmd,
+				usage:   "[search]",
+				maxArgs: unlimitedArgs,
+				feature: featureGiphy,
+				fn:      (*bot).giphyCommand,
+			}}
+		},
+	})
+}
+
 // Define Giphy errors
 var (
 	GiphyNoAPIKey          = errors.New("You need to set a Giphy API key")
@@ -21,14 +47,14 @@ var (
 
 // initGiphyDefaults sets default values for all settings.
 func (b *bot) initGiphyDefaults() {
-	if b.IRC.GiphyCmd == "" {
-		b.IRC.GiphyCmd = "!giphy"
+	if b.config.giphy.GiphyCmd == "" {
+		b.config.giphy.GiphyCmd = "giphy"
 	}
-	if b.IRC.GiphyLang == "" {
-		b.IRC.GiphyLang = "en"
+	if b.config.giphy.GiphyLang == "" {
+		b.config.giphy.GiphyLang = "en"
 	}
-	if b.IRC.GiphyMsgNothingFound == "" {
-		b.IRC.GiphyMsgNothingFound = "nothing found"
+	if b.config.giphy.GiphyMsgNothingFound == "" {
+		b.config.giphy.GiphyMsgNothingFound = "nothing found"
 	}
 }
 
@@ -47,25 +73,12 @@ type GiphyMeta struct {
 	Msg    string `json:"msg"`
 }
 
-// giphyHandler handles the giphy integration.
+// giphyCommand handles the giphy integration.
 // If arguments are passed a search will be performed, otherwise a random
 // giphy will be returned to the channel.
-func (b *bot) giphyHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
-		return
-	}
-
-	if a.cmd != b.IRC.GiphyCmd {
-		return
-	}
-
-	if b.shouldIgnore(m) {
-		return
-	}
-
-	if b.IRC.GiphyAPIKey == "" {
-		b.logger.Printf("giphyHandler: you need to set a giphy api key\n")
+func (b *bot) giphyCommand(a *privmsgAction) {
+	if b.config.giphy.GiphyAPIKey == "" {
+		b.log(featureGiphy).Warnf("giphyCommand: you need to set a giphy api key")
 		return
 	}
 
@@ -74,13 +87,13 @@ func (b *bot) giphyHandler(m *irc.Message) {
 	if len(a.args) == 0 {
 		giphy, err = b.giphyRandom()
 	} else {
-		giphy, err = b.giphySearch(a.msg)
+		giphy, err = b.giphySearch(strings.Join(a.args, " "))
 	}
 
 	if err == GiphyNothingFound {
-		b.privmsg(b.IRC.GiphyMsgNothingFound)
+		a.reply(b.config.giphy.GiphyMsgNothingFound)
 	} else if giphy != "" {
-		b.privmsg(giphy)
+		a.reply(giphy)
 	}
 }
 
@@ -91,26 +104,29 @@ func giphyStripCid(url string) string {
 
 // giphyRandom prints a random giphy to the chanel.
 func (b *bot) giphyRandom() (string, error) {
-	if b.IRC.GiphyAPIKey == "" {
-		b.logger.Printf("giphyRandom: you need to set a giphy api key\n")
+	if b.config.giphy.GiphyAPIKey == "" {
+		b.log(featureGiphy).Warnf("giphyRandom: you need to set a giphy api key")
 		return "", GiphyNoAPIKey
 	}
 
 	url := fmt.Sprintf(
-		"https://api.giphy.com/v1/gifs/random?api_key=%s&rating=R",
-		b.IRC.GiphyAPIKey,
+		"%s/v1/gifs/random?api_key=%s&rating=R",
+		b.baseURL("giphy"),
+		b.config.giphy.GiphyAPIKey,
 	)
 
-	res, err := http.Get(url)
+	start := time.Now()
+	res, err := b.httpGet(url)
+	b.metrics.observeHTTP("giphy", start, res, err)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
@@ -120,7 +136,7 @@ func (b *bot) giphyRandom() (string, error) {
 	}
 	err = json.Unmarshal(data, &g)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
@@ -133,28 +149,31 @@ func (b *bot) giphyRandom() (string, error) {
 
 // giphySearch search and return a random giphy for the given query.
 func (b *bot) giphySearch(query string) (string, error) {
-	if b.IRC.GiphyAPIKey == "" {
-		b.logger.Printf("giphySearch: you need to set a giphy api key\n")
+	if b.config.giphy.GiphyAPIKey == "" {
+		b.log(featureGiphy).Warnf("giphySearch: you need to set a giphy api key")
 		return "", GiphyNoAPIKey
 	}
 
 	url := fmt.Sprintf(
-		"https://api.giphy.com/v1/gifs/search?api_key=%s&q=%s&rating=R&lang=%s",
-		b.IRC.GiphyAPIKey,
-		strings.Replace(strings.Replace(query, fmt.Sprintf("%s ", b.IRC.GiphyCmd), "", 1), " ", "%20", -1),
-		b.IRC.GiphyLang,
+		"%s/v1/gifs/search?api_key=%s&q=%s&rating=R&lang=%s",
+		b.baseURL("giphy"),
+		b.config.giphy.GiphyAPIKey,
+		strings.Replace(strings.Replace(query, fmt.Sprintf("%s ", b.config.giphy.GiphyCmd), "", 1), " ", "%20", -1),
+		b.config.giphy.GiphyLang,
 	)
 
-	res, err := http.Get(url)
+	start := time.Now()
+	res, err := b.httpGet(url)
+	b.metrics.observeHTTP("giphy", start, res, err)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
@@ -164,7 +183,7 @@ func (b *bot) giphySearch(query string) (string, error) {
 	}
 	err = json.Unmarshal(data, &g)
 	if err != nil {
-		b.logger.Printf("giphy: %v", err)
+		b.log(featureGiphy).Errorf("giphy: %v", err)
 		return "", err
 	}
 
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGoogleSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" || r.URL.Query().Get("q") != "osm bot" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/url?q="+url.QueryEscape("https://github.com/osm/bot"), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	tb := newTestBotWithConfig(t, map[string]interface{}{
+		"enableGoogleSearch": true,
+	}, map[string]interface{}{
+		"httpClient": map[string]interface{}{
+			"baseURLs": map[string]string{"googleSearch": srv.URL},
+		},
+	})
+	defer tb.close()
+
+	tb.server.privmsg("alice", "#bot", "!g osm bot")
+	tb.server.expect("PRIVMSG #bot :https://github.com/osm/bot")
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout is how long the tests waits for the bot to do something.
+const testTimeout = 5 * time.Second
+
+// fakeServer is an in-memory IRC server that the bot connects to in the
+// end-to-end tests. It completes the registration without any capabilities,
+// echoes the joins of the bot and records all lines that the bot sends.
+type fakeServer struct {
+	t *testing.T
+
+	// nick is the nick that the bot registered with.
+	nick string
+
+	// out holds the lines that are sent to the bot, lines holds the lines
+	// that are received from the bot. Lines is closed when the bot has
+	// disconnected.
+	out   chan string
+	lines chan string
+
+	// registered is closed when the registration is completed.
+	registered chan struct{}
+}
+
+// newFakeServer returns a server that the bot can dial.
+func newFakeServer(t *testing.T) *fakeServer {
+	return &fakeServer{
+		t:          t,
+		out:        make(chan string, 100),
+		lines:      make(chan string, 1000),
+		registered: make(chan struct{}),
+	}
+}
+
+// dial is the dialer of the bot, it returns the client side of a pipe and
+// serves the other side.
+func (s *fakeServer) dial(n *network) (net.Conn, error) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go s.read(server, done)
+	go s.write(server, done)
+	return client, nil
+}
+
+// read reads the lines from the bot and answers the registration. The
+// connection is closed when the bot quits.
+func (s *fakeServer) read(conn net.Conn, done chan struct{}) {
+	defer close(s.lines)
+	defer close(done)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	capEnd, registered := false, false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CAP":
+			if len(fields) > 1 && fields[1] == "LS" {
+				s.send(":fake.example.com CAP * LS :")
+			}
+			if len(fields) > 1 && fields[1] == "END" {
+				capEnd = true
+			}
+		case "NICK":
+			if len(fields) > 1 {
+				s.nick = fields[1]
+			}
+		case "JOIN":
+			if len(fields) > 1 {
+				s.send(":%s!%s@bot.example.com JOIN %s", s.nick, s.nick, fields[1])
+			}
+		case "QUIT":
+			return
+		}
+
+		// The registration is completed once the capability
+		// negotiation has ended and the bot has a nick.
+		if capEnd && s.nick != "" && !registered {
+			s.send(":fake.example.com 001 %s :Welcome", s.nick)
+			close(s.registered)
+			registered = true
+		}
+
+		s.lines <- line
+	}
+}
+
+// write writes the queued lines to the bot. The lines are written from their
+// own goroutine since the pipe blocks until the other side reads.
+func (s *fakeServer) write(conn net.Conn, done chan struct{}) {
+	for {
+		select {
+		case line := <-s.out:
+			if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// send queues a line to the bot.
+func (s *fakeServer) send(format string, args ...interface{}) {
+	s.out <- fmt.Sprintf(format, args...)
+}
+
+// privmsg sends a message from the nick to the target, the host of the
+// sender is <nick>@<nick>.example.com.
+func (s *fakeServer) privmsg(nick, target, message string) {
+	s.send(":%s!%s@%s.example.com PRIVMSG %s :%s", nick, nick, nick, target, message)
+}
+
+// expect waits until the bot sends the line, the lines that are sent before
+// it are skipped.
+func (s *fakeServer) expect(line string) {
+	s.t.Helper()
+
+	var seen []string
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case l, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("the bot disconnected while waiting for %q, got %q", line, seen)
+			}
+			if l == line {
+				return
+			}
+			seen = append(seen, l)
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %q, got %q", line, seen)
+		}
+	}
+}
+
+// expectNo makes sure that the bot doesn't send any line that starts with the
+// prefix within the given duration.
+func (s *fakeServer) expectNo(prefix string, d time.Duration) {
+	s.t.Helper()
+
+	timeout := time.After(d)
+	for {
+		select {
+		case l, ok := <-s.lines:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(l, prefix) {
+				s.t.Fatalf("expected no line starting with %q, got %q", prefix, l)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// testBot is a bot that is connected to a fake server and uses a temporary
+// SQLite database.
+type testBot struct {
+	*bot
+
+	t      *testing.T
+	server *fakeServer
+	dir    string
+
+	// servers holds the server of each network by name, server is the
+	// server of the first network.
+	servers map[string]*fakeServer
+
+	// done receives the error that start returns.
+	done chan error
+}
+
+// newTestBot starts a bot with the given settings in the irc section of the
+// configuration. The bot joins #bot on the network test and all hosts that
+// starts with admin@ are operators. Each network is served by its own fake
+// server. The bot is started once the registration is completed, close has
+// to be called when the test is done.
+func newTestBot(t *testing.T, settings map[string]interface{}) *testBot {
+	t.Helper()
+	return newTestBotWithConfig(t, settings, nil)
+}
+
+// newTestBotWithConfig is like newTestBot but it also takes the top level
+// sections of the configuration, such as httpClient, that are added to it.
+func newTestBotWithConfig(t *testing.T, settings map[string]interface{}, sections map[string]interface{}) *testBot {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "bot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	irc := map[string]interface{}{
+		"address":     "irc.example.com:6667",
+		"network":     "test",
+		"nick":        "bot",
+		"user":        "bot",
+		"realName":    "bot",
+		"version":     "bot",
+		"gracePeriod": 1,
+		"channels":    []map[string]interface{}{{"name": "#bot"}},
+		"operators":   []string{"^admin@"},
+	}
+	for k, v := range settings {
+		irc[k] = v
+	}
+
+	c := map[string]interface{}{
+		"db":  map[string]interface{}{"path": filepath.Join(dir, "bot.db")},
+		"log": map[string]interface{}{"file": filepath.Join(dir, "bot.log"), "level": "debug"},
+		"irc": irc,
+	}
+	for k, v := range sections {
+		c[k] = v
+	}
+
+	config, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bot.conf")
+	if err := ioutil.WriteFile(path, config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBotFromConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := make(map[string]*fakeServer)
+	for _, n := range b.IRC.Networks {
+		servers[n.Name] = newFakeServer(t)
+	}
+	b.dialer = func(n *network) (net.Conn, error) {
+		return servers[n.Name].dial(n)
+	}
+
+	tb := &testBot{
+		bot:     b,
+		t:       t,
+		server:  servers[b.IRC.Networks[0].Name],
+		servers: servers,
+		dir:     dir,
+		done:    make(chan error, 1),
+	}
+	go func() { tb.done <- b.start() }()
+
+	for _, s := range servers {
+		select {
+		case <-s.registered:
+		case err := <-tb.done:
+			tb.removeDir()
+			t.Fatalf("the bot stopped before it registered: %v", err)
+		case <-time.After(testTimeout):
+			tb.removeDir()
+			t.Fatal("timed out waiting for the bot to register")
+		}
+	}
+
+	return tb
+}
+
+// close stops the bot and removes the database and the logs.
+func (tb *testBot) close() {
+	tb.t.Helper()
+
+	tb.stop(nil)
+	select {
+	case err := <-tb.done:
+		if err != nil {
+			tb.t.Errorf("start: %v", err)
+		}
+	case <-time.After(2 * shutdownTimeout):
+		tb.t.Error("timed out waiting for the bot to stop")
+	}
+
+	tb.removeDir()
+}
+
+// removeDir removes the temporary directory of the bot, the log is printed
+// if the test has failed.
+func (tb *testBot) removeDir() {
+	if tb.t.Failed() {
+		if log, err := ioutil.ReadFile(filepath.Join(tb.dir, "bot.log")); err == nil {
+			tb.t.Logf("bot.log:\n%s", log)
+		}
+	}
+
+	os.RemoveAll(tb.dir)
+}
+
+// queryString returns the string that the query returns, an empty string is
+// returned if there are no rows.
+func (tb *testBot) queryString(query string, args ...interface{}) string {
+	tb.t.Helper()
+
+	var s string
+	if err := tb.queryRow(query, args...).Scan(&s); err != nil && err != sql.ErrNoRows {
+		tb.t.Fatalf("%s: %v", query, err)
+	}
+	return s
+}
+
+// waitFor waits until the condition is true, the event handlers runs
+// concurrently so the tests can't rely on the order of their side effects.
+func (tb *testBot) waitFor(what string, cond func() bool) {
+	tb.t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			tb.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of the health checks.
+const (
+	healthOK      = "ok"
+	healthFail    = "fail"
+	healthStale   = "stale"
+	healthPending = "pending"
+)
+
+// smhiStaleAfter is how old the last SMHI fetch can be before it's reported
+// as stale, the forecasts are fetched once every hour.
+const smhiStaleAfter = 2 * time.Hour
+
+// healthState holds the state that the health checks report on that isn't
+// kept anywhere else.
+type healthState struct {
+	mu sync.Mutex
+
+	// smhiFetched is the time of the last successful SMHI fetch.
+	smhiFetched time.Time
+}
+
+// setSMHIFetched records a successful SMHI fetch.
+func (h *healthState) setSMHIFetched(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.smhiFetched = t
+}
+
+// lastSMHIFetch returns the time of the last successful SMHI fetch.
+func (h *healthState) lastSMHIFetch() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.smhiFetched
+}
+
+// healthReport is the JSON that is returned by the health and readiness
+// routes.
+type healthReport struct {
+	Status   string            `json:"status"`
+	Networks []networkHealth   `json:"networks"`
+	Database componentHealth   `json:"database"`
+	SMHI     *smhiHealth       `json:"smhi,omitempty"`
+	Workers  map[string]string `json:"workers"`
+}
+
+// networkHealth is the connection state of a network, channels tells
+// whether the configured channels have been joined.
+type networkHealth struct {
+	Name      string          `json:"name"`
+	Status    string          `json:"status"`
+	Connected bool            `json:"connected"`
+	Channels  map[string]bool `json:"channels"`
+}
+
+// componentHealth is the state of a component that can fail.
+type componentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// smhiHealth is the state of the SMHI forecast fetcher.
+type smhiHealth struct {
+	Status    string     `json:"status"`
+	LastFetch *time.Time `json:"lastFetch,omitempty"`
+}
+
+// healthReport checks the state of the bot. The bot is healthy as long as
+// the database answers and all background workers are running, it's also
+// ready when all networks are connected and all channels are joined. A
+// stale SMHI fetch is reported but doesn't affect the status.
+func (b *bot) healthReport(ready bool) healthReport {
+	r := healthReport{
+		Status:   healthOK,
+		Workers:  b.workerStates(),
+		Database: b.databaseHealth(),
+	}
+
+	for _, state := range r.Workers {
+		if state != workerRunning {
+			r.Status = healthFail
+		}
+	}
+	if r.Database.Status != healthOK {
+		r.Status = healthFail
+	}
+
+	for _, n := range b.IRC.Networks {
+		nh := n.health()
+		if ready && nh.Status != healthOK {
+			r.Status = healthFail
+		}
+		r.Networks = append(r.Networks, nh)
+	}
+
+	if b.moduleEnabled(featureSMHI) {
+		r.SMHI = &smhiHealth{Status: healthPending}
+		if t := b.health.lastSMHIFetch(); !t.IsZero() {
+			r.SMHI.Status = healthOK
+			if time.Since(t) > smhiStaleAfter {
+				r.SMHI.Status = healthStale
+			}
+			r.SMHI.LastFetch = &t
+		}
+	}
+
+	return r
+}
+
+// databaseHealth pings the database.
+func (b *bot) databaseHealth() componentHealth {
+	if b.DB.client == nil {
+		return componentHealth{Status: healthFail, Error: "not connected"}
+	}
+
+	ctx, cancel := context.WithTimeout(b.ctx, 2*time.Second)
+	defer cancel()
+
+	if err := b.DB.client.PingContext(ctx); err != nil {
+		return componentHealth{Status: healthFail, Error: err.Error()}
+	}
+
+	return componentHealth{Status: healthOK}
+}
+
+// health returns the connection state of the network.
+func (n *network) health() networkHealth {
+	h := networkHealth{
+		Name:      n.Name,
+		Status:    healthOK,
+		Connected: n.isConnected(),
+		Channels:  make(map[string]bool),
+	}
+	if !h.Connected {
+		h.Status = healthFail
+	}
+
+	for _, c := range n.Channels {
+		h.Channels[c.Name] = h.Connected && n.joined(c.Name)
+		if !h.Channels[c.Name] {
+			h.Status = healthFail
+		}
+	}
+
+	return h
+}
+
+// joined returns true if the bot is in the channel, according to the names
+// map.
+func (n *network) joined(channel string) bool {
+	nick := n.client.GetNick()
+
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	for name := range n.names[strings.ToLower(channel)] {
+		if strings.EqualFold(name, nick) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// healthHandler writes the health report, the status code is 503 if the bot
+// isn't healthy, or ready if ready is true.
+func (b *bot) healthHandler(w http.ResponseWriter, r *http.Request, ready bool) {
+	report := b.healthReport(ready)
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
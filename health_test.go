@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// healthRequest makes a request to the health or the readiness route and
+// returns the status code and the decoded report.
+func healthRequest(t *testing.T, b *bot, ready bool) (int, healthReport) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	b.healthHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), ready)
+
+	var report healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("can't decode %q: %v", w.Body.String(), err)
+	}
+	return w.Code, report
+}
+
+func TestHealthReady(t *testing.T) {
+	tb := newTestBot(t, nil)
+	defer tb.close()
+
+	tb.waitFor("the bot to be ready", func() bool {
+		code, _ := healthRequest(t, tb.bot, true)
+		return code == http.StatusOK
+	})
+	code, report := healthRequest(t, tb.bot, true)
+	if report.Database.Status != healthOK || len(report.Networks) != 1 || !report.Networks[0].Connected || !report.Networks[0].Channels["#bot"] {
+		t.Errorf("expected the network to be connected and the channel to be joined, got %d %+v", code, report)
+	}
+
+	// The bot is still healthy when it's not in all channels, but it's
+	// not ready.
+	tb.server.send(":bot!bot@bot.example.com PART #bot")
+	tb.waitFor("the bot not to be ready", func() bool {
+		code, report := healthRequest(t, tb.bot, true)
+		return code == http.StatusServiceUnavailable && report.Status == healthFail && !report.Networks[0].Channels["#bot"]
+	})
+	if code, report := healthRequest(t, tb.bot, false); code != http.StatusOK || report.Status != healthOK {
+		t.Errorf("expected the bot to be healthy, got %d %+v", code, report)
+	}
+
+	tb.server.send(":irc.example.com 353 bot = #bot :@bot bob")
+	tb.waitFor("the bot to be ready again", func() bool {
+		code, _ := healthRequest(t, tb.bot, true)
+		return code == http.StatusOK
+	})
+}
+
+func TestHealthFailures(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+
+	b := &bot{}
+	b.modules.state = map[string]*moduleState{
+		featureCron: {enabled: true, done: stopped},
+		featureSMHI: {enabled: true},
+	}
+
+	code, report := healthRequest(t, b, false)
+	if code != http.StatusServiceUnavailable || report.Status != healthFail {
+		t.Errorf("expected the bot to be unhealthy, got %d %+v", code, report)
+	}
+	if report.Workers[featureCron] != workerStopped {
+		t.Errorf("expected the cron worker to be stopped, got %v", report.Workers)
+	}
+	if report.Database.Status != healthFail || report.Database.Error != "not connected" {
+		t.Errorf("expected the database to fail, got %+v", report.Database)
+	}
+
+	// The SMHI fetch is reported but never makes the bot unhealthy.
+	for _, test := range []struct {
+		fetched time.Time
+		status  string
+	}{
+		{time.Time{}, healthPending},
+		{time.Now().Add(-time.Minute), healthOK},
+		{time.Now().Add(-3 * time.Hour), healthStale},
+	} {
+		b.health.setSMHIFetched(test.fetched)
+		if r := b.healthReport(false); r.SMHI == nil || r.SMHI.Status != test.status {
+			t.Errorf("fetched at %v: expected %s, got %+v", test.fetched, test.status, r.SMHI)
+		}
+	}
+}
@@ -3,29 +3,83 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
-// initHTTP initializes the HTTP server.
-func (b *bot) initHTTP() {
-	if b.HTTP.EnableEcho {
-		b.initEcho()
+// initHTTPDefaults sets the default values of the HTTP routes, it's also
+// called when the configuration is reloaded.
+func (b *bot) initHTTPDefaults() {
+	b.initEcho()
+	b.initWebhookDefaults()
+
+	if b.HTTP.ReloadRoute == "" {
+		b.HTTP.ReloadRoute = "/reload"
+	}
+	if b.HTTP.MetricsRoute == "" {
+		b.HTTP.MetricsRoute = "/metrics"
+	}
+	if b.HTTP.APIRoute == "" {
+		b.HTTP.APIRoute = "/api"
+	}
+	if b.HTTP.WebUIRoute == "" {
+		b.HTTP.WebUIRoute = "/ui"
+	}
+	if b.HTTP.HealthRoute == "" {
+		b.HTTP.HealthRoute = "/healthz"
+	}
+	if b.HTTP.ReadyRoute == "" {
+		b.HTTP.ReadyRoute = "/readyz"
 	}
+}
 
+// initHTTP initializes the HTTP server.
+func (b *bot) initHTTP() {
 	// Handle all routing from here.
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		b.HTTP.logger.Printf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		b.HTTP.logger.Infof("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+
+		// The reload has to be handled before the configuration is
+		// locked for the request, since the reload waits for all
+		// readers. The settings of the route are only read while it's
+		// locked.
+		b.configMu.RLock()
+		reload := b.HTTP.EnableReload && r.URL.Path == b.HTTP.ReloadRoute && r.Method == http.MethodPost
+		b.configMu.RUnlock()
+		if reload {
+			b.reloadHandler(w, r)
+			return
+		}
+
+		b.configMu.RLock()
+		defer b.configMu.RUnlock()
 
 		if b.HTTP.EnableEcho && r.URL.Path == b.HTTP.EchoRoute && r.Method == b.HTTP.EchoMethod {
 			b.echoHandler(w, r)
+		} else if b.HTTP.EnableMetrics && r.URL.Path == b.HTTP.MetricsRoute && r.Method == http.MethodGet {
+			b.metricsHandler(w, r)
+		} else if b.HTTP.EnableHealth && r.URL.Path == b.HTTP.HealthRoute && r.Method == http.MethodGet {
+			b.healthHandler(w, r, false)
+		} else if b.HTTP.EnableHealth && r.URL.Path == b.HTTP.ReadyRoute && r.Method == http.MethodGet {
+			b.healthHandler(w, r, true)
+		} else if b.HTTP.EnableAPI && strings.HasPrefix(r.URL.Path, b.HTTP.APIRoute+"/") {
+			b.apiHandler(w, r)
+		} else if h := b.webhook(r.URL.Path); b.HTTP.EnableWebhooks && h != nil && r.Method == http.MethodPost {
+			b.webhookHandler(w, r, h)
+		} else if b.HTTP.EnableWebUI && (r.URL.Path == b.HTTP.WebUIRoute || strings.HasPrefix(r.URL.Path, b.HTTP.WebUIRoute+"/")) {
+			b.webUIHandler(w, r)
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprintf(w, "404")
 		}
 	})
 
-	// Fire up the server in a goroutine so that we aren't blocking.
+	// Fire up the server in a goroutine so that we aren't blocking, it's
+	// stopped by Shutdown when the bot shuts down.
+	b.HTTP.server = &http.Server{Addr: b.HTTP.Address + ":" + b.HTTP.Port}
 	go func() {
-		http.ListenAndServe(b.HTTP.Address+":"+b.HTTP.Port, nil)
+		if err := b.HTTP.server.ListenAndServe(); err != http.ErrServerClosed {
+			b.HTTP.logger.Errorf("initHTTP: %v", err)
+		}
 		b.mainWG.Done()
 	}()
 }
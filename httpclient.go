@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpClientConfig holds the settings of the HTTP client that all
+// integrations use to talk to the outside world.
+type httpClientConfig struct {
+	// Timeout is the timeout of each request in seconds, it defaults to
+	// 10 seconds.
+	Timeout int `json:"timeout"`
+
+	// UserAgent is sent with all requests that doesn't set their own,
+	// it defaults to the name and version of the bot.
+	UserAgent string `json:"userAgent"`
+
+	// Proxy is the URL of the proxy that the requests are sent through,
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are
+	// used if it's empty.
+	Proxy string `json:"proxy"`
+
+	// BaseURLs overrides the base URLs of the integrations, the key is
+	// the name of the integration. It makes it possible to point an
+	// integration at a mirror or at a fake server in the tests.
+	BaseURLs map[string]string `json:"baseURLs"`
+
+	// client is created from the settings by initHTTPClient.
+	client *http.Client
+}
+
+// defaultBaseURLs contains the base URL of each integration.
+var defaultBaseURLs = map[string]string{
+	"dumpinen":       "https://dumpinen.com",
+	"giphy":          "https://api.giphy.com",
+	"googleSearch":   "https://www.google.com",
+	"lyssnar":        "http://lyssnar.com",
+	"openweathermap": "http://api.openweathermap.org",
+	"supernytt":      "https://direkte.vg.no",
+	"tenor":          "https://api.tenor.com",
+	"updateNotifier": "https://github.com",
+}
+
+// userAgentTransport sets the User-Agent header of the requests that doesn't
+// have one.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		// The request must not be modified, so the header is set on a
+		// copy of it.
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// initHTTPClient sets the default values of the HTTP client settings and
+// creates the client, all problems with the settings are returned.
+func (b *bot) initHTTPClient() configErrors {
+	var errs configErrors
+	c := &b.HTTPClient
+
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, configError{"httpClient.timeout", "can't be negative"})
+	}
+
+	if c.UserAgent == "" {
+		c.UserAgent = "bot"
+		if VERSION != "" {
+			c.UserAgent += "/" + VERSION
+		}
+		c.UserAgent += " (+https://github.com/osm/bot)"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Host == "" {
+			errs = append(errs, configError{"httpClient.proxy", fmt.Sprintf("%q is not a valid URL", c.Proxy)})
+		} else {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+	}
+
+	for _, name := range sortedStringKeys(c.BaseURLs) {
+		path := "httpClient.baseURLs." + name
+		if _, ok := defaultBaseURLs[name]; !ok {
+			errs = append(errs, configError{path, fmt.Sprintf("%s is not an integration, it must be one of %s", name, strings.Join(sortedStringKeys(defaultBaseURLs), ", "))})
+			continue
+		}
+		if u, err := url.Parse(c.BaseURLs[name]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, configError{path, fmt.Sprintf("%q is not a valid http or https URL", c.BaseURLs[name])})
+		}
+	}
+
+	c.client = &http.Client{
+		Timeout:   time.Duration(c.Timeout) * time.Second,
+		Transport: &userAgentTransport{c.UserAgent, transport},
+	}
+
+	return errs
+}
+
+// httpClient returns the HTTP client that the integrations should use.
+func (b *bot) httpClient() *http.Client {
+	if b.HTTPClient.client == nil {
+		return http.DefaultClient
+	}
+
+	return b.HTTPClient.client
+}
+
+// baseURL returns the base URL of the integration, without a trailing slash.
+func (b *bot) baseURL(integration string) string {
+	if u, ok := b.HTTPClient.BaseURLs[integration]; ok {
+		return strings.TrimRight(u, "/")
+	}
+
+	return defaultBaseURLs[integration]
+}
+
+// httpGet sends a GET request to the URL with the HTTP client of the
+// integrations.
+func (b *bot) httpGet(url string) (*http.Response, error) {
+	return b.httpClient().Get(url)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	b := &bot{}
+	if errs := b.initHTTPClient(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	res, err := b.httpGet(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ua := <-agents; !strings.HasPrefix(ua, "bot") {
+		t.Errorf("expected the default user agent, got %q", ua)
+	}
+
+	// Requests that sets their own User-Agent keeps it.
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("User-Agent", "custom")
+	res, err = b.httpClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ua := <-agents; ua != "custom" {
+		t.Errorf("expected the user agent of the request, got %q", ua)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	b := &bot{}
+	b.HTTPClient.Timeout = 1
+	if errs := b.initHTTPClient(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	start := time.Now()
+	if _, err := b.httpGet(srv.URL); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if d := time.Since(start); d > testTimeout {
+		t.Errorf("the request took %v", d)
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	urls := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urls <- r.URL.String()
+	}))
+	defer proxy.Close()
+
+	b := &bot{}
+	b.HTTPClient.Proxy = proxy.URL
+	if errs := b.initHTTPClient(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	res, err := b.httpGet("http://mirror.example.com/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if u := <-urls; u != "http://mirror.example.com/test" {
+		t.Errorf("expected the request to go through the proxy, got %q", u)
+	}
+}
+
+func TestHTTPClientBaseURLs(t *testing.T) {
+	b := &bot{}
+	b.HTTPClient.BaseURLs = map[string]string{"giphy": "http://giphy.example.com/"}
+	if errs := b.initHTTPClient(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if u := b.baseURL("giphy"); u != "http://giphy.example.com" {
+		t.Errorf("expected the overridden base URL without a trailing slash, got %q", u)
+	}
+	if u := b.baseURL("tenor"); u != defaultBaseURLs["tenor"] {
+		t.Errorf("expected the default base URL, got %q", u)
+	}
+
+	b = &bot{}
+	b.HTTPClient.Timeout = -1
+	b.HTTPClient.Proxy = "::"
+	b.HTTPClient.BaseURLs = map[string]string{
+		"giphy":   "ftp://giphy.example.com",
+		"unknown": "http://example.com",
+	}
+	var paths []string
+	for _, err := range b.initHTTPClient() {
+		paths = append(paths, err.path)
+	}
+	expected := "httpClient.timeout httpClient.proxy httpClient.baseURLs.giphy httpClient.baseURLs.unknown"
+	if p := strings.Join(paths, " "); p != expected {
+		t.Errorf("expected errors for %q, got %q", expected, p)
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"math/rand"
-	"os"
-	"strings"
+	"net"
 	"time"
 
 	"github.com/osm/irc"
@@ -17,271 +17,221 @@ func init() {
 // VERSION will be set to the current git commit id on build.
 var VERSION string
 
-// initIRC connects to the IRC server that is defined in the configuration.
-// The connection is launched within a goroutine. So this function will not
-// block, so we have a wait group that controls the IRC life cycle.
-func (b *bot) initIRC() {
-	// Make sure that all the below options are set in the configuration.
-	if b.IRC.Address == "" {
-		b.logger.Fatalf("irc.address is missing in %v", b.configFile)
-	}
-	if b.IRC.Channel == "" {
-		b.logger.Fatalf("irc.channel is missing in %v", b.configFile)
-	}
-	if b.IRC.Nick == "" {
-		b.logger.Fatalf("irc.nick is missing in %v", b.configFile)
-	}
-	if b.IRC.RealName == "" {
-		b.logger.Fatalf("irc.realName is missing in %v", b.configFile)
-	}
-	if b.IRC.User == "" {
-		b.logger.Fatalf("irc.user is missing in %v", b.configFile)
-	}
-	if b.IRC.Version == "" {
-		b.logger.Fatalf("irc.version is missing in %v", b.configFile)
+// initIRC connects to the IRC networks that are defined in the
+// configuration. Each connection is launched within a goroutine. So this
+// function will not block, so we have a wait group that controls the IRC
+// life cycle. An error is returned if the configuration is invalid or the
+// certificates can't be loaded, nothing has been started when that happens.
+func (b *bot) initIRC() error {
+	// Make sure that the configuration is valid before anything is
+	// started.
+	if err := b.checkConfig(); err != nil {
+		return fmt.Errorf("invalid configuration in %v:\n%v", b.configFile, err)
 	}
 
-	// We'll set the grace period to 750 ms by default if no value was set
-	// in the config.
-	if b.IRC.GracePeriod == 0 {
-		b.IRC.GracePeriod = 750
-	}
+	// Set the default values of the settings that doesn't belong to a
+	// module.
+	b.initIRCDefaults()
 
-	// Prepare an array of irc options.
-	opts := []irc.Option{
-		irc.WithAddr(b.IRC.Address),
-		irc.WithChannel(b.IRC.Channel),
-		irc.WithDebug(),
-		irc.WithNick(b.IRC.Nick),
-		irc.WithRealName(b.IRC.RealName),
-		irc.WithUser(b.IRC.User),
-		irc.WithVersion(fmt.Sprintf("%s %s", b.IRC.Version, VERSION)),
+	// Load the certificates of the networks that uses TLS.
+	if err := b.initTLS(); err != nil {
+		return err
 	}
 
-	// Append post connect messages, if there are any.
-	for _, pcm := range b.IRC.PostConnectMessages {
-		opts = append(opts, irc.WithPostConnectMessage(pcm.Target, pcm.Message))
+	// The clients has to be created before the modules are enabled,
+	// since the modules might start to send messages right away.
+	for _, n := range b.IRC.Networks {
+		b.initClient(n)
 	}
 
-	// Append post connect modes, if there are any.
-	for _, m := range b.IRC.PostConnectModes {
-		opts = append(opts, irc.WithPostConnectMode(m))
-	}
+	b.initModules()
 
-	b.IRC.client = irc.NewClient(opts...)
-
-	b.IRC.client.Handle("JOIN", b.operatorsHandler)
-
-	// Event handlers that are needed for the names map.
-	b.IRC.client.Handle("353", b.handleNamesReply)
-	b.IRC.client.Handle("JOIN", b.handleNamesAdd)
-	b.IRC.client.Handle("PART", b.handleNamesRemove)
-	b.IRC.client.Handle("QUIT", b.handleNamesRemove)
-	b.IRC.client.Handle("NICK", b.handleNamesChange)
-
-	if b.IRC.RejoinOnKick {
-		b.IRC.client.Handle("KICK", b.kickHandler)
-	}
-
-	if b.IRC.EnableFloodProt {
-		b.initFloodProt()
-		b.initFloodProtDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.floodProtHandler)
-	}
-
-	if b.IRC.EnableUpdateNotifier {
-		b.initUpdateNotifier()
-		go b.updateNotifierHandler()
-	}
-
-	if b.IRC.EnableSMHI {
-		b.initSMHIDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.smhiCommandHandler)
-		go b.smhiGetForecasts()
+	for _, n := range b.IRC.Networks {
+		go n.queue.run(n.sendQueued)
+		b.connect(n)
 	}
 
-	if b.IRC.EnableSupernytt {
-		b.initSupernytt()
-		go b.supernyttHandler()
-	}
-
-	if b.IRC.EnableQuiz {
-		b.initQuizDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.quizHandler)
-	}
+	return nil
+}
 
-	if b.IRC.EnableCommands {
-		b.initCommandDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.commandHandler)
+// initIRCDefaults sets the default values of the settings that doesn't
+// belong to a module, it's also called when the configuration is reloaded.
+func (b *bot) initIRCDefaults() {
+	// We'll set the grace period to 750 ms by default if no value was set
+	// in the config.
+	if b.IRC.GracePeriod == 0 {
+		b.IRC.GracePeriod = 750
 	}
+	b.IRC.gracePeriod = time.Duration(b.IRC.GracePeriod) * time.Millisecond
 
-	if b.IRC.EnableURLCheck {
-		b.initURLCheckDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.urlCheckHandler)
+	// The send queue allows a small burst of messages and drops stale
+	// announcements when there are more than 20 messages waiting.
+	if b.IRC.SendBurst == 0 {
+		b.IRC.SendBurst = 3
 	}
-
-	if b.IRC.EnableURLMeta {
-		b.initURLMetaDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.urlMetaHandler)
+	if b.IRC.SendBacklog == 0 {
+		b.IRC.SendBacklog = 20
 	}
-
-	if b.IRC.EnableMarch {
-		b.initMarchDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.marchHandler)
+	if b.IRC.SendStaleAfter == 0 {
+		b.IRC.SendStaleAfter = 60
 	}
-
-	if b.IRC.EnableLogging {
-		b.IRC.client.Handle("PRIVMSG", b.loggingHandler)
-		b.IRC.client.Handle("PRIVMSG", b.seenHandler)
+	for _, n := range b.IRC.Networks {
+		n.queue.configure(b.IRC.gracePeriod, b.IRC.SendBurst, b.IRC.SendBacklog, time.Duration(b.IRC.SendStaleAfter)*time.Second)
 	}
 
-	if b.IRC.EnableLyssnar {
-		b.initLyssnarDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.lyssnarHandler)
+	// The quit message defaults to the version.
+	if b.IRC.QuitMessage == "" {
+		b.IRC.QuitMessage = b.IRC.Version
 	}
 
-	if b.IRC.EnableGiphy {
-		b.initGiphyDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.giphyHandler)
-	}
+	// Set the defaults of the module, role and reload commands, the
+	// replies and the command dispatcher.
+	b.initModuleDefaults()
+	b.initRoleDefaults()
+	b.initAPITokenDefaults()
+	b.initReloadDefaults()
+	b.initReplyDefaults()
+	b.initDispatcher()
+}
 
-	if b.IRC.EnableTenor {
-		b.initTenorDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.tenorHandler)
-	}
+// initClient creates the IRC client of the network and registers the event
+// handlers.
+func (b *bot) initClient(n *network) {
+	// The event handlers are paused while the configuration is
+	// reloaded.
+	n.configMu = &b.configMu
+	n.metrics = b.metrics
 
-	if b.IRC.EnableChattistik {
-		b.initChattistikDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.chattistikHandler)
+	// Log the announcements that are dropped by the send queue.
+	n.queue.dropped = func(m queuedMessage) {
+		b.log("irc").with("network", n.Name).Warnf("dropped stale message to %s: %s", m.target, m.line)
 	}
 
-	if b.IRC.EnableFactoid {
-		b.initFactoidDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.factoidHandler)
+	// Prepare an array of irc options.
+	opts := []irc.Option{
+		irc.WithAddr(n.Address),
+		irc.WithNick(n.Nick),
+		irc.WithRealName(n.RealName),
+		irc.WithUser(n.User),
+		irc.WithVersion(fmt.Sprintf("%s %s", b.IRC.Version, VERSION)),
 	}
 
-	if b.IRC.EnableWeather {
-		b.initWeatherDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.weatherHandler)
+	// Log the IRC protocol if it's asked for.
+	if b.Log.DebugIRC {
+		opts = append(opts, irc.WithDebug(), irc.WithLogger(b.log("wire").with("network", n.Name).stdLogger()))
 	}
 
-	if b.IRC.EnableCron {
-		b.initCronDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.cronHandler)
+	// Join all configured channels.
+	for _, c := range n.Channels {
+		opts = append(opts, irc.WithChannel(c.Name))
 	}
 
-	if len(b.IRC.Dictionaries) > 0 {
-		b.initDictionaries()
-		b.IRC.client.Handle("PRIVMSG", b.dictionaryHandler)
+	// Append post connect messages, if there are any.
+	for _, pcm := range n.PostConnectMessages {
+		opts = append(opts, irc.WithPostConnectMessage(pcm.Target, pcm.Message))
 	}
 
-	if b.IRC.EnableParcelTracking {
-		b.initParcelTrackingDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.parcelTrackingCommandHandler)
+	// Append post connect modes, if there are any.
+	for _, m := range n.PostConnectModes {
+		opts = append(opts, irc.WithPostConnectMode(m))
 	}
 
-	if b.IRC.EnableWeek {
-		b.initWeekDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.weekCommandHandler)
-	}
+	n.client = irc.NewClient(opts...)
 
-	if b.IRC.EnableGoogleSearch {
-		b.initGoogleSearchDefaults()
-		b.IRC.client.Handle("PRIVMSG", b.googleSearchCommandHandler)
-	}
+	// Event handlers that are needed for the names map.
+	n.handle("353", b.handleNamesReply)
+	n.handle("JOIN", b.handleNamesAdd)
+	n.handle("PART", b.handleNamesRemove)
+	n.handle("KICK", b.handleNamesKick)
+	n.handle("QUIT", b.handleNamesQuit)
+	n.handle("NICK", b.handleNamesChange)
+
+	// All commands are routed through the command dispatcher.
+	n.handle("PRIVMSG", b.dispatchHandler)
+
+	// Register the handlers of all modules.
+	b.handleModules(n)
+}
 
-	// This goroutine handles the connection to the IRC server. The IRC
-	// library will automatically try to reconnect if the connection dies
-	// for some reason.
+// connect connects to the IRC server of the network within a goroutine.
+func (b *bot) connect(n *network) {
+	// This goroutine handles the connection to the IRC server. We'll try
+	// to reconnect if the connection dies, the delay is doubled for each
+	// failed attempt and we give up after ten attempts in a row.
 	go func() {
-		if err := b.IRC.client.Connect(); err != nil {
-			fmt.Printf("IRC connection died: %v\n", err)
-			os.Exit(1)
+		defer b.mainWG.Done()
+
+		attempts := 0
+		for {
+			conn, err := b.dial(n)
+			if err == nil {
+				n.setConnection(conn)
+				irc.WithConn(conn)(n.client)
+				err = n.client.Connect()
+				n.setConnected(false)
+				if err == nil {
+					return
+				}
+				conn.Close()
+
+				if conn.registered {
+					attempts = 0
+				}
+			}
+
+			// The connection is closed when the bot shuts down,
+			// so there is no need to reconnect.
+			if b.ctx.Err() != nil {
+				return
+			}
+
+			attempts++
+			if attempts > 10 {
+				b.stop(fmt.Errorf("IRC connection to %s died: %v", n.Name, err))
+				return
+			}
+
+			b.metrics.reconnects.inc(n.Name)
+			delay := (5 * time.Second) << uint(attempts-1)
+			b.log("irc").with("network", n.Name).Warnf("connect: %v, reconnecting in %v", err, delay)
+
+			select {
+			case <-time.After(delay):
+			case <-b.ctx.Done():
+				return
+			}
 		}
-
-		b.mainWG.Done()
 	}()
 }
 
-// preventSpam checks when the last message was sent and makes sure that the
-// value defined in "GracePeriod" has been passed before we we proceed with
-// the execution. This is used so that we don't spam the IRC server with
-// messages too fast.
-func (b *bot) preventSpam() {
-	// Acquire the lock and close it when we are done.
-	b.IRC.lastSentMessageMu.Lock()
-	defer b.IRC.lastSentMessageMu.Unlock()
-
-	// Get the current time and subtract it with when the last message was
-	// sent.
-	last := time.Now().Sub(b.IRC.lastSentMessage)
-
-	// If the last message was sent prior to the grace period we'll sleep
-	// for the remaining time.
-	if last < b.IRC.gracePeriod {
-		time.Sleep(b.IRC.gracePeriod - last)
+// dial opens a connection to the IRC server of the network, over TLS if it's
+// enabled, and starts the capability negotiation.
+func (b *bot) dial(n *network) (*ircConn, error) {
+	var conn net.Conn
+	var err error
+	if b.dialer != nil {
+		conn, err = b.dialer(n)
+	} else if n.tlsConfig != nil {
+		conn, err = tls.Dial("tcp", n.Address, n.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", n.Address)
 	}
-
-	// Set the last sent message to now.
-	b.IRC.lastSentMessage = time.Now()
-}
-
-// privmsg sends the given message back to the channel set from the
-// configuration.
-func (b *bot) privmsg(msg string) {
-	b.preventSpam()
-	b.IRC.client.Privmsg(b.IRC.Channel, msg)
-}
-
-// privmsgph replaces the keys of the phs map with the values and sends the
-// message to the configured channel.
-func (b *bot) privmsgph(msg string, phs map[string]string) {
-	b.preventSpam()
-
-	for k, v := range phs {
-		msg = strings.ReplaceAll(msg, k, v)
+	if err != nil {
+		return nil, err
 	}
 
-	b.IRC.client.Privmsg(b.IRC.Channel, msg)
-}
-
-// privmsgpht replaces the keys of the phs map with the values and sends the
-// message to the specified target.
-func (b *bot) privmsgpht(msg, target string, phs map[string]string) {
-	b.preventSpam()
-
-	for k, v := range phs {
-		msg = strings.ReplaceAll(msg, k, v)
-	}
-
-	b.IRC.client.Privmsg(target, msg)
-}
-
-// action sends the given message back to the channel set from the
-// configuration as an ACTION message.
-func (b *bot) action(msg string) {
-	b.preventSpam()
-	b.IRC.client.Privmsg(b.IRC.Channel, "\u0001ACTION "+msg+"\u0001")
-}
-
-// rndName returns a random name from the names map.
-func (b *bot) rndName() string {
-	i := 0
-	stop := rand.Intn(len(b.IRC.names))
-
-	for n := range b.IRC.names {
-		if i == stop {
-			return n
-		}
-		i++
+	c, err := newIRCConn(conn, n, b.log("irc").with("network", n.Name))
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return b.IRC.Nick
+	return c, nil
 }
 
 // shouldIgnore determines if the given message should be ignored by the bot
 // or not.
-func (b *bot) shouldIgnore(m *irc.Message) bool {
+func (b *bot) shouldIgnore(n *network, m *irc.Message) bool {
 	h := parseHost(m)
 
 	// Check if the message comes from a permanently ignored user.
@@ -291,12 +241,12 @@ func (b *bot) shouldIgnore(m *irc.Message) bool {
 		}
 	}
 
-	if b.IRC.EnableFloodProt {
+	if b.moduleEnabled("floodProt") {
 		// Acquire the ignore mutex lock before we check if the dynamic ignore
 		// map contains the host.
 		b.IRC.ignoreDynMu.Lock()
 		defer b.IRC.ignoreDynMu.Unlock()
-		if _, ignored := b.IRC.ignoreDyn[h]; ignored {
+		if _, ignored := b.IRC.ignoreDyn[floodProtKey(n, h)]; ignored {
 			return true
 		}
 	}
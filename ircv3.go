@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osm/irc"
+)
+
+// defaultCapabilities contains the IRCv3 capabilities that are requested
+// when no capabilities are configured for the network.
+var defaultCapabilities = []string{
+	"sasl",
+	"server-time",
+	"account-tag",
+	"message-tags",
+	"echo-message",
+	"away-notify",
+}
+
+// errConnectionClosed is returned by ircConn when the server closes the
+// connection. The IRC library tries to reconnect on its own when it reads
+// io.EOF, but it doesn't know how to negotiate capabilities, so we return
+// this error instead and handle the reconnect ourselves.
+var errConnectionClosed = errors.New("connection closed by the server")
+
+// saslConfig holds the SASL settings of a network.
+type saslConfig struct {
+	// Mechanism is either PLAIN or EXTERNAL, SASL is disabled if it's
+	// empty.
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+
+	// AllowUnauthenticated lets the bot complete the registration
+	// without being logged in when the authentication fails, the
+	// connection is closed otherwise.
+	AllowUnauthenticated bool `json:"allowUnauthenticated"`
+}
+
+// ircConn wraps the connection to the IRC server. It negotiates the IRCv3
+// capabilities and the SASL authentication before the registration is
+// completed, and it strips the message tags from the received lines since
+// the IRC library doesn't understand them. The tags of the PRIVMSG lines are
+// kept in the tag store of the network so that parseAction can find them.
+type ircConn struct {
+	net.Conn
+
+	network *network
+	logger  *logger
+	reader  *bufio.Reader
+
+	// buf contains the remaining data of the current line.
+	buf []byte
+
+	// negotiating is true until the negotiation is done, ls contains
+	// the capabilities that the server supports.
+	negotiating bool
+	ls          []string
+
+	// registered is set when the server has completed the
+	// registration.
+	registered bool
+
+	// err ends the connection when it's set, it's returned by Read.
+	err error
+}
+
+// newIRCConn wraps the connection and starts the capability negotiation by
+// sending CAP LS, the server will hold the registration until CAP END is
+// sent.
+func newIRCConn(conn net.Conn, n *network, logger *logger) (*ircConn, error) {
+	c := &ircConn{
+		Conn:        conn,
+		network:     n,
+		logger:      logger,
+		reader:      bufio.NewReader(conn),
+		negotiating: true,
+	}
+
+	n.setAcked(nil)
+	if err := c.send("CAP LS 302"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// send writes the line to the server.
+func (c *ircConn) send(format string, args ...interface{}) error {
+	_, err := fmt.Fprintf(c.Conn, format+"\r\n", args...)
+	return err
+}
+
+// Read reads one line at a time from the server, strips the tags and passes
+// it on to the IRC library.
+func (c *ircConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		line, err := c.reader.ReadString('\n')
+		if err == io.EOF {
+			return 0, errConnectionClosed
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		tags, line := splitTags(strings.TrimRight(line, "\r\n"))
+		fields := lineFields(line)
+		if len(fields) > 0 && fields[0] == "PRIVMSG" {
+			c.network.tags.add(line, tags)
+		}
+
+		if c.negotiating {
+			c.negotiate(line, fields)
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if len(fields) > 0 && fields[0] == "001" {
+			c.registered = true
+			c.network.setConnected(true)
+		}
+
+		c.buf = []byte(line + "\r\n")
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// lineFields splits the line into fields and skips the prefix, the returned
+// fields are the command and its parameters.
+func lineFields(line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], ":") {
+		fields = fields[1:]
+	}
+
+	return fields
+}
+
+// negotiate handles the CAP and SASL replies from the server.
+func (c *ircConn) negotiate(line string, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	// trailing returns the parameters from the i:th field, with the
+	// leading ':' removed.
+	trailing := func(i int) string {
+		if i >= len(fields) {
+			return ""
+		}
+		return strings.TrimPrefix(strings.Join(fields[i:], " "), ":")
+	}
+
+	switch fields[0] {
+	case "CAP":
+		if len(fields) < 3 {
+			return
+		}
+
+		switch fields[2] {
+		case "LS":
+			// The list is split over several lines if there is
+			// a * before the capabilities.
+			if len(fields) > 3 && fields[3] == "*" {
+				c.ls = append(c.ls, strings.Fields(trailing(4))...)
+				return
+			}
+			c.ls = append(c.ls, strings.Fields(trailing(3))...)
+			c.requestCapabilities()
+		case "ACK":
+			acked := strings.Fields(trailing(3))
+			c.network.setAcked(acked)
+			c.logger.Infof("capabilities acknowledged: %s", strings.Join(acked, " "))
+
+			if c.network.hasCapability("sasl") && c.network.SASL.Mechanism != "" {
+				c.send("AUTHENTICATE %s", strings.ToUpper(c.network.SASL.Mechanism))
+				return
+			}
+			c.end()
+		case "NAK":
+			c.logger.Warnf("capabilities rejected: %s", trailing(3))
+			c.end()
+		}
+	case "AUTHENTICATE":
+		if len(fields) > 1 && fields[1] == "+" {
+			c.authenticate()
+		}
+	case "900":
+		c.logger.Infof("SASL logged in: %s", line)
+	case "903":
+		c.logger.Infof("SASL authentication successful")
+		c.end()
+	case "902", "904", "905", "906", "907":
+		if !c.network.SASL.AllowUnauthenticated {
+			c.logger.Errorf("SASL authentication failed, disconnecting: %s", line)
+			c.err = fmt.Errorf("SASL authentication failed: %s", line)
+			return
+		}
+		c.logger.Warnf("SASL authentication failed, continuing without it: %s", line)
+		c.end()
+	case "001":
+		// The server doesn't support capabilities, since it has
+		// completed the registration without a CAP END.
+		c.negotiating = false
+	}
+}
+
+// requestCapabilities requests the configured capabilities that the server
+// supports, the negotiation is ended if there are none.
+func (c *ircConn) requestCapabilities() {
+	supported := make(map[string]bool)
+	for _, capability := range c.ls {
+		// Capabilities can have values, e.g. sasl=PLAIN,EXTERNAL.
+		supported[strings.SplitN(capability, "=", 2)[0]] = true
+	}
+
+	var req []string
+	for _, capability := range c.network.Capabilities {
+		if supported[capability] {
+			req = append(req, capability)
+		}
+	}
+
+	if len(req) == 0 {
+		c.end()
+		return
+	}
+
+	c.send("CAP REQ :%s", strings.Join(req, " "))
+}
+
+// authenticate sends the SASL credentials. The PLAIN credentials are base64
+// encoded and split into chunks of 400 bytes, EXTERNAL uses the client
+// certificate so there is nothing to send but an empty response.
+func (c *ircConn) authenticate() {
+	sasl := c.network.SASL
+	if !strings.EqualFold(sasl.Mechanism, "PLAIN") {
+		c.send("AUTHENTICATE +")
+		return
+	}
+
+	creds := base64.StdEncoding.EncodeToString([]byte(sasl.Username + "\x00" + sasl.Username + "\x00" + sasl.Password))
+	for len(creds) >= 400 {
+		c.send("AUTHENTICATE %s", creds[:400])
+		creds = creds[400:]
+	}
+
+	// An empty response tells the server that the previous chunk was the
+	// last one when the length is a multiple of 400.
+	if creds == "" {
+		creds = "+"
+	}
+	c.send("AUTHENTICATE %s", creds)
+}
+
+// end ends the capability negotiation, which lets the server complete the
+// registration.
+func (c *ircConn) end() {
+	c.send("CAP END")
+	c.negotiating = false
+}
+
+// splitTags splits the line into its tags and the rest of the line.
+func splitTags(line string) (map[string]string, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+
+	i := strings.Index(line, " ")
+	if i == -1 {
+		return nil, ""
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(line[1:i], ";") {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = unescapeTagValue(kv[1])
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+
+	return tags, strings.TrimLeft(line[i:], " ")
+}
+
+// tagValueReplacer unescapes the escaped characters of tag values.
+var tagValueReplacer = strings.NewReplacer(
+	`\:`, ";",
+	`\s`, " ",
+	`\\`, `\`,
+	`\r`, "\r",
+	`\n`, "\n",
+)
+
+// unescapeTagValue unescapes the tag value.
+func unescapeTagValue(v string) string {
+	return tagValueReplacer.Replace(v)
+}
+
+const (
+	// tagStoreSize is the number of lines whose tags are kept, the
+	// oldest line is forgotten when a line is added to a full store.
+	tagStoreSize = 1024
+
+	// tagStoreWait is how long a line waits for an earlier identical
+	// line to be claimed before the earlier line is forgotten.
+	tagStoreWait = time.Second
+)
+
+// tagStore keeps the tags of the received PRIVMSG lines. The lines are passed
+// to the IRC library without the tags, so the message that the library
+// parses from a line claims the tags of the line when its first handler
+// runs. A line isn't added until an earlier identical line has been
+// claimed, so that their messages can't get each other's tags. The lines
+// are kept in a ring buffer, the tags of a message can be found until
+// tagStoreSize more lines have been received.
+type tagStore struct {
+	mu sync.Mutex
+
+	// entries is the ring buffer, seq is the sequence number of the
+	// next line.
+	entries [tagStoreSize]tagStoreEntry
+	seq     uint64
+
+	// unclaimed maps the lines that haven't been claimed to their
+	// sequence number and claimed maps the messages to the sequence
+	// number of their line.
+	unclaimed map[string]uint64
+	claimed   map[*irc.Message]uint64
+}
+
+// tagStoreEntry holds the tags of a line and the message that claimed it,
+// claimedCh is closed when the line is claimed.
+type tagStoreEntry struct {
+	seq       uint64
+	line      string
+	tags      map[string]string
+	message   *irc.Message
+	claimedCh chan struct{}
+}
+
+// add stores the tags of the line, it waits for an earlier identical line
+// to be claimed first. A line that the IRC library can't parse is never
+// claimed, so the earlier line is forgotten after tagStoreWait.
+func (s *tagStore) add(line string, tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unclaimed == nil {
+		s.unclaimed = make(map[string]uint64)
+		s.claimed = make(map[*irc.Message]uint64)
+	}
+
+	if seq, ok := s.unclaimed[line]; ok {
+		claimedCh := s.entries[seq%tagStoreSize].claimedCh
+		s.mu.Unlock()
+		select {
+		case <-claimedCh:
+		case <-time.After(tagStoreWait):
+		}
+		s.mu.Lock()
+		s.forget(line, seq)
+	}
+
+	// Evict the oldest line if the ring buffer is full.
+	e := &s.entries[s.seq%tagStoreSize]
+	if e.claimedCh != nil {
+		s.forget(e.line, e.seq)
+		delete(s.claimed, e.message)
+	}
+
+	*e = tagStoreEntry{seq: s.seq, line: line, tags: tags, claimedCh: make(chan struct{})}
+	s.unclaimed[line] = s.seq
+	s.seq++
+}
+
+// forget removes the line from the unclaimed lines if it still has the
+// given sequence number.
+func (s *tagStore) forget(line string, seq uint64) {
+	if unclaimed, ok := s.unclaimed[line]; ok && unclaimed == seq {
+		delete(s.unclaimed, line)
+	}
+}
+
+// claim lets the message claim the tags of the line that it was parsed from,
+// it's done before the handlers of the message runs.
+func (s *tagStore) claim(m *irc.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.claimLocked(m)
+}
+
+// claimLocked is like claim but the caller must hold the lock.
+func (s *tagStore) claimLocked(m *irc.Message) {
+	if _, ok := s.claimed[m]; ok {
+		return
+	}
+
+	seq, ok := s.unclaimed[m.Raw]
+	if !ok {
+		return
+	}
+	delete(s.unclaimed, m.Raw)
+
+	e := &s.entries[seq%tagStoreSize]
+	e.message = m
+	close(e.claimedCh)
+	s.claimed[m] = seq
+}
+
+// get returns the tags of the message, nil is returned if the line had no
+// tags or if it has been forgotten.
+func (s *tagStore) get(m *irc.Message) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.claimLocked(m)
+	seq, ok := s.claimed[m]
+	if !ok {
+		return nil
+	}
+
+	return s.entries[seq%tagStoreSize].tags
+}
+
+// parseServerTime parses the value of the server-time tag, the current time
+// is returned if the tag is missing or invalid.
+func parseServerTime(v string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t
+	}
+
+	return time.Now()
+}
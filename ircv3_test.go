@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/irc"
+)
+
+// scriptConn is a connection that reads the canned lines of a server and
+// records the lines that are written to it.
+type scriptConn struct {
+	net.Conn
+	r io.Reader
+	w bytes.Buffer
+}
+
+// Read reads from the canned lines.
+func (c *scriptConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write records the written data.
+func (c *scriptConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// sent returns the lines that has been written.
+func (c *scriptConn) sent() []string {
+	return strings.Split(strings.TrimSuffix(c.w.String(), "\r\n"), "\r\n")
+}
+
+// runIRCConn wraps a connection that receives the server lines with an
+// ircConn and reads until the lines runs out. The lines that are passed on
+// to the IRC library and the lines that are sent to the server are
+// returned.
+func runIRCConn(t *testing.T, n *network, server []string) ([]string, []string) {
+	t.Helper()
+
+	received, sent, err := readIRCConn(t, n, server)
+	if err != errConnectionClosed {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	return received, sent
+}
+
+// readIRCConn is like runIRCConn but it returns the error that ended the
+// reading.
+func readIRCConn(t *testing.T, n *network, server []string) ([]string, []string, error) {
+	t.Helper()
+
+	conn := &scriptConn{r: strings.NewReader(strings.Join(server, "\r\n") + "\r\n")}
+	c, err := newIRCConn(conn, n, newLogger(&logOutput{w: ioutil.Discard}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(c)
+	return strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n"), conn.sent(), err
+}
+
+// saslPlain returns the AUTHENTICATE lines of the PLAIN credentials.
+func saslPlain(username, password string) []string {
+	creds := base64.StdEncoding.EncodeToString([]byte(username + "\x00" + username + "\x00" + password))
+
+	var lines []string
+	for len(creds) >= 400 {
+		lines = append(lines, "AUTHENTICATE "+creds[:400])
+		creds = creds[400:]
+	}
+	if creds == "" {
+		creds = "+"
+	}
+	return append(lines, "AUTHENTICATE "+creds)
+}
+
+func TestIRCConnNegotiation(t *testing.T) {
+	plain := saslConfig{Mechanism: "PLAIN", Username: "bot", Password: "secret"}
+
+	tests := []struct {
+		name   string
+		sasl   saslConfig
+		server []string
+		sent   []string
+		acked  []string
+
+		// failed is true if the connection is closed because the
+		// authentication failed.
+		failed bool
+	}{
+		{
+			name: "multiline ls",
+			server: []string{
+				":irc.example.com CAP * LS * :sasl=PLAIN,EXTERNAL server-time multi-prefix",
+				":irc.example.com CAP * LS :account-tag echo-message",
+				":irc.example.com CAP * ACK :sasl server-time account-tag echo-message",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: []string{
+				"CAP LS 302",
+				"CAP REQ :sasl server-time account-tag echo-message",
+				"CAP END",
+			},
+			acked: []string{"sasl", "server-time", "account-tag", "echo-message"},
+		},
+		{
+			name: "nak",
+			server: []string{
+				":irc.example.com CAP * LS :server-time away-notify",
+				":irc.example.com CAP * NAK :server-time away-notify",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: []string{
+				"CAP LS 302",
+				"CAP REQ :server-time away-notify",
+				"CAP END",
+			},
+		},
+		{
+			name: "nothing supported",
+			server: []string{
+				":irc.example.com CAP * LS :multi-prefix",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: []string{"CAP LS 302", "CAP END"},
+		},
+		{
+			name: "no capabilities",
+			server: []string{
+				":irc.example.com 001 bot :Welcome",
+				":irc.example.com CAP * LS :sasl",
+			},
+			sent: []string{"CAP LS 302"},
+		},
+		{
+			name: "sasl plain",
+			sasl: plain,
+			server: []string{
+				":irc.example.com CAP * LS :sasl",
+				":irc.example.com CAP * ACK :sasl",
+				"AUTHENTICATE +",
+				":irc.example.com 900 bot bot!bot@host bot :You are now logged in as bot",
+				":irc.example.com 903 bot :SASL authentication successful",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: append(append([]string{
+				"CAP LS 302",
+				"CAP REQ :sasl",
+				"AUTHENTICATE PLAIN",
+			}, saslPlain("bot", "secret")...), "CAP END"),
+			acked: []string{"sasl"},
+		},
+		{
+			name: "sasl not acked",
+			sasl: plain,
+			server: []string{
+				":irc.example.com CAP * LS :server-time",
+				":irc.example.com CAP * ACK :server-time",
+			},
+			sent:  []string{"CAP LS 302", "CAP REQ :server-time", "CAP END"},
+			acked: []string{"server-time"},
+		},
+		{
+			name: "sasl failed",
+			sasl: plain,
+			server: []string{
+				":irc.example.com CAP * LS :sasl",
+				":irc.example.com CAP * ACK :sasl",
+				"AUTHENTICATE +",
+				":irc.example.com 904 bot :SASL authentication failed",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: append([]string{
+				"CAP LS 302",
+				"CAP REQ :sasl",
+				"AUTHENTICATE PLAIN",
+			}, saslPlain("bot", "secret")...),
+			acked:  []string{"sasl"},
+			failed: true,
+		},
+		{
+			name: "sasl aborted",
+			sasl: plain,
+			server: []string{
+				":irc.example.com CAP * LS :sasl",
+				":irc.example.com CAP * ACK :sasl",
+				":irc.example.com 905 bot :SASL message too long",
+			},
+			sent: []string{
+				"CAP LS 302",
+				"CAP REQ :sasl",
+				"AUTHENTICATE PLAIN",
+			},
+			acked:  []string{"sasl"},
+			failed: true,
+		},
+		{
+			name: "sasl failed without authentication allowed",
+			sasl: saslConfig{Mechanism: "PLAIN", Username: "bot", Password: "secret", AllowUnauthenticated: true},
+			server: []string{
+				":irc.example.com CAP * LS :sasl",
+				":irc.example.com CAP * ACK :sasl",
+				"AUTHENTICATE +",
+				":irc.example.com 904 bot :SASL authentication failed",
+				":irc.example.com 001 bot :Welcome",
+			},
+			sent: append(append([]string{
+				"CAP LS 302",
+				"CAP REQ :sasl",
+				"AUTHENTICATE PLAIN",
+			}, saslPlain("bot", "secret")...), "CAP END"),
+			acked: []string{"sasl"},
+		},
+		{
+			name: "sasl external",
+			sasl: saslConfig{Mechanism: "external"},
+			server: []string{
+				":irc.example.com CAP * LS :sasl",
+				":irc.example.com CAP * ACK :sasl",
+				"AUTHENTICATE +",
+				":irc.example.com 903 bot :SASL authentication successful",
+			},
+			sent: []string{
+				"CAP LS 302",
+				"CAP REQ :sasl",
+				"AUTHENTICATE EXTERNAL",
+				"AUTHENTICATE +",
+				"CAP END",
+			},
+			acked: []string{"sasl"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &network{Capabilities: defaultCapabilities, SASL: test.sasl}
+			_, sent, err := readIRCConn(t, n, test.server)
+			if test.failed && (err == nil || !strings.HasPrefix(err.Error(), "SASL authentication failed")) {
+				t.Errorf("expected the authentication to fail, got %v", err)
+			}
+			if !test.failed && err != errConnectionClosed {
+				t.Errorf("expected the connection to be closed, got %v", err)
+			}
+			if !reflect.DeepEqual(sent, test.sent) {
+				t.Errorf("expected %q, got %q", test.sent, sent)
+			}
+
+			for _, c := range test.acked {
+				if !n.hasCapability(c) {
+					t.Errorf("expected %s to be acknowledged", c)
+				}
+			}
+			if n.hasCapability("multi-prefix") {
+				t.Error("expected multi-prefix not to be acknowledged")
+			}
+		})
+	}
+}
+
+func TestIRCConnSASLChunks(t *testing.T) {
+	tests := []struct {
+		password string
+		chunks   []int
+	}{
+		// The credentials are "bot\x00bot\x00" and the password, 300
+		// bytes are encoded as exactly 400 bytes. A chunk of a single
+		// byte is the + that ends the credentials, base64 is never a
+		// single byte.
+		{"secret", []int{20}},
+		{strings.Repeat("x", 292), []int{400, 1}},
+		{strings.Repeat("x", 400), []int{400, 144}},
+		{strings.Repeat("x", 592), []int{400, 400, 1}},
+	}
+
+	for _, test := range tests {
+		n := &network{Capabilities: []string{"sasl"}, SASL: saslConfig{Mechanism: "PLAIN", Username: "bot", Password: test.password}}
+		_, sent := runIRCConn(t, n, []string{
+			":irc.example.com CAP * LS :sasl",
+			":irc.example.com CAP * ACK :sasl",
+			"AUTHENTICATE +",
+		})
+
+		var creds string
+		var chunks []int
+		for _, l := range sent[3:] {
+			chunk := strings.TrimPrefix(l, "AUTHENTICATE ")
+			chunks = append(chunks, len(chunk))
+			if len(chunk) == 1 && chunk != "+" {
+				t.Errorf("password of %d bytes: expected the last chunk to be +, got %q", len(test.password), chunk)
+			}
+			if chunk != "+" {
+				creds += chunk
+			}
+		}
+		if !reflect.DeepEqual(chunks, test.chunks) {
+			t.Errorf("password of %d bytes: expected chunks of %v bytes, got %v", len(test.password), test.chunks, chunks)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(creds)
+		if err != nil || string(decoded) != "bot\x00bot\x00"+test.password {
+			t.Errorf("password of %d bytes: expected the credentials to be decoded, got %q, %v", len(test.password), decoded, err)
+		}
+	}
+}
+
+func TestIRCConnTags(t *testing.T) {
+	n := &network{Capabilities: defaultCapabilities}
+	received, _ := runIRCConn(t, n, []string{
+		":irc.example.com CAP * LS :message-tags server-time",
+		":irc.example.com CAP * ACK :message-tags server-time",
+		":irc.example.com 001 bot :Welcome",
+		`@time=2020-01-02T03:04:05.000Z;account=alice;msg=a\sb\:c\\d :alice!alice@host PRIVMSG #bot :hello`,
+		"@draft/flag :bob!bob@host PRIVMSG #bot :hi",
+		":carol!carol@host PRIVMSG #bot :hey",
+	})
+
+	want := []string{
+		":irc.example.com CAP * LS :message-tags server-time",
+		":irc.example.com CAP * ACK :message-tags server-time",
+		":irc.example.com 001 bot :Welcome",
+		":alice!alice@host PRIVMSG #bot :hello",
+		":bob!bob@host PRIVMSG #bot :hi",
+		":carol!carol@host PRIVMSG #bot :hey",
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("expected %q, got %q", want, received)
+	}
+
+	tags := n.tags.get(&irc.Message{Raw: ":alice!alice@host PRIVMSG #bot :hello"})
+	if want := map[string]string{"time": "2020-01-02T03:04:05.000Z", "account": "alice", "msg": `a b;c\d`}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("expected the tags %v, got %v", want, tags)
+	}
+	if tags := n.tags.get(&irc.Message{Raw: ":bob!bob@host PRIVMSG #bot :hi"}); !reflect.DeepEqual(tags, map[string]string{"draft/flag": ""}) {
+		t.Errorf("expected the draft/flag tag, got %v", tags)
+	}
+	if tags := n.tags.get(&irc.Message{Raw: ":carol!carol@host PRIVMSG #bot :hey"}); tags != nil {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+	if !n.isConnected() {
+		t.Error("expected the network to be connected after 001")
+	}
+}
+
+func TestTagStoreIdenticalLines(t *testing.T) {
+	var s tagStore
+	line := ":alice!alice@host PRIVMSG #bot :hello"
+	s.add(line, map[string]string{"time": "1"})
+
+	// The second line waits until the first line has been claimed by
+	// its message.
+	added := make(chan struct{})
+	go func() {
+		s.add(line, map[string]string{"time": "2"})
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("expected the identical line to wait for the first line to be claimed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first := &irc.Message{Raw: line}
+	if tags := s.get(first); tags["time"] != "1" {
+		t.Errorf("expected the tags of the first line, got %v", tags)
+	}
+	<-added
+
+	second := &irc.Message{Raw: line}
+	if tags := s.get(second); tags["time"] != "2" {
+		t.Errorf("expected the tags of the second line, got %v", tags)
+	}
+	if tags := s.get(first); tags["time"] != "1" {
+		t.Errorf("expected the first message to keep its tags, got %v", tags)
+	}
+}
+
+func TestTagStoreRingBuffer(t *testing.T) {
+	var s tagStore
+	first := &irc.Message{Raw: ":alice!alice@host PRIVMSG #bot :0"}
+	s.add(first.Raw, map[string]string{"time": "0"})
+	s.claim(first)
+
+	for i := 1; i <= tagStoreSize; i++ {
+		s.add(fmt.Sprintf(":alice!alice@host PRIVMSG #bot :%d", i), map[string]string{"time": fmt.Sprint(i)})
+	}
+
+	// The first line has been replaced by the last one.
+	if tags := s.get(first); tags != nil {
+		t.Errorf("expected the first line to be forgotten, got %v", tags)
+	}
+	last := &irc.Message{Raw: fmt.Sprintf(":alice!alice@host PRIVMSG #bot :%d", tagStoreSize)}
+	if tags := s.get(last); tags["time"] != fmt.Sprint(tagStoreSize) {
+		t.Errorf("expected the tags of the last line, got %v", tags)
+	}
+	if len(s.unclaimed) != tagStoreSize-1 || len(s.claimed) != 1 {
+		t.Errorf("expected the store to be bounded, got %d unclaimed and %d claimed lines", len(s.unclaimed), len(s.claimed))
+	}
+}
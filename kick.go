@@ -7,8 +7,25 @@ import (
 	"github.com/osm/irc"
 )
 
-func (b *bot) kickHandler(m *irc.Message) {
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    "rejoinOnKick",
+		enabled: func(b *bot) bool { return b.IRC.RejoinOnKick },
+		handlers: []moduleHandler{
+			{"KICK", (*bot).kickHandler},
+		},
+	})
+}
+
+// kickHandler rejoins the channel that the bot was kicked from.
+func (b *bot) kickHandler(n *network, m *irc.Message) {
+	// :the_nick!~bar@172.17.0.1 KICK #foo victim :reason
+	if len(m.ParamsArray) < 2 || m.ParamsArray[1] != n.client.GetNick() {
+		return
+	}
+
 	// Wait between 1 and 5 seconds before rejoining the channel.
 	time.Sleep(time.Duration(rand.Intn(5)) * time.Second)
-	b.IRC.client.Sendf("JOIN %s", b.IRC.Channel)
+	n.client.Sendf("JOIN %s", m.ParamsArray[0])
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log entry, entries below the level of the
+// module are discarded.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevelNames contains the names of the levels, as they are given in the
+// configuration and written to the log.
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// parseLogLevel returns the level with the given name.
+func parseLogLevel(name string) (logLevel, error) {
+	for l, n := range logLevelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+
+	return levelInfo, fmt.Errorf("%s is not a log level, use debug, info, warn or error", name)
+}
+
+// logConfig holds the configuration of the log.
+type logConfig struct {
+	// Level is the level of all modules that doesn't have a level in
+	// Levels, the key of Levels is the name of the module, e.g.
+	// "factoid", "irc" or "http".
+	Level  string            `json:"level"`
+	Levels map[string]string `json:"levels"`
+
+	// Format is either text or json.
+	Format string `json:"format"`
+
+	// File is written to instead of stdout if it's set. The file is
+	// rotated when it's larger than MaxSize megabytes, MaxBackups of the
+	// rotated files are kept.
+	File       string `json:"file"`
+	MaxSize    int    `json:"maxSize"`
+	MaxBackups int    `json:"maxBackups"`
+
+	// DebugIRC logs all lines that are sent to and received from the
+	// IRC servers.
+	DebugIRC bool `json:"debugIRC"`
+
+	// level and levels are parsed from Level and Levels.
+	level  logLevel
+	levels map[string]logLevel
+}
+
+// initLogConfig parses the log levels and makes sure that the format is
+// valid.
+func (b *bot) initLogConfig() configErrors {
+	var errs configErrors
+
+	b.Log.level = levelInfo
+	if b.Log.Level != "" {
+		l, err := parseLogLevel(b.Log.Level)
+		if err != nil {
+			errs = append(errs, configError{"log.level", err.Error()})
+		}
+		b.Log.level = l
+	}
+
+	b.Log.levels = make(map[string]logLevel)
+	for _, name := range sortedStringKeys(b.Log.Levels) {
+		l, err := parseLogLevel(b.Log.Levels[name])
+		if err != nil {
+			errs = append(errs, configError{"log.levels." + name, err.Error()})
+		}
+		b.Log.levels[name] = l
+	}
+
+	switch b.Log.Format {
+	case "", "text", "json":
+	default:
+		errs = append(errs, configError{"log.format", fmt.Sprintf("%s is not a log format, use text or json", b.Log.Format)})
+	}
+
+	return errs
+}
+
+// logOutput is where the entries of all loggers are written, it also holds
+// the levels of the modules so that they can be changed on reload.
+type logOutput struct {
+	mu     sync.Mutex
+	w      io.Writer
+	json   bool
+	level  logLevel
+	levels map[string]logLevel
+}
+
+// newLogOutput creates the log output from the configuration.
+func newLogOutput(c logConfig) (*logOutput, error) {
+	out := &logOutput{
+		w:    os.Stdout,
+		json: c.Format == "json",
+	}
+	out.setLevels(c.level, c.levels)
+
+	if c.File != "" {
+		maxBackups := c.MaxBackups
+		if maxBackups == 0 {
+			maxBackups = 5
+		}
+
+		f, err := openRotatingFile(c.File, int64(c.MaxSize)*1024*1024, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out.w = f
+	}
+
+	return out, nil
+}
+
+// setLevels replaces the default level and the levels of the modules.
+func (o *logOutput) setLevels(level logLevel, levels map[string]logLevel) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.level = level
+	o.levels = levels
+}
+
+// enabled returns true if entries of the given level should be written for
+// the module.
+func (o *logOutput) enabled(module string, level logLevel) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if l, ok := o.levels[module]; ok {
+		return level >= l
+	}
+
+	return level >= o.level
+}
+
+// write writes the entry as a single line.
+func (o *logOutput) write(level logLevel, module, msg string, fields []string) {
+	var buf bytes.Buffer
+	now := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+
+	if o.json {
+		pairs := append([]string{
+			"time", now,
+			"level", logLevelNames[level],
+			"module", module,
+			"msg", msg,
+		}, fields...)
+
+		buf.WriteString("{")
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeJSONString(&buf, pairs[i])
+			buf.WriteString(":")
+			writeJSONString(&buf, pairs[i+1])
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s %s: %s", now, strings.ToUpper(logLevelNames[level]), module, msg)
+		for i := 0; i < len(fields); i += 2 {
+			fmt.Fprintf(&buf, " %s=%q", fields[i], fields[i+1])
+		}
+		buf.WriteString("\n")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(buf.Bytes())
+}
+
+// writeJSONString writes the JSON encoding of the string to the buffer.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
+
+// logger writes levelled entries for a module to the log output, fields are
+// key and value pairs that are added to each entry. A nil logger discards
+// everything, which is convenient when the configuration is checked.
+type logger struct {
+	out    *logOutput
+	module string
+	fields []string
+}
+
+// newLogger returns the logger of the bot, the other loggers are derived
+// from it.
+func newLogger(out *logOutput) *logger {
+	return &logger{out: out, module: "bot"}
+}
+
+// log returns the logger of the given module.
+func (b *bot) log(module string) *logger {
+	return b.logger.withModule(module)
+}
+
+// withModule returns a logger for the module with the same fields.
+func (l *logger) withModule(module string) *logger {
+	if l == nil {
+		return nil
+	}
+
+	return &logger{out: l.out, module: module, fields: l.fields}
+}
+
+// with returns a logger that adds the key and value to each entry.
+func (l *logger) with(key string, value interface{}) *logger {
+	if l == nil {
+		return nil
+	}
+
+	fields := make([]string, len(l.fields), len(l.fields)+2)
+	copy(fields, l.fields)
+	return &logger{out: l.out, module: l.module, fields: append(fields, key, fmt.Sprint(value))}
+}
+
+// logf writes the entry if the level is enabled for the module.
+func (l *logger) logf(level logLevel, format string, args ...interface{}) {
+	if l == nil || !l.out.enabled(l.module, level) {
+		return
+	}
+
+	l.out.write(level, l.module, strings.TrimRight(fmt.Sprintf(format, args...), "\n"), l.fields)
+}
+
+// Debugf logs information that is only useful when debugging.
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+// Infof logs things that happen during normal operation.
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+// Warnf logs things that are unexpected but doesn't need any action.
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+// Errorf logs errors.
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+// Fatalf logs the error and exits.
+func (l *logger) Fatalf(format string, args ...interface{}) {
+	if l == nil {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	} else {
+		l.out.write(levelError, l.module, fmt.Sprintf(format, args...), l.fields)
+	}
+	os.Exit(1)
+}
+
+// stdLogger returns a standard library logger that writes each line as an
+// info entry, it's used by the IRC client to log the protocol.
+func (l *logger) stdLogger() *log.Logger {
+	return log.New(logWriter{l}, "", 0)
+}
+
+// logWriter writes each line that it receives to the logger.
+type logWriter struct {
+	l *logger
+}
+
+// Write logs each line of p.
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\r\n"), "\n") {
+		w.l.Infof("%s", strings.TrimRight(line, "\r"))
+	}
+
+	return len(p), nil
+}
+
+// rotatingFile is a log file that is rotated when it grows larger than
+// maxSize bytes, the rotated files are named file.1, file.2 and so on.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+// openRotatingFile opens the file for appending, maxSize 0 turns off the
+// rotation.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open opens the file and sets the size to the current size of the file.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// Write writes to the file, the file is rotated first if the data doesn't
+// fit. The caller is responsible for the locking.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log: can't rotate %s, %v\n", r.path, err)
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file to file.1, the old backups are shifted
+// up one step and the oldest one is removed.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		r.open()
+		return err
+	}
+
+	return r.open()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	out := &logOutput{w: &buf}
+	out.setLevels(levelWarn, map[string]logLevel{"factoid": levelDebug})
+	l := newLogger(out)
+
+	l.Infof("hidden")
+	l.Warnf("shown")
+	l.withModule("quiz").Infof("hidden")
+	l.withModule("factoid").Debugf("debug")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " WARN  bot: shown") || !strings.HasSuffix(lines[1], " DEBUG factoid: debug") {
+		t.Errorf("expected the warning and the factoid debug entry, got %q", buf.String())
+	}
+
+	// The levels can be changed after the loggers are created.
+	buf.Reset()
+	out.setLevels(levelDebug, nil)
+	l.withModule("quiz").Debugf("debug")
+	if !strings.HasSuffix(buf.String(), " DEBUG quiz: debug\n") {
+		t.Errorf("expected the entry to be written after the level was changed, got %q", buf.String())
+	}
+
+	// A nil logger discards everything.
+	var nl *logger
+	nl.with("nick", "bob").withModule("quiz").Errorf("discarded")
+}
+
+func TestLoggerFields(t *testing.T) {
+	var buf bytes.Buffer
+	out := &logOutput{w: &buf}
+	l := newLogger(out).withModule("irc").with("network", "test")
+	l.with("nick", `bob "b"`).Errorf("line one\n")
+	l.Infof("no nick")
+
+	if expected := ` ERROR irc: line one network="test" nick="bob \"b\""` + "\n"; !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if strings.Contains(buf.String(), `no nick network="test" nick=`) {
+		t.Errorf("expected the fields of the derived logger to be left out, got %q", buf.String())
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&logOutput{w: &buf, json: true}).withModule("http").with("status", 404)
+	l.Warnf(`not "found"`)
+
+	var entry map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("can't decode %q: %v", buf.String(), err)
+	}
+	if entry["time"] == "" {
+		t.Errorf("expected a time, got %v", entry)
+	}
+	delete(entry, "time")
+
+	expected := map[string]string{"level": "warn", "module": "http", "msg": `not "found"`, "status": "404"}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Errorf("expected %v, got %v", expected, entry)
+	}
+}
+
+func TestInitLogConfig(t *testing.T) {
+	b := &bot{}
+	b.Log.Level = "WARN"
+	b.Log.Levels = map[string]string{"factoid": "debug", "quiz": "loud"}
+	b.Log.Format = "xml"
+
+	errs := b.initLogConfig()
+	if paths := errs.paths(); !reflect.DeepEqual(paths, []string{"log.levels.quiz", "log.format"}) {
+		t.Errorf("expected problems with log.levels.quiz and log.format, got %v", errs)
+	}
+	if b.Log.level != levelWarn || b.Log.levels["factoid"] != levelDebug {
+		t.Errorf("expected the levels to be parsed, got %v %v", b.Log.level, b.Log.levels)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-log-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bot.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.f.Close()
+
+	// Only two backups are kept, so the first line is gone.
+	for name, expected := range map[string]string{
+		"bot.log":   "fourth\n",
+		"bot.log.1": "third\n",
+		"bot.log.2": "second\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != expected {
+			t.Errorf("%s: expected %q, got %q, %v", name, expected, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no third backup, got %v", err)
+	}
+}
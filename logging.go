@@ -4,29 +4,61 @@ import (
 	"github.com/osm/irc"
 )
 
+// loggingConfig holds the configuration of the logging module.
+type loggingConfig struct {
+	EnableLogging bool `json:"enableLogging"`
+
+	SeenCmd         string `json:"seenCmd"`
+	SeenMsgFound    string `json:"seenMsgFound"`
+	SeenMsgNotFound string `json:"seenMsgNotFound"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureLogging,
+		config:  func(b *bot) interface{} { return &b.config.logging },
+		enabled: func(b *bot) bool { return b.config.logging.EnableLogging },
+		init:    (*bot).initSeenHandler,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).loggingHandler},
+		},
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.logging.SeenCmd,
+				usage:   "<nick>",
+				minArgs: 1,
+				maxArgs: 1,
+				feature: featureLogging,
+				fn:      (*bot).seenCommand,
+			}}
+		},
+	})
+}
+
 // loggingHandler implements basic logging of all the received messages. This
 // functionality can be toggled by setting enableLogging to false in the
-// configuration file.  The bot is written to support one and only one
-// channel, so the channel information is NOT stored in the database.
-func (b *bot) loggingHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+// configuration file, or per channel with the logging feature. The network
+// and channel that the message was sent to is stored together with the
+// message.
+func (b *bot) loggingHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
 
-	if !a.validChannel {
-		return
-	}
-
-	stmt, err := b.prepare(`INSERT INTO log (id, timestamp, nick, message) VALUES($1, $2, $3, $4);`)
-	if err != nil {
-		b.logger.Printf("loggingHandler: %v", err)
-		b.privmsg(b.DB.Err)
+	if !n.hasFeature(a.target, featureLogging) {
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(newUUID(), newTimestamp(), a.nick, a.msg)
+	err := b.store.log.insert(logEntry{
+		ID:        newUUID(),
+		Timestamp: a.time,
+		Network:   n.Name,
+		Channel:   a.target,
+		Nick:      a.nick,
+		Message:   a.msg,
+	})
 	if err != nil {
-		b.logger.Printf("loggingHandler: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureLogging).Errorf("loggingHandler: %v", err)
+		b.replyDBError(a)
 		return
 	}
 }
@@ -4,68 +4,79 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-
-	"github.com/osm/irc"
 )
 
+// lyssnarConfig holds the configuration of the lyssnar module.
+type lyssnarConfig struct {
+	EnableLyssnar                bool              `json:"enableLyssnar"`
+	LyssnarCmd                   string            `json:"lyssnarcmd"`
+	Lyssnare                     map[string]string `json:"lyssnare"`
+	LyssnarErr                   string            `json:"lyssnarErr"`
+	LyssnarErrUserNotConfigured  string            `json:"lyssnarErrUserNotConfigured"`
+	LyssnarMsg                   string            `json:"lyssnarMsg"`
+	LyssnarMsgUserIsNotListening string            `json:"lyssnarMsgUserIsNotListening"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureLyssnar,
+		config:  func(b *bot) interface{} { return &b.config.lyssnar },
+		enabled: func(b *bot) bool { return b.config.lyssnar.EnableLyssnar },
+		init:    (*bot).initLyssnarDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.lyssnar.LyssnarCmd,
+				usage:   "<nick>",
+				minArgs: 1,
+				maxArgs: 1,
+				feature: featureLyssnar,
+				fn:      (*bot).lyssnarCommand,
+			}}
+		},
+	})
+}
+
 // initLyssnarDefaults sets default values for all settings.
 func (b *bot) initLyssnarDefaults() {
-	if b.IRC.LyssnarCmd == "" {
-		b.IRC.LyssnarCmd = "!lyssnar"
+	if b.config.lyssnar.LyssnarCmd == "" {
+		b.config.lyssnar.LyssnarCmd = "lyssnar"
 	}
-	if b.IRC.LyssnarMsg == "" {
-		b.IRC.LyssnarMsg = "<nick> listening to <song>"
+	if b.config.lyssnar.LyssnarMsg == "" {
+		b.config.lyssnar.LyssnarMsg = "<nick> listening to <song>"
 	}
-	if b.IRC.LyssnarMsgUserIsNotListening == "" {
-		b.IRC.LyssnarMsgUserIsNotListening = "the user is not listening to anything right now"
+	if b.config.lyssnar.LyssnarMsgUserIsNotListening == "" {
+		b.config.lyssnar.LyssnarMsgUserIsNotListening = "the user is not listening to anything right now"
 	}
-	if b.IRC.LyssnarErr == "" {
-		b.IRC.LyssnarErr = "there's something wrong with the lyssnar.com integration"
+	if b.config.lyssnar.LyssnarErr == "" {
+		b.config.lyssnar.LyssnarErr = "there's something wrong with the lyssnar.com integration"
 	}
-	if b.IRC.LyssnarErrUserNotConfigured == "" {
-		b.IRC.LyssnarErrUserNotConfigured = "the user is not configured"
+	if b.config.lyssnar.LyssnarErrUserNotConfigured == "" {
+		b.config.lyssnar.LyssnarErrUserNotConfigured = "the user is not configured"
 	}
 }
 
-// lyssnarHandler handles the lyssnar request from the IRC channel.
-func (b *bot) lyssnarHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
-		return
-	}
-
-	if a.cmd != b.IRC.LyssnarCmd {
-		return
-	}
-
-	if b.shouldIgnore(m) {
-		return
-	}
-
-	if len(a.args) != 1 {
-		return
-	}
-
+// lyssnarCommand handles the lyssnar request from the IRC channel.
+func (b *bot) lyssnarCommand(a *privmsgAction) {
 	nick := a.args[0]
-	spotifyUsername, ok := b.IRC.Lyssnare[nick]
+	spotifyUsername, ok := b.config.lyssnar.Lyssnare[nick]
 	if !ok {
-		b.privmsg(b.IRC.LyssnarErrUserNotConfigured)
+		a.replyUrgent(b.config.lyssnar.LyssnarErrUserNotConfigured)
 		return
 	}
 
-	res, err := http.Get(fmt.Sprintf("http://lyssnar.com/v1/user/%s/currently-playing-short", spotifyUsername))
+	res, err := b.httpGet(fmt.Sprintf("%s/v1/user/%s/currently-playing-short", b.baseURL("lyssnar"), spotifyUsername))
 	if err != nil {
-		b.logger.Printf("lyssnar: %v", err)
-		b.privmsg(b.IRC.LyssnarErr)
+		b.log(featureLyssnar).Errorf("lyssnar: %v", err)
+		a.replyUrgent(b.config.lyssnar.LyssnarErr)
 		return
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("lyssnar: %v", err)
-		b.privmsg(b.IRC.LyssnarErr)
+		b.log(featureLyssnar).Errorf("lyssnar: %v", err)
+		a.replyUrgent(b.config.lyssnar.LyssnarErr)
 		return
 	}
 
@@ -74,17 +85,17 @@ func (b *bot) lyssnarHandler(m *irc.Message) {
 	}
 	err = json.Unmarshal(data, &obj)
 	if err != nil {
-		b.logger.Printf("lyssnar: %v", err)
-		b.privmsg(b.IRC.LyssnarErr)
+		b.log(featureLyssnar).Errorf("lyssnar: %v", err)
+		a.replyUrgent(b.config.lyssnar.LyssnarErr)
 		return
 	}
 
 	if obj.Playing == "" {
-		b.privmsg(b.IRC.LyssnarMsgUserIsNotListening)
+		a.reply(b.config.lyssnar.LyssnarMsgUserIsNotListening)
 		return
 	}
 
-	b.privmsgph(b.IRC.LyssnarMsg, map[string]string{
+	a.replyph(b.config.lyssnar.LyssnarMsg, map[string]string{
 		"<nick>": nick,
 		"<song>": obj.Playing,
 	})
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLyssnar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/user/alicespotify/currently-playing-short":
+			fmt.Fprint(w, `{"playing":"Kent - Musik non stop"}`)
+		case "/v1/user/bobspotify/currently-playing-short":
+			fmt.Fprint(w, `{"playing":""}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tb := newTestBotWithConfig(t, map[string]interface{}{
+		"enableLyssnar": true,
+		"lyssnare":      map[string]string{"alice": "alicespotify", "bob": "bobspotify"},
+	}, map[string]interface{}{
+		"httpClient": map[string]interface{}{
+			"baseURLs": map[string]string{"lyssnar": srv.URL},
+		},
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("bob", "#bot", "!lyssnar alice")
+	s.expect("PRIVMSG #bot :alice listening to Kent - Musik non stop")
+
+	s.privmsg("alice", "#bot", "!lyssnar bob")
+	s.expect("PRIVMSG #bot :the user is not listening to anything right now")
+
+	s.privmsg("alice", "#bot", "!lyssnar carol")
+	s.expect("PRIVMSG #bot :the user is not configured")
+}
@@ -7,11 +7,18 @@ import (
 )
 
 // main starts the bot. The only required flag is the -config flag which
-// should point to the configuration file that you want to use.
+// should point to the configuration file that you want to use. If a database
+// command is given it's run instead of the bot.
 func main() {
 	configPath := flag.String("config", "", "config file path")
 	version := flag.Bool("version", false, "display current version")
 	schemaOnly := flag.Bool("init-schema-only", false, "init db schema and exit")
+	checkOnly := flag.Bool("check-config", false, "check the config file for problems and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -config <file> [command]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", dbCommandUsage)
+	}
 	flag.Parse()
 
 	if *version {
@@ -24,6 +31,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *checkOnly {
+		if err := checkConfigFile(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n%v\n", *configPath, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stdout, "%s: ok\n", *configPath)
+		os.Exit(0)
+	}
+
 	var bot *bot
 	var err error
 	if bot, err = newBotFromConfig(*configPath); err != nil {
@@ -36,6 +52,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.NArg() > 0 {
+		if err = bot.runDBCommand(flag.Args(), os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if err = bot.start(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
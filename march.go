@@ -6,38 +6,71 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
 
 	"github.com/osm/irc"
 )
 
-// initMarchDefaults initializes default values and makes sure that all
-// required values has been set, if something is missing we'll exit with an
-// error.
-func (b *bot) initMarchDefaults() {
-	if b.IRC.MarchURL == "" {
-		fmt.Fprintf(os.Stderr, "error: no marchURL in config")
-		os.Exit(1)
+// marchConfig holds the configuration of the march module.
+type marchConfig struct {
+	EnableMarch      bool     `json:"enableMarch"`
+	MarchURL         string   `json:"marchURL"`
+	MarchCredentials string   `json:"marchCredentials"`
+	MarchURLRegexps  []string `json:"marchURLRegexps"`
+	marchURLRegexps  []*regexp.Regexp
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureMarch,
+		config:  func(b *bot) interface{} { return &b.config.march },
+		enabled: func(b *bot) bool { return b.config.march.EnableMarch },
+		check:   (*bot).checkMarchConfig,
+		init:    (*bot).initMarchDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).marchHandler},
+		},
+	})
+}
+
+// checkMarchConfig makes sure that all required values has been set and that
+// the URL regexps compiles.
+func (b *bot) checkMarchConfig() error {
+	var errs configErrors
+	if b.config.march.MarchURL == "" {
+		errs = append(errs, configError{"marchURL", "is missing"})
 	}
-	if b.IRC.MarchCredentials == "" {
-		fmt.Fprintf(os.Stderr, "error: no marchCredentials in config")
-		os.Exit(1)
+	if b.config.march.MarchCredentials == "" {
+		errs = append(errs, configError{"marchCredentials", "is missing"})
 	}
 
-	for _, r := range b.IRC.MarchURLRegexps {
-		b.IRC.marchURLRegexps = append(b.IRC.marchURLRegexps, regexp.MustCompile(r))
+	for i, r := range b.config.march.MarchURLRegexps {
+		if _, err := regexp.Compile(r); err != nil {
+			errs = append(errs, configError{fmt.Sprintf("marchURLRegexps[%d]", i), err.Error()})
+		}
+	}
+
+	return errs.err()
+}
+
+// initMarchDefaults compiles the URL regexps, the configuration has already
+// been checked by checkMarchConfig.
+func (b *bot) initMarchDefaults() {
+	b.config.march.marchURLRegexps = nil
+	for _, r := range b.config.march.MarchURLRegexps {
+		b.config.march.marchURLRegexps = append(b.config.march.marchURLRegexps, regexp.MustCompile(r))
 	}
 }
 
 // marchHandler listens for urls in the channel, if the url matches any
 // regexps that's defined in the config we'll pass it on to the march url. The
 // returned ID will be stored in the march table.
-func (b *bot) marchHandler(m *irc.Message) {
+func (b *bot) marchHandler(n *network, m *irc.Message) {
 	// Parse the action and make sure that it's a valid channel.
-	a := b.parseAction(m).(*privmsgAction)
-	if !a.validChannel {
+	a := b.parseAction(n, m).(*privmsgAction)
+	if !n.hasFeature(a.target, featureMarch) {
 		return
 	}
 
@@ -49,7 +82,7 @@ func (b *bot) marchHandler(m *irc.Message) {
 
 	// Iterate over the regexps, if no URL matches we'll return.
 	var shouldArchive = false
-	for _, r := range b.IRC.marchURLRegexps {
+	for _, r := range b.config.march.marchURLRegexps {
 		if r.Match([]byte(u)) {
 			shouldArchive = true
 			break
@@ -72,54 +105,54 @@ func (b *bot) marchHandler(m *irc.Message) {
 	params.Set("url", u)
 	postData := strings.NewReader(params.Encode())
 
-	// Initialize a new http client that we'll use to send the request to
-	// the march server.
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", b.IRC.MarchURL, postData)
+	req, err := http.NewRequest("POST", b.config.march.MarchURL, postData)
 
 	// Do the basic error checking.
 	if err != nil {
-		b.logger.Printf("march: post error: %v", err)
+		b.log(featureMarch).Errorf("march: post error: %v", err)
 		return
 	}
 
-	req.Header.Add("Authorization", "Basic "+base64Encode(b.IRC.MarchCredentials))
+	req.Header.Add("Authorization", "Basic "+base64Encode(b.config.march.MarchCredentials))
 	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-
+	resp, err := b.httpClient().Do(req)
+	if err != nil {
+		b.log(featureMarch).Errorf("march: post error: %v", err)
+		return
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 {
-		b.logger.Printf("march: unexpected status code: %d", resp.StatusCode)
+		b.log(featureMarch).Errorf("march: unexpected status code: %d", resp.StatusCode)
 		return
 	}
 	foreignID, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		b.logger.Printf("march: unable to read body: %v", err)
+		b.log(featureMarch).Errorf("march: unable to read body: %v", err)
 		return
 	}
 	if string(foreignID) == "" {
-		b.logger.Printf("march: no foreign id returned: %v", err)
+		b.log(featureMarch).Errorf("march: no foreign id returned: %v", err)
 		return
 	}
 	if !isUUID(string(foreignID)) {
-		b.logger.Printf("march: foreign id isn't a valid uuid: %v", err)
+		b.log(featureMarch).Errorf("march: foreign id isn't a valid uuid: %v", err)
 		return
 	}
 
 	// Everything seems to be in order, let's insert the archived item.
 	stmt, err := b.prepare("INSERT INTO march (id, url, foreign_id, inserted_at) VALUES($1, $2, $3, $4)")
 	if err != nil {
-		b.logger.Printf("march: prepare insert failed: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureMarch).Errorf("march: prepare insert failed: %v", err)
+		b.replyDBError(a)
 		return
 	}
 	defer stmt.Close()
 
 	_, err = stmt.Exec(newUUID(), u, foreignID, newTimestamp())
 	if err != nil {
-		b.logger.Printf("march: insert failed: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureMarch).Errorf("march: insert failed: %v", err)
+		b.replyDBError(a)
 		return
 	}
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// duration histograms.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// botMetrics holds the metrics of the bot, they are exposed in the
+// Prometheus text format on the metrics route of the HTTP server.
+type botMetrics struct {
+	messagesReceived *counterVec
+	messagesSent     *counterVec
+	commands         *counterVec
+	handlerDuration  *histogramVec
+	sendQueueLength  *gaugeVec
+	floodIgnores     *counterVec
+	dbErrors         *counterVec
+	apiDuration      *histogramVec
+	apiFailures      *counterVec
+	reconnects       *counterVec
+	cronRuns         *counterVec
+}
+
+// newBotMetrics creates the metrics of the bot.
+func newBotMetrics() *botMetrics {
+	return &botMetrics{
+		messagesReceived: newCounterVec("bot_messages_received_total", "Number of messages received from channels and users.", "network"),
+		messagesSent:     newCounterVec("bot_messages_sent_total", "Number of messages sent to the IRC servers.", "network", "command"),
+		commands:         newCounterVec("bot_commands_total", "Number of command invocations.", "command"),
+		handlerDuration:  newHistogramVec("bot_handler_duration_seconds", "Time spent in the handlers of the IRC events.", "event"),
+		sendQueueLength:  newGaugeVec("bot_send_queue_length", "Number of messages waiting in the send queue.", "network"),
+		floodIgnores:     newCounterVec("bot_flood_ignores_total", "Number of users ignored by the flood protection.", "network"),
+		dbErrors:         newCounterVec("bot_db_errors_total", "Number of failed database queries."),
+		apiDuration:      newHistogramVec("bot_api_request_duration_seconds", "Duration of the calls to external APIs.", "api"),
+		apiFailures:      newCounterVec("bot_api_failures_total", "Number of failed calls to external APIs.", "api"),
+		reconnects:       newCounterVec("bot_reconnects_total", "Number of reconnects to the IRC servers.", "network"),
+		cronRuns:         newCounterVec("bot_cron_runs_total", "Number of cron job runs.", "network"),
+	}
+}
+
+// observeAPI records the duration of an external API call that was started
+// at start, the call has failed if err isn't nil.
+func (m *botMetrics) observeAPI(api string, start time.Time, err error) {
+	m.apiDuration.observe(time.Since(start).Seconds(), api)
+	if err != nil {
+		m.apiFailures.inc(api)
+	}
+}
+
+// observeHTTP is observeAPI for API calls that are made over HTTP, responses
+// with an error status are counted as failures as well.
+func (m *botMetrics) observeHTTP(api string, start time.Time, res *http.Response, err error) {
+	if err == nil && res.StatusCode >= 400 {
+		err = fmt.Errorf("%s", res.Status)
+	}
+	m.observeAPI(api, start, err)
+}
+
+// write writes all metrics in the Prometheus text format.
+func (m *botMetrics) write(w io.Writer) {
+	m.messagesReceived.write(w)
+	m.messagesSent.write(w)
+	m.commands.write(w)
+	m.handlerDuration.write(w)
+	m.sendQueueLength.write(w)
+	m.floodIgnores.write(w)
+	m.dbErrors.write(w)
+	m.apiDuration.write(w)
+	m.apiFailures.write(w)
+	m.reconnects.write(w)
+	m.cronRuns.write(w)
+}
+
+// metricsHandler writes the metrics of the bot, the lengths of the send
+// queues are read when the metrics are requested.
+func (b *bot) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	for _, n := range b.IRC.Networks {
+		b.metrics.sendQueueLength.set(float64(n.queue.length()), n.Name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b.metrics.write(w)
+}
+
+// metric holds what's common to all kinds of metrics, the values of a metric
+// are kept per combination of label values.
+type metric struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// key returns the key of the label values, the number of values must match
+// the number of labels.
+func (m *metric) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs returns the labels with the values of the key in the text
+// format, extra is added last.
+func (m *metric) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(m.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, m.labels[i], escapeLabelValue(v)))
+		}
+	}
+	for i := 0; i < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (m *metric) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+}
+
+// labelValueEscaper escapes the backslashes, quotes and newlines of label
+// values, as the text format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes the label value for the text format.
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+// sortedFloatKeys returns the keys of the map in order.
+func sortedFloatKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	metric
+	values map[string]float64
+}
+
+// newCounterVec creates a counter with the given labels, a counter without
+// labels starts out at zero so that it's exposed before it's incremented.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{
+		metric: metric{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	return c
+}
+
+// inc increments the counter of the label values by one.
+func (c *counterVec) inc(values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key]++
+}
+
+// write writes the counter in the text format.
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, k := range sortedFloatKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %v\n", c.name, c.labelPairs(k), c.values[k])
+	}
+}
+
+// gaugeVec is a gauge with labels.
+type gaugeVec struct {
+	counterVec
+}
+
+// newGaugeVec creates a gauge with the given labels.
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{counterVec{
+		metric: metric{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}}
+}
+
+// set sets the gauge of the label values.
+func (g *gaugeVec) set(v float64, values ...string) {
+	key := g.key(values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = v
+}
+
+// histogram holds the observations of one combination of label values.
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// histogramVec is a histogram with labels, the buckets are durationBuckets.
+type histogramVec struct {
+	metric
+	values map[string]*histogram
+}
+
+// newHistogramVec creates a histogram with the given labels.
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		metric: metric{name: name, help: help, kind: "histogram", labels: labels},
+		values: make(map[string]*histogram),
+	}
+}
+
+// observe adds the value to the histogram of the label values.
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	o, ok := h.values[key]
+	if !ok {
+		o = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		h.values[key] = o
+	}
+
+	for i, le := range durationBuckets {
+		if v <= le {
+			o.buckets[i]++
+		}
+	}
+	o.count++
+	o.sum += v
+}
+
+// write writes the histogram in the text format, the buckets are
+// cumulative.
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(w)
+	for _, k := range keys {
+		o := h.values[k]
+		for i, le := range durationBuckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", fmt.Sprint(le)), o.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", "+Inf"), o.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, h.labelPairs(k), o.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(k), o.count)
+	}
+}
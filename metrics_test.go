@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	c := newCounterVec("test_total", "A counter.", "nick")
+	c.inc(`bob "b"`)
+	c.inc(`bob "b"`)
+	c.inc("alice")
+
+	g := newGaugeVec("test_length", "A gauge.", "network")
+	g.set(3, "test")
+	g.set(1, "test")
+
+	h := newHistogramVec("test_seconds", "A histogram.", "event")
+	h.observe(0.02, "PRIVMSG")
+	h.observe(3, "PRIVMSG")
+
+	var buf bytes.Buffer
+	newCounterVec("test_errors_total", "A counter without labels.").write(&buf)
+	c.write(&buf)
+	g.write(&buf)
+	h.write(&buf)
+
+	for _, line := range []string{
+		"# HELP test_errors_total A counter without labels.",
+		"# TYPE test_errors_total counter",
+		"test_errors_total 0",
+		`test_total{nick="alice"} 1`,
+		`test_total{nick="bob \"b\""} 2`,
+		"# TYPE test_length gauge",
+		`test_length{network="test"} 1`,
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{event="PRIVMSG",le="0.01"} 0`,
+		`test_seconds_bucket{event="PRIVMSG",le="0.025"} 1`,
+		`test_seconds_bucket{event="PRIVMSG",le="2.5"} 1`,
+		`test_seconds_bucket{event="PRIVMSG",le="5"} 2`,
+		`test_seconds_bucket{event="PRIVMSG",le="+Inf"} 2`,
+		`test_seconds_sum{event="PRIVMSG"} 3.02`,
+		`test_seconds_count{event="PRIVMSG"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsObserveHTTP(t *testing.T) {
+	m := newBotMetrics()
+	start := time.Now()
+	m.observeHTTP("giphy", start, &http.Response{StatusCode: http.StatusOK}, nil)
+	m.observeHTTP("giphy", start, &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}, nil)
+	m.observeAPI("smhi", start, errors.New("timeout"))
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	for _, line := range []string{
+		`bot_api_request_duration_seconds_count{api="giphy"} 2`,
+		`bot_api_failures_total{api="giphy"} 1`,
+		`bot_api_failures_total{api="smhi"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	tb := newTestBot(t, nil)
+	defer tb.close()
+
+	tb.server.privmsg("bob", "#bot", "hello")
+	tb.server.privmsg("bob", "#bot", "!role whoami")
+	tb.server.expect("PRIVMSG #bot :bob has the user role")
+
+	// The reply is counted after it has been written.
+	var w *httptest.ResponseRecorder
+	tb.waitFor("the reply to be counted", func() bool {
+		w = httptest.NewRecorder()
+		tb.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return strings.Contains(w.Body.String(), "bot_messages_sent_total{")
+	})
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %s", ct)
+	}
+	for _, line := range []string{
+		`bot_messages_received_total{network="test"} 2`,
+		`bot_commands_total{command="role whoami"} 1`,
+		`bot_messages_sent_total{network="test",command="PRIVMSG"} 1`,
+		`bot_send_queue_length{network="test"} 0`,
+		"bot_db_errors_total 0",
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, w.Body.String())
+		}
+	}
+
+	// Each handler of the event is timed, so only the presence of the
+	// histogram is checked.
+	if !strings.Contains(w.Body.String(), `bot_handler_duration_seconds_count{event="PRIVMSG"} `) {
+		t.Errorf("expected the handlers of PRIVMSG to be timed, got\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/osm/irc"
+)
+
+// module describes a feature of the bot. Each feature registers itself with
+// registerModule from an init function in its own file, the bot takes care
+// of initializing the module, registering its handlers on all networks and
+// starting its background work.
+type module struct {
+	// name of the module, it's used when modules are listed, enabled
+	// and disabled from IRC and as the key in the modules section of
+	// the configuration.
+	name string
+
+	// config returns a pointer to the configuration section of the
+	// module in b.config. The section is given in the modules section
+	// of the configuration file, the settings are also accepted in the
+	// irc section for backwards compatibility.
+	config func(b *bot) interface{}
+
+	// enabled returns true if the module is enabled in the
+	// configuration.
+	enabled func(b *bot) bool
+
+	// check validates the configuration of the module, it's called
+	// before the bot is started and before the configuration is
+	// reloaded if the module is enabled.
+	check func(b *bot) error
+
+	// init sets the default values of the module, it's called once
+	// before the module is enabled for the first time and again when
+	// the configuration has been reloaded.
+	init func(b *bot)
+
+	// handlers contains the IRC event handlers of the module.
+	handlers []moduleHandler
+
+	// commands returns the IRC commands of the module, they are handed
+	// to the command dispatcher when the module is enabled.
+	commands func(b *bot) []*botCommand
+
+	// run is started in a goroutine when the module is enabled, the
+	// context is cancelled when the module is disabled.
+	run func(b *bot, ctx context.Context)
+
+	// shutdown is called when the module is disabled.
+	shutdown func(b *bot)
+}
+
+// moduleHandler is an IRC event handler that belongs to a module.
+type moduleHandler struct {
+	event string
+	fn    func(b *bot, n *network, m *irc.Message)
+}
+
+// modules contains all registered modules.
+var modules []*module
+
+// registerModule adds the module to the registry, it should be called from
+// an init function.
+func registerModule(m *module) {
+	for _, r := range modules {
+		if r.name == m.name {
+			panic(fmt.Sprintf("module %s is registered twice", m.name))
+		}
+	}
+
+	modules = append(modules, m)
+}
+
+// findModule returns the registered module with the given name, nil is
+// returned if there is no such module.
+func findModule(name string) *module {
+	for _, m := range modules {
+		if m.name == name {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// moduleState holds the runtime state of a module.
+type moduleState struct {
+	enabled     bool
+	initialized bool
+	cancel      context.CancelFunc
+	commands    []*botCommand
+
+	// done is closed when the background work of the module returns.
+	done chan struct{}
+}
+
+// The states of the background work of a module, as reported by the health
+// checks.
+const (
+	workerRunning = "running"
+	workerStopped = "stopped"
+)
+
+// decodeModulesConfig decodes the configuration file in data into the
+// configuration sections of the modules. The irc section is decoded first,
+// since the settings of the modules used to be given there, and then the
+// modules section on top of it.
+func (b *bot) decodeModulesConfig(data []byte) error {
+	var sections struct {
+		IRC json.RawMessage `json:"irc"`
+	}
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("error: can't decode config, %v", err)
+	}
+
+	for _, m := range modules {
+		if m.config == nil || len(sections.IRC) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(sections.IRC, m.config(b)); err != nil {
+			return fmt.Errorf("error: can't decode irc for module %s, %v", m.name, err)
+		}
+	}
+
+	for name, raw := range b.Modules {
+		m := findModule(name)
+		if m == nil {
+			return fmt.Errorf("error: unknown module %s in modules", name)
+		}
+		if m.config == nil {
+			return fmt.Errorf("error: module %s has no configuration", name)
+		}
+		if err := json.Unmarshal(raw, m.config(b)); err != nil {
+			return fmt.Errorf("error: can't decode modules.%s, %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// initModules enables all modules that are enabled in the configuration.
+func (b *bot) initModules() {
+	b.modules.state = make(map[string]*moduleState)
+
+	for _, m := range modules {
+		b.modules.state[m.name] = &moduleState{}
+
+		if m.enabled(b) {
+			b.enableModule(m)
+		}
+	}
+}
+
+// enableModule initializes the module if it hasn't been initialized before
+// and starts its background work.
+func (b *bot) enableModule(m *module) {
+	b.modules.mu.Lock()
+	defer b.modules.mu.Unlock()
+
+	s := b.modules.state[m.name]
+	if s.enabled {
+		return
+	}
+
+	if !s.initialized {
+		if m.init != nil {
+			m.init(b)
+		}
+		if m.commands != nil {
+			s.commands = m.commands(b)
+			b.normalizeCommands(s.commands)
+		}
+		s.initialized = true
+	}
+
+	if m.run != nil {
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(b.ctx)
+		done := make(chan struct{})
+		s.done = done
+		b.modules.wg.Add(1)
+		go func() {
+			defer b.modules.wg.Done()
+			defer close(done)
+			m.run(b, ctx)
+		}()
+	}
+
+	s.enabled = true
+}
+
+// disableModule stops the background work of the module and calls its
+// shutdown function. The handlers of a disabled module are not called.
+func (b *bot) disableModule(m *module) {
+	b.modules.mu.Lock()
+	defer b.modules.mu.Unlock()
+
+	s := b.modules.state[m.name]
+	if !s.enabled {
+		return
+	}
+
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.done = nil
+
+	if m.shutdown != nil {
+		m.shutdown(b)
+	}
+
+	s.enabled = false
+}
+
+// moduleEnabled returns true if the module with the given name is enabled.
+func (b *bot) moduleEnabled(name string) bool {
+	b.modules.mu.Lock()
+	defer b.modules.mu.Unlock()
+
+	s, ok := b.modules.state[name]
+	return ok && s.enabled
+}
+
+// workerStates returns the state of the background work of the enabled
+// modules, the key is the name of the module. The work of an enabled module
+// should never stop.
+func (b *bot) workerStates() map[string]string {
+	b.modules.mu.Lock()
+	defer b.modules.mu.Unlock()
+
+	states := make(map[string]string)
+	for name, s := range b.modules.state {
+		if !s.enabled || s.done == nil {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			states[name] = workerStopped
+		default:
+			states[name] = workerRunning
+		}
+	}
+
+	return states
+}
+
+// handleModules registers the handlers of all modules on the network. The
+// handlers are registered regardless of whether the module is enabled or
+// not, so that modules can be enabled at runtime.
+func (b *bot) handleModules(n *network) {
+	for _, m := range modules {
+		for _, h := range m.handlers {
+			name, fn := m.name, h.fn
+			n.handle(h.event, func(n *network, msg *irc.Message) {
+				if b.moduleEnabled(name) {
+					fn(b, n, msg)
+				}
+			})
+		}
+	}
+}
+
+// initModuleDefaults sets default values for the module command and
+// messages.
+func (b *bot) initModuleDefaults() {
+	if b.IRC.ModuleCmd == "" {
+		b.IRC.ModuleCmd = "module"
+	}
+	if b.IRC.ModuleSubCmdList == "" {
+		b.IRC.ModuleSubCmdList = "list"
+	}
+	if b.IRC.ModuleSubCmdEnable == "" {
+		b.IRC.ModuleSubCmdEnable = "enable"
+	}
+	if b.IRC.ModuleSubCmdDisable == "" {
+		b.IRC.ModuleSubCmdDisable = "disable"
+	}
+	if b.IRC.ModuleMsgList == "" {
+		b.IRC.ModuleMsgList = "enabled: <enabled>, disabled: <disabled>"
+	}
+	if b.IRC.ModuleMsgEnabled == "" {
+		b.IRC.ModuleMsgEnabled = "<name> is enabled"
+	}
+	if b.IRC.ModuleMsgDisabled == "" {
+		b.IRC.ModuleMsgDisabled = "<name> is disabled"
+	}
+	if b.IRC.ModuleErrUnknown == "" {
+		b.IRC.ModuleErrUnknown = "<name> is not a module"
+	}
+}
+
+// moduleCommand returns the module command, which lists, enables and
+// disables modules. Only admins are allowed to use the command.
+func (b *bot) moduleCommand() *botCommand {
+	return &botCommand{
+		name: b.IRC.ModuleCmd,
+		role: roleAdmin,
+		subCommands: []*botCommand{
+			{name: b.IRC.ModuleSubCmdList, fn: (*bot).moduleList},
+			{name: b.IRC.ModuleSubCmdEnable, usage: "<module>", minArgs: 1, maxArgs: 1, fn: (*bot).moduleEnable},
+			{name: b.IRC.ModuleSubCmdDisable, usage: "<module>", minArgs: 1, maxArgs: 1, fn: (*bot).moduleDisable},
+		},
+	}
+}
+
+// moduleList lists the enabled and disabled modules.
+func (b *bot) moduleList(a *privmsgAction) {
+	var enabled, disabled []string
+	for _, mod := range modules {
+		if b.moduleEnabled(mod.name) {
+			enabled = append(enabled, mod.name)
+		} else {
+			disabled = append(disabled, mod.name)
+		}
+	}
+	sort.Strings(enabled)
+	sort.Strings(disabled)
+
+	a.replyUrgentph(b.IRC.ModuleMsgList, map[string]string{
+		"<enabled>":  strings.Join(enabled, ", "),
+		"<disabled>": strings.Join(disabled, ", "),
+	})
+}
+
+// moduleEnable enables the given module.
+func (b *bot) moduleEnable(a *privmsgAction) {
+	mod := b.moduleFromAction(a)
+	if mod == nil {
+		return
+	}
+
+	b.enableModule(mod)
+	a.replyUrgentph(b.IRC.ModuleMsgEnabled, map[string]string{
+		"<name>": mod.name,
+	})
+}
+
+// moduleDisable disables the given module.
+func (b *bot) moduleDisable(a *privmsgAction) {
+	mod := b.moduleFromAction(a)
+	if mod == nil {
+		return
+	}
+
+	b.disableModule(mod)
+	a.replyUrgentph(b.IRC.ModuleMsgDisabled, map[string]string{
+		"<name>": mod.name,
+	})
+}
+
+// moduleFromAction returns the module that is given as the argument of the
+// action. nil is returned if there is no such module.
+func (b *bot) moduleFromAction(a *privmsgAction) *module {
+	mod := findModule(a.args[0])
+	if mod == nil {
+		a.replyUrgentph(b.IRC.ModuleErrUnknown, map[string]string{
+			"<name>": a.args[0],
+		})
+	}
+
+	return mod
+}
@@ -6,72 +6,130 @@ import (
 	"github.com/osm/irc"
 )
 
+// channelNames returns the names map for the given channel, the map is
+// created if it doesn't exist. The caller must hold the names mutex.
+func (n *network) channelNames(channel string) map[string]bool {
+	key := strings.ToLower(channel)
+
+	names, ok := n.names[key]
+	if !ok {
+		names = make(map[string]bool)
+		n.names[key] = names
+	}
+
+	return names
+}
+
 // handleNamesReply handles the 353 command (RPL_NAMREPLY) that is issued when
-// the bot joins the channel for the first time. It returns a list of all the
+// the bot joins a channel for the first time. It returns a list of all the
 // names that currently is in the channel. Big channels might return multiple
 // 353 commands, so we'll have to lock the map before we use it to prevent
 // race conditions.
-func (b *bot) handleNamesReply(m *irc.Message) {
+func (b *bot) handleNamesReply(n *network, m *irc.Message) {
 	// The raw IRC message will look something like this.
 	// :irc.example.net 353 foo = #foo :foo +bar @baz
 	// So we'll remove the foo = #foo part and keep the names.
+	if len(m.ParamsArray) < 4 {
+		return
+	}
+	channel := m.ParamsArray[2]
 	msg := strings.Join(m.ParamsArray[3:], " ")
 
 	// There can be more than one response in big channels, so we'll have
 	// to acquire a lock before we add anything to the names map.
-	b.IRC.namesMu.Lock()
-	defer b.IRC.namesMu.Unlock()
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	names := n.channelNames(channel)
 
 	// Names are separated with a space character, so we split on it and
 	// check whether the name exists in the map before or not.
-	for _, n := range strings.Split(msg, " ") {
+	for _, name := range strings.Split(msg, " ") {
 		// Strip any protocol and status characters from the given name.
-		if n[0] == ':' || n[0] == '+' || n[0] == '%' || n[0] == '@' {
-			n = n[1:]
+		name = strings.TrimLeft(name, ":+%@&~")
+		if name == "" {
+			continue
 		}
 
-		if _, ok := b.IRC.names[n]; !ok {
-			b.IRC.names[n] = true
+		if _, ok := names[name]; !ok {
+			names[name] = true
 		}
 	}
 }
 
-// handleNamesAdd adds a non existing name to the names map.
-func (b *bot) handleNamesAdd(m *irc.Message) {
-	b.IRC.namesMu.Lock()
-	defer b.IRC.namesMu.Unlock()
-	if _, ok := b.IRC.names[m.Name]; !ok {
-		b.IRC.names[m.Name] = true
+// handleNamesAdd adds a non existing name to the names map of the joined
+// channel.
+func (b *bot) handleNamesAdd(n *network, m *irc.Message) {
+	a, ok := b.parseAction(n, m).(*joinAction)
+	if !ok {
+		return
+	}
+
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	names := n.channelNames(a.channel)
+	if _, ok := names[m.Name]; !ok {
+		names[m.Name] = true
 	}
 }
 
-// handleNamesRemove updates the names map of the bot and removes the name of
-// the user that is either PARTing or QUITing.
-func (b *bot) handleNamesRemove(m *irc.Message) {
-	b.IRC.namesMu.Lock()
-	defer b.IRC.namesMu.Unlock()
-	if _, ok := b.IRC.names[m.Name]; ok {
-		delete(b.IRC.names, m.Name)
+// handleNamesRemove updates the names map of the channel that the user is
+// PARTing from.
+func (b *bot) handleNamesRemove(n *network, m *irc.Message) {
+	if len(m.ParamsArray) < 1 {
+		return
 	}
+
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	delete(n.channelNames(strings.TrimPrefix(m.ParamsArray[0], ":")), m.Name)
 }
 
-// handleNamesChange replaces the name in the names map when someone uses the
+// handleNamesKick removes the kicked user from the names map of the
+// channel.
+func (b *bot) handleNamesKick(n *network, m *irc.Message) {
+	// :the_nick!~bar@172.17.0.1 KICK #foo victim :reason
+	if len(m.ParamsArray) < 2 {
+		return
+	}
+
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	delete(n.channelNames(m.ParamsArray[0]), m.ParamsArray[1])
+}
+
+// handleNamesQuit removes the name of the user that is QUITing from all
+// channels.
+func (b *bot) handleNamesQuit(n *network, m *irc.Message) {
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	for _, names := range n.names {
+		delete(names, m.Name)
+	}
+}
+
+// handleNamesChange replaces the name in the names maps when someone uses the
 // NICK command.
-func (b *bot) handleNamesChange(m *irc.Message) {
+func (b *bot) handleNamesChange(n *network, m *irc.Message) {
 	// Extract the current and new name from the message.
 	currentName := m.Name
-	newName := m.Params[1:]
+	newName := strings.TrimPrefix(m.Params, ":")
 
-	b.IRC.namesMu.Lock()
-	defer b.IRC.namesMu.Unlock()
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
 
-	// Remove the old name from the map.
-	if _, ok := b.IRC.names[currentName]; ok {
-		delete(b.IRC.names, currentName)
-	}
+	// Replace the old name with the new in all channels that the user is
+	// in.
+	for _, names := range n.names {
+		if _, ok := names[currentName]; !ok {
+			continue
+		}
 
-	// ... and add the new name.
-	if _, ok := b.IRC.names[newName]; !ok {
-		b.IRC.names[newName] = true
+		delete(names, currentName)
+		names[newName] = true
 	}
 }
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osm/irc"
+)
+
+// defaultNetworkName is the name of the network that is created from the
+// legacy connection settings in the irc section of the configuration.
+const defaultNetworkName = "default"
+
+// postConnectMessage is a message that is sent to the target as soon as the
+// bot has connected to the network.
+type postConnectMessage struct {
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+// network holds the configuration and the connection state of a single IRC
+// network. All networks share the same database, HTTP server and feature
+// settings.
+type network struct {
+	// Name identifies the network, it is stored together with all rows
+	// that originates from the network.
+	Name string `json:"name"`
+
+	// Connection related settings, Nick, RealName and User falls back
+	// to the values in the irc section if they are empty.
+	Address  string `json:"address"`
+	Nick     string `json:"nick"`
+	RealName string `json:"realName"`
+	User     string `json:"user"`
+
+	// Channels contains all channels the bot should join together with
+	// the features that are allowed in each of them.
+	Channels []channelConfig `json:"channels"`
+
+	PostConnectMessages []postConnectMessage `json:"postConnectMessages"`
+	PostConnectModes    []string             `json:"postConnectModes"`
+
+	// Capabilities contains the IRCv3 capabilities that are requested
+	// when the bot connects, it defaults to defaultCapabilities. SASL
+	// is used to log in before the channels are joined.
+	Capabilities []string   `json:"capabilities"`
+	SASL         saslConfig `json:"sasl"`
+
+	// TLS contains the TLS settings of the connection, tlsConfig is
+	// created from them when the bot starts.
+	TLS       tlsSettings `json:"tls"`
+	tlsConfig *tls.Config
+
+	// configPath is the path to the network in the configuration file,
+	// it is used when configuration errors are reported.
+	configPath string
+
+	client *irc.Client
+
+	// configMu is the configuration lock of the bot, it's held for
+	// reading while the event handlers runs.
+	configMu *sync.RWMutex
+
+	// metrics are the metrics of the bot.
+	metrics *botMetrics
+
+	// conn is the current connection to the server, connected is true
+	// while the bot is registered with the server.
+	conn      *ircConn
+	connected bool
+	connMu    sync.Mutex
+
+	// queue holds the outgoing messages, they are sent in priority
+	// order and no faster than the grace period allows.
+	queue *sendQueue
+
+	// names contains all the nicks in each channel, the key of the outer
+	// map is the lower cased channel name. The map is updated on JOIN,
+	// PART, KICK, NICK and QUIT messages.
+	names map[string]map[string]bool
+
+	// namesMu holds the mutex for the names map.
+	namesMu sync.Mutex
+
+	// acked contains the capabilities that the server has acknowledged
+	// on the current connection.
+	acked   map[string]bool
+	ackedMu sync.Mutex
+
+	// tags contains the message tags of the received lines.
+	tags tagStore
+}
+
+// initNetworks creates the default network from the legacy connection
+// settings if no networks are configured and initializes the runtime state
+// of each network.
+func (b *bot) initNetworks() {
+	if len(b.IRC.Networks) == 0 {
+		name := b.IRC.Network
+		if name == "" {
+			name = defaultNetworkName
+		}
+
+		// Merge the legacy channel setting into the channels slice.
+		channels := b.IRC.Channels
+		if b.IRC.Channel != "" {
+			exists := false
+			for _, c := range channels {
+				if strings.EqualFold(c.Name, b.IRC.Channel) {
+					exists = true
+				}
+			}
+			if !exists {
+				channels = append([]channelConfig{{Name: b.IRC.Channel}}, channels...)
+			}
+		}
+
+		b.IRC.Networks = []*network{{
+			Name:                name,
+			Address:             b.IRC.Address,
+			Nick:                b.IRC.Nick,
+			RealName:            b.IRC.RealName,
+			User:                b.IRC.User,
+			Channels:            channels,
+			PostConnectMessages: b.IRC.PostConnectMessages,
+			PostConnectModes:    b.IRC.PostConnectModes,
+			Capabilities:        b.IRC.Capabilities,
+			SASL:                b.IRC.SASL,
+			TLS:                 b.IRC.TLS,
+			configPath:          "irc",
+		}}
+	} else {
+		for i, n := range b.IRC.Networks {
+			n.configPath = fmt.Sprintf("irc.networks[%d]", i)
+		}
+	}
+
+	for _, n := range b.IRC.Networks {
+		if n.Nick == "" {
+			n.Nick = b.IRC.Nick
+		}
+		if n.RealName == "" {
+			n.RealName = b.IRC.RealName
+		}
+		if n.User == "" {
+			n.User = b.IRC.User
+		}
+		if len(n.Capabilities) == 0 {
+			n.Capabilities = defaultCapabilities
+		}
+
+		n.names = make(map[string]map[string]bool)
+		n.queue = newSendQueue()
+	}
+}
+
+// network returns the network with the given name, nil is returned if the
+// network isn't configured.
+func (b *bot) network(name string) *network {
+	for _, n := range b.IRC.Networks {
+		if n.Name == name {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// defaultNetwork returns the first configured network. Rows that were stored
+// before multi network support was added belongs to this network.
+func (b *bot) defaultNetwork() *network {
+	if len(b.IRC.Networks) == 0 {
+		return nil
+	}
+
+	return b.IRC.Networks[0]
+}
+
+// handle registers the handler for the given event on the network client,
+// the handler is called with the network that the message was received on.
+// Our own messages that the server echoes back are not passed on to the
+// handlers.
+func (n *network) handle(event string, fn func(*network, *irc.Message)) {
+	n.client.Handle(event, func(m *irc.Message) {
+		// The message claims its tags before the handler can be held
+		// up, since the next identical line waits for it.
+		n.tags.claim(m)
+
+		if (m.Command == "PRIVMSG" || m.Command == "NOTICE") && m.Name == n.client.GetNick() {
+			return
+		}
+
+		n.configMu.RLock()
+		defer n.configMu.RUnlock()
+
+		start := time.Now()
+		fn(n, m)
+		n.metrics.handlerDuration.observe(time.Since(start).Seconds(), event)
+	})
+}
+
+// setAcked replaces the acknowledged capabilities.
+func (n *network) setAcked(capabilities []string) {
+	n.ackedMu.Lock()
+	defer n.ackedMu.Unlock()
+
+	n.acked = make(map[string]bool)
+	for _, c := range capabilities {
+		n.acked[c] = true
+	}
+}
+
+// hasCapability returns true if the server has acknowledged the capability.
+func (n *network) hasCapability(capability string) bool {
+	n.ackedMu.Lock()
+	defer n.ackedMu.Unlock()
+
+	return n.acked[capability]
+}
+
+// channel returns the configuration of the given channel, nil is returned if
+// the channel isn't configured.
+func (n *network) channel(name string) *channelConfig {
+	for i := range n.Channels {
+		if strings.EqualFold(n.Channels[i].Name, name) {
+			return &n.Channels[i]
+		}
+	}
+
+	return nil
+}
+
+// defaultChannel returns the name of the first configured channel. Rows that
+// were stored before multi channel support was added belongs to this
+// channel.
+func (n *network) defaultChannel() string {
+	if len(n.Channels) == 0 {
+		return ""
+	}
+
+	return n.Channels[0].Name
+}
+
+// hasFeature returns true if the given channel is configured and the feature
+// is allowed in it.
+func (n *network) hasFeature(channel, feature string) bool {
+	c := n.channel(channel)
+	if c == nil {
+		return false
+	}
+
+	return c.hasFeature(feature)
+}
+
+// channelsWithFeature returns the names of all channels where the feature is
+// allowed.
+func (n *network) channelsWithFeature(feature string) []string {
+	var channels []string
+
+	for i := range n.Channels {
+		if n.Channels[i].hasFeature(feature) {
+			channels = append(channels, n.Channels[i].Name)
+		}
+	}
+
+	return channels
+}
+
+// send splits the message into lines that fits within the line length
+// limit and queues them with the given priority, command is either PRIVMSG
+// or NOTICE.
+func (n *network) send(p messagePriority, command, target, msg string) {
+	n.queue.push(p, command, target, splitMessage(msg, n.maxMessageLength(command, target)))
+}
+
+// maxMessageLength returns how long a message to the target can be. The
+// server prepends our full hostmask when the message is relayed, so room is
+// left for the longest possible host.
+func (n *network) maxMessageLength(command, target string) int {
+	nick := n.Nick
+	if n.client != nil {
+		nick = n.client.GetNick()
+	}
+	prefix := ":" + nick + "!~" + n.User + "@ "
+	cmd := command + " " + target + " :"
+
+	return maxLineLength - len("\r\n") - len(prefix) - maxHostLength - len(cmd)
+}
+
+// sendQueued sends a message that has been taken from the queue.
+func (n *network) sendQueued(m queuedMessage) {
+	n.client.Sendf("%s %s :%s", m.command, m.target, m.line)
+	n.metrics.messagesSent.inc(n.Name, m.command)
+}
+
+// privmsg sends the given message to the target, the target is either a
+// channel or a nick.
+func (n *network) privmsg(target, msg string) {
+	n.send(priorityReply, "PRIVMSG", target, msg)
+}
+
+// privmsgph replaces the keys of the phs map with the values and sends the
+// message to the specified target.
+func (n *network) privmsgph(target, msg string, phs map[string]string) {
+	n.privmsg(target, replacePlaceholders(msg, phs))
+}
+
+// urgent sends the message before the replies and announcements that are
+// waiting, it's used for errors and replies to admin commands.
+func (n *network) urgent(target, msg string) {
+	n.send(priorityUrgent, "PRIVMSG", target, msg)
+}
+
+// urgentph is privmsgph for urgent messages.
+func (n *network) urgentph(target, msg string, phs map[string]string) {
+	n.urgent(target, replacePlaceholders(msg, phs))
+}
+
+// announce sends a message that nobody asked for, such as the output of a
+// cron job. Announcements are sent after everything else and are dropped if
+// they have been waiting for too long.
+func (n *network) announce(target, msg string) {
+	n.send(priorityBackground, "PRIVMSG", target, msg)
+}
+
+// announceph is privmsgph for announcements.
+func (n *network) announceph(target, msg string, phs map[string]string) {
+	n.announce(target, replacePlaceholders(msg, phs))
+}
+
+// replacePlaceholders replaces the keys of the phs map with the values.
+func replacePlaceholders(msg string, phs map[string]string) string {
+	for k, v := range phs {
+		msg = strings.ReplaceAll(msg, k, v)
+	}
+
+	return msg
+}
+
+// action sends the given message to the target as an ACTION message, each
+// line is wrapped separately if the message has to be split.
+func (n *network) action(target, msg string) {
+	const start, end = "\u0001ACTION ", "\u0001"
+
+	lines := splitMessage(msg, n.maxMessageLength("PRIVMSG", target)-len(start)-len(end))
+	for i := range lines {
+		lines[i] = start + lines[i] + end
+	}
+	n.queue.push(priorityReply, "PRIVMSG", target, lines)
+}
+
+// drain returns when there are no more messages waiting to be sent.
+func (n *network) drain() {
+	n.queue.drain()
+}
+
+// setConnection sets the current connection to the server.
+func (n *network) setConnection(c *ircConn) {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+
+	n.conn = c
+}
+
+// setConnected sets whether the bot is registered with the server, the names
+// are cleared when the connection is lost since they are sent again when the
+// channels are joined.
+func (n *network) setConnected(connected bool) {
+	n.connMu.Lock()
+	n.connected = connected
+	n.connMu.Unlock()
+
+	if !connected {
+		n.namesMu.Lock()
+		n.names = make(map[string]map[string]bool)
+		n.namesMu.Unlock()
+	}
+}
+
+// isConnected returns true if the bot is registered with the server.
+func (n *network) isConnected() bool {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+
+	return n.connected
+}
+
+// connection returns the current connection to the server, nil is returned
+// if the bot hasn't connected yet.
+func (n *network) connection() *ircConn {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+
+	return n.conn
+}
+
+// rndName returns a random name from the names map of the given channel.
+func (n *network) rndName(channel string) string {
+	n.namesMu.Lock()
+	defer n.namesMu.Unlock()
+
+	names := n.names[strings.ToLower(channel)]
+	if len(names) == 0 {
+		return n.Nick
+	}
+
+	i := 0
+	stop := rand.Intn(len(names))
+
+	for name := range names {
+		if i == stop {
+			return name
+		}
+		i++
+	}
+
+	return n.Nick
+}
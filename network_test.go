@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultipleNetworks(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"enableFactoid": true,
+		"enableLogging": true,
+		"networks": []map[string]interface{}{
+			{"name": "one", "address": "irc.one.example.com:6667", "channels": []map[string]interface{}{{"name": "#bot"}}},
+			{"name": "two", "address": "irc.two.example.com:6667", "nick": "bot2", "channels": []map[string]interface{}{{"name": "#two"}}},
+		},
+	})
+	defer tb.close()
+	one, two := tb.servers["one"], tb.servers["two"]
+
+	if one.nick != "bot" || two.nick != "bot2" {
+		t.Errorf("expected the nicks bot and bot2, got %s and %s", one.nick, two.nick)
+	}
+
+	// The networks share the database, so a factoid that is added on
+	// one network is known on the other one. The reply is only sent to
+	// the network that asked.
+	one.privmsg("alice", "#bot", "!factoid add coffee _is_ hot")
+	one.expect("PRIVMSG #bot :noted")
+	two.privmsg("bob", "#two", "coffee")
+	two.expect("PRIVMSG #two :coffee is hot")
+	one.expectNo("PRIVMSG", 200*time.Millisecond)
+
+	// The rows are tagged with the network that they came from.
+	tb.waitFor("the messages to be logged", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM log") == "2"
+	})
+	for _, row := range []struct{ network, channel, nick string }{{"one", "#bot", "alice"}, {"two", "#two", "bob"}} {
+		if n := tb.queryString("SELECT COUNT(*) FROM log WHERE network = $1 AND channel = $2 AND nick = $3", row.network, row.channel, row.nick); n != "1" {
+			t.Errorf("expected the message of %s to be logged on %s %s, got %s", row.nick, row.network, row.channel, n)
+		}
+	}
+	if network := tb.queryString("SELECT network FROM factoid WHERE trigger = 'coffee'"); network != "one" {
+		t.Errorf("expected the factoid to be added on one, got %q", network)
+	}
+}
@@ -4,23 +4,43 @@ import (
 	"github.com/osm/irc"
 )
 
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureOperators,
+		enabled: func(b *bot) bool { return len(b.IRC.Operators) > 0 },
+		handlers: []moduleHandler{
+			{"JOIN", (*bot).operatorsHandler},
+		},
+	})
+}
+
 // operatorsHandler will automatically give a joining user operator status if
 // the host matches a host that exists in the operators section in the
 // configuration.
-func (b *bot) operatorsHandler(m *irc.Message) {
+func (b *bot) operatorsHandler(n *network, m *irc.Message) {
 	if len(b.IRC.Operators) < 1 {
 		return
 	}
 
-	a := b.parseAction(m).(*joinAction)
-	if !a.validChannel {
+	a := b.parseAction(n, m).(*joinAction)
+	if !n.hasFeature(a.channel, featureOperators) {
 		return
 	}
 
+	if b.isOperator(a.host) {
+		n.client.Sendf("MODE %s +o %s", a.channel, a.nick)
+	}
+}
+
+// isOperator returns true if the host matches one of the hosts in the
+// operators section in the configuration.
+func (b *bot) isOperator(host string) bool {
 	for _, o := range b.IRC.operators {
-		if o.Match([]byte(a.host)) {
-			b.IRC.client.Sendf("MODE %s +o %s", b.IRC.Channel, a.nick)
-			return
+		if o.Match([]byte(host)) {
+			return true
 		}
 	}
+
+	return false
 }
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/osm/irc"
+)
+
+// The events that can be sent to the outgoing webhooks.
+const (
+	outgoingEventMessage        = "message"
+	outgoingEventJoin           = "join"
+	outgoingEventPart           = "part"
+	outgoingEventFactoidAdded   = "factoidAdded"
+	outgoingEventFactoidDeleted = "factoidDeleted"
+	outgoingEventQuizFinished   = "quizFinished"
+	outgoingEventCronFired      = "cronFired"
+)
+
+// The statuses of the deliveries in the webhook_delivery table.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// outgoingWebhookPoll is how often the delivery log is checked for
+// deliveries that should be retried.
+const outgoingWebhookPoll = 5 * time.Second
+
+// outgoingWebhookBatch is the maximum number of deliveries that are made
+// each time the delivery log is checked.
+const outgoingWebhookBatch = 50
+
+// outgoingWebhookConfig holds the configuration of the outgoing webhooks
+// module.
+type outgoingWebhookConfig struct {
+	EnableOutgoingWebhooks bool               `json:"enableOutgoingWebhooks"`
+	OutgoingWebhooks       []*outgoingWebhook `json:"outgoingWebhooks"`
+
+	// OutgoingWebhookMaxAttempts is how many times a delivery is tried
+	// before it's marked as failed, and OutgoingWebhookTimeout is the
+	// timeout of each attempt in seconds.
+	OutgoingWebhookMaxAttempts int `json:"outgoingWebhookMaxAttempts"`
+	OutgoingWebhookTimeout     int `json:"outgoingWebhookTimeout"`
+
+	// outgoingWebhookWake wakes up the delivery worker when an event
+	// has been queued.
+	outgoingWebhookWake chan struct{}
+}
+
+// outgoingWebhook is a URL that the events are POSTed to as JSON.
+type outgoingWebhook struct {
+	// Name identifies the webhook in the delivery log.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Secret signs the payloads, the hex encoded HMAC-SHA256 of the
+	// body is sent in the X-Signature-256 header if it's set.
+	Secret string `json:"secret"`
+
+	// Events are the events that are sent to the webhook.
+	Events []string `json:"events"`
+
+	// Match is a regexp that the messages must match for message
+	// events to be sent, all messages are sent if it's empty.
+	Match string `json:"match"`
+	match *regexp.Regexp
+
+	// Network and Channel limits the webhook to the events of the
+	// network and channel, if they are set.
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+}
+
+// outgoingEvent is the JSON that is POSTed to the webhooks.
+type outgoingEvent struct {
+	Webhook string                 `json:"webhook"`
+	Event   string                 `json:"event"`
+	Time    string                 `json:"time"`
+	Network string                 `json:"network"`
+	Channel string                 `json:"channel"`
+	Nick    string                 `json:"nick,omitempty"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureOutgoingWebhooks,
+		config:  func(b *bot) interface{} { return &b.config.outgoingWebhook },
+		enabled: func(b *bot) bool { return b.config.outgoingWebhook.EnableOutgoingWebhooks },
+		check:   (*bot).checkOutgoingWebhookConfig,
+		init:    (*bot).initOutgoingWebhookDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).outgoingWebhookMessageHandler},
+			{"JOIN", (*bot).outgoingWebhookJoinHandler},
+			{"PART", (*bot).outgoingWebhookPartHandler},
+		},
+		run: (*bot).runOutgoingWebhooks,
+	})
+}
+
+// checkOutgoingWebhookConfig makes sure that the webhooks have a name and a
+// URL, that the events exist and that the match regexps compiles.
+func (b *bot) checkOutgoingWebhookConfig() error {
+	var errs configErrors
+	names := make(map[string]bool)
+	for i, h := range b.config.outgoingWebhook.OutgoingWebhooks {
+		path := fmt.Sprintf("outgoingWebhooks[%d]", i)
+		if h.Name == "" {
+			errs = append(errs, configError{path + ".name", "is missing"})
+		} else if names[h.Name] {
+			errs = append(errs, configError{path + ".name", fmt.Sprintf("%q is used by more than one webhook", h.Name)})
+		}
+		names[h.Name] = true
+
+		if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+			errs = append(errs, configError{path + ".url", "must be a http or https URL"})
+		}
+		if len(h.Events) == 0 {
+			errs = append(errs, configError{path + ".events", "is missing"})
+		}
+		for j, e := range h.Events {
+			switch e {
+			case outgoingEventMessage, outgoingEventJoin, outgoingEventPart, outgoingEventFactoidAdded,
+				outgoingEventFactoidDeleted, outgoingEventQuizFinished, outgoingEventCronFired:
+			default:
+				errs = append(errs, configError{fmt.Sprintf("%s.events[%d]", path, j), fmt.Sprintf("%s is not an event", e)})
+			}
+		}
+		if _, err := regexp.Compile(h.Match); err != nil {
+			errs = append(errs, configError{path + ".match", err.Error()})
+		}
+	}
+
+	return errs.err()
+}
+
+// initOutgoingWebhookDefaults sets the default values of the module and
+// compiles the match regexps.
+func (b *bot) initOutgoingWebhookDefaults() {
+	if b.config.outgoingWebhook.OutgoingWebhookMaxAttempts == 0 {
+		b.config.outgoingWebhook.OutgoingWebhookMaxAttempts = 8
+	}
+	if b.config.outgoingWebhook.OutgoingWebhookTimeout == 0 {
+		b.config.outgoingWebhook.OutgoingWebhookTimeout = 10
+	}
+	if b.config.outgoingWebhook.outgoingWebhookWake == nil {
+		b.config.outgoingWebhook.outgoingWebhookWake = make(chan struct{}, 1)
+	}
+
+	for _, h := range b.config.outgoingWebhook.OutgoingWebhooks {
+		if h.Match != "" {
+			h.match = regexp.MustCompile(h.Match)
+		}
+	}
+}
+
+// wants returns true if the event should be sent to the webhook.
+func (h *outgoingWebhook) wants(e *outgoingEvent) bool {
+	if h.Network != "" && h.Network != e.Network {
+		return false
+	}
+	if h.Channel != "" && !strings.EqualFold(h.Channel, e.Channel) {
+		return false
+	}
+	if e.Event == outgoingEventMessage && h.match != nil {
+		msg, _ := e.Data["message"].(string)
+		if !h.match.MatchString(msg) {
+			return false
+		}
+	}
+
+	for _, event := range h.Events {
+		if event == e.Event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emitEvent queues the event for delivery to the webhooks that wants it. The
+// deliveries are stored in the delivery log before they are made, so that
+// they are retried even if the bot is restarted.
+func (b *bot) emitEvent(n *network, channel, nick, event string, data map[string]interface{}) {
+	if !b.moduleEnabled(featureOutgoingWebhooks) || !n.hasFeature(channel, featureOutgoingWebhooks) {
+		return
+	}
+
+	e := &outgoingEvent{
+		Event:   event,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Network: n.Name,
+		Channel: channel,
+		Nick:    nick,
+		Data:    data,
+	}
+
+	queued := false
+	for _, h := range b.config.outgoingWebhook.OutgoingWebhooks {
+		if !h.wants(e) {
+			continue
+		}
+
+		e.Webhook = h.Name
+		payload, err := json.Marshal(e)
+		if err != nil {
+			b.log(featureOutgoingWebhooks).Errorf("emitEvent: %v", err)
+			continue
+		}
+
+		if err := b.queueDelivery(h.Name, event, payload); err != nil {
+			b.metrics.dbErrors.inc()
+			b.log(featureOutgoingWebhooks).Errorf("emitEvent: %v", err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case b.config.outgoingWebhook.outgoingWebhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queueDelivery stores the payload as a pending delivery in the delivery log.
+func (b *bot) queueDelivery(webhook, event string, payload []byte) error {
+	stmt, err := b.prepare("INSERT INTO webhook_delivery (id, webhook, event, payload, status, attempts, last_error, next_attempt_at, inserted_at) VALUES($1, $2, $3, $4, $5, 0, '', $6, $6)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(newUUID(), webhook, event, string(payload), deliveryPending, newTimestamp())
+	return err
+}
+
+// outgoingWebhookMessageHandler sends the channel messages to the webhooks.
+func (b *bot) outgoingWebhookMessageHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
+	if !a.validChannel {
+		return
+	}
+
+	b.emitEvent(n, a.target, a.nick, outgoingEventMessage, map[string]interface{}{
+		"message": a.msg,
+	})
+}
+
+// outgoingWebhookJoinHandler sends the joins to the webhooks.
+func (b *bot) outgoingWebhookJoinHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*joinAction)
+	if !a.validChannel {
+		return
+	}
+
+	b.emitEvent(n, a.channel, a.nick, outgoingEventJoin, map[string]interface{}{
+		"host": a.host,
+	})
+}
+
+// outgoingWebhookPartHandler sends the parts to the webhooks.
+func (b *bot) outgoingWebhookPartHandler(n *network, m *irc.Message) {
+	// :the_nick!~bar@172.17.0.1 PART #foo :reason
+	if len(m.ParamsArray) < 1 {
+		return
+	}
+
+	c := n.channel(strings.TrimPrefix(m.ParamsArray[0], ":"))
+	if c == nil {
+		return
+	}
+
+	reason := ""
+	if i := strings.Index(m.Params, " :"); i != -1 {
+		reason = m.Params[i+2:]
+	}
+
+	b.emitEvent(n, c.Name, m.Name, outgoingEventPart, map[string]interface{}{
+		"reason": reason,
+	})
+}
+
+// runOutgoingWebhooks is the worker that makes the deliveries. It's woken
+// up when an event has been queued and checks the delivery log for retries
+// every outgoingWebhookPoll.
+func (b *bot) runOutgoingWebhooks(ctx context.Context) {
+	for {
+		b.deliverOutgoingWebhooks(ctx, b.httpClient())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.config.outgoingWebhook.outgoingWebhookWake:
+		case <-time.After(outgoingWebhookPoll):
+		}
+	}
+}
+
+// pendingDelivery is a delivery that is waiting to be made.
+type pendingDelivery struct {
+	id       string
+	webhook  string
+	event    string
+	payload  string
+	attempts int
+}
+
+// deliverOutgoingWebhooks makes the pending deliveries that are due. A
+// failed delivery is retried with an exponential backoff until it has been
+// tried OutgoingWebhookMaxAttempts times.
+func (b *bot) deliverOutgoingWebhooks(ctx context.Context, client *http.Client) {
+	rows, err := b.query("SELECT id, webhook, event, payload, attempts FROM webhook_delivery WHERE status = $1 AND next_attempt_at <= $2 ORDER BY inserted_at LIMIT $3", deliveryPending, newTimestamp(), outgoingWebhookBatch)
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureOutgoingWebhooks).Errorf("deliverOutgoingWebhooks: %v", err)
+		return
+	}
+
+	var deliveries []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.webhook, &d.event, &d.payload, &d.attempts); err != nil {
+			b.metrics.dbErrors.inc()
+			b.log(featureOutgoingWebhooks).Errorf("deliverOutgoingWebhooks: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The configuration is only locked while the webhook is looked
+		// up, so that a slow webhook doesn't block a reload.
+		b.configMu.RLock()
+		var h outgoingWebhook
+		found := false
+		for _, w := range b.config.outgoingWebhook.OutgoingWebhooks {
+			if w.Name == d.webhook {
+				h, found = *w, true
+			}
+		}
+		timeout := time.Duration(b.config.outgoingWebhook.OutgoingWebhookTimeout) * time.Second
+		maxAttempts := b.config.outgoingWebhook.OutgoingWebhookMaxAttempts
+		b.configMu.RUnlock()
+
+		d.attempts++
+		if !found {
+			b.updateDelivery(d, deliveryFailed, "the webhook has been removed from the configuration", time.Now())
+			continue
+		}
+
+		err := b.postOutgoingWebhook(ctx, client, &h, d, timeout)
+		switch {
+		case err == nil:
+			b.updateDelivery(d, deliveryDelivered, "", time.Now())
+		case d.attempts >= maxAttempts:
+			b.log(featureOutgoingWebhooks).Warnf("deliverOutgoingWebhooks: giving up on %s to %s after %d attempts, %v", d.id, h.Name, d.attempts, err)
+			b.updateDelivery(d, deliveryFailed, err.Error(), time.Now())
+		default:
+			b.log(featureOutgoingWebhooks).Debugf("deliverOutgoingWebhooks: %s to %s failed, %v", d.id, h.Name, err)
+			b.updateDelivery(d, deliveryPending, err.Error(), time.Now().Add(outgoingWebhookBackoff(d.attempts)))
+		}
+	}
+}
+
+// outgoingWebhookBackoff returns how long to wait before a delivery that has
+// failed the given number of times is tried again, the wait is doubled for
+// each attempt and is at most an hour.
+func outgoingWebhookBackoff(attempts int) time.Duration {
+	wait := 5 * time.Second
+	for i := 1; i < attempts && wait < time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > time.Hour {
+		wait = time.Hour
+	}
+	return wait
+}
+
+// postOutgoingWebhook POSTs the payload of the delivery to the webhook, the
+// delivery has failed unless the response has a 2xx status.
+func (b *bot) postOutgoingWebhook(ctx context.Context, client *http.Client, h *outgoingWebhook, d pendingDelivery, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, strings.NewReader(d.payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bot-Event", d.event)
+	req.Header.Set("X-Bot-Delivery", d.id)
+	if h.Secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+signHMAC(h.Secret, []byte(d.payload)))
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	b.metrics.observeHTTP("outgoingWebhook", start, res, err)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
+
+// updateDelivery records the outcome of an attempt in the delivery log, next
+// is when a pending delivery is tried again.
+func (b *bot) updateDelivery(d pendingDelivery, status, lastError string, next time.Time) {
+	var deliveredAt interface{}
+	if status == deliveryDelivered {
+		deliveredAt = newTimestamp()
+	}
+
+	stmt, err := b.prepare("UPDATE webhook_delivery SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, delivered_at = $5 WHERE id = $6")
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureOutgoingWebhooks).Errorf("updateDelivery: %v", err)
+		return
+	}
+	defer stmt.Close()
+
+	if _, err = stmt.Exec(status, d.attempts, lastError, formatTimestamp(next), deliveredAt, d.id); err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureOutgoingWebhooks).Errorf("updateDelivery: %v", err)
+	}
+}
+
+// deleteDeliveriesBefore deletes the deliveries that were queued before the
+// time and have been delivered or have failed, the pending deliveries are
+// kept until they are made. It returns how many that were deleted.
+func (b *bot) deleteDeliveriesBefore(t time.Time) (int64, error) {
+	stmt, err := b.prepare("DELETE FROM webhook_delivery WHERE status <> $1 AND inserted_at < $2")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(deliveryPending, formatTimestamp(t))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
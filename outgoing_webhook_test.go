@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// outgoingRequest is a request that the outgoing webhook server received.
+type outgoingRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newOutgoingWebhookServer returns a server that sends the requests it
+// receives to the channel and responds with the given status.
+func newOutgoingWebhookServer(status int) (*httptest.Server, chan outgoingRequest) {
+	requests := make(chan outgoingRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- outgoingRequest{r.Header, body}
+		w.WriteHeader(status)
+	}))
+	return srv, requests
+}
+
+func TestOutgoingWebhookSignature(t *testing.T) {
+	srv, requests := newOutgoingWebhookServer(http.StatusOK)
+	defer srv.Close()
+
+	tb := newTestBotWithConfig(t, nil, map[string]interface{}{
+		"modules": map[string]interface{}{
+			"outgoingWebhooks": map[string]interface{}{
+				"enableOutgoingWebhooks": true,
+				"outgoingWebhooks": []map[string]interface{}{
+					{"name": "ci", "url": srv.URL, "secret": "s3cret", "events": []string{"message"}},
+				},
+			},
+		},
+	})
+	defer tb.close()
+
+	tb.server.privmsg("bob", "#bot", "hello")
+
+	var r outgoingRequest
+	select {
+	case r = <-requests:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the delivery")
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(r.body)
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.header.Get("X-Signature-256") != expected {
+		t.Errorf("expected the signature %s, got %s", expected, r.header.Get("X-Signature-256"))
+	}
+	if r.header.Get("X-Bot-Event") != "message" || r.header.Get("X-Bot-Delivery") == "" {
+		t.Errorf("expected the event and delivery headers, got %v", r.header)
+	}
+
+	var e outgoingEvent
+	if err := json.Unmarshal(r.body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Webhook != "ci" || e.Event != "message" || e.Channel != "#bot" || e.Nick != "bob" || e.Data["message"] != "hello" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	tb.waitFor("the delivery to be recorded", func() bool {
+		return tb.queryString("SELECT status FROM webhook_delivery") == deliveryDelivered
+	})
+}
+
+func TestOutgoingWebhookRetry(t *testing.T) {
+	srv, requests := newOutgoingWebhookServer(http.StatusInternalServerError)
+	defer srv.Close()
+
+	// The module isn't enabled, so the deliveries are only made when the
+	// test asks for it.
+	tb := newTestBot(t, nil)
+	defer tb.close()
+
+	tb.configMu.Lock()
+	tb.config.outgoingWebhook.OutgoingWebhooks = []*outgoingWebhook{{Name: "ci", URL: srv.URL}}
+	tb.config.outgoingWebhook.OutgoingWebhookMaxAttempts = 3
+	tb.config.outgoingWebhook.OutgoingWebhookTimeout = 5
+	tb.configMu.Unlock()
+
+	if err := tb.queueDelivery("ci", "message", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// deliver makes the deliveries that are due and returns how many
+	// requests that were made.
+	deliver := func() int {
+		tb.deliverOutgoingWebhooks(context.Background(), srv.Client())
+		n := 0
+		for len(requests) > 0 {
+			<-requests
+			n++
+		}
+		return n
+	}
+
+	for attempt, wait := range []time.Duration{5 * time.Second, 10 * time.Second, 0} {
+		start := time.Now()
+		if n := deliver(); n != 1 {
+			t.Fatalf("attempt %d: expected one request, got %d", attempt+1, n)
+		}
+
+		var status, lastError string
+		var attempts int
+		var next time.Time
+		if err := tb.queryRow("SELECT status, attempts, last_error, next_attempt_at FROM webhook_delivery").Scan(&status, &attempts, &lastError, dbTime{&next}); err != nil {
+			t.Fatal(err)
+		}
+		if attempts != attempt+1 || lastError != "500 Internal Server Error" {
+			t.Errorf("attempt %d: expected the attempt and the error to be recorded, got %d %q", attempt+1, attempts, lastError)
+		}
+
+		// The last attempt fails the delivery, the others are retried
+		// after the backoff.
+		if wait == 0 {
+			if status != deliveryFailed {
+				t.Errorf("expected the delivery to fail after the last attempt, got %s", status)
+			}
+			break
+		}
+		if status != deliveryPending || next.Before(start.Add(wait-time.Second)) || next.After(time.Now().Add(wait+time.Second)) {
+			t.Errorf("attempt %d: expected a retry in %v, got %s at %v", attempt+1, wait, status, next.Sub(start))
+		}
+
+		// Nothing is sent again until the retry is due.
+		if n := deliver(); n != 0 {
+			t.Fatalf("attempt %d: expected no request before the retry, got %d", attempt+1, n)
+		}
+		if _, err := tb.DB.client.Exec("UPDATE webhook_delivery SET next_attempt_at = $1", formatTimestamp(time.Now().Add(-time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed delivery is never tried again.
+	if _, err := tb.DB.client.Exec("UPDATE webhook_delivery SET next_attempt_at = $1", formatTimestamp(time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if n := deliver(); n != 0 {
+		t.Errorf("expected no request for a failed delivery, got %d", n)
+	}
+}
+
+func TestOutgoingWebhookBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		10: 42*time.Minute + 40*time.Second,
+		11: time.Hour,
+		50: time.Hour,
+	} {
+		if wait := outgoingWebhookBackoff(attempts); wait != expected {
+			t.Errorf("%d attempts: expected %v, got %v", attempts, expected, wait)
+		}
+	}
+}
+
+func TestDeleteDeliveriesBefore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-delivery-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := newDBCommandBot(t, filepath.Join(dir, "bot.db"))
+	defer b.DB.client.Close()
+
+	now := time.Now().UTC()
+	for _, d := range []struct {
+		status string
+		age    int
+	}{
+		{deliveryDelivered, 40},
+		{deliveryFailed, 40},
+		{deliveryPending, 40},
+		{deliveryDelivered, 1},
+	} {
+		_, err := b.DB.client.Exec("INSERT INTO webhook_delivery (id, webhook, event, payload, status, attempts, last_error, next_attempt_at, inserted_at) VALUES($1, 'ci', 'message', '{}', $2, 1, '', $3, $3)", newUUID(), d.status, formatTimestamp(now.AddDate(0, 0, -d.age)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pending delivery is kept even though it's old.
+	b.config.retention.RetentionWebhookDelivery = 30
+	result, err := b.prune(now)
+	if err != nil || len(result) != 1 || result[0] != (pruned{"webhook_delivery", 2}) {
+		t.Errorf("expected two deliveries to be pruned, got %v, %v", result, err)
+	}
+
+	var pending int
+	b.DB.client.QueryRow("SELECT COUNT(*) FROM webhook_delivery WHERE status = $1", deliveryPending).Scan(&pending)
+	if n := count(t, filepath.Join(dir, "bot.db"), "webhook_delivery"); n != 2 || pending != 1 {
+		t.Errorf("expected the pending and the new delivery to be left, got %d rows and %d pending", n, pending)
+	}
+}
@@ -3,105 +3,102 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/osm/irc"
 	"github.com/osm/postnord"
 )
 
+// parcelTrackingConfig holds the configuration of the parcel tracking module.
+type parcelTrackingConfig struct {
+	EnableParcelTracking               bool   `json:"enableParcelTracking"`
+	ParcelTrackingPostNordAPIKey       string `json:"parcelTrackingPostNordAPIKey"`
+	ParcelTrackingLocale               string `json:"parcelTrackingLocale"`
+	ParcelTrackingMsgInfo              string `json:"parcelTrackingMsgInfo"`
+	ParcelTrackingMsgAliasRemoved      string `json:"parcelTrackingMsgAliasRemoved"`
+	ParcelTrackingMsgAliasDoesNotExist string `json:"parcelTrackingMsgAliasDoesNotExist"`
+	ParcelTrackingErrNoData            string `json:"parcelTrackingErrNoData"`
+	ParcelTrackingErrDuplicateAlias    string `json:"parcelTrackingErrDuplicateAlias"`
+	ParcelTrackingCmd                  string `json:"parcelTrackingCmd"`
+	ParcelTrackingCmdAdd               string `json:"parcelTrackingCmdAdd"`
+	ParcelTrackingCmdRemove            string `json:"parcelTrackingCmdRemove"`
+	ParcelTrackingCmdInfo              string `json:"parcelTrackingCmdInfo"`
+	ParcelTrackingCmdFull              string `json:"parcelTrackingCmdFull"`
+	ParcelTrackingCmdList              string `json:"parcelTrackingCmdList"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureParcelTracking,
+		config:  func(b *bot) interface{} { return &b.config.parcelTracking },
+		enabled: func(b *bot) bool { return b.config.parcelTracking.EnableParcelTracking },
+		init:    (*bot).initParcelTrackingDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.parcelTracking.ParcelTrackingCmd,
+				feature: featureParcelTracking,
+				subCommands: []*botCommand{
+					{name: b.config.parcelTracking.ParcelTrackingCmdAdd, usage: "<id> <alias>", minArgs: 2, maxArgs: 2, fn: (*bot).parcelTrackingAdd},
+					{name: b.config.parcelTracking.ParcelTrackingCmdRemove, usage: "<alias>", minArgs: 1, maxArgs: 1, role: roleTrusted, fn: (*bot).parcelTrackingRemove},
+					{name: b.config.parcelTracking.ParcelTrackingCmdInfo, usage: "<id|alias> [alias]", minArgs: 1, maxArgs: 2, fn: (*bot).parcelTrackingInfo},
+					{name: b.config.parcelTracking.ParcelTrackingCmdFull, usage: "<id|alias> [alias]", minArgs: 1, maxArgs: 2, fn: (*bot).parcelTrackingFull},
+					{name: b.config.parcelTracking.ParcelTrackingCmdList, fn: (*bot).parcelTrackingList},
+				},
+			}}
+		},
+	})
+}
+
 // initParcelTrackingDefaults sets default values for all settings.
 func (b *bot) initParcelTrackingDefaults() {
-	if b.IRC.ParcelTrackingLocale == "" {
-		b.IRC.ParcelTrackingLocale = "sv"
-	}
-	if b.IRC.ParcelTrackingMsgInfo == "" {
-		b.IRC.ParcelTrackingMsgInfo = "<consignor_name>, <event_date> <event_time>, <location_display_name>, <event_description> estimated time of arrival: <estimated_time_of_arrival_date> <estimated_time_of_arrival_time>"
+	if b.config.parcelTracking.ParcelTrackingLocale == "" {
+		b.config.parcelTracking.ParcelTrackingLocale = "sv"
 	}
-	if b.IRC.ParcelTrackingMsgAliasRemoved == "" {
-		b.IRC.ParcelTrackingMsgAliasRemoved = "<alias> removed"
+	if b.config.parcelTracking.ParcelTrackingMsgInfo == "" {
+		b.config.parcelTracking.ParcelTrackingMsgInfo = "<consignor_name>, <event_date> <event_time>, <location_display_name>, <event_description> estimated time of arrival: <estimated_time_of_arrival_date> <estimated_time_of_arrival_time>"
 	}
-	if b.IRC.ParcelTrackingMsgAliasDoesNotExist == "" {
-		b.IRC.ParcelTrackingMsgAliasDoesNotExist = "<alias> does not exist"
+	if b.config.parcelTracking.ParcelTrackingMsgAliasRemoved == "" {
+		b.config.parcelTracking.ParcelTrackingMsgAliasRemoved = "<alias> removed"
 	}
-	if b.IRC.ParcelTrackingErrNoData == "" {
-		b.IRC.ParcelTrackingErrNoData = "no tracking data found"
+	if b.config.parcelTracking.ParcelTrackingMsgAliasDoesNotExist == "" {
+		b.config.parcelTracking.ParcelTrackingMsgAliasDoesNotExist = "<alias> does not exist"
 	}
-	if b.IRC.ParcelTrackingErrDuplicateAlias == "" {
-		b.IRC.ParcelTrackingErrDuplicateAlias = "<alias> is already in use for parcel <existing_id>"
+	if b.config.parcelTracking.ParcelTrackingErrNoData == "" {
+		b.config.parcelTracking.ParcelTrackingErrNoData = "no tracking data found"
 	}
-	if b.IRC.ParcelTrackingCmd == "" {
-		b.IRC.ParcelTrackingCmd = "!pt"
+	if b.config.parcelTracking.ParcelTrackingErrDuplicateAlias == "" {
+		b.config.parcelTracking.ParcelTrackingErrDuplicateAlias = "<alias> is already in use for parcel <existing_id>"
 	}
-	if b.IRC.ParcelTrackingCmdAdd == "" {
-		b.IRC.ParcelTrackingCmdAdd = "add"
+	if b.config.parcelTracking.ParcelTrackingCmd == "" {
+		b.config.parcelTracking.ParcelTrackingCmd = "pt"
 	}
-	if b.IRC.ParcelTrackingCmdRemove == "" {
-		b.IRC.ParcelTrackingCmdRemove = "remove"
-	}
-	if b.IRC.ParcelTrackingCmdInfo == "" {
-		b.IRC.ParcelTrackingCmdInfo = "info"
-	}
-	if b.IRC.ParcelTrackingCmdFull == "" {
-		b.IRC.ParcelTrackingCmdFull = "full"
-	}
-	if b.IRC.ParcelTrackingCmdList == "" {
-		b.IRC.ParcelTrackingCmdList = "list"
-	}
-}
-
-// parcelTrackingCommandHandler handles the commands issued from the IRC channel.
-func (b *bot) parcelTrackingCommandHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	// Not our channel, return.
-	if !a.validChannel {
-		return
+	if b.config.parcelTracking.ParcelTrackingCmdAdd == "" {
+		b.config.parcelTracking.ParcelTrackingCmdAdd = "add"
 	}
-
-	// Not a parcel tracking command, return.
-	if a.cmd != b.IRC.ParcelTrackingCmd {
-		return
+	if b.config.parcelTracking.ParcelTrackingCmdRemove == "" {
+		b.config.parcelTracking.ParcelTrackingCmdRemove = "remove"
 	}
-
-	// Use should be ignored, return.
-	if b.shouldIgnore(m) {
-		return
+	if b.config.parcelTracking.ParcelTrackingCmdInfo == "" {
+		b.config.parcelTracking.ParcelTrackingCmdInfo = "info"
 	}
-
-	// Not enough args, return.
-	if len(a.args) < 1 {
-		return
+	if b.config.parcelTracking.ParcelTrackingCmdFull == "" {
+		b.config.parcelTracking.ParcelTrackingCmdFull = "full"
 	}
-
-	// Determine which sub command to execute.
-	subCmd := a.args[0]
-	if subCmd == b.IRC.ParcelTrackingCmdAdd {
-		b.parcelTrackingAdd(a)
-	} else if subCmd == b.IRC.ParcelTrackingCmdRemove {
-		b.parcelTrackingRemove(a)
-	} else if subCmd == b.IRC.ParcelTrackingCmdInfo {
-		b.parcelTrackingInfo(a)
-	} else if subCmd == b.IRC.ParcelTrackingCmdFull {
-		b.parcelTrackingFull(a)
-	} else if subCmd == b.IRC.ParcelTrackingCmdList {
-		b.parcelTrackingList(a)
+	if b.config.parcelTracking.ParcelTrackingCmdList == "" {
+		b.config.parcelTracking.ParcelTrackingCmdList = "list"
 	}
 }
 
 // parcelTrackingAdd adds the given id and alias to the database and returns
 // the latest event data to the channel.
 func (b *bot) parcelTrackingAdd(a *privmsgAction) {
-	// !pt add <id> <alias>
-	if len(a.args) != 3 {
-		return
-	}
-
 	// Store the arguments in convenient variable names.
-	id := a.args[1]
-	alias := a.args[2]
+	id := a.args[0]
+	alias := a.args[1]
 
 	// Make sure that the alias isn't used already.
 	if existingID := b.parcelTrackingAliasExists(alias); existingID != "" {
-		b.privmsgph(b.IRC.ParcelTrackingErrDuplicateAlias, map[string]string{
+		a.replyUrgentph(b.config.parcelTracking.ParcelTrackingErrDuplicateAlias, map[string]string{
 			"<alias>":       alias,
 			"<existing_id>": existingID,
 		})
@@ -112,30 +109,25 @@ func (b *bot) parcelTrackingAdd(a *privmsgAction) {
 	// Make sure that the requested id exists.
 	events := b.fetchPostNordInfo(id)
 	if events == nil {
-		b.privmsg(b.IRC.ParcelTrackingErrNoData)
+		a.replyUrgent(b.config.parcelTracking.ParcelTrackingErrNoData)
 		return
 	}
 
 	// Store the id and alias.
-	b.insertParcelTracking(alias, id, a.nick)
+	b.insertParcelTracking(a, alias, id)
 
 	// Print the latest info
-	b.sendParcelTrackingInfo(&events[len(events)-1])
+	b.sendParcelTrackingInfo(a, &events[len(events)-1])
 }
 
 // parcelTrackingRemove removes the alias from the database.
 func (b *bot) parcelTrackingRemove(a *privmsgAction) {
-	// !pt remove <alias>
-	if len(a.args) != 2 {
-		return
-	}
-
 	// Store the arguments in convenient variable names.
-	alias := a.args[1]
+	alias := a.args[0]
 
 	// Make sure that the ID exists before we try to remove it.
 	if existingID := b.parcelTrackingAliasExists(alias); existingID == "" {
-		b.privmsgph(b.IRC.ParcelTrackingMsgAliasDoesNotExist, map[string]string{
+		a.replyph(b.config.parcelTracking.ParcelTrackingMsgAliasDoesNotExist, map[string]string{
 			"<alias>": alias,
 		})
 		return
@@ -143,23 +135,14 @@ func (b *bot) parcelTrackingRemove(a *privmsgAction) {
 	}
 
 	// Delete it.
-	stmt, err := b.prepare("UPDATE parcel_tracking SET is_deleted = true WHERE alias = $1")
-	if err != nil {
-		b.logger.Printf("parcelTracking: %v", err)
-		b.privmsg(b.DB.Err)
-		return
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(alias)
-	if err != nil {
-		b.logger.Printf("parcelTracking: %v", err)
-		b.privmsg(b.DB.Err)
+	if err := b.store.parcelTracking.deleteAlias(alias); err != nil {
+		b.log(featureParcelTracking).Errorf("parcelTracking: %v", err)
+		b.replyDBError(a)
 		return
 	}
 
 	// Print it to the channel.
-	b.privmsgph(b.IRC.ParcelTrackingMsgAliasRemoved, map[string]string{
+	a.replyph(b.config.parcelTracking.ParcelTrackingMsgAliasRemoved, map[string]string{
 		"<alias>": alias,
 	})
 }
@@ -171,7 +154,7 @@ func (b *bot) parcelTrackingInfo(a *privmsgAction) {
 		return
 	}
 
-	b.sendParcelTrackingInfo(&events[len(events)-1])
+	b.sendParcelTrackingInfo(a, &events[len(events)-1])
 }
 
 // parcelTrackingFull fetches a full details info for the given parcel id.
@@ -198,7 +181,7 @@ func (b *bot) parcelTrackingFull(a *privmsgAction) {
 			"<estimation_or_drop_off_time>":    e.estimationOrDropOffTime,
 		}
 
-		msg := b.IRC.ParcelTrackingMsgInfo
+		msg := b.config.parcelTracking.ParcelTrackingMsgInfo
 		for k, v := range data {
 			msg = strings.ReplaceAll(msg, k, v)
 		}
@@ -210,33 +193,25 @@ func (b *bot) parcelTrackingFull(a *privmsgAction) {
 	fullMsg = fullMsg[0 : len(fullMsg)-1]
 
 	// Upload to pastebin.
-	b.newPaste(a.args[0], fullMsg)
+	b.newPaste(a, a.args[0], fullMsg)
 }
 
 // parcelTrackingList lists all stored aliases
 func (b *bot) parcelTrackingList(a *privmsgAction) {
 	// Select all non deleted parcel trackings.
-	rows, err := b.query(`
-		SELECT alias, parcel_tracking_id, nick
-		FROM parcel_tracking
-		WHERE is_deleted = false
-		ORDER BY inserted_at DESC
-	`)
+	parcels, err := b.store.parcelTracking.list()
 	if err != nil {
-		b.logger.Printf("parcelTracking: %v", err)
+		b.log(featureParcelTracking).Errorf("parcelTracking: %v", err)
 		return
 	}
-	defer rows.Close()
 
 	// Concat all aliases
 	var content string
-	for rows.Next() {
-		var alias, id, nick string
-		rows.Scan(&alias, &id, &nick)
-		if nick == "" {
-			content = fmt.Sprintf("%s%s -> %s\n", content, alias, id)
+	for _, p := range parcels {
+		if p.Nick == "" {
+			content = fmt.Sprintf("%s%s -> %s\n", content, p.Alias, p.ParcelTrackingID)
 		} else {
-			content = fmt.Sprintf("%s%s: %s -> %s\n", content, nick, alias, id)
+			content = fmt.Sprintf("%s%s: %s -> %s\n", content, p.Nick, p.Alias, p.ParcelTrackingID)
 		}
 	}
 
@@ -249,18 +224,13 @@ func (b *bot) parcelTrackingList(a *privmsgAction) {
 	content = content[0 : len(content)-1]
 
 	// Upload to pastebin.
-	b.newPaste("", content)
+	b.newPaste(a, "", content)
 }
 
 // parcelTrackingInfo fetches tracking info for the given id.
 func (b *bot) fetchParcelTrackingInfo(a *privmsgAction) []postNordEvent {
-	// !pt info <id|alias> [alias]
-	if len(a.args) < 2 {
-		return nil
-	}
-
 	// Store the arguments in convenient variable names.
-	id := a.args[1]
+	id := a.args[0]
 
 	// Check if the given ID is actually an alias that we have stored in
 	// the database.
@@ -272,7 +242,7 @@ func (b *bot) fetchParcelTrackingInfo(a *privmsgAction) []postNordEvent {
 	// Make sure that the requested id exists.
 	events := b.fetchPostNordInfo(id)
 	if events == nil {
-		b.privmsg(b.IRC.ParcelTrackingErrNoData)
+		a.replyUrgent(b.config.parcelTracking.ParcelTrackingErrNoData)
 		return nil
 	}
 
@@ -280,12 +250,12 @@ func (b *bot) fetchParcelTrackingInfo(a *privmsgAction) []postNordEvent {
 	// parcel with an alias, as long as the alias isn't actually an alias
 	// already.
 	alias := ""
-	if len(a.args) >= 3 {
-		alias = a.args[2]
+	if len(a.args) >= 2 {
+		alias = a.args[1]
 	}
 	if alias != "" && existingID == "" && b.parcelTrackingAliasExists(alias) == "" {
 		// Store the id and alias.
-		b.insertParcelTracking(alias, id, a.nick)
+		b.insertParcelTracking(a, alias, id)
 	}
 
 	return events
@@ -295,14 +265,17 @@ func (b *bot) fetchParcelTrackingInfo(a *privmsgAction) []postNordEvent {
 // we'll return the parcel tracking ID, otherwise we'll return an empty
 // string.
 func (b *bot) parcelTrackingAliasExists(alias string) string {
-	var existingID string
-	b.queryRow("SELECT parcel_tracking_id FROM parcel_tracking WHERE alias = $1 AND is_deleted = false", alias).Scan(&existingID)
+	existingID, err := b.store.parcelTracking.parcelID(alias)
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.log(featureParcelTracking).Errorf("parcelTracking: %v", err)
+	}
 	return existingID
 }
 
-// sendParcelTrackingInfo sends the given event to the channel.
-func (b *bot) sendParcelTrackingInfo(e *postNordEvent) {
-	b.privmsgph(b.IRC.ParcelTrackingMsgInfo, map[string]string{
+// sendParcelTrackingInfo replies to the action with the given event.
+func (b *bot) sendParcelTrackingInfo(a *privmsgAction, e *postNordEvent) {
+	a.replyph(b.config.parcelTracking.ParcelTrackingMsgInfo, map[string]string{
 		"<consignor_name>":                 e.consignorName,
 		"<event_date>":                     e.eventDate,
 		"<event_time>":                     e.eventTime,
@@ -318,33 +291,17 @@ func (b *bot) sendParcelTrackingInfo(e *postNordEvent) {
 }
 
 // insertParcelTracking adds an entry to the parcel_tracking table.
-func (b *bot) insertParcelTracking(alias, id, nick string) {
-	stmt, err := b.prepare(`INSERT INTO parcel_tracking (
-		id,
-		alias,
-		parcel_tracking_id,
-		nick,
-		inserted_at,
-		is_deleted
-	) VALUES (
-		$1,
-		$2,
-		$3,
-		$4,
-		$5,
-		$6
-	);`)
-	if err != nil {
-		b.logger.Printf("parcelTracking: %v", err)
-		b.privmsg(b.DB.Err)
-		return
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(newUUID(), alias, id, nick, newTimestamp(), false)
+func (b *bot) insertParcelTracking(a *privmsgAction, alias, id string) {
+	err := b.store.parcelTracking.insert(parcelTrackingEntry{
+		ID:               newUUID(),
+		Alias:            alias,
+		ParcelTrackingID: id,
+		Nick:             a.nick,
+		InsertedAt:       time.Now(),
+	})
 	if err != nil {
-		b.logger.Printf("parcelTracking: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureParcelTracking).Errorf("parcelTracking: %v", err)
+		b.replyDBError(a)
 		return
 	}
 }
@@ -352,8 +309,10 @@ func (b *bot) insertParcelTracking(alias, id, nick string) {
 // fetchPostNordInfo fetches info from the PostNord API for the given id.
 func (b *bot) fetchPostNordInfo(id string) []postNordEvent {
 	// Fetch the PostNord info.
-	pn := postnord.New(b.IRC.ParcelTrackingPostNordAPIKey, b.IRC.ParcelTrackingLocale)
+	pn := postnord.New(b.config.parcelTracking.ParcelTrackingPostNordAPIKey, b.config.parcelTracking.ParcelTrackingLocale)
+	start := time.Now()
 	tir, err := pn.FindByIdentifierV5(id)
+	b.metrics.observeAPI("postnord", start, err)
 	if err != nil {
 		return nil
 	}
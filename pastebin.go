@@ -7,37 +7,37 @@ import (
 	"github.com/osm/pastebin"
 )
 
-// newPaste uploads the given title and content and prints the url to the
-// channel.
-func (b *bot) newPaste(title, content string) {
+// newPaste uploads the given title and content and replies to the action
+// with the url.
+func (b *bot) newPaste(a *privmsgAction, title, content string) {
 	var url string
 	var err error
 
 	if b.IRC.EnableDumpinen {
 		opts := []dumpinen.Option{
-			dumpinen.WithAddr("https://dumpinen.com"),
+			dumpinen.WithAddr(b.baseURL("dumpinen")),
 			dumpinen.WithContentType("text/plain; charset=utf-8"),
 		}
 
 		d := dumpinen.NewClient(opts...)
 		url, err = d.Dump(strings.NewReader(content))
 		if err != nil {
-			b.logger.Printf("dumpinen err: %v\n", err)
+			b.log("pastebin").Errorf("dumpinen err: %v", err)
 			return
 		}
 	} else {
 		if b.IRC.PastebinAPIKey == "" {
-			b.logger.Printf("pastebin: you need to set a pastebin api key\n")
+			b.log("pastebin").Warnf("pastebin: you need to set a pastebin api key")
 			return
 		}
 		pb := pastebin.New(b.IRC.PastebinAPIKey)
 
 		url, err = pb.NewPaste(content, title, pastebin.Unlisted, pastebin.TenMinutes)
 		if err != nil {
-			b.logger.Printf("pastebin: pastebin err: %v\n", err)
+			b.log("pastebin").Errorf("pastebin: pastebin err: %v", err)
 			return
 		}
 	}
 
-	b.privmsg(url)
+	a.reply(url)
 }
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"sort"
 	"strings"
 	"sync"
@@ -14,114 +13,206 @@ import (
 	"github.com/osm/irc"
 )
 
+// quizConfig holds the configuration of the quiz module.
+type quizConfig struct {
+	EnableQuiz       bool              `json:"enableQuiz"`
+	QuizSources      map[string]string `json:"quizSources"`
+	quizSourcesCache map[string][]QuizQuestion
+
+	QuizCmd         string `json:"quizCmd"`
+	QuizSubCmdStart string `json:"quizSubCmdStart"`
+	QuizSubCmdStop  string `json:"quizSubCmdStop"`
+	QuizSubCmdStats string `json:"quizSubCmdStats"`
+
+	QuizHintInterval time.Duration `json:"quizHintInterval"`
+
+	QuizMsgNameDoesNotExist string `json:"quizMsgNameDoesNotExist"`
+	QuizMsgLoadError        string `json:"quizMsgLoadError"`
+	QuizMsgAlreadyStarted   string `json:"quizMsgAlreadyStarted"`
+	QuizMsgQuestion         string `json:"quizMsgQuestion"`
+	QuizMsgHint             string `json:"quizMsgHint"`
+	QuizMsgAnswer           string `json:"quizMsgAnswer"`
+	QuizMsgCorrect          string `json:"quizMsgCorrect"`
+	QuizMsgQuizEnd          string `json:"quizMsgQuizEnd"`
+
+	// quizRounds holds the active quiz round of each channel.
+	quizRounds   map[string]*quizRound
+	quizRoundsMu sync.Mutex
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureQuiz,
+		config:  func(b *bot) interface{} { return &b.config.quiz },
+		enabled: func(b *bot) bool { return b.config.quiz.EnableQuiz },
+		init:    (*bot).initQuizDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).quizHandler},
+		},
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.quiz.QuizCmd,
+				feature: featureQuiz,
+				subCommands: []*botCommand{
+					{name: b.config.quiz.QuizSubCmdStart, usage: "<quiz>", minArgs: 1, maxArgs: 1, fn: (*bot).quizStartCommand},
+					{name: b.config.quiz.QuizSubCmdStop, role: roleTrusted, fn: (*bot).quizStopCommand},
+				},
+			}}
+		},
+	})
+}
+
 // initQuizDefaults sets default values for the quiz commands and messages.
 func (b *bot) initQuizDefaults() {
 	// Commands.
-	if b.IRC.QuizCmd == "" {
-		b.IRC.QuizCmd = "!quiz"
+	if b.config.quiz.QuizCmd == "" {
+		b.config.quiz.QuizCmd = "quiz"
 	}
-	if b.IRC.QuizSubCmdStart == "" {
-		b.IRC.QuizCmd = "start"
+	if b.config.quiz.QuizSubCmdStart == "" {
+		b.config.quiz.QuizSubCmdStart = "start"
 	}
-	if b.IRC.QuizSubCmdStop == "" {
-		b.IRC.QuizCmd = "stop"
+	if b.config.quiz.QuizSubCmdStop == "" {
+		b.config.quiz.QuizSubCmdStop = "stop"
 	}
-	if b.IRC.QuizSubCmdStats == "" {
-		b.IRC.QuizCmd = "stats"
+	if b.config.quiz.QuizSubCmdStats == "" {
+		b.config.quiz.QuizSubCmdStats = "stats"
 	}
 
 	// Hint interval.
-	if b.IRC.QuizHintInterval == 0 {
-		b.IRC.QuizHintInterval = 15
+	if b.config.quiz.QuizHintInterval == 0 {
+		b.config.quiz.QuizHintInterval = 15
 	}
 
 	// Messages.
-	if b.IRC.QuizMsgNameDoesNotExist == "" {
-		b.IRC.QuizMsgNameDoesNotExist = "<name> does not exist"
+	if b.config.quiz.QuizMsgNameDoesNotExist == "" {
+		b.config.quiz.QuizMsgNameDoesNotExist = "<name> does not exist"
 	}
-	if b.IRC.QuizMsgLoadError == "" {
-		b.IRC.QuizMsgLoadError = "unable to load <name>"
+	if b.config.quiz.QuizMsgLoadError == "" {
+		b.config.quiz.QuizMsgLoadError = "unable to load <name>"
 	}
-	if b.IRC.QuizMsgAlreadyStarted == "" {
-		b.IRC.QuizMsgAlreadyStarted = "a quiz is already started"
+	if b.config.quiz.QuizMsgAlreadyStarted == "" {
+		b.config.quiz.QuizMsgAlreadyStarted = "a quiz is already started"
 	}
-	if b.IRC.QuizMsgQuestion == "" {
-		b.IRC.QuizMsgQuestion = "<category>: <question>"
+	if b.config.quiz.QuizMsgQuestion == "" {
+		b.config.quiz.QuizMsgQuestion = "<category>: <question>"
 	}
-	if b.IRC.QuizMsgHint == "" {
-		b.IRC.QuizMsgHint = "hint: <text>"
+	if b.config.quiz.QuizMsgHint == "" {
+		b.config.quiz.QuizMsgHint = "hint: <text>"
 	}
-	if b.IRC.QuizMsgAnswer == "" {
-		b.IRC.QuizMsgHint = "answer: <text>"
+	if b.config.quiz.QuizMsgAnswer == "" {
+		b.config.quiz.QuizMsgAnswer = "answer: <text>"
 	}
-	if b.IRC.QuizMsgCorrect == "" {
-		b.IRC.QuizMsgCorrect = "correct! one point to <nick>"
+	if b.config.quiz.QuizMsgCorrect == "" {
+		b.config.quiz.QuizMsgCorrect = "correct! one point to <nick>"
 	}
-	if b.IRC.QuizMsgQuizEnd == "" {
-		b.IRC.QuizMsgQuizEnd = "the quiz is over"
+	if b.config.quiz.QuizMsgQuizEnd == "" {
+		b.config.quiz.QuizMsgQuizEnd = "the quiz is over"
 	}
 
-	// Initialize the quiz sources cache.
-	b.IRC.quizSourcesCache = make(map[string][]QuizQuestion)
+	// Initialize the quiz sources cache and the map of active rounds.
+	b.config.quiz.quizSourcesCache = make(map[string][]QuizQuestion)
+	b.config.quiz.quizRounds = make(map[string]*quizRound)
 }
 
-// quizHandler handles all IRC related communication with the quiz bot.
-func (b *bot) quizHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+// quizRoundKey returns the key of the channel on the network in the map of
+// active quiz rounds.
+func quizRoundKey(n *network, channel string) string {
+	return n.Name + " " + strings.ToLower(channel)
+}
 
-	if !a.validChannel {
-		return
+// quizRound returns the active quiz round of the given channel on the
+// network, nil is returned if there's no active round.
+func (b *bot) quizRound(n *network, channel string) *quizRound {
+	b.config.quiz.quizRoundsMu.Lock()
+	defer b.config.quiz.quizRoundsMu.Unlock()
+	return b.config.quiz.quizRounds[quizRoundKey(n, channel)]
+}
+
+// setQuizRound sets the active quiz round of the given channel on the
+// network, the round is removed if qr is nil.
+func (b *bot) setQuizRound(n *network, channel string, qr *quizRound) {
+	b.config.quiz.quizRoundsMu.Lock()
+	defer b.config.quiz.quizRoundsMu.Unlock()
+
+	if qr == nil {
+		delete(b.config.quiz.quizRounds, quizRoundKey(n, channel))
+	} else {
+		b.config.quiz.quizRounds[quizRoundKey(n, channel)] = qr
 	}
+}
 
-	if b.shouldIgnore(m) {
+// quizHandler checks whether the messages are correct answers to the
+// question of the active quiz round.
+func (b *bot) quizHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
+
+	if !n.hasFeature(a.target, featureQuiz) {
 		return
 	}
 
-	// Handle the quiz IRC commands.
-	if len(a.args) == 2 &&
-		a.cmd == b.IRC.QuizCmd &&
-		a.args[0] == b.IRC.QuizSubCmdStart {
-		b.quizStart(a.args[1])
+	if b.shouldIgnore(n, m) {
 		return
-	} else if len(a.args) == 1 &&
-		a.cmd == b.IRC.QuizCmd &&
-		a.args[0] == b.IRC.QuizSubCmdStop &&
-		b.IRC.quizRound != nil {
-		b.IRC.quizRound.stop()
+	}
+
+	// The quiz commands are handled by the command dispatcher.
+	if b.isCommand(a, b.config.quiz.QuizCmd) {
 		return
 	}
 
 	// No active quiz round, return immediately.
-	if b.IRC.quizRound == nil {
+	qr := b.quizRound(n, a.target)
+	if qr == nil {
 		return
 	}
 
 	// Check if the given message was the correct answer for the question.
-	b.IRC.quizRound.answer(a.nick, a.msg)
+	qr.answer(a.nick, a.msg)
 }
 
-// quizStart starts a quiz with the given name.
-func (b *bot) quizStart(name string) {
+// quizStartCommand starts a new round of the given quiz.
+func (b *bot) quizStartCommand(a *privmsgAction) {
+	b.quizStart(a, a.args[0])
+}
+
+// quizStopCommand stops the active quiz round.
+func (b *bot) quizStopCommand(a *privmsgAction) {
+	if qr := b.quizRound(a.network, a.target); qr != nil {
+		qr.mu.Lock()
+		defer qr.mu.Unlock()
+
+		// The round might have ended while we waited for the lock.
+		if b.quizRound(a.network, a.target) == qr {
+			qr.stop()
+		}
+	}
+}
+
+// quizStart starts a quiz with the given name in the channel of the action.
+func (b *bot) quizStart(a *privmsgAction, name string) {
 	// Don't allow a new quiz to be started if there are one running
 	// already.
-	if b.IRC.quizRound != nil {
-		b.privmsg(b.IRC.QuizMsgAlreadyStarted)
+	if b.quizRound(a.network, a.target) != nil {
+		a.reply(b.config.quiz.QuizMsgAlreadyStarted)
 		return
 	}
 
 	// Make sure that the name of the quiz exists in our database.
-	_, exists := b.IRC.QuizSources[name]
+	_, exists := b.config.quiz.QuizSources[name]
 	if !exists {
-		b.privmsgph(b.IRC.QuizMsgNameDoesNotExist, map[string]string{
+		a.replyph(b.config.quiz.QuizMsgNameDoesNotExist, map[string]string{
 			"<name>": name,
 		})
 		return
 	}
 
 	// Initialize a new quiz round and pop the first question.
-	b.IRC.quizRound = newQuizRound(b, name, 10)
-	if b.IRC.quizRound != nil {
-		b.IRC.quizRound.getQuestion()
+	qr := newQuizRound(b, a.network, a.target, name, 10)
+	if qr != nil {
+		qr.mu.Lock()
+		b.setQuizRound(a.network, a.target, qr)
+		qr.getQuestion()
+		qr.mu.Unlock()
 	}
 }
 
@@ -145,6 +236,12 @@ type quizRound struct {
 	// configuration file.
 	name string
 
+	// network is the network that the quiz round is running on.
+	network *network
+
+	// channel is the channel that the quiz round is running in.
+	channel string
+
 	// ch is a channel that is used to stop the hint goroutines early if
 	// the correct answer has been given by a user.
 	ch chan bool
@@ -184,8 +281,8 @@ func quizLoadFromFile(filePath string) ([]QuizQuestion, error) {
 }
 
 // quizLoadFromHttp loads quiz questions from the given url.
-func quizLoadFromHttp(url string) ([]QuizQuestion, error) {
-	res, err := http.Get(url)
+func quizLoadFromHttp(b *bot, url string) ([]QuizQuestion, error) {
+	res, err := b.httpGet(url)
 	if err != nil {
 		return nil, fmt.Errorf("quizLoadFromHttp: cant open url %s, %v", url, err)
 	}
@@ -226,34 +323,34 @@ func quizLoadFromSql(b *bot, query string) ([]QuizQuestion, error) {
 }
 
 // newQuizRound returns a new quizRound data structure.
-func newQuizRound(bot *bot, name string, nQuestions int) *quizRound {
+func newQuizRound(bot *bot, n *network, channel, name string, nQuestions int) *quizRound {
 	var allQuestions []QuizQuestion
 	var err error
-	path := bot.IRC.QuizSources[name]
+	path := bot.config.quiz.QuizSources[name]
 
 	// Load questions.
 	if strings.HasPrefix(path, "http") {
 		// Always refreh quiz from http.
-		allQuestions, err = quizLoadFromHttp(path)
+		allQuestions, err = quizLoadFromHttp(bot, path)
 	} else if strings.HasPrefix(path, "SELECT ") {
 		// Load questions from the database.
 		allQuestions, err = quizLoadFromSql(bot, path)
-	} else if _, ok := bot.IRC.quizSourcesCache[name]; ok {
+	} else if _, ok := bot.config.quiz.quizSourcesCache[name]; ok {
 		// The quiz exists in the cache, so just fetch it from there.
-		allQuestions = bot.IRC.quizSourcesCache[name]
+		allQuestions = bot.config.quiz.quizSourcesCache[name]
 	} else {
 		// Fetch the quiz data from a local file.
 		allQuestions, err = quizLoadFromFile(path)
 		if err == nil {
-			bot.IRC.quizSourcesCache[name] = allQuestions
+			bot.config.quiz.quizSourcesCache[name] = allQuestions
 		}
 	}
 
 	// Something went wrong when the quiz was loaded, log the error and
 	// print a message to the channel.
 	if err != nil {
-		bot.logger.Printf("%v", err)
-		bot.privmsgph(bot.IRC.QuizMsgLoadError, map[string]string{
+		bot.log(featureQuiz).Errorf("%v", err)
+		n.privmsgph(channel, bot.config.quiz.QuizMsgLoadError, map[string]string{
 			"<name>": name,
 		})
 		return nil
@@ -275,8 +372,10 @@ func newQuizRound(bot *bot, name string, nQuestions int) *quizRound {
 
 	// Return a new quiz round with the randomly picked questions.
 	return &quizRound{
-		id:   newUUID(),
-		name: name,
+		id:      newUUID(),
+		name:    name,
+		network: n,
+		channel: channel,
 
 		bot:   bot,
 		ch:    make(chan bool),
@@ -296,7 +395,7 @@ func (qr *quizRound) answer(n, a string) {
 
 	// The quiz round might have been completed by another goroutine, so
 	// let's check that before we proceed.
-	if qr.bot.IRC.quizRound == nil {
+	if qr.bot.quizRound(qr.network, qr.channel) != qr {
 		return
 	}
 
@@ -312,7 +411,7 @@ func (qr *quizRound) answer(n, a string) {
 	qr.ch = make(chan bool)
 
 	// Print the correct message to the channel.
-	qr.bot.privmsgph(qr.bot.IRC.QuizMsgCorrect, map[string]string{
+	qr.network.privmsgph(qr.channel, qr.bot.config.quiz.QuizMsgCorrect, map[string]string{
 		"<nick>": n,
 		"<text>": a,
 	})
@@ -324,23 +423,21 @@ func (qr *quizRound) answer(n, a string) {
 	qr.stats[n]++
 
 	// Also, add stats to the database.
-	stmt, err := qr.bot.prepare("INSERT INTO quiz_stat (id, nick, quiz_round_id, quiz_name, category, question, answer, inserted_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")
-	if err != nil {
-		qr.bot.logger.Printf("quizAnswer: %v", err)
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(
-		newUUID(),
-		n,
-		qr.id,
-		qr.name,
-		qr.question.Category,
-		qr.question.Question,
-		qr.question.Answer,
-		newTimestamp(),
-	)
+	err := qr.bot.store.quizStat.insert(quizStat{
+		ID:          newUUID(),
+		Network:     qr.network.Name,
+		Channel:     qr.channel,
+		Nick:        n,
+		QuizRoundID: qr.id,
+		QuizName:    qr.name,
+		Category:    qr.question.Category,
+		Question:    qr.question.Question,
+		Answer:      qr.question.Answer,
+		InsertedAt:  time.Now(),
+	})
 	if err != nil {
-		qr.bot.logger.Printf("quizAnswer: %v", err)
+		qr.bot.metrics.dbErrors.inc()
+		qr.bot.log(featureQuiz).Errorf("quizAnswer: %v", err)
 	}
 
 	// Pop a new qusetion.
@@ -349,18 +446,36 @@ func (qr *quizRound) answer(n, a string) {
 
 // hint sleeps for t number of seconds before it writes the given hint back to
 // the channel. If newQuestion is set to true a new question will be popped
-// when the timeout occurs.
-func (qr *quizRound) hint(t time.Duration, ph, text string, newQuestion bool) {
+// when the timeout occurs. The hint is cancelled when done is closed, which
+// is the channel of the question that it was started for, or if the bot
+// shuts down.
+func (qr *quizRound) hint(done chan bool, t time.Duration, ph, text string, newQuestion bool) {
 	select {
 	case <-time.After(t * time.Second):
-		qr.bot.privmsgph(ph, map[string]string{
+		// The next question reads the settings of the quiz, which
+		// might be replaced by a reload.
+		qr.bot.configMu.RLock()
+		defer qr.bot.configMu.RUnlock()
+
+		// Hold the same lock as answer, and make sure that the
+		// question hasn't been answered while we were waiting for it.
+		qr.mu.Lock()
+		defer qr.mu.Unlock()
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		qr.network.privmsgph(qr.channel, ph, map[string]string{
 			"<text>": text,
 		})
 
 		if newQuestion {
 			qr.getQuestion()
 		}
-	case <-qr.ch:
+	case <-done:
+	case <-qr.bot.ctx.Done():
 	}
 }
 
@@ -372,7 +487,7 @@ func (qr *quizRound) getQuestion() {
 		qr.question, qr.questions = qr.questions[0], qr.questions[1:]
 
 		// Write the question to the channel.
-		qr.bot.privmsgph(qr.bot.IRC.QuizMsgQuestion, map[string]string{
+		qr.network.privmsgph(qr.channel, qr.bot.config.quiz.QuizMsgQuestion, map[string]string{
 			"<category>": qr.question.Category,
 			"<question>": qr.question.Question,
 		})
@@ -380,34 +495,37 @@ func (qr *quizRound) getQuestion() {
 		// Start the hint and correct answer goroutines for the
 		// question.
 		go qr.hint(
-			qr.bot.IRC.QuizHintInterval,
-			qr.bot.IRC.QuizMsgHint,
+			qr.ch,
+			qr.bot.config.quiz.QuizHintInterval,
+			qr.bot.config.quiz.QuizMsgHint,
 			maskText(qr.question.Answer),
 			false,
 		)
 		go qr.hint(
-			qr.bot.IRC.QuizHintInterval*2,
-			qr.bot.IRC.QuizMsgHint,
+			qr.ch,
+			qr.bot.config.quiz.QuizHintInterval*2,
+			qr.bot.config.quiz.QuizMsgHint,
 			hintText(qr.question.Answer),
 			false,
 		)
 		go qr.hint(
-			qr.bot.IRC.QuizHintInterval*3,
-			qr.bot.IRC.QuizMsgAnswer,
+			qr.ch,
+			qr.bot.config.quiz.QuizHintInterval*3,
+			qr.bot.config.quiz.QuizMsgAnswer,
 			qr.question.Answer,
 			true,
 		)
 
 	} else {
 		// No more questions left, stop the quiz.
-		qr.bot.IRC.quizRound.stop()
+		qr.stop()
 	}
 }
 
 // stops the current quiz round early.
 func (qr *quizRound) stop() {
 	// Quiz is over, present the results.
-	qr.bot.privmsg(qr.bot.IRC.QuizMsgQuizEnd)
+	qr.network.privmsg(qr.channel, qr.bot.config.quiz.QuizMsgQuizEnd)
 
 	// Construct a map of the stats but where the key is the
 	// number of points instead of the nick.
@@ -429,11 +547,17 @@ func (qr *quizRound) stop() {
 
 	// Output the results to the channel.
 	for _, k := range sortedKeys {
-		qr.bot.privmsg(fmt.Sprintf("%d: %s", k, count[k]))
+		qr.network.privmsg(qr.channel, fmt.Sprintf("%d: %s", k, count[k]))
 	}
 
 	close(qr.ch)
-	qr.bot.IRC.quizRound = nil
+	qr.bot.setQuizRound(qr.network, qr.channel, nil)
+
+	qr.bot.emitEvent(qr.network, qr.channel, "", outgoingEventQuizFinished, map[string]interface{}{
+		"id":     qr.id,
+		"name":   qr.name,
+		"scores": qr.stats,
+	})
 }
 
 // maskText replaces all characters of the string with an asterisk unless it's
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQuiz(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"enableQuiz":       true,
+		"quizSources":      map[string]string{"geography": "testdata/quiz.json"},
+		"quizHintInterval": 1,
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!quiz start history")
+	s.expect("PRIVMSG #bot :history does not exist")
+
+	s.privmsg("alice", "#bot", "!quiz start geography")
+	s.expect("PRIVMSG #bot :Geography: What is the capital of Sweden?")
+
+	s.privmsg("alice", "#bot", "!quiz start geography")
+	s.expect("PRIVMSG #bot :a quiz is already started")
+
+	// The quiz is over when the only question has been answered.
+	s.privmsg("bob", "#bot", "Oslo")
+	s.privmsg("alice", "#bot", "stockholm")
+	s.expect("PRIVMSG #bot :correct! one point to alice")
+	s.expect("PRIVMSG #bot :the quiz is over")
+	s.expect("PRIVMSG #bot :1: alice")
+
+	if nick := tb.queryString("SELECT nick FROM quiz_stat WHERE quiz_name = 'geography'"); nick != "alice" {
+		t.Errorf("expected the point to be stored for alice, got %q", nick)
+	}
+
+	// The answer is given after three hints if no one knows it.
+	s.privmsg("alice", "#bot", "!quiz start geography")
+	s.expect("PRIVMSG #bot :Geography: What is the capital of Sweden?")
+	s.expect("PRIVMSG #bot :answer: Stockholm")
+	s.expect("PRIVMSG #bot :the quiz is over")
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartSettings contains the settings that can't be changed without a
+// restart of the bot, they are left untouched when the configuration is
+// reloaded.
+var restartSettings = map[string]bool{
+	"db.engine":               true,
+	"db.path":                 true,
+	"db.migrationTimezone":    true,
+	"http.enableHTTP":         true,
+	"http.address":            true,
+	"http.port":               true,
+	"irc.networks":            true,
+	"irc.network":             true,
+	"irc.address":             true,
+	"irc.nick":                true,
+	"irc.realName":            true,
+	"irc.user":                true,
+	"irc.version":             true,
+	"irc.channel":             true,
+	"irc.channels":            true,
+	"irc.postConnectMessages": true,
+	"irc.postConnectModes":    true,
+	"irc.capabilities":        true,
+	"irc.sasl":                true,
+	"irc.tls":                 true,
+	"log.format":              true,
+	"log.file":                true,
+	"log.maxSize":             true,
+	"log.maxBackups":          true,
+	"log.debugIRC":            true,
+}
+
+// reloadConfig holds the configuration of the reload command.
+type reloadConfig struct {
+	ReloadCmd          string `json:"reloadCmd"`
+	ReloadMsgChanged   string `json:"reloadMsgChanged"`
+	ReloadMsgUnchanged string `json:"reloadMsgUnchanged"`
+	ReloadMsgRestart   string `json:"reloadMsgRestart"`
+	ReloadErr          string `json:"reloadErr"`
+}
+
+// initReloadDefaults sets default values for the reload command and
+// messages.
+func (b *bot) initReloadDefaults() {
+	if b.IRC.ReloadCmd == "" {
+		b.IRC.ReloadCmd = "reload"
+	}
+	if b.IRC.ReloadMsgChanged == "" {
+		b.IRC.ReloadMsgChanged = "reloaded, changed settings: <settings>"
+	}
+	if b.IRC.ReloadMsgUnchanged == "" {
+		b.IRC.ReloadMsgUnchanged = "reloaded, no settings changed"
+	}
+	if b.IRC.ReloadMsgRestart == "" {
+		b.IRC.ReloadMsgRestart = "these settings needs a restart: <settings>"
+	}
+	if b.IRC.ReloadErr == "" {
+		b.IRC.ReloadErr = "reload failed: <error>"
+	}
+}
+
+// reloadResult describes the outcome of a reload.
+type reloadResult struct {
+	// Changed contains the settings that were changed.
+	Changed []string `json:"changed"`
+
+	// Restart contains the settings that were changed in the file but
+	// that needs a restart to take effect.
+	Restart []string `json:"restart"`
+}
+
+// configSetting is a setting in the configuration file, path is the location
+// of the setting, e.g. irc.factoidCmd.
+type configSetting struct {
+	path  string
+	value reflect.Value
+}
+
+// configSettings returns all settings of the bot in a stable order.
+func (b *bot) configSettings() []configSetting {
+	s := appendSettings(nil, "db.", reflect.ValueOf(&b.DB).Elem())
+	s = appendSettings(s, "http.", reflect.ValueOf(&b.HTTP).Elem())
+	s = appendSettings(s, "httpClient.", reflect.ValueOf(&b.HTTPClient).Elem())
+	s = appendSettings(s, "irc.", reflect.ValueOf(&b.IRC).Elem())
+	s = appendSettings(s, "log.", reflect.ValueOf(&b.Log).Elem())
+	s = append(s, configSetting{"timezone", reflect.ValueOf(&b.Timezone).Elem()})
+
+	// The settings of the modules are given by the section of each
+	// module.
+	for _, m := range modules {
+		if m.config != nil {
+			s = appendSettings(s, "modules."+m.name+".", reflect.ValueOf(m.config(b)).Elem())
+		}
+	}
+
+	return s
+}
+
+// appendSettings appends the fields of the struct that are decoded from the
+// configuration file to settings.
+func appendSettings(settings []configSetting, prefix string, v reflect.Value) []configSetting {
+	for _, f := range jsonFields(v.Type()) {
+		settings = append(settings, configSetting{prefix + f.name, v.FieldByIndex(f.Index)})
+	}
+
+	return settings
+}
+
+// settingValues returns the JSON encoding of each setting, it's used to tell
+// which settings that have changed.
+func settingValues(settings []configSetting) map[string][]byte {
+	values := make(map[string][]byte)
+	for _, s := range settings {
+		values[s.path], _ = json.Marshal(s.value.Interface())
+	}
+
+	return values
+}
+
+// reload reads the configuration file again and replaces the current
+// settings with the new ones. The new configuration is checked before
+// anything is replaced, so the old settings are kept if there's an error.
+// All settings are replaced at once while the event handlers are paused,
+// settings that needs a restart are reported but not replaced.
+func (b *bot) reload() (*reloadResult, error) {
+	next, err := loadConfig(b.configFile)
+	if err != nil {
+		return nil, err
+	}
+	next.logger = b.logger
+	next.initNetworks()
+	if err = next.checkConfig(); err != nil {
+		return nil, err
+	}
+
+	b.configMu.Lock()
+	defer b.configMu.Unlock()
+
+	// Keep track of which modules that are enabled in the current
+	// configuration, so that we know which modules that are enabled or
+	// disabled by the new one.
+	wasEnabled := make(map[string]bool)
+	for _, m := range modules {
+		wasEnabled[m.name] = m.enabled(b)
+	}
+
+	current := b.configSettings()
+	before := settingValues(current)
+	result := &reloadResult{}
+
+	for i, s := range next.configSettings() {
+		if !restartSettings[s.path] {
+			current[i].value.Set(s.value)
+			continue
+		}
+
+		if v, _ := json.Marshal(s.value.Interface()); !bytes.Equal(v, before[s.path]) {
+			result.Restart = append(result.Restart, s.path)
+		}
+	}
+
+	// Replace the values that are derived from the settings.
+	b.Modules = next.Modules
+	b.timezone = next.timezone
+	b.IRC.operators = next.IRC.operators
+	b.IRC.ignorePerm = next.IRC.ignorePerm
+	b.config.command.commands = next.config.command.commands
+	b.HTTPClient.client = next.HTTPClient.client
+	b.Log.level = next.Log.level
+	b.Log.levels = next.Log.levels
+	b.logger.out.setLevels(b.Log.level, b.Log.levels)
+
+	// Set the default values again, since the new settings are empty
+	// if they're not in the file. The commands are recreated as well
+	// since their names might have changed.
+	b.initIRCDefaults()
+	b.initHTTPDefaults()
+	b.modules.mu.Lock()
+	for _, m := range modules {
+		s := b.modules.state[m.name]
+		if !s.initialized {
+			continue
+		}
+		if m.init != nil {
+			m.init(b)
+		}
+		if m.commands != nil {
+			s.commands = m.commands(b)
+			b.normalizeCommands(s.commands)
+		}
+	}
+	b.modules.mu.Unlock()
+
+	for path, v := range settingValues(b.configSettings()) {
+		if !restartSettings[path] && !bytes.Equal(v, before[path]) {
+			result.Changed = append(result.Changed, path)
+		}
+	}
+	sort.Strings(result.Changed)
+
+	// Modules that has been enabled or disabled in the file are
+	// enabled or disabled.
+	for _, m := range modules {
+		if enabled := m.enabled(b); enabled != wasEnabled[m.name] {
+			if enabled {
+				b.enableModule(m)
+			} else {
+				b.disableModule(m)
+			}
+		}
+	}
+
+	b.log("reload").Infof("reload: reloaded %s, changed: %s, needs a restart: %s", b.configFile, strings.Join(result.Changed, ", "), strings.Join(result.Restart, ", "))
+	return result, nil
+}
+
+// reloadCommand returns the reload command, which reloads the configuration
+// file.
+func (b *bot) reloadCommand() *botCommand {
+	return &botCommand{
+		name: b.IRC.ReloadCmd,
+		role: roleAdmin,
+		fn:   (*bot).reloadFromIRC,
+	}
+}
+
+// reloadFromIRC reloads the configuration and reports the result to the
+// user. The reload waits for all running event handlers to return, including
+// this one, so it has to be done in a goroutine.
+func (b *bot) reloadFromIRC(a *privmsgAction) {
+	go func() {
+		result, err := b.reload()
+		if err != nil {
+			b.log("reload").Errorf("reloadFromIRC: %v", err)
+			// All problems are sent on a single line.
+			a.replyUrgentph(b.IRC.ReloadErr, map[string]string{
+				"<error>": strings.Replace(err.Error(), "\n", "; ", -1),
+			})
+			return
+		}
+
+		if len(result.Changed) > 0 {
+			a.replyUrgentph(b.IRC.ReloadMsgChanged, map[string]string{
+				"<settings>": strings.Join(result.Changed, ", "),
+			})
+		} else {
+			a.replyUrgent(b.IRC.ReloadMsgUnchanged)
+		}
+
+		if len(result.Restart) > 0 {
+			a.replyUrgentph(b.IRC.ReloadMsgRestart, map[string]string{
+				"<settings>": strings.Join(result.Restart, ", "),
+			})
+		}
+	}()
+}
+
+// reloadHandler reloads the configuration when the reload route is
+// requested, the result is returned as JSON. The request is denied unless it
+// has the reload token as a bearer token.
+func (b *bot) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	// The lock has to be released before the reload, which waits for
+	// all readers.
+	b.configMu.RLock()
+	token := b.HTTP.ReloadToken
+	b.configMu.RUnlock()
+
+	auth := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := b.reload()
+	if err != nil {
+		b.HTTP.logger.Errorf("reload: %v", err)
+		http.Error(w, fmt.Sprintf("Reload failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
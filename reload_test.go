@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadHandlerToken(t *testing.T) {
+	b := &bot{logger: newLogger(&logOutput{w: ioutil.Discard})}
+	b.HTTP.logger = b.logger
+	b.configFile = filepath.Join("testdata", "missing.conf")
+
+	tests := []struct {
+		token string
+		auth  string
+		code  int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		// The configuration file doesn't exist, so the authorized
+		// request fails when the configuration is loaded.
+		{"secret", "Bearer secret", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		b.HTTP.ReloadToken = test.token
+		r := httptest.NewRequest(http.MethodPost, "/reload", nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+
+		w := httptest.NewRecorder()
+		b.reloadHandler(w, r)
+		if w.Code != test.code {
+			t.Errorf("token %q, authorization %q: expected status %d, got %d", test.token, test.auth, test.code, w.Code)
+		}
+	}
+}
+
+func TestReloadWhileCronJobRuns(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableCron": true})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("admin", "#bot", "!cron add 0 12 1 1 * lunch <week>")
+	s.expect("PRIVMSG #bot :cron job added")
+	id := tb.queryString("SELECT id FROM cron WHERE message = 'lunch <week>'")
+
+	// The job reads the settings that the reload replaces, the race
+	// detector reports it if it isn't locked.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			runCronJob(tb, id)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, err := tb.reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestReloadWhileRetentionRuns(t *testing.T) {
+	defer func(d time.Duration) { retentionCheckInterval = d }(retentionCheckInterval)
+	retentionCheckInterval = time.Millisecond
+
+	tb := newTestBot(t, map[string]interface{}{
+		"enableRetention":   true,
+		"retentionLog":      30,
+		"retentionInterval": 1,
+	})
+	defer tb.close()
+
+	// The pruning reads the retention of the tables on every check,
+	// while the reload replaces them.
+	for i := 0; i < 5; i++ {
+		if _, err := tb.reload(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
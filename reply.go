@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// The ways that the replies to a command can be sent.
+const (
+	// replyChannel sends the replies to where the command came from,
+	// which is the channel or the user for private messages.
+	replyChannel = "privmsg"
+
+	// replyNotice sends the replies to the user as notices.
+	replyNotice = "notice"
+
+	// replyPrivate sends the replies to the user as private messages.
+	replyPrivate = "private"
+)
+
+// replyConfig holds the configuration of how commands are replied to.
+type replyConfig struct {
+	// CommandReplies sets how the replies to a command are sent, the key
+	// is the command and sub command names without the prefix, e.g.
+	// "factoid snoop". The setting of a command applies to its sub
+	// commands as well. The value is privmsg, notice or private.
+	CommandReplies map[string]string `json:"commandReplies"`
+
+	// ReplyMaxLines is the maximum number of lines that a command can
+	// reply with in a channel, longer replies are sent as a private
+	// message to the user instead. 0 sets it to the default and -1
+	// turns it off.
+	ReplyMaxLines int `json:"replyMaxLines"`
+
+	ReplyMsgPrivate string `json:"replyMsgPrivate"`
+}
+
+// initReplyDefaults sets default values for the reply settings.
+func (b *bot) initReplyDefaults() {
+	if b.IRC.ReplyMaxLines == 0 {
+		b.IRC.ReplyMaxLines = 5
+	}
+	if b.IRC.ReplyMsgPrivate == "" {
+		b.IRC.ReplyMsgPrivate = "<nick>: the reply was sent as a private message"
+	}
+}
+
+// checkReplyConfig makes sure that the reply modes are valid.
+func (b *bot) checkReplyConfig() configErrors {
+	var errs configErrors
+	for _, cmd := range sortedStringKeys(b.IRC.CommandReplies) {
+		switch b.IRC.CommandReplies[cmd] {
+		case replyChannel, replyNotice, replyPrivate:
+		default:
+			errs = append(errs, configError{"irc.commandReplies." + cmd, fmt.Sprintf("%s is not a reply mode, use %s, %s or %s", b.IRC.CommandReplies[cmd], replyChannel, replyNotice, replyPrivate)})
+		}
+	}
+
+	return errs
+}
+
+// commandReplyMode returns how the replies to the command are sent. The
+// setting of the closest parent command is used if the command has none.
+func (b *bot) commandReplyMode(path string) string {
+	name := b.commandName(path)
+	for name != "" {
+		if mode, ok := b.IRC.CommandReplies[name]; ok {
+			return mode
+		}
+
+		i := strings.LastIndex(name, " ")
+		if i == -1 {
+			break
+		}
+		name = name[:i]
+	}
+
+	return replyChannel
+}
+
+// bufferedReply is a reply that is held back until the command returns.
+type bufferedReply struct {
+	priority messagePriority
+	msg      string
+}
+
+// replyBuffer holds the replies of a command while it runs, so that the
+// size of the whole reply is known before it's decided where it's sent.
+type replyBuffer struct {
+	mu       sync.Mutex
+	buffered bool
+	replies  []bufferedReply
+}
+
+// reply sends the message as a reply to the action, see replyMode for where
+// it's sent.
+func (a *privmsgAction) reply(msg string) {
+	a.send(priorityReply, msg)
+}
+
+// replyph replaces the keys of the phs map with the values and sends the
+// message as a reply to the action.
+func (a *privmsgAction) replyph(msg string, phs map[string]string) {
+	a.reply(replacePlaceholders(msg, phs))
+}
+
+// replyUrgent sends the message as a reply to the action before any other
+// messages that are waiting, it's used for errors and admin commands.
+func (a *privmsgAction) replyUrgent(msg string) {
+	a.send(priorityUrgent, msg)
+}
+
+// replyUrgentph is replyph for urgent replies.
+func (a *privmsgAction) replyUrgentph(msg string, phs map[string]string) {
+	a.replyUrgent(replacePlaceholders(msg, phs))
+}
+
+// send buffers the reply if the command is running, otherwise it's sent
+// right away.
+func (a *privmsgAction) send(p messagePriority, msg string) {
+	if r := a.replies; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.buffered {
+			r.replies = append(r.replies, bufferedReply{p, msg})
+			return
+		}
+	}
+
+	a.deliver(p, msg, false)
+}
+
+// deliver sends the reply according to the reply mode of the action, the
+// reply is sent as a private message to the user if private is true.
+func (a *privmsgAction) deliver(p messagePriority, msg string, private bool) {
+	command, target := "PRIVMSG", a.replyTarget()
+	switch a.replyMode {
+	case replyNotice:
+		command, target = "NOTICE", a.nick
+	case replyPrivate:
+		target = a.nick
+	}
+	if private {
+		target = a.nick
+	}
+
+	a.network.send(p, command, target, msg)
+}
+
+// bufferReplies makes the replies to the action wait until flushReplies is
+// called.
+func (a *privmsgAction) bufferReplies() {
+	a.replies = &replyBuffer{buffered: true}
+}
+
+// flushReplies sends the replies that are buffered, replies that are sent
+// after this are sent right away. Replies that are too long to be sent to
+// the channel are sent as a private message to the user and the channel is
+// told about it.
+func (b *bot) flushReplies(a *privmsgAction) {
+	r := a.replies
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buffered = false
+	replies := r.replies
+	r.replies = nil
+
+	private := false
+	if a.validChannel && a.replyMode == replyChannel && b.IRC.ReplyMaxLines > 0 {
+		lines := 0
+		for _, reply := range replies {
+			lines += len(splitMessage(reply.msg, a.network.maxMessageLength("PRIVMSG", a.target)))
+		}
+		private = lines > b.IRC.ReplyMaxLines
+	}
+
+	// The channel is told with the priority of the most urgent reply, so
+	// that the replies can't be sent before it.
+	if private {
+		p := replies[0].priority
+		for _, reply := range replies {
+			if reply.priority < p {
+				p = reply.priority
+			}
+		}
+		a.network.send(p, "PRIVMSG", a.target, replacePlaceholders(b.IRC.ReplyMsgPrivate, map[string]string{
+			"<nick>": a.nick,
+		}))
+	}
+	for _, reply := range replies {
+		a.deliver(reply.priority, reply.msg, private)
+	}
+}
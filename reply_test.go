@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCommandReplyMode(t *testing.T) {
+	b := &bot{}
+	b.IRC.CommandPrefix = "!"
+	b.IRC.CommandReplies = map[string]string{
+		"factoid":       replyNotice,
+		"factoid snoop": replyPrivate,
+	}
+
+	for path, expected := range map[string]string{
+		"!factoid":             replyNotice,
+		"!factoid count":       replyNotice,
+		"!factoid snoop":       replyPrivate,
+		"!factoid snoop extra": replyPrivate,
+		"!role whoami":         replyChannel,
+	} {
+		if mode := b.commandReplyMode(path); mode != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, mode)
+		}
+	}
+}
+
+func TestCheckConfigCommandReplies(t *testing.T) {
+	errs := checkTestConfig(t, map[string]interface{}{
+		"irc": testIRCSection(map[string]interface{}{
+			"commandReplies": map[string]string{"role": replyNotice, "role whoami": "shout"},
+		}),
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "irc.commandReplies.role whoami" {
+		t.Errorf("expected a problem with irc.commandReplies.role whoami, got %v", errs)
+	}
+}
+
+func TestReplyModes(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"commandReplies": map[string]string{"role": replyNotice, "role whoami": replyPrivate},
+	})
+	defer tb.close()
+	s := tb.server
+
+	// The setting of the subcommand overrides the one of the command.
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG bob :bob has the user role")
+
+	s.privmsg("bob", "#bot", "!role add bob!*@* admin")
+	s.expect("NOTICE bob :you need the admin role to use !role add")
+
+	// A command that is sent as a private message is answered privately.
+	s.privmsg("bob", "bot", "!role whoami")
+	s.expect("PRIVMSG bob :bob has the user role")
+}
+
+func TestReplyMaxLines(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"replyMaxLines": 1})
+	defer tb.close()
+	s := tb.server
+
+	for i, mask := range []string{"bob!*@*", "carol!*@*"} {
+		s.privmsg("admin", "#bot", "!role add "+mask+" trusted")
+		n := strconv.Itoa(i + 1)
+		tb.waitFor("the role to be added", func() bool {
+			return tb.queryString("SELECT COUNT(*) FROM user_role") == n
+		})
+	}
+	bob := tb.queryString("SELECT id FROM user_role WHERE mask = 'bob!*@*'")
+	carol := tb.queryString("SELECT id FROM user_role WHERE mask = 'carol!*@*'")
+
+	// The list is longer than the channel is allowed, so it's sent to the
+	// user and the channel is told about it.
+	s.privmsg("admin", "#bot", "!role list")
+	s.expect("PRIVMSG #bot :admin: the reply was sent as a private message")
+	s.expect("PRIVMSG admin :" + bob + ": bob!*@* has the trusted role")
+	s.expect("PRIVMSG admin :" + carol + ": carol!*@* has the trusted role")
+
+	// A reply that fits is still sent to the channel.
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :bob has the trusted role")
+}
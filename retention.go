@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// retentionConfig holds the configuration of the retention module, which
+// prunes old rows from the database. The retention of each table is given
+// in days, the rows of a table are kept forever if it's zero.
+type retentionConfig struct {
+	EnableRetention          bool `json:"enableRetention"`
+	RetentionLog             int  `json:"retentionLog"`
+	RetentionURLCheck        int  `json:"retentionURLCheck"`
+	RetentionSMHIForecast    int  `json:"retentionSMHIForecast"`
+	RetentionWebhookDelivery int  `json:"retentionWebhookDelivery"`
+
+	// RetentionInterval is how often the tables are pruned in hours, it
+	// defaults to once a day.
+	RetentionInterval int `json:"retentionInterval"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureRetention,
+		config:  func(b *bot) interface{} { return &b.config.retention },
+		enabled: func(b *bot) bool { return b.config.retention.EnableRetention },
+		check:   (*bot).checkRetentionConfig,
+		init:    (*bot).initRetentionDefaults,
+		run:     (*bot).runRetention,
+	})
+}
+
+// checkRetentionConfig makes sure that none of the retention settings are
+// negative.
+func (b *bot) checkRetentionConfig() error {
+	var errs configErrors
+	for _, s := range []struct {
+		name  string
+		value int
+	}{
+		{"retentionLog", b.config.retention.RetentionLog},
+		{"retentionURLCheck", b.config.retention.RetentionURLCheck},
+		{"retentionSMHIForecast", b.config.retention.RetentionSMHIForecast},
+		{"retentionWebhookDelivery", b.config.retention.RetentionWebhookDelivery},
+		{"retentionInterval", b.config.retention.RetentionInterval},
+	} {
+		if s.value < 0 {
+			errs = append(errs, configError{s.name, "can't be negative"})
+		}
+	}
+
+	return errs.err()
+}
+
+// initRetentionDefaults sets default values for all settings.
+func (b *bot) initRetentionDefaults() {
+	if b.config.retention.RetentionInterval == 0 {
+		b.config.retention.RetentionInterval = 24
+	}
+}
+
+// retentionCheckInterval is how often runRetention checks whether the
+// tables are due to be pruned.
+var retentionCheckInterval = time.Minute
+
+// runRetention prunes the tables once every RetentionInterval hours until
+// the module is stopped. The interval is read again on every check, so a
+// reloaded interval takes effect within retentionCheckInterval.
+func (b *bot) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		// The settings might be replaced by a reload.
+		b.configMu.RLock()
+		interval := time.Duration(b.config.retention.RetentionInterval) * time.Hour
+		if now := time.Now(); now.Sub(last) >= interval {
+			if _, err := b.prune(now); err != nil {
+				b.metrics.dbErrors.inc()
+				b.log(featureRetention).Errorf("runRetention: %v", err)
+			}
+			last = now
+		}
+		b.configMu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruned is the number of rows that were deleted from a table.
+type pruned struct {
+	Table string
+	Rows  int64
+}
+
+// prune deletes the rows that are older than the retention of their table,
+// counted from now. The tables without a retention are left as they are. The
+// caller must make sure that the settings aren't reloaded meanwhile.
+func (b *bot) prune(now time.Time) ([]pruned, error) {
+	var result []pruned
+	for _, t := range []struct {
+		table        string
+		days         int
+		deleteBefore func(time.Time) (int64, error)
+	}{
+		{"log", b.config.retention.RetentionLog, b.store.log.deleteBefore},
+		{"url_check", b.config.retention.RetentionURLCheck, b.store.urlCheck.deleteBefore},
+		{"smhi_forecast", b.config.retention.RetentionSMHIForecast, b.store.smhiForecast.deleteBefore},
+		{"webhook_delivery", b.config.retention.RetentionWebhookDelivery, b.deleteDeliveriesBefore},
+	} {
+		if t.days <= 0 {
+			continue
+		}
+
+		n, err := t.deleteBefore(now.AddDate(0, 0, -t.days))
+		if err != nil {
+			return result, fmt.Errorf("can't prune %s: %v", t.table, err)
+		}
+		if n > 0 {
+			b.log(featureRetention).Infof("prune: deleted %d rows from %s", n, t.table)
+		}
+		result = append(result, pruned{t.table, n})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The roles that can be assigned to users, each role includes the
+// privileges of the roles below it. Everyone has the user role.
+const (
+	roleUser    = "user"
+	roleTrusted = "trusted"
+	roleAdmin   = "admin"
+)
+
+// roleLevels maps each role to its privilege level.
+var roleLevels = map[string]int{
+	roleUser:    0,
+	roleTrusted: 1,
+	roleAdmin:   2,
+}
+
+// roleConfig holds the configuration of the role system.
+type roleConfig struct {
+	// CommandRoles overrides the role that is required by a command,
+	// the key is the command and sub command names without the prefix,
+	// e.g. "factoid forget".
+	CommandRoles map[string]string `json:"commandRoles"`
+
+	RoleCmd          string `json:"roleCmd"`
+	RoleSubCmdAdd    string `json:"roleSubCmdAdd"`
+	RoleSubCmdDelete string `json:"roleSubCmdDelete"`
+	RoleSubCmdList   string `json:"roleSubCmdList"`
+	RoleSubCmdWhoami string `json:"roleSubCmdWhoami"`
+
+	RoleMsgAdd      string `json:"roleMsgAdd"`
+	RoleMsgDelete   string `json:"roleMsgDelete"`
+	RoleMsgList     string `json:"roleMsgList"`
+	RoleMsgWhoami   string `json:"roleMsgWhoami"`
+	RoleErrUnknown  string `json:"roleErrUnknown"`
+	RoleErrNotFound string `json:"roleErrNotFound"`
+	RoleErrDenied   string `json:"roleErrDenied"`
+}
+
+// initRoleDefaults sets default values for the role command and messages.
+func (b *bot) initRoleDefaults() {
+	if b.IRC.RoleCmd == "" {
+		b.IRC.RoleCmd = "role"
+	}
+	if b.IRC.RoleSubCmdAdd == "" {
+		b.IRC.RoleSubCmdAdd = "add"
+	}
+	if b.IRC.RoleSubCmdDelete == "" {
+		b.IRC.RoleSubCmdDelete = "delete"
+	}
+	if b.IRC.RoleSubCmdList == "" {
+		b.IRC.RoleSubCmdList = "list"
+	}
+	if b.IRC.RoleSubCmdWhoami == "" {
+		b.IRC.RoleSubCmdWhoami = "whoami"
+	}
+	if b.IRC.RoleMsgAdd == "" {
+		b.IRC.RoleMsgAdd = "<mask> has the <role> role (<id>)"
+	}
+	if b.IRC.RoleMsgDelete == "" {
+		b.IRC.RoleMsgDelete = "role deleted"
+	}
+	if b.IRC.RoleMsgList == "" {
+		b.IRC.RoleMsgList = "<id>: <mask> has the <role> role"
+	}
+	if b.IRC.RoleMsgWhoami == "" {
+		b.IRC.RoleMsgWhoami = "<nick> has the <role> role"
+	}
+	if b.IRC.RoleErrUnknown == "" {
+		b.IRC.RoleErrUnknown = "<role> is not a role, use admin, trusted or user"
+	}
+	if b.IRC.RoleErrNotFound == "" {
+		b.IRC.RoleErrNotFound = "there is no role with id <id>"
+	}
+	if b.IRC.RoleErrDenied == "" {
+		b.IRC.RoleErrDenied = "you need the <role> role to use <command>"
+	}
+}
+
+// isAccountMask returns true if the mask is a services account name rather
+// than a nick!user@host mask.
+func isAccountMask(mask string) bool {
+	return !strings.ContainsAny(mask, "!@*?")
+}
+
+// hostmaskRegexp converts a hostmask with * and ? wildcards into a case
+// insensitive regexp.
+func hostmaskRegexp(mask string) (*regexp.Regexp, error) {
+	r := regexp.QuoteMeta(mask)
+	r = strings.ReplaceAll(r, `\*`, ".*")
+	r = strings.ReplaceAll(r, `\?`, ".")
+	return regexp.Compile("(?i)^" + r + "$")
+}
+
+// userRole returns the role of the user that sent the action. Hosts that
+// matches one of the configured operators are always admins, otherwise the
+// highest role that is assigned to a matching hostmask, or to the account of
+// the user, on the network is returned.
+func (b *bot) userRole(a *privmsgAction) string {
+	if b.isOperator(a.host) {
+		return roleAdmin
+	}
+
+	rows, err := b.query("SELECT mask, role FROM user_role WHERE network = $1 AND is_deleted = false", a.network.Name)
+	if err != nil {
+		b.log("role").Errorf("userRole: %v", err)
+		return roleUser
+	}
+	defer rows.Close()
+
+	role := roleUser
+	hostmask := a.nick + "!" + a.host
+	for rows.Next() {
+		var mask, r string
+		rows.Scan(&mask, &r)
+
+		if isAccountMask(mask) {
+			if a.account == "" || !strings.EqualFold(mask, a.account) {
+				continue
+			}
+		} else {
+			re, err := hostmaskRegexp(mask)
+			if err != nil || !re.MatchString(hostmask) {
+				continue
+			}
+		}
+
+		if roleLevels[r] > roleLevels[role] {
+			role = r
+		}
+	}
+
+	return role
+}
+
+// commandRole returns the role that is required to use the command, the
+// configured command roles takes precedence over the role of the command.
+func (b *bot) commandRole(path string, c *botCommand) string {
+	if r, ok := b.IRC.CommandRoles[b.commandName(path)]; ok {
+		return r
+	}
+	if c.role != "" {
+		return c.role
+	}
+
+	return roleUser
+}
+
+// checkRole returns true if the user that sent the action is allowed to use
+// the command. Denied attempts are logged and the user is told which role
+// that is needed.
+func (b *bot) checkRole(a *privmsgAction, c *botCommand, path string) bool {
+	required := b.commandRole(path, c)
+	if required == roleUser {
+		return true
+	}
+
+	role := b.userRole(a)
+	if roleLevels[role] >= roleLevels[required] {
+		return true
+	}
+
+	b.log("role").Warnf("checkRole: %s!%s on %s with the %s role was denied %s, it requires the %s role", a.nick, a.host, a.network.Name, role, path, required)
+	a.replyUrgentph(b.IRC.RoleErrDenied, map[string]string{
+		"<role>":    required,
+		"<command>": path,
+	})
+	return false
+}
+
+// roleCommand returns the role command, which is used to manage the roles
+// of the users.
+func (b *bot) roleCommand() *botCommand {
+	return &botCommand{
+		name: b.IRC.RoleCmd,
+		subCommands: []*botCommand{
+			{name: b.IRC.RoleSubCmdAdd, usage: "<nick!user@host|account> <role>", minArgs: 2, maxArgs: 2, role: roleAdmin, fn: (*bot).roleAdd},
+			{name: b.IRC.RoleSubCmdDelete, usage: "<id>", minArgs: 1, maxArgs: 1, role: roleAdmin, fn: (*bot).roleDelete},
+			{name: b.IRC.RoleSubCmdList, role: roleAdmin, fn: (*bot).roleList},
+			{name: b.IRC.RoleSubCmdWhoami, fn: (*bot).roleWhoami},
+		},
+	}
+}
+
+// roleAdd assigns the role to the hostmask or account on the network that
+// the command was issued on.
+func (b *bot) roleAdd(a *privmsgAction) {
+	mask, role := a.args[0], a.args[1]
+
+	if _, ok := roleLevels[role]; !ok {
+		a.replyUrgentph(b.IRC.RoleErrUnknown, map[string]string{
+			"<role>": role,
+		})
+		return
+	}
+
+	stmt, err := b.prepare("INSERT INTO user_role (id, network, mask, role, author, inserted_at, is_deleted) VALUES($1, $2, $3, $4, $5, $6, false)")
+	if err != nil {
+		b.log("role").Errorf("roleAdd: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer stmt.Close()
+
+	id := newUUID()
+	_, err = stmt.Exec(id, a.network.Name, mask, role, a.nick, newTimestamp())
+	if err != nil {
+		b.log("role").Errorf("roleAdd: %v", err)
+		b.replyDBError(a)
+		return
+	}
+
+	b.log("role").Infof("roleAdd: %s gave %s the %s role on %s", a.nick, mask, role, a.network.Name)
+	a.replyUrgentph(b.IRC.RoleMsgAdd, map[string]string{
+		"<id>":   id,
+		"<mask>": mask,
+		"<role>": role,
+	})
+}
+
+// roleDelete deletes the role with the given id.
+func (b *bot) roleDelete(a *privmsgAction) {
+	id := a.args[0]
+
+	notFound := func() {
+		a.replyUrgentph(b.IRC.RoleErrNotFound, map[string]string{
+			"<id>": id,
+		})
+	}
+
+	// We expect a valid UUID to be sent.
+	if !isUUID(id) {
+		notFound()
+		return
+	}
+
+	stmt, err := b.prepare("UPDATE user_role SET is_deleted = true WHERE id = $1 AND network = $2 AND is_deleted = false")
+	if err != nil {
+		b.log("role").Errorf("roleDelete: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(id, a.network.Name)
+	if err != nil {
+		b.log("role").Errorf("roleDelete: %v", err)
+		b.replyDBError(a)
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		notFound()
+		return
+	}
+
+	b.log("role").Infof("roleDelete: %s deleted role %s on %s", a.nick, id, a.network.Name)
+	a.replyUrgent(b.IRC.RoleMsgDelete)
+}
+
+// roleList lists the roles of the network that the command was issued on.
+func (b *bot) roleList(a *privmsgAction) {
+	rows, err := b.query("SELECT id, mask, role FROM user_role WHERE network = $1 AND is_deleted = false ORDER BY inserted_at", a.network.Name)
+	if err != nil {
+		b.log("role").Errorf("roleList: %v", err)
+		b.replyDBError(a)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, mask, role string
+		rows.Scan(&id, &mask, &role)
+
+		a.replyUrgentph(b.IRC.RoleMsgList, map[string]string{
+			"<id>":   id,
+			"<mask>": mask,
+			"<role>": role,
+		})
+	}
+}
+
+// roleWhoami tells the user which role it has.
+func (b *bot) roleWhoami(a *privmsgAction) {
+	a.replyph(b.IRC.RoleMsgWhoami, map[string]string{
+		"<nick>": a.nick,
+		"<role>": b.userRole(a),
+	})
+}
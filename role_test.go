@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostmaskRegexp(t *testing.T) {
+	tests := []struct {
+		mask     string
+		hostmask string
+		expected bool
+	}{
+		{"bob!*@*.example.com", "bob!bob@bob.example.com", true},
+		{"BOB!*@*", "bob!bob@bob.example.com", true},
+		{"bob!*@*.example.com", "bobby!bob@bob.example.com", false},
+		{"b?b!*@*", "bab!bob@host", true},
+		{"bob.example.com", "bobxexample.com", false},
+	}
+
+	for _, test := range tests {
+		re, err := hostmaskRegexp(test.mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := re.MatchString(test.hostmask); actual != test.expected {
+			t.Errorf("%s matching %s: expected %v, got %v", test.mask, test.hostmask, test.expected, actual)
+		}
+	}
+
+	for mask, expected := range map[string]bool{"bob": true, "bob!*@*": false, "*.example.com": false, "bob@host": false} {
+		if actual := isAccountMask(mask); actual != expected {
+			t.Errorf("%s: expected account mask to be %v, got %v", mask, expected, actual)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tb := newTestBot(t, nil)
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :bob has the user role")
+
+	// Only admins can assign roles, and the denied attempt is logged.
+	s.privmsg("bob", "#bot", "!role add bob!*@* admin")
+	s.expect("PRIVMSG #bot :you need the admin role to use !role add")
+	tb.waitFor("the denied attempt to be logged", func() bool {
+		log, _ := ioutil.ReadFile(filepath.Join(tb.dir, "bot.log"))
+		return strings.Contains(string(log), "bob!bob@bob.example.com on test with the user role was denied !role add")
+	})
+
+	s.privmsg("admin", "#bot", "!role add bob!*@* boss")
+	s.expect("PRIVMSG #bot :boss is not a role, use admin, trusted or user")
+
+	s.privmsg("admin", "#bot", "!role add bob!*@*.example.com trusted")
+	tb.waitFor("the role to be added", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM user_role") == "1"
+	})
+	id := tb.queryString("SELECT id FROM user_role WHERE mask = 'bob!*@*.example.com'")
+	s.expect("PRIVMSG #bot :bob!*@*.example.com has the trusted role (" + id + ")")
+
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :bob has the trusted role")
+
+	// Roles can also be given to services accounts, the account is
+	// taken from the account tag.
+	s.privmsg("admin", "#bot", "!role add carolsacct admin")
+	tb.waitFor("the role to be added", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM user_role") == "2"
+	})
+	s.expect("PRIVMSG #bot :carolsacct has the admin role (" + tb.queryString("SELECT id FROM user_role WHERE mask = 'carolsacct'") + ")")
+	s.send("@account=carolsacct :carol!carol@carol.example.com PRIVMSG #bot :!role whoami")
+	s.expect("PRIVMSG #bot :carol has the admin role")
+	s.privmsg("carol", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :carol has the user role")
+
+	s.privmsg("admin", "#bot", "!role delete "+id)
+	s.expect("PRIVMSG #bot :role deleted")
+	s.privmsg("admin", "#bot", "!role delete "+id)
+	s.expect("PRIVMSG #bot :there is no role with id " + id)
+
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :bob has the user role")
+}
+
+func TestCommandRoles(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"commandRoles": map[string]string{"role whoami": roleTrusted},
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("bob", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :you need the trusted role to use !role whoami")
+
+	s.privmsg("admin", "#bot", "!role whoami")
+	s.expect("PRIVMSG #bot :admin has the admin role")
+}
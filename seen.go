@@ -1,62 +1,37 @@
 package main
 
-import (
-	"database/sql"
-
-	"github.com/osm/irc"
-)
-
 // initSeenHandler initializes the seen handler default messages.
 func (b *bot) initSeenHandler() {
-	if b.IRC.SeenMsgFound == "" {
-		b.IRC.SeenMsgFound = "<nick> <date> <time>, saying <message>"
+	if b.config.logging.SeenMsgFound == "" {
+		b.config.logging.SeenMsgFound = "<nick> <date> <time>, saying <message>"
 	}
-	if b.IRC.SeenMsgNotFound == "" {
-		b.IRC.SeenMsgNotFound = "<nick> has never been here"
+	if b.config.logging.SeenMsgNotFound == "" {
+		b.config.logging.SeenMsgNotFound = "<nick> has never been here"
 	}
 }
 
-// seenHandler handle the !seen requests
-func (b *bot) seenHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	if !a.validChannel {
-		return
-	}
-	if a.cmd != b.IRC.SeenCmd {
-		return
-	}
-	if b.shouldIgnore(m) {
-		return
-	}
-	if len(a.args) < 1 {
-		return
-	}
+// seenCommand handle the !seen requests, only messages from the channel and
+// network that the command was issued in are considered.
+func (b *bot) seenCommand(a *privmsgAction) {
 
 	nick := a.args[0]
-	var timestamp string
-	var message string
-	err := b.queryRow(
-		"SELECT timestamp, message FROM log WHERE nick = $1 ORDER BY timestamp DESC LIMIT 1",
-		nick,
-	).Scan(&timestamp, &message)
-
-	if err != nil && err != sql.ErrNoRows {
-		b.logger.Printf("seenHandler: %v", err)
-		b.privmsg(b.DB.Err)
+	e, ok, err := b.store.log.lastByNick(a.network.Name, a.target, nick)
+	if err != nil {
+		b.log(featureLogging).Errorf("seenHandler: %v", err)
+		b.replyDBError(a)
 		return
 	}
 
-	if len(timestamp) == 0 {
-		b.privmsgph(b.IRC.SeenMsgNotFound, map[string]string{
+	if !ok {
+		a.replyph(b.config.logging.SeenMsgNotFound, map[string]string{
 			"<nick>": nick,
 		})
 	} else {
-		b.privmsgph(b.IRC.SeenMsgFound, map[string]string{
+		a.replyph(b.config.logging.SeenMsgFound, map[string]string{
 			"<nick>":    nick,
-			"<date>":    timestamp[0:10],
-			"<time>":    timestamp[11:16],
-			"<message>": message,
+			"<date>":    e.Timestamp.In(b.timezone).Format("2006-01-02"),
+			"<time>":    e.Timestamp.In(b.timezone).Format("15:04"),
+			"<message>": e.Message,
 		})
 	}
 }
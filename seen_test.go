@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeen(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{
+		"enableLogging": true,
+		"seenCmd":       "seen",
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "good morning")
+	s.privmsg("alice", "#bot", "is anyone here?")
+	tb.waitFor("the messages to be logged", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM log WHERE nick = 'alice'") == "2"
+	})
+
+	// The timestamp is stored in UTC and shown in the timezone of the
+	// bot.
+	ts, err := parseTimestamp(tb.queryString("SELECT timestamp FROM log WHERE message = 'is anyone here?'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.privmsg("bob", "#bot", "!seen alice")
+	s.expect("PRIVMSG #bot :alice " + ts.In(stockholm).Format("2006-01-02 15:04") + ", saying is anyone here?")
+
+	s.privmsg("bob", "#bot", "!seen carol")
+	s.expect("PRIVMSG #bot :carol has never been here")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// messagePriority decides in which order the queued messages are sent, the
+// messages with the highest priority are sent first.
+type messagePriority int
+
+const (
+	// priorityUrgent is used for errors and replies to admin commands.
+	priorityUrgent messagePriority = iota
+
+	// priorityReply is used for replies to commands and other messages
+	// that users are waiting for.
+	priorityReply
+
+	// priorityBackground is used for announcements that nobody asked
+	// for, such as cron jobs and news. They may be dropped if the queue
+	// is backlogged.
+	priorityBackground
+
+	numPriorities
+)
+
+const (
+	// maxLineLength is the maximum length of a line, including the
+	// trailing CR-LF.
+	maxLineLength = 512
+
+	// maxHostLength is the longest host name that the server prepends
+	// to our messages when they are relayed to other clients.
+	maxHostLength = 63
+)
+
+// queuedMessage is a line that is waiting to be sent, command is either
+// PRIVMSG or NOTICE.
+type queuedMessage struct {
+	command string
+	target  string
+	line    string
+	queued  time.Time
+}
+
+// sendQueue holds the outgoing messages of a network. The messages are sent
+// by run in priority order, the rate is limited by a token bucket that is
+// refilled with one token each interval and holds at most burst tokens.
+type sendQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queues [numPriorities][]queuedMessage
+
+	// sending is true while a message that has been taken from the
+	// queue is being sent.
+	sending bool
+
+	// interval, burst, backlog and staleAfter are set from the
+	// configuration by configure.
+	interval   time.Duration
+	burst      int
+	backlog    int
+	staleAfter time.Duration
+
+	tokens float64
+	filled time.Time
+
+	// dropped is called with the background messages that are dropped.
+	dropped func(m queuedMessage)
+}
+
+// newSendQueue creates an empty send queue.
+func newSendQueue() *sendQueue {
+	q := &sendQueue{burst: 1}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// configure sets the rate limit of the queue and when background messages
+// are considered stale. When more than backlog messages are waiting, the
+// background messages that have waited longer than staleAfter are dropped.
+func (q *sendQueue) configure(interval time.Duration, burst, backlog int, staleAfter time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.interval = interval
+	q.burst = burst
+	q.backlog = backlog
+	q.staleAfter = staleAfter
+	if q.tokens > float64(burst) {
+		q.tokens = float64(burst)
+	}
+}
+
+// push adds the lines to the queue with the given priority.
+func (q *sendQueue) push(p messagePriority, command, target string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for _, l := range lines {
+		q.queues[p] = append(q.queues[p], queuedMessage{command, target, l, now})
+	}
+	q.cond.Broadcast()
+}
+
+// len returns the number of messages that are waiting to be sent, the
+// caller must hold the lock.
+func (q *sendQueue) len() int {
+	n := 0
+	for _, msgs := range q.queues {
+		n += len(msgs)
+	}
+	return n
+}
+
+// length returns the number of messages that are waiting to be sent.
+func (q *sendQueue) length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.len()
+}
+
+// dropStale removes the background messages that are stale if the queue is
+// backlogged, the caller must hold the lock.
+func (q *sendQueue) dropStale(now time.Time) {
+	if q.backlog <= 0 || q.len() <= q.backlog {
+		return
+	}
+
+	kept := q.queues[priorityBackground][:0]
+	for _, m := range q.queues[priorityBackground] {
+		if now.Sub(m.queued) > q.staleAfter {
+			if q.dropped != nil {
+				q.dropped(m)
+			}
+			continue
+		}
+		kept = append(kept, m)
+	}
+	q.queues[priorityBackground] = kept
+}
+
+// refill adds the tokens that has been earned since the last refill, the
+// caller must hold the lock. The bucket starts out full.
+func (q *sendQueue) refill(now time.Time) {
+	if q.interval <= 0 || q.filled.IsZero() {
+		q.tokens = float64(q.burst)
+	} else {
+		q.tokens += float64(now.Sub(q.filled)) / float64(q.interval)
+		if q.tokens > float64(q.burst) {
+			q.tokens = float64(q.burst)
+		}
+	}
+	q.filled = now
+}
+
+// next waits until there's a message in the queue and a token is available,
+// the message with the highest priority is returned. The token is waited
+// for before the message is picked, so that a message with a higher
+// priority that arrives in the meantime is sent first.
+func (q *sendQueue) next() queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for q.len() == 0 {
+			q.cond.Wait()
+		}
+
+		now := time.Now()
+		q.refill(now)
+		if q.tokens < 1 {
+			wait := time.Duration((1 - q.tokens) * float64(q.interval))
+			q.mu.Unlock()
+			time.Sleep(wait)
+			q.mu.Lock()
+			continue
+		}
+
+		q.dropStale(now)
+		for p := range q.queues {
+			if len(q.queues[p]) == 0 {
+				continue
+			}
+
+			m := q.queues[p][0]
+			q.queues[p] = q.queues[p][1:]
+			q.tokens--
+			q.sending = true
+			return m
+		}
+	}
+}
+
+// done marks the message that was returned by next as sent.
+func (q *sendQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sending = false
+	q.cond.Broadcast()
+}
+
+// run sends the queued messages with send, it never returns.
+func (q *sendQueue) run(send func(m queuedMessage)) {
+	for {
+		send(q.next())
+		q.done()
+	}
+}
+
+// drain returns when the queue is empty and the last message has been sent.
+func (q *sendQueue) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.len() > 0 || q.sending {
+		q.cond.Wait()
+	}
+}
+
+// splitMessage splits the message into lines that are at most max bytes
+// long. The message is split at both CR and LF, since either of them ends
+// the line on the server, and NUL bytes are removed, so that the message
+// can't be used to send other commands. Long lines are split at the last
+// space that fits, or at the last UTF-8 boundary if there is no space.
+// Empty lines are skipped since they can't be sent.
+func splitMessage(msg string, max int) []string {
+	var lines []string
+
+	msg = strings.Replace(msg, "\x00", "", -1)
+	for _, l := range strings.FieldsFunc(msg, isLineBreak) {
+		for len(l) > max {
+			i := strings.LastIndexByte(l[:max+1], ' ')
+			if i <= 0 {
+				i = max
+				for i > 0 && !utf8.RuneStart(l[i]) {
+					i--
+				}
+				if i == 0 {
+					_, i = utf8.DecodeRuneInString(l)
+				}
+			}
+
+			lines = append(lines, l[:i])
+			l = strings.TrimLeft(l[i:], " ")
+		}
+
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	return lines
+}
+
+// isLineBreak returns true if r is a CR or an LF.
+func isLineBreak(r rune) bool {
+	return r == '\r' || r == '\n'
+}
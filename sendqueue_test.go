@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		max  int
+		want []string
+	}{
+		{"hello world", 20, []string{"hello world"}},
+		{"hello world", 11, []string{"hello world"}},
+		{"hello world", 10, []string{"hello", "world"}},
+		{"hello  world", 7, []string{"hello ", "world"}},
+		{"one\ntwo\r\nthree", 20, []string{"one", "two", "three"}},
+		{"\n\r\n  \nfour", 20, []string{"  ", "four"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"hi abcdefghij", 4, []string{"hi", "abcd", "efgh", "ij"}},
+		{"åäöåäö", 5, []string{"åä", "öå", "äö"}},
+		{"a€b€", 4, []string{"a€", "b€"}},
+		{"a€b€", 3, []string{"a", "€", "b", "€"}},
+		{"€", 2, []string{"€"}},
+		{"", 10, nil},
+
+		// A CR or a NUL byte would let the message send another
+		// command to the server.
+		{"hi\rQUIT :bye", 20, []string{"hi", "QUIT :bye"}},
+		{"hi\r\rQUIT :bye\r", 20, []string{"hi", "QUIT :bye"}},
+		{"hi\x00there", 20, []string{"hithere"}},
+		{"\x00\r\x00\n", 20, nil},
+	}
+
+	for _, test := range tests {
+		got := splitMessage(test.msg, test.max)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitMessage(%q, %d): expected %q, got %q", test.msg, test.max, test.want, got)
+		}
+		for _, l := range got {
+			if len(l) > test.max && len([]rune(l)) > 1 {
+				t.Errorf("splitMessage(%q, %d): %q is longer than the limit", test.msg, test.max, l)
+			}
+			if strings.ContainsAny(l, "\r\n\x00") {
+				t.Errorf("splitMessage(%q, %d): %q contains a line break or NUL", test.msg, test.max, l)
+			}
+		}
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := newSendQueue()
+	q.push(priorityBackground, "PRIVMSG", "#bot", []string{"background 1", "background 2"})
+	q.push(priorityReply, "PRIVMSG", "#bot", []string{"reply"})
+	q.push(priorityUrgent, "NOTICE", "admin", []string{"urgent"})
+
+	var got []string
+	for q.length() > 0 {
+		got = append(got, q.next().line)
+		q.done()
+	}
+
+	if want := []string{"urgent", "reply", "background 1", "background 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSendQueueTokenBucket(t *testing.T) {
+	q := newSendQueue()
+	q.configure(time.Second, 3, 0, 0)
+
+	// The bucket starts out full.
+	now := time.Now()
+	q.refill(now)
+	if q.tokens != 3 {
+		t.Fatalf("expected 3 tokens, got %v", q.tokens)
+	}
+
+	q.tokens = 0
+	q.refill(now.Add(1500 * time.Millisecond))
+	if q.tokens != 1.5 {
+		t.Errorf("expected 1.5 tokens, got %v", q.tokens)
+	}
+
+	// The bucket never holds more than burst tokens.
+	q.refill(now.Add(time.Hour))
+	if q.tokens != 3 {
+		t.Errorf("expected 3 tokens, got %v", q.tokens)
+	}
+
+	// Lowering the burst empties the bucket down to the new burst.
+	q.configure(time.Second, 2, 0, 0)
+	if q.tokens != 2 {
+		t.Errorf("expected 2 tokens, got %v", q.tokens)
+	}
+
+	// next waits for a token when the bucket is empty.
+	q.configure(50*time.Millisecond, 1, 0, 0)
+	q.filled = time.Time{}
+	q.push(priorityReply, "PRIVMSG", "#bot", []string{"one", "two"})
+	start := time.Now()
+	q.next()
+	q.done()
+	q.next()
+	q.done()
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("expected the second message to wait for a token, it took %v", d)
+	}
+}
+
+func TestSendQueueDropStale(t *testing.T) {
+	q := newSendQueue()
+	q.configure(0, 1, 2, time.Minute)
+
+	var dropped []string
+	q.dropped = func(m queuedMessage) { dropped = append(dropped, m.line) }
+
+	now := time.Now()
+	q.queues[priorityBackground] = []queuedMessage{
+		{"PRIVMSG", "#bot", "stale", now.Add(-2 * time.Minute)},
+		{"PRIVMSG", "#bot", "fresh", now},
+	}
+	q.queues[priorityReply] = []queuedMessage{
+		{"PRIVMSG", "#bot", "old reply", now.Add(-2 * time.Minute)},
+	}
+
+	q.dropStale(now)
+	if want := []string{"stale"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("expected %q to be dropped, got %q", want, dropped)
+	}
+	if n := q.length(); n != 2 {
+		t.Errorf("expected 2 messages to be kept, got %d", n)
+	}
+
+	// Nothing is dropped unless the queue is backlogged.
+	dropped = nil
+	q.queues[priorityBackground] = append(q.queues[priorityBackground], queuedMessage{"PRIVMSG", "#bot", "stale", now.Add(-2 * time.Minute)})
+	q.configure(0, 1, 3, time.Minute)
+	q.dropStale(now)
+	if dropped != nil || q.length() != 3 {
+		t.Errorf("expected nothing to be dropped, got %q", dropped)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the bot waits for each step of the shutdown,
+// such as draining the outgoing messages or waiting for the modules to stop,
+// before it moves on.
+const shutdownTimeout = 10 * time.Second
+
+// handleSignals stops the bot when SIGINT or SIGTERM is received and reloads
+// the configuration when SIGHUP is received.
+func (b *bot) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case s := <-signals:
+			if s == syscall.SIGHUP {
+				if _, err := b.reload(); err != nil {
+					b.logger.Errorf("handleSignals: %v", err)
+				}
+				continue
+			}
+
+			b.logger.Infof("handleSignals: received %v, shutting down", s)
+			b.stop(nil)
+			return
+		case <-b.stopCh:
+			return
+		}
+	}
+}
+
+// stop makes the bot shut down, err is returned from start unless it's nil.
+// Only the first call has any effect.
+func (b *bot) stop(err error) {
+	b.stopOnce.Do(func() {
+		b.stopErr = err
+		close(b.stopCh)
+	})
+}
+
+// shutdown waits until the bot is stopped and then shuts it down. The
+// background work is cancelled, cron is stopped, the outgoing messages are
+// drained and a QUIT is sent to each network. start returns once all
+// connections and the HTTP server are closed.
+func (b *bot) shutdown() {
+	<-b.stopCh
+
+	// Cancel the root context, which stops the background work of the
+	// modules and the quiz timers.
+	b.cancel()
+	if !waitTimeout(b.modules.wg.Wait) {
+		b.logger.Warnf("shutdown: timed out waiting for the modules to stop")
+	}
+
+	// Wait for the cron jobs that are running to complete.
+	if b.cron != nil {
+		if !waitTimeout(func() { <-b.cron.cron.Stop().Done() }) {
+			b.logger.Warnf("shutdown: timed out waiting for the cron jobs to complete")
+		}
+	}
+
+	if b.HTTP.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := b.HTTP.server.Shutdown(ctx); err != nil {
+			b.logger.Errorf("shutdown: %v", err)
+		}
+		cancel()
+	}
+
+	for _, n := range b.IRC.Networks {
+		go b.quit(n)
+	}
+}
+
+// quit sends the pending messages and a QUIT to the network. The connection
+// is closed if the server hasn't closed it within the shutdown timeout.
+func (b *bot) quit(n *network) {
+	if !waitTimeout(n.drain) {
+		b.logger.with("network", n.Name).Warnf("quit: timed out waiting for the outgoing messages")
+	}
+
+	// Quit blocks until the client reads the next line from the server,
+	// which it never does if the connection already is closed.
+	go n.client.Quit(b.IRC.QuitMessage)
+
+	time.Sleep(shutdownTimeout)
+	if c := n.connection(); c != nil {
+		c.Close()
+	}
+}
+
+// waitTimeout calls fn and waits for it to return, false is returned if it
+// doesn't return within the shutdown timeout.
+func waitTimeout(fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(shutdownTimeout):
+		return false
+	}
+}
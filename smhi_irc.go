@@ -9,96 +9,64 @@ import (
 	"time"
 
 	"github.com/nathan-osman/go-sunrise"
-	"github.com/osm/irc"
 )
 
-const SMHI_FORECAST_CURRENT_SELECT_SQL_SQLITE = `SELECT
-	id,
-	timestamp,
-	inserted_at,
-	updated_at,
-	air_pressure,
-	air_temperature,
-	horizontal_visibility,
-	maximum_precipitation_intensity,
-	mean_precipitation_intensity,
-	mean_value_of_high_level_cloud_cover,
-	mean_value_of_low_level_cloud_cover,
-	mean_value_of_medium_level_cloud_cover,
-	mean_value_of_total_cloud_cover,
-	median_precipitation_intensity,
-	minimum_precipitation_intensity,
-	percent_of_precipitation_in_frozen_form,
-	precipitation_category,
-	precipitation_category_description,
-	relative_humidity,
-	thunder_probability,
-	weather_symbol,
-	weather_symbol_description,
-	wind_direction,
-	wind_gust_speed,
-	wind_speed,
-	wind_speed_description
-FROM
-	smhi_forecast
-WHERE
-	name = $1
-	AND substr(timestamp, 0, 11) = $2
-ORDER BY timestamp`
-
-const SMHI_FORECAST_CURRENT_SELECT_SQL_POSTGRES = `SELECT
-	id,
-	timestamp,
-	inserted_at,
-	updated_at,
-	round(air_pressure, 1),
-	round(air_temperature, 1),
-	round(horizontal_visibility, 1),
-	round(maximum_precipitation_intensity, 1),
-	mean_precipitation_intensity,
-	mean_value_of_high_level_cloud_cover,
-	mean_value_of_low_level_cloud_cover,
-	mean_value_of_medium_level_cloud_cover,
-	mean_value_of_total_cloud_cover,
-	round(median_precipitation_intensity, 1),
-	round(minimum_precipitation_intensity, 1),
-	percent_of_precipitation_in_frozen_form,
-	precipitation_category,
-	precipitation_category_description,
-	relative_humidity,
-	thunder_probability,
-	weather_symbol,
-	weather_symbol_description,
-	wind_direction,
-	round(wind_gust_speed, 1),
-	round(wind_speed, 1),
-	wind_speed_description
-FROM
-	smhi_forecast
-WHERE
-	name = $1
-	AND substr(timestamp::text, 0, 11) = $2
-ORDER BY timestamp`
+// smhiConfig holds the configuration of the SMHI module.
+type smhiConfig struct {
+	EnableSMHI            bool   `json:"enableSMHI"`
+	SMHILanguage          string `json:"smhiLanguage"`
+	SMHICmdWeather        string `json:"smhiCmdWeather"`
+	SMHIMsgWeatherError   string `json:"smhiMsgWeatherError"`
+	SMHIMsgWeather        string `json:"smhiMsgWeather"`
+	SMHIMsgWeatherFull    string `json:"smhiMsgWeatherFull"`
+	SMHIMsgSun            string `json:"smhiMsgSun"`
+	SMHIForecastLocations map[string]struct {
+		Alias     string  `json:"alias"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureSMHI,
+		config:  func(b *bot) interface{} { return &b.config.smhi },
+		enabled: func(b *bot) bool { return b.config.smhi.EnableSMHI },
+		init:    (*bot).initSMHIDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.smhi.SMHICmdWeather,
+				usage:   "<location[,location]> [fullforecast|sun] [tomorrow|date] [hour]",
+				minArgs: 1,
+				maxArgs: unlimitedArgs,
+				feature: featureSMHI,
+				fn:      (*bot).smhiCommand,
+			}}
+		},
+		run: (*bot).smhiGetForecasts,
+	})
+}
 
 // initSMHIDefaults sets default values for all settings.
 func (b *bot) initSMHIDefaults() {
-	if b.IRC.SMHILanguage == "" {
-		b.IRC.SMHILanguage = "en-US"
+	if b.config.smhi.SMHILanguage == "" {
+		b.config.smhi.SMHILanguage = "en-US"
 	}
-	if b.IRC.SMHICmdWeather == "" {
-		b.IRC.SMHICmdWeather = "!smhi"
+	if b.config.smhi.SMHICmdWeather == "" {
+		b.config.smhi.SMHICmdWeather = "smhi"
 	}
-	if b.IRC.SMHIMsgWeatherError == "" {
-		b.IRC.SMHIMsgWeatherError = "unable to find forecast"
+	if b.config.smhi.SMHIMsgWeatherError == "" {
+		b.config.smhi.SMHIMsgWeatherError = "unable to find forecast"
 	}
-	if b.IRC.SMHIMsgWeather == "" {
-		b.IRC.SMHIMsgWeather = "<weather_symbol_description>, <air_temperature> C"
+	if b.config.smhi.SMHIMsgWeather == "" {
+		b.config.smhi.SMHIMsgWeather = "<weather_symbol_description>, <air_temperature> C"
 	}
-	if b.IRC.SMHIMsgWeatherFull == "" {
-		b.IRC.SMHIMsgWeather = "<date> <time>, <weather_symbol_description>, <air_temperature> C"
+	if b.config.smhi.SMHIMsgWeatherFull == "" {
+		b.config.smhi.SMHIMsgWeather = "<date> <time>, <weather_symbol_description>, <air_temperature> C"
 	}
-	if b.IRC.SMHIMsgSun == "" {
-		b.IRC.SMHIMsgSun = "sunrise: <sunrise>, sunset: <sunset>, total sun time: <sun_hours>h <sun_minutes>m"
+	if b.config.smhi.SMHIMsgSun == "" {
+		b.config.smhi.SMHIMsgSun = "sunrise: <sunrise>, sunset: <sunset>, total sun time: <sun_hours>h <sun_minutes>m"
 	}
 }
 
@@ -120,35 +88,13 @@ var smhiForecastCmdRegexp = regexp.MustCompile(fmt.Sprintf(`^%s%s%s%s%s%s%s$`,
 	smhiForecastCmdRegexpTime,
 ))
 
-// smhiCommandHandler handles the commands issued from the IRC channel.
-func (b *bot) smhiCommandHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	// Not our channel, return.
-	if !a.validChannel {
-		return
-	}
-
-	// Not a SMHI command, return.
-	if a.cmd != b.IRC.SMHICmdWeather {
-		return
-	}
-
-	// Use should be ignored, return.
-	if b.shouldIgnore(m) {
-		return
-	}
-
-	// Not enough args, return.
-	if len(a.args) < 1 {
-		return
-	}
-
+// smhiCommand handles the commands issued from the IRC channel.
+func (b *bot) smhiCommand(a *privmsgAction) {
 	// Not enough args, return.
 	parts := smhiForecastCmdRegexp.FindStringSubmatch(strings.Join(a.args, " "))
 
 	if len(parts) == 0 {
-		b.privmsg(b.IRC.SMHIMsgWeatherError)
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
 		return
 	}
 
@@ -160,8 +106,8 @@ func (b *bot) smhiCommandHandler(m *irc.Message) {
 	printAll := false
 	if len(nicks) == 1 && nicks[0] == "*" {
 		printAll = true
-		for n := range b.IRC.SMHIForecastLocations {
-			nicks = append(nicks, n)
+		for nick := range b.config.smhi.SMHIForecastLocations {
+			nicks = append(nicks, nick)
 		}
 	}
 
@@ -175,11 +121,11 @@ func (b *bot) smhiCommandHandler(m *irc.Message) {
 	// to todays date.
 	var d string
 	if len(parts[6]) > 0 {
-		d = newDateWithDuration(time.Hour * 24)
+		d = b.today(1)
 	} else if len(parts[7]) > 0 {
 		d = parts[7]
 	} else {
-		d = newDate()
+		d = b.today(0)
 	}
 
 	// If we've got a match we use the submitted value, otherwise fallback
@@ -188,13 +134,13 @@ func (b *bot) smhiCommandHandler(m *irc.Message) {
 	if len(parts[12]) > 0 {
 		h = stringToInt(parts[12])
 	} else {
-		h = stringToInt(newHour())
+		h = time.Now().In(b.timezone).Hour()
 	}
 
 	// Iterate over the nicks.
-	for _, n := range nicks {
+	for _, nick := range nicks {
 		// The nick wasn't found, continue.
-		data, hasName := b.IRC.SMHIForecastLocations[n]
+		data, hasName := b.config.smhi.SMHIForecastLocations[nick]
 		if !hasName {
 			continue
 		}
@@ -210,73 +156,39 @@ func (b *bot) smhiCommandHandler(m *irc.Message) {
 		if len(data.Alias) > 0 {
 			name = data.Alias
 		} else {
-			name = n
+			name = nick
 		}
 
 		if subCmd == "forecast" {
-			b.smhiPrintForecast(name, n, d, h)
+			b.smhiPrintForecast(a, name, nick, d, h)
 		} else if subCmd == "fullforecast" || subCmd == "prognos" {
-			b.smhiPrintFullForecast(name, n)
+			b.smhiPrintFullForecast(a, name, nick)
 		} else {
-			b.smhiPrintSun(name, n, d)
+			b.smhiPrintSun(a, name, nick, d)
 		}
 	}
 }
 
-// smhiPrintForecast prints the forecast for the given name, nick date and
-// hour.
-func (b *bot) smhiPrintForecast(name, n, d string, h int) {
-	selectQuery := SMHI_FORECAST_CURRENT_SELECT_SQL_SQLITE
-	if b.DB.Engine == "postgres" {
-		selectQuery = SMHI_FORECAST_CURRENT_SELECT_SQL_POSTGRES
+// smhiPrintForecast replies with the forecast for the given name, nick date
+// and hour.
+func (b *bot) smhiPrintForecast(a *privmsgAction, name, nick, d string, h int) {
+	from, to, err := dayRange(d, b.timezone)
+	if err != nil {
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
+		return
 	}
 
-	// Execute the query and return the results.
-	rows, err := b.query(selectQuery, name, d)
+	forecasts, err := b.store.smhiForecast.between(name, from, to)
 	if err != nil {
-		b.privmsg(b.IRC.SMHIMsgWeatherError)
+		b.metrics.dbErrors.inc()
+		b.log(featureSMHI).Errorf("smhiPrintForecast: %v", err)
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
 		return
 	}
-	defer rows.Close()
-
-	// Append forecasts for each returned row.
-	var forecasts []smhiForecast
-	for rows.Next() {
-		var fc smhiForecast
-		err = rows.Scan(
-			&fc.Id,
-			&fc.Timestamp,
-			&fc.InsertedAt,
-			&fc.UpdatedAt,
-			&fc.AirPressure,
-			&fc.AirTemperature,
-			&fc.HorizontalVisibility,
-			&fc.MaximumPrecipitationIntensity,
-			&fc.MeanPrecipitationIntensity,
-			&fc.MeanValueOfHighLevelCloudCover,
-			&fc.MeanValueOfLowLevelCloudCover,
-			&fc.MeanValueOfMediumLevelCloudCover,
-			&fc.MeanValueOfTotalCloudCover,
-			&fc.MedianPrecipitationIntensity,
-			&fc.MinimumPrecipitationIntensity,
-			&fc.PercentOfPrecipitationInFrozenForm,
-			&fc.PrecipitationCategory,
-			&fc.PrecipitationCategoryDescription,
-			&fc.RelativeHumidity,
-			&fc.ThunderProbability,
-			&fc.WeatherSymbol,
-			&fc.WeatherSymbolDescription,
-			&fc.WindDirection,
-			&fc.WindGustSpeed,
-			&fc.WindSpeed,
-			&fc.WindSpeedDescription,
-		)
-		forecasts = append(forecasts, fc)
-	}
 
 	// No forecasts found, return early.
 	if len(forecasts) == 0 {
-		b.privmsg(b.IRC.SMHIMsgWeatherError)
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
 		return
 	}
 
@@ -289,7 +201,7 @@ func (b *bot) smhiPrintForecast(name, n, d string, h int) {
 	if len(forecasts) < 24 {
 		var l []int
 		for _, f := range forecasts {
-			l = append(l, stringToInt(f.Timestamp[11:13]))
+			l = append(l, f.Timestamp.In(b.timezone).Hour())
 		}
 
 		idx = sort.SearchInts(l, h)
@@ -304,17 +216,17 @@ func (b *bot) smhiPrintForecast(name, n, d string, h int) {
 	var fc *smhiForecast = &forecasts[idx]
 
 	// Send the message.
-	b.privmsgph(b.IRC.SMHIMsgWeather, map[string]string{
+	a.replyph(b.config.smhi.SMHIMsgWeather, map[string]string{
 		"<id>":                                   fc.Id,
-		"<timestamp>":                            fc.Timestamp,
-		"<date>":                                 fc.Timestamp[0:10],
-		"<time>":                                 fc.Timestamp[11:16],
-		"<inserted_at>":                          fc.InsertedAt,
-		"<updated_at>":                           fc.UpdatedAt,
-		"<nick>":                                 n,
+		"<timestamp>":                            fc.Timestamp.In(b.timezone).Format("2006-01-02T15:04:05"),
+		"<date>":                                 fc.Timestamp.In(b.timezone).Format("2006-01-02"),
+		"<time>":                                 fc.Timestamp.In(b.timezone).Format("15:04"),
+		"<inserted_at>":                          b.formatTime(fc.InsertedAt),
+		"<updated_at>":                           b.formatTime(fc.UpdatedAt),
+		"<nick>":                                 nick,
 		"<name>":                                 name,
 		"<air_pressure>":                         fc.AirPressure,
-		"<air_temperature>":                      fmtNumber(fc.AirTemperature, b.IRC.SMHILanguage),
+		"<air_temperature>":                      fmtNumber(fc.AirTemperature, b.config.smhi.SMHILanguage),
 		"<horizontal_visibility>":                fc.HorizontalVisibility,
 		"<maximum_precipitation_intensity>":      fc.MaximumPrecipitationIntensity,
 		"<mean_precipitation_intensity>":         fc.MeanPrecipitationIntensity,
@@ -333,73 +245,25 @@ func (b *bot) smhiPrintForecast(name, n, d string, h int) {
 		"<weather_symbol_description>":              fc.WeatherSymbolDescription,
 		"<wind_direction>":                          fc.WindDirection,
 		"<wind_gust_speed>":                         fc.WindGustSpeed,
-		"<wind_speed>":                              fmtNumber(fc.WindSpeed, b.IRC.SMHILanguage),
+		"<wind_speed>":                              fmtNumber(fc.WindSpeed, b.config.smhi.SMHILanguage),
 		"<wind_speed_description>":                  fc.WindSpeedDescription,
 	})
 }
 
-func (b *bot) smhiPrintFullForecast(name, n string) {
-	selectQuery := strings.Replace(
-		SMHI_FORECAST_CURRENT_SELECT_SQL_SQLITE,
-		"AND substr(timestamp, 0, 11) = $2",
-		"AND timestamp >= current_timestamp",
-		-1,
-	)
-	if b.DB.Engine == "postgres" {
-		selectQuery = strings.Replace(
-			SMHI_FORECAST_CURRENT_SELECT_SQL_POSTGRES,
-			"AND substr(timestamp::text, 0, 11) = $2",
-			"AND timestamp >= now()",
-			-1,
-		)
-	}
-
-	// Execute the query and return the results.
-	rows, err := b.query(selectQuery, name)
+// smhiPrintFullForecast uploads all upcoming forecasts for the given name to
+// a pastebin and replies with the url.
+func (b *bot) smhiPrintFullForecast(a *privmsgAction, name, nick string) {
+	forecasts, err := b.store.smhiForecast.upcoming(name)
 	if err != nil {
-		b.privmsg(b.IRC.SMHIMsgWeatherError)
+		b.metrics.dbErrors.inc()
+		b.log(featureSMHI).Errorf("smhiPrintFullForecast: %v", err)
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
 		return
 	}
-	defer rows.Close()
-
-	// Append forecasts for each returned row.
-	var forecasts []smhiForecast
-	for rows.Next() {
-		var fc smhiForecast
-		err = rows.Scan(
-			&fc.Id,
-			&fc.Timestamp,
-			&fc.InsertedAt,
-			&fc.UpdatedAt,
-			&fc.AirPressure,
-			&fc.AirTemperature,
-			&fc.HorizontalVisibility,
-			&fc.MaximumPrecipitationIntensity,
-			&fc.MeanPrecipitationIntensity,
-			&fc.MeanValueOfHighLevelCloudCover,
-			&fc.MeanValueOfLowLevelCloudCover,
-			&fc.MeanValueOfMediumLevelCloudCover,
-			&fc.MeanValueOfTotalCloudCover,
-			&fc.MedianPrecipitationIntensity,
-			&fc.MinimumPrecipitationIntensity,
-			&fc.PercentOfPrecipitationInFrozenForm,
-			&fc.PrecipitationCategory,
-			&fc.PrecipitationCategoryDescription,
-			&fc.RelativeHumidity,
-			&fc.ThunderProbability,
-			&fc.WeatherSymbol,
-			&fc.WeatherSymbolDescription,
-			&fc.WindDirection,
-			&fc.WindGustSpeed,
-			&fc.WindSpeed,
-			&fc.WindSpeedDescription,
-		)
-		forecasts = append(forecasts, fc)
-	}
 
 	// No forecasts found, return early.
 	if len(forecasts) == 0 {
-		b.privmsg(b.IRC.SMHIMsgWeatherError)
+		a.replyUrgent(b.config.smhi.SMHIMsgWeatherError)
 		return
 	}
 
@@ -407,15 +271,15 @@ func (b *bot) smhiPrintFullForecast(name, n string) {
 	for _, fc := range forecasts {
 		data := map[string]string{
 			"<id>":                                   fc.Id,
-			"<timestamp>":                            fc.Timestamp,
-			"<date>":                                 fc.Timestamp[0:10],
-			"<time>":                                 fc.Timestamp[11:16],
-			"<inserted_at>":                          fc.InsertedAt,
-			"<updated_at>":                           fc.UpdatedAt,
-			"<nick>":                                 n,
+			"<timestamp>":                            fc.Timestamp.In(b.timezone).Format("2006-01-02T15:04:05"),
+			"<date>":                                 fc.Timestamp.In(b.timezone).Format("2006-01-02"),
+			"<time>":                                 fc.Timestamp.In(b.timezone).Format("15:04"),
+			"<inserted_at>":                          b.formatTime(fc.InsertedAt),
+			"<updated_at>":                           b.formatTime(fc.UpdatedAt),
+			"<nick>":                                 nick,
 			"<name>":                                 name,
 			"<air_pressure>":                         fc.AirPressure,
-			"<air_temperature>":                      fmtNumber(fc.AirTemperature, b.IRC.SMHILanguage),
+			"<air_temperature>":                      fmtNumber(fc.AirTemperature, b.config.smhi.SMHILanguage),
 			"<horizontal_visibility>":                fc.HorizontalVisibility,
 			"<maximum_precipitation_intensity>":      fc.MaximumPrecipitationIntensity,
 			"<mean_precipitation_intensity>":         fc.MeanPrecipitationIntensity,
@@ -434,11 +298,11 @@ func (b *bot) smhiPrintFullForecast(name, n string) {
 			"<weather_symbol_description>":              fc.WeatherSymbolDescription,
 			"<wind_direction>":                          fc.WindDirection,
 			"<wind_gust_speed>":                         fc.WindGustSpeed,
-			"<wind_speed>":                              fmtNumber(fc.WindSpeed, b.IRC.SMHILanguage),
+			"<wind_speed>":                              fmtNumber(fc.WindSpeed, b.config.smhi.SMHILanguage),
 			"<wind_speed_description>":                  fc.WindSpeedDescription,
 		}
 
-		code := b.IRC.SMHIMsgWeatherFull
+		code := b.config.smhi.SMHIMsgWeatherFull
 		for k, v := range data {
 			code = strings.ReplaceAll(code, k, v)
 		}
@@ -449,17 +313,18 @@ func (b *bot) smhiPrintFullForecast(name, n string) {
 		}
 	}
 
-	b.newPaste("smhi", pastebinCode)
+	b.newPaste(a, "smhi", pastebinCode)
 }
 
-// smhiPrintSun prints the sunrise and sunset times for the requested name.
-func (b *bot) smhiPrintSun(name, n, d string) {
+// smhiPrintSun replies with the sunrise and sunset times for the requested
+// name.
+func (b *bot) smhiPrintSun(a *privmsgAction, name, nick, d string) {
 	// Parse the given date into a time.Time object.
 	t, _ := time.Parse("2006-01-02", fmt.Sprintf("%s", d))
 
 	// Fetch the coordinates for the given name and calculate the sunrise
 	// and sunset.
-	coord, _ := b.IRC.SMHIForecastLocations[name]
+	coord, _ := b.config.smhi.SMHIForecastLocations[name]
 	rise, set := sunrise.SunriseSunset(
 		coord.Latitude, coord.Longitude,
 		t.Year(), t.Month(), t.Day(),
@@ -471,42 +336,12 @@ func (b *bot) smhiPrintSun(name, n, d string) {
 	minutes := math.Floor(diff.Minutes() - hours*60)
 
 	// Return the message.
-	b.privmsgph(b.IRC.SMHIMsgSun, map[string]string{
+	a.replyph(b.config.smhi.SMHIMsgSun, map[string]string{
 		"<sunrise>":     rise.In(b.timezone).Format("15:04"),
 		"<sunset>":      set.In(b.timezone).Format("15:04"),
 		"<sun_hours>":   fmt.Sprintf("%.0f", hours),
 		"<sun_minutes>": fmt.Sprintf("%.0f", minutes),
-		"<nick>":        n,
+		"<nick>":        nick,
 		"<name>":        name,
 	})
 }
-
-// smhiForecast contains all the values that are read from the database.
-type smhiForecast struct {
-	Id                                 string
-	Timestamp                          string
-	InsertedAt                         string
-	UpdatedAt                          string
-	AirPressure                        string
-	AirTemperature                     string
-	HorizontalVisibility               string
-	MaximumPrecipitationIntensity      string
-	MeanPrecipitationIntensity         string
-	MeanValueOfHighLevelCloudCover     string
-	MeanValueOfLowLevelCloudCover      string
-	MeanValueOfMediumLevelCloudCover   string
-	MeanValueOfTotalCloudCover         string
-	MedianPrecipitationIntensity       string
-	MinimumPrecipitationIntensity      string
-	PercentOfPrecipitationInFrozenForm string
-	PrecipitationCategory              string
-	PrecipitationCategoryDescription   string
-	RelativeHumidity                   string
-	ThunderProbability                 string
-	WeatherSymbol                      string
-	WeatherSymbolDescription           string
-	WindDirection                      string
-	WindGustSpeed                      string
-	WindSpeed                          string
-	WindSpeedDescription               string
-}
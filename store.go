@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// dialect builds the parts of the SQL that differs between the database
+// engines, the stores use it so that the handlers never have to care about
+// which engine the bot is running on.
+type dialect interface {
+	// ilike returns a condition that matches the column against the LIKE
+	// pattern in param without regard to case.
+	ilike(column, param string) string
+
+	// round returns an expression that rounds the numeric column to one
+	// decimal.
+	round(column string) string
+
+	// upsert returns a statement that inserts the columns into the table,
+	// the row is replaced if there's already a row with the same key.
+	upsert(table string, columns []string, key string) string
+}
+
+// newDialect returns the dialect of the engine, empty defaults to sqlite.
+func newDialect(engine string) dialect {
+	if engine == "postgres" {
+		return postgresDialect{}
+	}
+
+	return sqliteDialect{}
+}
+
+// placeholders returns the placeholders $1 to $n separated by commas.
+func placeholders(n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	return strings.Join(p, ", ")
+}
+
+// stores holds one typed store for each table that the modules read and
+// write, they are created by initDB once the migrations have been run.
+type stores struct {
+	log            *logStore
+	factoid        *factoidStore
+	cron           *cronStore
+	quizStat       *quizStatStore
+	urlCheck       *urlCheckStore
+	parcelTracking *parcelTrackingStore
+	smhiForecast   *smhiForecastStore
+}
+
+// newStores returns the stores of the database.
+func newStores(db *sql.DB, d dialect) *stores {
+	t := table{db, d}
+
+	return &stores{
+		log:            &logStore{t},
+		factoid:        &factoidStore{t},
+		cron:           &cronStore{t},
+		quizStat:       &quizStatStore{t},
+		urlCheck:       &urlCheckStore{t},
+		parcelTracking: &parcelTrackingStore{t},
+		smhiForecast:   &smhiForecastStore{t},
+	}
+}
+
+// table holds what every store needs to query its table.
+type table struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// affected executes the statement and returns true if it changed at least
+// one row.
+func (t table) affected(query string, args ...interface{}) (bool, error) {
+	n, err := t.rowsAffected(query, args...)
+	return n > 0, err
+}
+
+// rowsAffected executes the statement and returns how many rows it changed.
+func (t table) rowsAffected(query string, args ...interface{}) (int64, error) {
+	res, err := t.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// cronEntry is a row in the cron table.
+type cronEntry struct {
+	ID         string
+	Network    string
+	Channel    string
+	Expression string
+	Message    string
+	IsLimited  bool
+	ExecCount  int
+	ExecLimit  int
+	InsertedAt time.Time
+}
+
+// exhausted returns true if the job is limited and has reached its limit.
+func (c cronEntry) exhausted() bool {
+	return c.IsLimited && c.ExecCount >= c.ExecLimit
+}
+
+// cronStore reads and writes the cron table.
+type cronStore struct {
+	table
+}
+
+// cronColumns are the columns that are scanned by scanCronEntries.
+const cronColumns = "id, network, channel, expression, message, is_limited, exec_count, exec_limit, inserted_at"
+
+// scanCronEntries scans all rows, which must have the cronColumns, and
+// closes them.
+func scanCronEntries(rows *sql.Rows, err error) ([]cronEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []cronEntry
+	for rows.Next() {
+		var c cronEntry
+		if err := rows.Scan(&c.ID, &c.Network, &c.Channel, &c.Expression, &c.Message, &c.IsLimited, &c.ExecCount, &c.ExecLimit, dbTime{&c.InsertedAt}); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, c)
+	}
+
+	return jobs, rows.Err()
+}
+
+// insert stores the job.
+func (s *cronStore) insert(c cronEntry) error {
+	_, err := s.db.Exec("INSERT INTO cron ("+cronColumns+", is_deleted) VALUES("+placeholders(9)+", false)", c.ID, c.Network, c.Channel, c.Expression, c.Message, c.IsLimited, c.ExecCount, c.ExecLimit, formatTimestamp(c.InsertedAt))
+	return err
+}
+
+// active returns all jobs that aren't deleted.
+func (s *cronStore) active() ([]cronEntry, error) {
+	return scanCronEntries(s.db.Query("SELECT " + cronColumns + " FROM cron WHERE is_deleted = false"))
+}
+
+// inChannel returns the jobs of the channel that aren't deleted.
+func (s *cronStore) inChannel(network, channel string) ([]cronEntry, error) {
+	return scanCronEntries(s.db.Query("SELECT "+cronColumns+" FROM cron WHERE is_deleted = false AND network = $1 AND channel = $2", network, channel))
+}
+
+// delete deletes the job of the channel, true is returned if there was such
+// a job.
+func (s *cronStore) delete(id, network, channel string) (bool, error) {
+	return s.affected("UPDATE cron SET is_deleted = true WHERE id = $1 AND network = $2 AND channel = $3", id, network, channel)
+}
+
+// update sets the expression and message of the job of the channel, true is
+// returned if there was such a job.
+func (s *cronStore) update(id, network, channel, expression, message string, updatedAt time.Time) (bool, error) {
+	return s.affected("UPDATE cron SET expression = $1, message = $2, updated_at = $3 WHERE id = $4 AND network = $5 AND channel = $6 AND is_deleted = false", expression, message, formatTimestamp(updatedAt), id, network, channel)
+}
+
+// setExecCount sets how many times the job has been executed.
+func (s *cronStore) setExecCount(id string, execCount int) error {
+	_, err := s.db.Exec("UPDATE cron SET exec_count = $1 WHERE id = $2", execCount, id)
+	return err
+}
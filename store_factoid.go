@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// factoidEntry is a row in the factoid table.
+type factoidEntry struct {
+	ID        string
+	Timestamp time.Time
+	Network   string
+	Channel   string
+	Author    string
+	Trigger   string
+	Reply     string
+	Rate      *int
+	Deleted   bool
+}
+
+// factoidFilter limits the factoids that are listed. Trigger, author and
+// reply matches the factoids that contains the value without regard to case
+// and any matches the factoids where either of them does. Network and
+// channel must match exactly. Deleted factoids are only listed if deleted
+// is true, all factoids are listed if it's nil.
+type factoidFilter struct {
+	Trigger string
+	Author  string
+	Reply   string
+	Any     string
+	Network string
+	Channel string
+	Deleted *bool
+}
+
+// factoidStore reads and writes the factoid table.
+type factoidStore struct {
+	table
+}
+
+// factoidColumns are the columns that are scanned by scanFactoids.
+const factoidColumns = "id, timestamp, network, channel, author, trigger, reply, rate, is_deleted"
+
+// scanFactoids scans all rows, which must have the factoidColumns, and
+// closes them.
+func scanFactoids(rows *sql.Rows, err error) ([]factoidEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var factoids []factoidEntry
+	for rows.Next() {
+		var f factoidEntry
+		if err := rows.Scan(&f.ID, dbTime{&f.Timestamp}, &f.Network, &f.Channel, &f.Author, &f.Trigger, &f.Reply, &f.Rate, &f.Deleted); err != nil {
+			return nil, err
+		}
+		factoids = append(factoids, f)
+	}
+
+	return factoids, rows.Err()
+}
+
+// insert stores the factoid.
+func (s *factoidStore) insert(f factoidEntry) error {
+	_, err := s.db.Exec("INSERT INTO factoid ("+factoidColumns+") VALUES("+placeholders(9)+")", f.ID, formatTimestamp(f.Timestamp), f.Network, f.Channel, f.Author, f.Trigger, f.Reply, f.Rate, f.Deleted)
+	return err
+}
+
+// get returns the factoid with the given id, false is returned if it doesn't
+// exist.
+func (s *factoidStore) get(id string) (factoidEntry, bool, error) {
+	factoids, err := scanFactoids(s.db.Query("SELECT "+factoidColumns+" FROM factoid WHERE id = $1", id))
+	if err != nil || len(factoids) == 0 {
+		return factoidEntry{}, false, err
+	}
+
+	return factoids[0], true, nil
+}
+
+// setDeleted deletes or restores the factoid, true is returned if the
+// factoid exists and wasn't already deleted or restored. The factoid is only
+// hidden when it's deleted so that it can be restored.
+func (s *factoidStore) setDeleted(id string, deleted bool) (bool, error) {
+	return s.affected("UPDATE factoid SET is_deleted = $1 WHERE id = $2 AND is_deleted = $3", deleted, id, !deleted)
+}
+
+// byTrigger returns the factoids of the trigger that aren't deleted.
+func (s *factoidStore) byTrigger(trigger string) ([]factoidEntry, error) {
+	return scanFactoids(s.db.Query("SELECT "+factoidColumns+" FROM factoid WHERE trigger = $1 AND is_deleted = false", trigger))
+}
+
+// count returns the number of factoids of the trigger that aren't deleted.
+func (s *factoidStore) count(trigger string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM factoid WHERE trigger = $1 AND is_deleted = false", trigger).Scan(&count)
+	return count, err
+}
+
+// match returns the factoids that aren't deleted where the column, which
+// must be trigger, author or reply, matches the LIKE pattern without regard
+// to case.
+func (s *factoidStore) match(column, pattern string) ([]factoidEntry, error) {
+	switch column {
+	case "trigger", "author", "reply":
+	default:
+		return nil, fmt.Errorf("can't match factoids on %s", column)
+	}
+
+	return scanFactoids(s.db.Query("SELECT "+factoidColumns+" FROM factoid WHERE "+s.dialect.ilike(column, "$1")+" AND is_deleted = false", pattern))
+}
+
+// list returns the factoids that matches the filter, ordered by when they
+// were added, and the total number of factoids that matches it.
+func (s *factoidStore) list(filter factoidFilter, limit, offset int) ([]factoidEntry, int, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, c := range []struct{ column, value string }{{"trigger", filter.Trigger}, {"author", filter.Author}, {"reply", filter.Reply}} {
+		if c.value != "" {
+			where = append(where, fmt.Sprintf(`LOWER(%s) LIKE %s ESCAPE '\'`, c.column, arg(likePattern(c.value))))
+		}
+	}
+	if filter.Any != "" {
+		p := arg(likePattern(filter.Any))
+		where = append(where, fmt.Sprintf(`(LOWER(trigger) LIKE %[1]s ESCAPE '\' OR LOWER(author) LIKE %[1]s ESCAPE '\' OR LOWER(reply) LIKE %[1]s ESCAPE '\')`, p))
+	}
+	if filter.Network != "" {
+		where = append(where, "network = "+arg(filter.Network))
+	}
+	if filter.Channel != "" {
+		where = append(where, "channel = "+arg(filter.Channel))
+	}
+	if filter.Deleted != nil {
+		where = append(where, "is_deleted = "+arg(*filter.Deleted))
+	}
+
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM factoid"+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM factoid%s ORDER BY timestamp, id LIMIT %s OFFSET %s", factoidColumns, cond, arg(limit), arg(offset))
+	factoids, err := scanFactoids(s.db.Query(query, args...))
+	return factoids, total, err
+}
+
+// likePattern returns a LIKE pattern that matches strings that contains s,
+// the wildcards of s are escaped.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// logEntry is a message in the log table.
+type logEntry struct {
+	ID        string
+	Timestamp time.Time
+	Network   string
+	Channel   string
+	Nick      string
+	Message   string
+}
+
+// logChannel is a summary of the logged messages of a channel.
+type logChannel struct {
+	Network     string
+	Channel     string
+	Messages    int
+	LastMessage time.Time
+}
+
+// logNickStats is a summary of the logged messages of a nick, first and
+// last are zero if the nick hasn't written anything.
+type logNickStats struct {
+	Messages int
+	First    time.Time
+	Last     time.Time
+}
+
+// logStore reads and writes the log table.
+type logStore struct {
+	table
+}
+
+// logEntryColumns are the columns that are scanned by scanLogEntries.
+const logEntryColumns = "id, timestamp, network, channel, nick, message"
+
+// scanLogEntries scans all rows, which must have the logEntryColumns, and
+// closes them.
+func scanLogEntries(rows *sql.Rows, err error) ([]logEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []logEntry
+	for rows.Next() {
+		var e logEntry
+		if err := rows.Scan(&e.ID, dbTime{&e.Timestamp}, &e.Network, &e.Channel, &e.Nick, &e.Message); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// insert stores the message.
+func (s *logStore) insert(e logEntry) error {
+	_, err := s.db.Exec("INSERT INTO log ("+logEntryColumns+") VALUES("+placeholders(6)+")", e.ID, formatTimestamp(e.Timestamp), e.Network, e.Channel, e.Nick, e.Message)
+	return err
+}
+
+// lastByNick returns the latest message of the nick in the channel, false
+// is returned if the nick hasn't written anything there.
+func (s *logStore) lastByNick(network, channel, nick string) (logEntry, bool, error) {
+	entries, err := scanLogEntries(s.db.Query("SELECT "+logEntryColumns+" FROM log WHERE network = $1 AND channel = $2 AND nick = $3 ORDER BY timestamp DESC LIMIT 1", network, channel, nick))
+	if err != nil || len(entries) == 0 {
+		return logEntry{}, false, err
+	}
+
+	return entries[0], true, nil
+}
+
+// between returns the messages of the channel that were written from and
+// including from up to but not including to, the oldest message first.
+func (s *logStore) between(network, channel string, from, to time.Time) ([]logEntry, error) {
+	return scanLogEntries(s.db.Query("SELECT "+logEntryColumns+" FROM log WHERE network = $1 AND channel = $2 AND timestamp >= $3 AND timestamp < $4 ORDER BY timestamp", network, channel, formatTimestamp(from), formatTimestamp(to)))
+}
+
+// deleteBefore deletes the messages that were written before the time and
+// returns how many that were deleted.
+func (s *logStore) deleteBefore(t time.Time) (int64, error) {
+	return s.rowsAffected("DELETE FROM log WHERE timestamp < $1", formatTimestamp(t))
+}
+
+// channels returns a summary of each channel that has been logged.
+func (s *logStore) channels() ([]logChannel, error) {
+	rows, err := s.db.Query("SELECT network, channel, COUNT(*), MAX(timestamp) FROM log GROUP BY network, channel ORDER BY network, channel")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []logChannel
+	for rows.Next() {
+		var c logChannel
+		if err := rows.Scan(&c.Network, &c.Channel, &c.Messages, dbTime{&c.LastMessage}); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+
+	return channels, rows.Err()
+}
+
+// where returns the condition and arguments that limits the query to the
+// channel, if it's given, the arguments are appended to args.
+func (s *logStore) where(cond string, args []interface{}, network, channel string) (string, []interface{}) {
+	if network != "" && channel != "" {
+		cond += " AND network = $" + strconv.Itoa(len(args)+1) + " AND channel = $" + strconv.Itoa(len(args)+2)
+		args = append(args, network, channel)
+	}
+
+	return cond, args
+}
+
+// search returns the messages that contains the text without regard to
+// case, the newest message first. The search is limited to the channel if
+// it's given.
+func (s *logStore) search(text, network, channel string, limit, offset int) ([]logEntry, error) {
+	where, args := s.where(`LOWER(message) LIKE $1 ESCAPE '\'`, []interface{}{likePattern(text)}, network, channel)
+	return scanLogEntries(s.db.Query("SELECT "+logEntryColumns+" FROM log WHERE "+where+" ORDER BY timestamp DESC LIMIT "+strconv.Itoa(limit)+" OFFSET "+strconv.Itoa(offset), args...))
+}
+
+// byNick returns the messages of the nick, which is matched without regard
+// to case, the newest message first. The messages are limited to the
+// channel if it's given.
+func (s *logStore) byNick(nick, network, channel string, limit, offset int) ([]logEntry, error) {
+	where, args := s.where("LOWER(nick) = LOWER($1)", []interface{}{nick}, network, channel)
+	return scanLogEntries(s.db.Query("SELECT "+logEntryColumns+" FROM log WHERE "+where+" ORDER BY timestamp DESC LIMIT "+strconv.Itoa(limit)+" OFFSET "+strconv.Itoa(offset), args...))
+}
+
+// nickStats returns a summary of the messages of the nick, which is matched
+// the same way as in byNick.
+func (s *logStore) nickStats(nick, network, channel string) (logNickStats, error) {
+	where, args := s.where("LOWER(nick) = LOWER($1)", []interface{}{nick}, network, channel)
+
+	var stats logNickStats
+	err := s.db.QueryRow("SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM log WHERE "+where, args...).Scan(&stats.Messages, dbTime{&stats.First}, dbTime{&stats.Last})
+	return stats, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// parcelTrackingEntry is a row in the parcel_tracking table, it maps an
+// alias to a parcel id.
+type parcelTrackingEntry struct {
+	ID               string
+	Alias            string
+	ParcelTrackingID string
+	Nick             string
+	InsertedAt       time.Time
+}
+
+// parcelTrackingStore reads and writes the parcel_tracking table.
+type parcelTrackingStore struct {
+	table
+}
+
+// insert stores the alias.
+func (s *parcelTrackingStore) insert(p parcelTrackingEntry) error {
+	_, err := s.db.Exec("INSERT INTO parcel_tracking (id, alias, parcel_tracking_id, nick, inserted_at, is_deleted) VALUES("+placeholders(5)+", false)", p.ID, p.Alias, p.ParcelTrackingID, p.Nick, formatTimestamp(p.InsertedAt))
+	return err
+}
+
+// deleteAlias deletes the alias.
+func (s *parcelTrackingStore) deleteAlias(alias string) error {
+	_, err := s.db.Exec("UPDATE parcel_tracking SET is_deleted = true WHERE alias = $1", alias)
+	return err
+}
+
+// parcelID returns the parcel id of the alias, an empty string is returned
+// if there's no such alias.
+func (s *parcelTrackingStore) parcelID(alias string) (string, error) {
+	var id string
+	err := s.db.QueryRow("SELECT parcel_tracking_id FROM parcel_tracking WHERE alias = $1 AND is_deleted = false", alias).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return id, err
+}
+
+// list returns all aliases that aren't deleted, the newest alias first.
+func (s *parcelTrackingStore) list() ([]parcelTrackingEntry, error) {
+	rows, err := s.db.Query("SELECT id, alias, parcel_tracking_id, COALESCE(nick, ''), inserted_at FROM parcel_tracking WHERE is_deleted = false ORDER BY inserted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []parcelTrackingEntry
+	for rows.Next() {
+		var p parcelTrackingEntry
+		if err := rows.Scan(&p.ID, &p.Alias, &p.ParcelTrackingID, &p.Nick, dbTime{&p.InsertedAt}); err != nil {
+			return nil, err
+		}
+		entries = append(entries, p)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+)
+
+// quizStat is a row in the quiz_stat table, there's one for each correct
+// answer.
+type quizStat struct {
+	ID          string
+	Network     string
+	Channel     string
+	Nick        string
+	QuizRoundID string
+	QuizName    string
+	Category    string
+	Question    string
+	Answer      string
+	InsertedAt  time.Time
+}
+
+// quizStatStore reads and writes the quiz_stat table.
+type quizStatStore struct {
+	table
+}
+
+// insert stores the answer.
+func (s *quizStatStore) insert(q quizStat) error {
+	_, err := s.db.Exec("INSERT INTO quiz_stat (id, network, channel, nick, quiz_round_id, quiz_name, category, question, answer, inserted_at) VALUES("+placeholders(10)+")",
+		q.ID, q.Network, q.Channel, q.Nick, q.QuizRoundID, q.QuizName, q.Category, q.Question, q.Answer, formatTimestamp(q.InsertedAt))
+	return err
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/osm/smhi"
+)
+
+// smhiForecast contains all the values of a forecast that are read from the
+// database.
+type smhiForecast struct {
+	Id                                 string
+	Timestamp                          time.Time
+	InsertedAt                         time.Time
+	UpdatedAt                          time.Time
+	AirPressure                        string
+	AirTemperature                     string
+	HorizontalVisibility               string
+	MaximumPrecipitationIntensity      string
+	MeanPrecipitationIntensity         string
+	MeanValueOfHighLevelCloudCover     string
+	MeanValueOfLowLevelCloudCover      string
+	MeanValueOfMediumLevelCloudCover   string
+	MeanValueOfTotalCloudCover         string
+	MedianPrecipitationIntensity       string
+	MinimumPrecipitationIntensity      string
+	PercentOfPrecipitationInFrozenForm string
+	PrecipitationCategory              string
+	PrecipitationCategoryDescription   string
+	RelativeHumidity                   string
+	ThunderProbability                 string
+	WeatherSymbol                      string
+	WeatherSymbolDescription           string
+	WindDirection                      string
+	WindGustSpeed                      string
+	WindSpeed                          string
+	WindSpeedDescription               string
+}
+
+// smhiForecastStore reads and writes the smhi_forecast table.
+type smhiForecastStore struct {
+	table
+}
+
+// smhiForecastUpsertColumns are the columns that are written by upsert.
+var smhiForecastUpsertColumns = []string{
+	"id",
+	"hash",
+	"updated_at",
+	"timestamp",
+	"name",
+	"air_pressure",
+	"air_temperature",
+	"horizontal_visibility",
+	"maximum_precipitation_intensity",
+	"mean_precipitation_intensity",
+	"mean_value_of_high_level_cloud_cover",
+	"mean_value_of_low_level_cloud_cover",
+	"mean_value_of_medium_level_cloud_cover",
+	"mean_value_of_total_cloud_cover",
+	"median_precipitation_intensity",
+	"minimum_precipitation_intensity",
+	"percent_of_precipitation_in_frozen_form",
+	"precipitation_category",
+	"precipitation_category_description",
+	"relative_humidity",
+	"thunder_probability",
+	"weather_symbol",
+	"weather_symbol_description",
+	"wind_direction",
+	"wind_gust_speed",
+	"wind_speed",
+	"wind_speed_description",
+}
+
+// upsert stores the forecast of the location with the given name, the
+// descriptions are stored in the given language. A stored forecast with the
+// same id is replaced.
+func (s *smhiForecastStore) upsert(id, hash string, updatedAt, timestamp time.Time, name, language string, f smhi.Forecast) error {
+	_, err := s.db.Exec(s.dialect.upsert("smhi_forecast", smhiForecastUpsertColumns, "id"),
+		id,
+		hash,
+		formatTimestamp(updatedAt),
+		formatTimestamp(timestamp),
+		name,
+		f.AirPressure,
+		f.AirTemperature,
+		f.HorizontalVisibility,
+		f.MaximumPrecipitationIntensity,
+		f.MeanPrecipitationIntensity,
+		f.MeanValueOfHighLevelCloudCover,
+		f.MeanValueOfLowLevelCloudCover,
+		f.MeanValueOfMediumLevelCloudCover,
+		f.MeanValueOfTotalCloudCover,
+		f.MedianPrecipitationIntensity,
+		f.MinimumPrecipitationIntensity,
+		f.PercentOfPrecipitationInFrozenForm,
+		f.PrecipitationCategory,
+		f.PrecipitationCategoryDescription[language],
+		f.RelativeHumidity,
+		f.ThunderProbability,
+		f.WeatherSymbol,
+		f.WeatherSymbolDescription[language],
+		f.WindDirection,
+		f.WindGustSpeed,
+		f.WindSpeed,
+		f.WindSpeedDescription[language],
+	)
+	return err
+}
+
+// upcomingHashes returns the hashes of the forecasts of the location that
+// are from now on.
+func (s *smhiForecastStore) upcomingHashes(name string) (map[string]bool, error) {
+	rows, err := s.db.Query("SELECT COALESCE(hash, '') FROM smhi_forecast WHERE name = $1 AND timestamp >= $2", name, newTimestamp())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+
+	return hashes, rows.Err()
+}
+
+// selectColumns returns the columns that are scanned by scanSMHIForecasts,
+// the measurements are rounded to one decimal.
+func (s *smhiForecastStore) selectColumns() string {
+	r := s.dialect.round
+	return strings.Join([]string{
+		"id",
+		"timestamp",
+		"inserted_at",
+		"updated_at",
+		r("air_pressure"),
+		r("air_temperature"),
+		r("horizontal_visibility"),
+		r("maximum_precipitation_intensity"),
+		"mean_precipitation_intensity",
+		"mean_value_of_high_level_cloud_cover",
+		"mean_value_of_low_level_cloud_cover",
+		"mean_value_of_medium_level_cloud_cover",
+		"mean_value_of_total_cloud_cover",
+		r("median_precipitation_intensity"),
+		r("minimum_precipitation_intensity"),
+		"percent_of_precipitation_in_frozen_form",
+		"precipitation_category",
+		"precipitation_category_description",
+		"relative_humidity",
+		"thunder_probability",
+		"weather_symbol",
+		"weather_symbol_description",
+		"wind_direction",
+		r("wind_gust_speed"),
+		r("wind_speed"),
+		"COALESCE(wind_speed_description, '')",
+	}, ", ")
+}
+
+// scanSMHIForecasts scans all rows, which must have the columns of
+// selectColumns, and closes them.
+func scanSMHIForecasts(rows *sql.Rows, err error) ([]smhiForecast, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var forecasts []smhiForecast
+	for rows.Next() {
+		var fc smhiForecast
+		err := rows.Scan(
+			&fc.Id,
+			dbTime{&fc.Timestamp},
+			dbTime{&fc.InsertedAt},
+			dbTime{&fc.UpdatedAt},
+			&fc.AirPressure,
+			&fc.AirTemperature,
+			&fc.HorizontalVisibility,
+			&fc.MaximumPrecipitationIntensity,
+			&fc.MeanPrecipitationIntensity,
+			&fc.MeanValueOfHighLevelCloudCover,
+			&fc.MeanValueOfLowLevelCloudCover,
+			&fc.MeanValueOfMediumLevelCloudCover,
+			&fc.MeanValueOfTotalCloudCover,
+			&fc.MedianPrecipitationIntensity,
+			&fc.MinimumPrecipitationIntensity,
+			&fc.PercentOfPrecipitationInFrozenForm,
+			&fc.PrecipitationCategory,
+			&fc.PrecipitationCategoryDescription,
+			&fc.RelativeHumidity,
+			&fc.ThunderProbability,
+			&fc.WeatherSymbol,
+			&fc.WeatherSymbolDescription,
+			&fc.WindDirection,
+			&fc.WindGustSpeed,
+			&fc.WindSpeed,
+			&fc.WindSpeedDescription,
+		)
+		if err != nil {
+			return nil, err
+		}
+		forecasts = append(forecasts, fc)
+	}
+
+	return forecasts, rows.Err()
+}
+
+// between returns the forecasts of the location from and including from up
+// to but not including to, ordered by time.
+func (s *smhiForecastStore) between(name string, from, to time.Time) ([]smhiForecast, error) {
+	return scanSMHIForecasts(s.db.Query("SELECT "+s.selectColumns()+" FROM smhi_forecast WHERE name = $1 AND timestamp >= $2 AND timestamp < $3 ORDER BY timestamp", name, formatTimestamp(from), formatTimestamp(to)))
+}
+
+// upcoming returns the forecasts of the location from now on, ordered by
+// time.
+func (s *smhiForecastStore) upcoming(name string) ([]smhiForecast, error) {
+	return scanSMHIForecasts(s.db.Query("SELECT "+s.selectColumns()+" FROM smhi_forecast WHERE name = $1 AND timestamp >= $2 ORDER BY timestamp", name, newTimestamp()))
+}
+
+// deleteBefore deletes the forecasts of the times before the time and
+// returns how many that were deleted.
+func (s *smhiForecastStore) deleteBefore(t time.Time) (int64, error) {
+	return s.rowsAffected("DELETE FROM smhi_forecast WHERE timestamp < $1", formatTimestamp(t))
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/migrator"
+	"github.com/osm/smhi"
+)
+
+// testStores runs fn with the stores of a new SQLite database and, if the
+// BOT_TEST_POSTGRES environment variable holds a connection string, with
+// the stores of a new schema in that Postgres database. The schema is
+// dropped when the test is done.
+func testStores(t *testing.T, fn func(t *testing.T, s *stores)) {
+	t.Run("sqlite", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "bot-store-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		b := &bot{}
+		b.DB.Path = filepath.Join(dir, "bot.db")
+		if err := b.initDB(); err != nil {
+			t.Fatal(err)
+		}
+		defer b.DB.client.Close()
+
+		fn(t, b.store)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("BOT_TEST_POSTGRES")
+		if dsn == "" {
+			t.Skip("BOT_TEST_POSTGRES isn't set")
+		}
+
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		schema := "bot_test_" + strings.Replace(newUUID(), "-", "", -1)
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+		b := &bot{}
+		b.DB.Engine = "postgres"
+		b.DB.Path = postgresSearchPath(dsn, schema)
+		if err := b.initDB(); err != nil {
+			t.Fatal(err)
+		}
+		defer b.DB.client.Close()
+
+		fn(t, b.store)
+	})
+}
+
+// postgresSearchPath returns the connection string with the search path set
+// to the schema, both URLs and key value connection strings are supported.
+func postgresSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	return dsn + " search_path=" + schema
+}
+
+// utc returns the time of the timestamp, which is formatted as
+// 2006-01-02T15:04:05 and in UTC.
+func utc(timestamp string) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05", timestamp)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestDayRange(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// The clocks were moved forward on 2020-03-29, so the day is only 23
+	// hours long in Stockholm.
+	from, to, err := dayRange("2020-03-29", stockholm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(utc("2020-03-28T23:00:00")) || !to.Equal(utc("2020-03-29T22:00:00")) {
+		t.Errorf("unexpected range of 2020-03-29, %v - %v", from.UTC(), to.UTC())
+	}
+
+	if _, _, err := dayRange("yesterday", stockholm); err == nil {
+		t.Error("expected an error when the date is invalid")
+	}
+}
+
+func TestTimestampMigrationSqlite(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Stockholm"); err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "bot-migration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open(sqliteDriver, filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The timestamps were stored in the local time of the server before
+	// they were moved to UTC.
+	repo := getDatabaseRepositorySqlite("Europe/Stockholm", "test", "#bot")
+	if err := migrator.ToVersion(db, repo, 25); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"INSERT INTO log (id, timestamp, nick, message) VALUES ('1', '2020-07-01T12:00:00.5', 'alice', 'summer')",
+		"INSERT INTO log (id, timestamp, nick, message) VALUES ('2', '2020-01-01T00:30:00', 'alice', 'winter')",
+		"INSERT INTO cron (id, expression, message, is_deleted, inserted_at) VALUES ('1', '* * * * *', 'hello', false, '2020-01-01T12:00:00')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrator.ToLatest(db, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, expected := range map[string]string{
+		"SELECT timestamp FROM log WHERE id = '1'":                 "2020-07-01T10:00:00.500Z",
+		"SELECT timestamp FROM log WHERE id = '2'":                 "2019-12-31T23:30:00.000Z",
+		"SELECT inserted_at || COALESCE(updated_at, '') FROM cron": "2020-01-01T11:00:00.000Z",
+		"SELECT MIN(timestamp) || ' ' || MAX(timestamp) FROM log":  "2019-12-31T23:30:00.000Z 2020-07-01T10:00:00.500Z",
+	} {
+		var actual string
+		if err := db.QueryRow(query).Scan(&actual); err != nil || actual != expected {
+			t.Errorf("%s: expected %s, got %s, %v", query, expected, actual, err)
+		}
+	}
+}
+
+func TestTimestampMigrationLocalZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// The server runs in New York while the bot shows the times in
+	// Stockholm, the timestamps were written in the zone of the server.
+	local := time.Local
+	time.Local = newYork
+	defer func() { time.Local = local }()
+
+	dir, err := ioutil.TempDir("", "bot-migration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &bot{Timezone: "Europe/Stockholm"}
+	if tz := b.migrationTimezone(); tz != "Local" {
+		t.Fatalf("expected the migration to use the local zone, got %s", tz)
+	}
+
+	db, err := sql.Open(sqliteDriver, filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := getDatabaseRepositorySqlite(b.migrationTimezone(), "test", "#bot")
+	if err := migrator.ToVersion(db, repo, 25); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO log (id, timestamp, nick, message) VALUES ('1', '2020-07-01T12:00:00', 'alice', 'summer')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.ToLatest(db, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual string
+	if err := db.QueryRow("SELECT timestamp FROM log").Scan(&actual); err != nil || actual != "2020-07-01T16:00:00.000Z" {
+		t.Errorf("expected 2020-07-01T16:00:00.000Z, got %s, %v", actual, err)
+	}
+
+	b.DB.MigrationTimezone = "Europe/Stockholm"
+	if tz := b.migrationTimezone(); tz != "Europe/Stockholm" {
+		t.Errorf("expected the configured migration timezone, got %s", tz)
+	}
+}
+
+func TestNetworkMigrationSqlite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-migration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open(sqliteDriver, filepath.Join(dir, "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	b := &bot{}
+	b.IRC.Networks = []*network{{Name: "test", Channels: []channelConfig{{Name: "#bot"}, {Name: "#other"}}}}
+	network, channel := b.migrationNetwork()
+	if network != "test" || channel != "#bot" {
+		t.Fatalf("expected the default network and channel, got %s %s", network, channel)
+	}
+
+	// The rows that were stored before the networks and channels were
+	// recorded are moved to the default channel, the others are kept.
+	repo := getDatabaseRepositorySqlite("UTC", network, channel)
+	if err := migrator.ToVersion(db, repo, 26); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"INSERT INTO log (id, timestamp, nick, message) VALUES ('1', '2020-01-01T12:00:00.000Z', 'alice', 'old')",
+		"INSERT INTO log (id, timestamp, network, channel, nick, message) VALUES ('2', '2020-01-01T12:00:00.000Z', 'two', '#two', 'bob', 'new')",
+		"INSERT INTO factoid (id, timestamp, author, trigger, reply, is_deleted) VALUES ('1', '2020-01-01T12:00:00.000Z', 'alice', 'coffee', 'hot', false)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrator.ToLatest(db, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, expected := range map[string]string{
+		"SELECT network || ' ' || channel FROM log WHERE id = '1'": "test #bot",
+		"SELECT network || ' ' || channel FROM log WHERE id = '2'": "two #two",
+		"SELECT network || ' ' || channel FROM factoid":            "test #bot",
+	} {
+		var actual string
+		if err := db.QueryRow(query).Scan(&actual); err != nil || actual != expected {
+			t.Errorf("%s: expected %s, got %s, %v", query, expected, actual, err)
+		}
+	}
+}
+
+func TestLogStore(t *testing.T) {
+	testStores(t, func(t *testing.T, s *stores) {
+		for _, e := range []logEntry{
+			{newUUID(), utc("2020-01-01T10:00:00"), "test", "#bot", "alice", "hello 100% world"},
+			{newUUID(), utc("2020-01-01T11:00:00"), "test", "#bot", "Alice", "HELLO again"},
+			{newUUID(), utc("2020-01-02T09:00:00"), "test", "#bot", "bob", "good morning"},
+			{newUUID(), utc("2020-01-01T12:00:00"), "test", "#other", "alice", "hello there"},
+		} {
+			if err := s.log.insert(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		e, ok, err := s.log.lastByNick("test", "#bot", "alice")
+		if err != nil || !ok || e.Message != "hello 100% world" {
+			t.Errorf("expected the last message of alice in #bot, got %v, %v, %v", e, ok, err)
+		}
+		if _, ok, err := s.log.lastByNick("test", "#bot", "carol"); err != nil || ok {
+			t.Errorf("expected carol to not be found, got %v, %v", ok, err)
+		}
+
+		entries, err := s.log.between("test", "#bot", utc("2020-01-01T00:00:00"), utc("2020-01-02T00:00:00"))
+		if err != nil || len(entries) != 2 || entries[0].Nick != "alice" || entries[1].Nick != "Alice" {
+			t.Errorf("expected the two messages of 2020-01-01 in order, got %v, %v", entries, err)
+		}
+		if len(entries) > 0 && !entries[0].Timestamp.Equal(utc("2020-01-01T10:00:00")) {
+			t.Errorf("expected the timestamp to be read back in UTC, got %v", entries[0].Timestamp)
+		}
+
+		channels, err := s.log.channels()
+		if err != nil || len(channels) != 2 {
+			t.Fatalf("expected two channels, got %v, %v", channels, err)
+		}
+		if c := channels[0]; c.Channel != "#bot" || c.Messages != 3 || !c.LastMessage.Equal(utc("2020-01-02T09:00:00")) {
+			t.Errorf("unexpected summary of #bot, %v", c)
+		}
+
+		// The search is case insensitive and the wildcards of the text
+		// are matched literally.
+		entries, err = s.log.search("hello", "", "", 10, 0)
+		if err != nil || len(entries) != 3 || entries[0].Channel != "#other" {
+			t.Errorf("expected three messages with the newest first, got %v, %v", entries, err)
+		}
+		entries, err = s.log.search("100%", "test", "#bot", 10, 0)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("expected one message, got %v, %v", entries, err)
+		}
+		entries, err = s.log.search("0%", "test", "#other", 10, 0)
+		if err != nil || len(entries) != 0 {
+			t.Errorf("expected no messages, got %v, %v", entries, err)
+		}
+
+		entries, err = s.log.byNick("ALICE", "test", "#bot", 1, 1)
+		if err != nil || len(entries) != 1 || entries[0].Message != "hello 100% world" {
+			t.Errorf("expected the second newest message of alice, got %v, %v", entries, err)
+		}
+
+		stats, err := s.log.nickStats("alice", "", "")
+		if err != nil || stats.Messages != 3 || !stats.First.Equal(utc("2020-01-01T10:00:00")) || !stats.Last.Equal(utc("2020-01-01T12:00:00")) {
+			t.Errorf("unexpected stats of alice, %v, %v", stats, err)
+		}
+		stats, err = s.log.nickStats("carol", "", "")
+		if err != nil || stats.Messages != 0 || !stats.First.IsZero() {
+			t.Errorf("expected no stats of carol, %v, %v", stats, err)
+		}
+	})
+}
+
+func TestFactoidStore(t *testing.T) {
+	testStores(t, func(t *testing.T, s *stores) {
+		rate := 50
+		coffee := factoidEntry{newUUID(), utc("2020-01-01T10:00:00"), "test", "#bot", "alice", "coffee", "is hot", &rate, false}
+		tea := factoidEntry{newUUID(), utc("2020-01-01T11:00:00"), "test", "#bot", "Bob", "tea", "is Hot too", nil, false}
+		for _, f := range []factoidEntry{coffee, tea} {
+			if err := s.factoid.insert(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		f, ok, err := s.factoid.get(coffee.ID)
+		if err != nil || !ok || f.Reply != "is hot" || f.Rate == nil || *f.Rate != 50 {
+			t.Errorf("expected the coffee factoid, got %v, %v, %v", f, ok, err)
+		}
+
+		factoids, err := s.factoid.byTrigger("tea")
+		if err != nil || len(factoids) != 1 || factoids[0].Rate != nil {
+			t.Errorf("expected the tea factoid, got %v, %v", factoids, err)
+		}
+
+		// The match is case insensitive on both engines.
+		factoids, err = s.factoid.match("reply", "%HOT%")
+		if err != nil || len(factoids) != 2 {
+			t.Errorf("expected two factoids, got %v, %v", factoids, err)
+		}
+		factoids, err = s.factoid.match("author", "bob")
+		if err != nil || len(factoids) != 1 || factoids[0].ID != tea.ID {
+			t.Errorf("expected the tea factoid, got %v, %v", factoids, err)
+		}
+		if _, err := s.factoid.match("id", "%"); err == nil {
+			t.Error("expected an error when matching on id")
+		}
+
+		deleted, err := s.factoid.setDeleted(coffee.ID, true)
+		if err != nil || !deleted {
+			t.Errorf("expected the coffee factoid to be deleted, got %v, %v", deleted, err)
+		}
+		if count, err := s.factoid.count("coffee"); err != nil || count != 0 {
+			t.Errorf("expected no coffee factoids, got %v, %v", count, err)
+		}
+		if deleted, err := s.factoid.setDeleted(coffee.ID, true); err != nil || deleted {
+			t.Errorf("expected the coffee factoid to already be deleted, got %v, %v", deleted, err)
+		}
+		if deleted, err := s.factoid.setDeleted(newUUID(), true); err != nil || deleted {
+			t.Errorf("expected nothing to be deleted, got %v, %v", deleted, err)
+		}
+
+		isDeleted := false
+		factoids, total, err := s.factoid.list(factoidFilter{Any: "hot", Deleted: &isDeleted}, 10, 0)
+		if err != nil || total != 1 || len(factoids) != 1 || factoids[0].ID != tea.ID {
+			t.Errorf("expected the tea factoid, got %v, %v, %v", factoids, total, err)
+		}
+		factoids, total, err = s.factoid.list(factoidFilter{Network: "test"}, 1, 1)
+		if err != nil || total != 2 || len(factoids) != 1 || factoids[0].ID != tea.ID {
+			t.Errorf("expected the second page to hold the tea factoid, got %v, %v, %v", factoids, total, err)
+		}
+	})
+}
+
+func TestCronStore(t *testing.T) {
+	testStores(t, func(t *testing.T, s *stores) {
+		lunch := cronEntry{newUUID(), "test", "#bot", "0 12 * * *", "lunch", true, 0, 2, utc("2020-01-01T10:00:00")}
+		coffee := cronEntry{newUUID(), "test", "#other", "0 15 * * *", "coffee", false, 0, 0, utc("2020-01-01T10:00:00")}
+		for _, c := range []cronEntry{lunch, coffee} {
+			if err := s.cron.insert(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := s.cron.setExecCount(lunch.ID, 2); err != nil {
+			t.Fatal(err)
+		}
+		jobs, err := s.cron.inChannel("test", "#bot")
+		if err != nil || len(jobs) != 1 || jobs[0].ExecCount != 2 || !jobs[0].exhausted() {
+			t.Errorf("expected the exhausted lunch job, got %v, %v", jobs, err)
+		}
+
+		updated, err := s.cron.update(coffee.ID, "test", "#bot", "0 16 * * *", "tea", utc("2020-01-02T10:00:00"))
+		if err != nil || updated {
+			t.Errorf("expected the job of another channel to not be updated, got %v, %v", updated, err)
+		}
+		updated, err = s.cron.update(coffee.ID, "test", "#other", "0 16 * * *", "tea", utc("2020-01-02T10:00:00"))
+		if err != nil || !updated {
+			t.Errorf("expected the job to be updated, got %v, %v", updated, err)
+		}
+
+		deleted, err := s.cron.delete(lunch.ID, "test", "#bot")
+		if err != nil || !deleted {
+			t.Errorf("expected the job to be deleted, got %v, %v", deleted, err)
+		}
+		jobs, err = s.cron.active()
+		if err != nil || len(jobs) != 1 || jobs[0].Message != "tea" || jobs[0].Expression != "0 16 * * *" {
+			t.Errorf("expected the updated job, got %v, %v", jobs, err)
+		}
+	})
+}
+
+func TestURLCheckAndParcelTrackingStores(t *testing.T) {
+	testStores(t, func(t *testing.T, s *stores) {
+		u := urlCheckEntry{newUUID(), utc("2020-01-01T10:00:00"), "test", "#bot", "alice", "https://example.com"}
+		if err := s.urlCheck.insert(u); err != nil {
+			t.Fatal(err)
+		}
+		seen, ok, err := s.urlCheck.find("test", "#bot", u.URL)
+		if err != nil || !ok || seen.Nick != "alice" || !seen.Timestamp.Equal(u.Timestamp) {
+			t.Errorf("expected the URL to be found, got %v, %v, %v", seen, ok, err)
+		}
+		if _, ok, err := s.urlCheck.find("test", "#other", u.URL); err != nil || ok {
+			t.Errorf("expected the URL to not be found in another channel, got %v, %v", ok, err)
+		}
+
+		for _, p := range []parcelTrackingEntry{
+			{newUUID(), "shoes", "1234", "alice", utc("2020-01-01T10:00:00")},
+			{newUUID(), "books", "5678", "", utc("2020-01-02T10:00:00")},
+		} {
+			if err := s.parcelTracking.insert(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if id, err := s.parcelTracking.parcelID("shoes"); err != nil || id != "1234" {
+			t.Errorf("expected the id of shoes, got %v, %v", id, err)
+		}
+		if err := s.parcelTracking.deleteAlias("shoes"); err != nil {
+			t.Fatal(err)
+		}
+		if id, err := s.parcelTracking.parcelID("shoes"); err != nil || id != "" {
+			t.Errorf("expected shoes to be deleted, got %v, %v", id, err)
+		}
+		parcels, err := s.parcelTracking.list()
+		if err != nil || len(parcels) != 1 || parcels[0].Alias != "books" {
+			t.Errorf("expected the books parcel, got %v, %v", parcels, err)
+		}
+
+		q := quizStat{newUUID(), "test", "#bot", "alice", newUUID(), "quiz", "Geography", "Capital of Sweden?", "Stockholm", utc("2020-01-01T10:00:00")}
+		if err := s.quizStat.insert(q); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestSMHIForecastStore(t *testing.T) {
+	testStores(t, func(t *testing.T, s *stores) {
+		forecast := func(temperature float64) smhi.Forecast {
+			return smhi.Forecast{
+				AirTemperature:           temperature,
+				WindSpeed:                3.25,
+				WeatherSymbolDescription: map[string]string{"en-US": "Clear sky"},
+				WindSpeedDescription:     map[string]string{"en-US": "Light breeze"},
+			}
+		}
+
+		future := time.Now().AddDate(1, 0, 0).UTC().Truncate(time.Hour)
+		id := fmt.Sprintf("%s-%s", formatTimestamp(future), "stockholm")
+
+		// The second upsert replaces the first forecast.
+		for _, temperature := range []float64{10, 12.04} {
+			if err := s.smhiForecast.upsert(id, id+"|hash", time.Now(), future, "stockholm", "en-US", forecast(temperature)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.smhiForecast.upsert("past", "past|hash", time.Now(), utc("2000-01-01T12:00:00"), "stockholm", "en-US", forecast(0)); err != nil {
+			t.Fatal(err)
+		}
+
+		forecasts, err := s.smhiForecast.between("stockholm", future.Add(-time.Hour), future.Add(time.Hour))
+		if err != nil || len(forecasts) != 1 {
+			t.Fatalf("expected one forecast, got %v, %v", forecasts, err)
+		}
+		fc := forecasts[0]
+		if !strings.HasPrefix(fc.AirTemperature, "12") || fc.WeatherSymbolDescription != "Clear sky" || fc.WindSpeedDescription != "Light breeze" {
+			t.Errorf("unexpected forecast, %v", fc)
+		}
+
+		forecasts, err = s.smhiForecast.upcoming("stockholm")
+		if err != nil || len(forecasts) != 1 || !forecasts[0].Timestamp.Equal(future) {
+			t.Errorf("expected the upcoming forecast, got %v, %v", forecasts, err)
+		}
+
+		hashes, err := s.smhiForecast.upcomingHashes("stockholm")
+		if err != nil || len(hashes) != 1 || !hashes[id+"|hash"] {
+			t.Errorf("expected the hash of the upcoming forecast, got %v, %v", hashes, err)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// urlCheckEntry is a row in the url_check table.
+type urlCheckEntry struct {
+	ID        string
+	Timestamp time.Time
+	Network   string
+	Channel   string
+	Nick      string
+	URL       string
+}
+
+// urlCheckStore reads and writes the url_check table.
+type urlCheckStore struct {
+	table
+}
+
+// insert stores the URL.
+func (s *urlCheckStore) insert(u urlCheckEntry) error {
+	_, err := s.db.Exec("INSERT INTO url_check (id, timestamp, network, channel, nick, url) VALUES("+placeholders(6)+")", u.ID, formatTimestamp(u.Timestamp), u.Network, u.Channel, u.Nick, u.URL)
+	return err
+}
+
+// deleteBefore deletes the URLs that were seen before the time and returns
+// how many that were deleted.
+func (s *urlCheckStore) deleteBefore(t time.Time) (int64, error) {
+	return s.rowsAffected("DELETE FROM url_check WHERE timestamp < $1", formatTimestamp(t))
+}
+
+// find returns the first time the URL was seen in the channel, false is
+// returned if it hasn't been seen there.
+func (s *urlCheckStore) find(network, channel, url string) (urlCheckEntry, bool, error) {
+	rows, err := s.db.Query("SELECT id, timestamp, network, channel, nick, url FROM url_check WHERE network = $1 AND channel = $2 AND url = $3 ORDER BY timestamp LIMIT 1", network, channel, url)
+	if err != nil {
+		return urlCheckEntry{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return urlCheckEntry{}, false, rows.Err()
+	}
+
+	var u urlCheckEntry
+	err = rows.Scan(&u.ID, dbTime{&u.Timestamp}, &u.Network, &u.Channel, &u.Nick, &u.URL)
+	return u, err == nil, err
+}
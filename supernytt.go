@@ -1,27 +1,47 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
-	"net/http"
 	"time"
 )
 
+// supernyttConfig holds the configuration of the supernytt module.
+type supernyttConfig struct {
+	// Supernytt - news in Swedish from aftonbladet.
+	EnableSupernytt         bool   `json:"enableSupernytt"`
+	SupernyttGrammarMessage string `json:"supernyttGrammarMessage"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureSupernytt,
+		config:  func(b *bot) interface{} { return &b.config.supernytt },
+		enabled: func(b *bot) bool { return b.config.supernytt.EnableSupernytt },
+		init:    (*bot).initSupernytt,
+		run:     (*bot).supernyttHandler,
+	})
+}
+
 // initSupernytt sets default values for the supernytt integration.
 func (b *bot) initSupernytt() {
-	if b.IRC.SupernyttGrammarMessage == "" {
-		b.IRC.SupernyttGrammarMessage = "<title> - <content>"
+	if b.config.supernytt.SupernyttGrammarMessage == "" {
+		b.config.supernytt.SupernyttGrammarMessage = "<title> - <content>"
 	}
 }
 
 // supernyttHandler is the goroutine that handles fetching and posting of the
 // news.
-func (b *bot) supernyttHandler() {
+func (b *bot) supernyttHandler(ctx context.Context) {
 	for {
 		// Download supernytt data, if the method returns nil
 		// something went wrong and we'll immediately goto the sleep
 		// part of the loop.
+		b.configMu.RLock()
 		entries := b.getSupernyttData()
+		b.configMu.RUnlock()
 		if entries == nil {
 			goto sleep
 		}
@@ -39,36 +59,51 @@ func (b *bot) supernyttHandler() {
 			// Store it.
 			b.insertSNEntry(&e)
 
-			// Output it to the channel
-			b.privmsgph(b.IRC.SupernyttGrammarMessage, map[string]string{
-				"<title>":   e.Title.Value,
-				"<content>": e.getContent(),
-			})
+			// Output it to all channels on all networks that has
+			// supernytt enabled.
+			b.configMu.RLock()
+			for _, n := range b.IRC.Networks {
+				for _, c := range n.channelsWithFeature(featureSupernytt) {
+					n.announceph(c, b.config.supernytt.SupernyttGrammarMessage, map[string]string{
+						"<title>":   e.Title.Value,
+						"<content>": e.getContent(),
+					})
+				}
+			}
+			b.configMu.RUnlock()
 
 			// To prevent spamming we'll sleep for a minute before
 			// we proceed.
-			time.Sleep(1 * time.Minute)
+			select {
+			case <-time.After(1 * time.Minute):
+			case <-ctx.Done():
+				return
+			}
 		}
 
 	sleep:
 		// Wait 10 minutes before we fetch the news again.
-		time.Sleep(10 * time.Minute)
+		select {
+		case <-time.After(10 * time.Minute):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 // getSupernyttData downloads the latest news from supernytt and returns a
 // slice of SNEntry objects.
 func (b *bot) getSupernyttData() []SNEntry {
-	res, err := http.Get("https://direkte.vg.no/api/ab/newsflow/5d0b819f4c641c00121148c9/entries?offset=0&limit=5&showAdverts=false")
+	res, err := b.httpGet(b.baseURL("supernytt") + "/api/ab/newsflow/5d0b819f4c641c00121148c9/entries?offset=0&limit=5&showAdverts=false")
 	if err != nil {
-		b.logger.Printf("getSupernyttData: %v", err)
+		b.log(featureSupernytt).Errorf("getSupernyttData: %v", err)
 		return nil
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("getSupernyttData: %v", err)
+		b.log(featureSupernytt).Errorf("getSupernyttData: %v", err)
 		return nil
 	}
 
@@ -77,7 +112,7 @@ func (b *bot) getSupernyttData() []SNEntry {
 	}
 	err = json.Unmarshal(data, &sn)
 	if err != nil {
-		b.logger.Printf("getSupernyttData: %v", err)
+		b.log(featureSupernytt).Errorf("getSupernyttData: %v", err)
 		return nil
 	}
 
@@ -98,7 +133,8 @@ func (b *bot) hasSNExternalID(externalID string) bool {
 func (b *bot) insertSNEntry(e *SNEntry) {
 	stmt, err := b.prepare("INSERT INTO supernytt (id, external_id, title, content, external_created, inserted_at) VALUES($1, $2, $3, $4, $5, $6);")
 	if err != nil {
-		b.logger.Printf("insertSNEntry: %v", err)
+		b.metrics.dbErrors.inc()
+		b.log(featureSupernytt).Errorf("insertSNEntry: %v", err)
 		return
 	}
 	defer stmt.Close()
@@ -112,7 +148,8 @@ func (b *bot) insertSNEntry(e *SNEntry) {
 		newTimestamp(),
 	)
 	if err != nil {
-		b.logger.Printf("insertSNEntry: %v", err)
+		b.metrics.dbErrors.inc()
+		b.log(featureSupernytt).Errorf("insertSNEntry: %v", err)
 	}
 }
 
@@ -5,12 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strings"
-
-	"github.com/osm/irc"
+	"time"
 )
 
+// tenorConfig holds the configuration of the tenor module.
+type tenorConfig struct {
+	EnableTenor          bool   `json:"enableTenor"`
+	TenorCmd             string `json:"tenorCmd"`
+	TenorLang            string `json:"tenorLang"`
+	TenorAPIKey          string `json:"tenorAPIKey"`
+	TenorMsgNothingFound string `json:"tenorMsgNothingFound"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureTenor,
+		config:  func(b *bot) interface{} { return &b.config.tenor },
+		enabled: func(b *bot) bool { return b.config.tenor.EnableTenor },
+		init:    (*bot).initTenorDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.tenor.TenorCmd,
+				usage:   "<search>",
+				minArgs: 1,
+				maxArgs: unlimitedArgs,
+				feature: featureTenor,
+				fn:      (*bot).tenorCommand,
+			}}
+		},
+	})
+}
+
 // Define Tenor errors
 var (
 	TenorNoAPIKey     = errors.New("You need to set a Tenor API key")
@@ -19,70 +46,59 @@ var (
 
 // iniTenorDefaults sets default values for all settings.
 func (b *bot) initTenorDefaults() {
-	if b.IRC.TenorCmd == "" {
-		b.IRC.TenorCmd = "!tenor"
+	if b.config.tenor.TenorCmd == "" {
+		b.config.tenor.TenorCmd = "tenor"
 	}
-	if b.IRC.TenorLang == "" {
-		b.IRC.TenorLang = "en_US"
+	if b.config.tenor.TenorLang == "" {
+		b.config.tenor.TenorLang = "en_US"
 	}
-	if b.IRC.TenorMsgNothingFound == "" {
-		b.IRC.TenorMsgNothingFound = "nothing found"
+	if b.config.tenor.TenorMsgNothingFound == "" {
+		b.config.tenor.TenorMsgNothingFound = "nothing found"
 	}
 }
 
-// tenorHandler handles the Tenor integration.
-func (b *bot) tenorHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	if !a.validChannel {
-		return
-	}
-
-	if a.cmd != b.IRC.TenorCmd {
-		return
-	}
-
-	if b.shouldIgnore(m) {
-		return
-	}
-
-	if b.IRC.TenorAPIKey == "" {
-		b.logger.Printf("tenorHandler: you need to set a Tenor API key\n")
+// tenorCommand handles the Tenor integration.
+func (b *bot) tenorCommand(a *privmsgAction) {
+	if b.config.tenor.TenorAPIKey == "" {
+		b.log(featureTenor).Warnf("tenorCommand: you need to set a Tenor API key")
 		return
 	}
 
-	url, err := b.tenorSearch(a.msg)
+	url, err := b.tenorSearch(strings.Join(a.args, " "))
 	if err == TenorNothingFound {
-		b.privmsg(b.IRC.TenorMsgNothingFound)
+		a.reply(b.config.tenor.TenorMsgNothingFound)
 	} else if url != "" {
-		b.privmsg(url)
+		a.reply(url)
 	}
 }
 
 // tenorSearch search and return a random gif for the given query.
 func (b *bot) tenorSearch(query string) (string, error) {
-	if b.IRC.TenorAPIKey == "" {
-		b.logger.Printf("tenorSearch: you need to set a Tenor API key\n")
+	if b.config.tenor.TenorAPIKey == "" {
+		b.log(featureTenor).Warnf("tenorSearch: you need to set a Tenor API key")
 		return "", TenorNoAPIKey
 	}
 
 	url := fmt.Sprintf(
-		"https://api.tenor.com/v1/random?key=%s&q=%s&locale=%s&media_filter=minimal",
-		b.IRC.TenorAPIKey,
-		strings.Replace(strings.Replace(query, fmt.Sprintf("%s ", b.IRC.TenorCmd), "", 1), " ", "%20", -1),
-		b.IRC.TenorLang,
+		"%s/v1/random?key=%s&q=%s&locale=%s&media_filter=minimal",
+		b.baseURL("tenor"),
+		b.config.tenor.TenorAPIKey,
+		strings.Replace(strings.Replace(query, fmt.Sprintf("%s ", b.config.tenor.TenorCmd), "", 1), " ", "%20", -1),
+		b.config.tenor.TenorLang,
 	)
 
-	res, err := http.Get(url)
+	start := time.Now()
+	res, err := b.httpGet(url)
+	b.metrics.observeHTTP("tenor", start, res, err)
 	if err != nil {
-		b.logger.Printf("tenor: %v", err)
+		b.log(featureTenor).Errorf("tenor: %v", err)
 		return "", err
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("tenor: %v", err)
+		b.log(featureTenor).Errorf("tenor: %v", err)
 		return "", err
 	}
 
@@ -95,7 +111,7 @@ func (b *bot) tenorSearch(query string) (string, error) {
 	}
 	err = json.Unmarshal(data, &d)
 	if err != nil {
-		b.logger.Printf("tenor: %v", err)
+		b.log(featureTenor).Errorf("tenor: %v", err)
 		return "", err
 	}
 
@@ -6,29 +6,91 @@ import (
 	"time"
 )
 
+// timestampFormat is the format of the timestamps that are stored in the
+// database. The timestamps are always in UTC and the format has a fixed
+// width, so they sort in chronological order on SQLite too.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// displayTimeFormat is the format of the times that are shown to the users.
+const displayTimeFormat = "2006-01-02 15:04"
+
 // newTimestamp returns a timestamp.
 func newTimestamp() string {
-	return time.Now().Format("2006-01-02T15:04:05.999")
+	return formatTimestamp(time.Now())
 }
 
-// newUnixTimestamp returns a UNIX timestamp.
-func newUnixTimestamp() int {
-	return int(time.Now().Unix())
+// formatTimestamp returns the timestamp of the given time.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+// parseTimestamp parses a timestamp that has been stored in the database.
+func parseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse timestamp %s", s)
+	}
+
+	return t.UTC(), nil
+}
+
+// dbTime scans a timestamp column into the time it points to. Postgres
+// returns the column as a time while SQLite returns the text that
+// formatTimestamp produced, NULL is scanned as the zero time.
+type dbTime struct {
+	t *time.Time
+}
+
+// Scan implements the sql.Scanner interface.
+func (d dbTime) Scan(v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		*d.t = time.Time{}
+	case time.Time:
+		*d.t = v.UTC()
+	case string:
+		*d.t, err = parseTimestamp(v)
+	case []byte:
+		*d.t, err = parseTimestamp(string(v))
+	default:
+		err = fmt.Errorf("can't scan %T into a time", v)
+	}
+
+	return err
+}
+
+// dayRange returns the start of the date, formatted as YYYY-MM-DD, in the
+// location and the start of the following day. The day isn't always 24
+// hours long, so the end is calculated in the location as well.
+func dayRange(date string, loc *time.Location) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return start, start.AddDate(0, 0, 1), nil
 }
 
-// newDate returns the current date.
-func newDate() string {
-	return time.Now().Format("2006-01-02")
+// formatTime returns the time formatted for the users in the timezone of the
+// bot, the zero time is formatted as an empty string.
+func (b *bot) formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.In(b.timezone).Format(displayTimeFormat)
 }
 
-// newDateWithDuration returns the current date and adds the given duration.
-func newDateWithDuration(d time.Duration) string {
-	return time.Now().Add(d).Format("2006-01-02")
+// today returns the current date, formatted as YYYY-MM-DD, in the timezone
+// of the bot. The date is moved the given number of days.
+func (b *bot) today(days int) string {
+	return time.Now().In(b.timezone).AddDate(0, 0, days).Format("2006-01-02")
 }
 
-// newHour returns the current hour.
-func newHour() string {
-	return time.Now().Format("15")
+// newUnixTimestamp returns a UNIX timestamp.
+func newUnixTimestamp() int {
+	return int(time.Now().Unix())
 }
 
 // getWeek returns the week number for the given date, or the current week if
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// tlsSettings holds the TLS settings of a network.
+type tlsSettings struct {
+	// Enabled makes the bot connect to the server over TLS.
+	Enabled bool `json:"enabled"`
+
+	// CAFile is a PEM encoded bundle of certificates that are used to
+	// verify the server, the system certificates are used if it's
+	// empty.
+	CAFile string `json:"caFile"`
+
+	// InsecureSkipVerify turns off the verification of the server
+	// certificate, it should only be used for testing.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+
+	// CertFile and KeyFile is the client certificate and key, they are
+	// used for CertFP and SASL EXTERNAL.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+// clientConfig creates the TLS configuration that is used to connect to the
+// given address.
+func (s tlsSettings) clientConfig(addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+
+	if s.CAFile != "" {
+		data, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", s.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if s.CertFile != "" || s.KeyFile != "" {
+		if s.CertFile == "" || s.KeyFile == "" {
+			return nil, errors.New("both certFile and keyFile must be set")
+		}
+
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// initTLS loads the TLS configuration of the networks that has TLS enabled,
+// an error is returned if the certificates can't be loaded.
+func (b *bot) initTLS() error {
+	for _, n := range b.IRC.Networks {
+		if !n.TLS.Enabled {
+			continue
+		}
+
+		config, err := n.TLS.clientConfig(n.Address)
+		if err != nil {
+			return fmt.Errorf("%s.tls: %v in %v", n.configPath, err, b.configFile)
+		}
+		n.tlsConfig = config
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key that is generated for the tests.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate that is signed by the parent, or a self
+// signed CA certificate if parent is nil, and writes it to dir.
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, c.certFile, "CERTIFICATE", der)
+	writePEM(t, c.keyFile, "EC PRIVATE KEY", keyDER)
+
+	return c
+}
+
+// writePEM writes the PEM encoded data to the file.
+func writePEM(t *testing.T, path, typ string, data []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsServer is a minimal stand-in for a TLS IRC server. It accepts one
+// connection and reports the first line that it receives together with the
+// common name of the client certificate.
+type tlsServer struct {
+	addr  string
+	lines chan string
+	peers chan string
+}
+
+// newTLSServer starts a TLS server with a certificate that is signed by the
+// CA, client certificates signed by the CA are requested but not required.
+func newTLSServer(t *testing.T, ca, cert *testCert) *tlsServer {
+	pair, err := tls.LoadX509KeyPair(cert.certFile, cert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &tlsServer{
+		addr:  ln.Addr().String(),
+		lines: make(chan string, 1),
+		peers: make(chan string, 1),
+	}
+
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			close(s.lines)
+			return
+		}
+
+		peer := ""
+		if certs := conn.(*tls.Conn).ConnectionState().PeerCertificates; len(certs) > 0 {
+			peer = certs[0].Subject.CommonName
+		}
+		s.peers <- peer
+		s.lines <- strings.TrimSpace(line)
+	}()
+
+	return s
+}
+
+// newTLSTestBot returns a bot with a single network that connects to addr
+// with the given TLS settings.
+func newTLSTestBot(addr string, settings tlsSettings) (*bot, *network) {
+	b := &bot{logger: newLogger(&logOutput{w: ioutil.Discard})}
+	n := &network{
+		Name:       "test",
+		Address:    addr,
+		TLS:        settings,
+		configPath: "irc",
+	}
+	b.IRC.Networks = []*network{n}
+
+	return b, n
+}
+
+func TestDialTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+
+	tests := []struct {
+		name     string
+		settings tlsSettings
+		peer     string
+	}{
+		{"ca file", tlsSettings{Enabled: true, CAFile: ca.certFile}, ""},
+		{"client certificate", tlsSettings{Enabled: true, CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile}, "client"},
+		{"skip verify", tlsSettings{Enabled: true, InsecureSkipVerify: true}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTLSServer(t, ca, server)
+			b, n := newTLSTestBot(s.addr, test.settings)
+			if err := b.initTLS(); err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := b.dial(n)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+
+			select {
+			case peer := <-s.peers:
+				if peer != test.peer {
+					t.Errorf("client certificate is %q, want %q", peer, test.peer)
+				}
+				if line := <-s.lines; line != "CAP LS 302" {
+					t.Errorf("first line is %q, want %q", line, "CAP LS 302")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the server")
+			}
+		})
+	}
+}
+
+func TestDialTLSUnknownAuthority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	other := newTestCert(t, dir, "other", nil)
+
+	s := newTLSServer(t, ca, server)
+	b, n := newTLSTestBot(s.addr, tlsSettings{Enabled: true, CAFile: other.certFile})
+	if err := b.initTLS(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := b.dial(n)
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded with a certificate from an unknown authority")
+	}
+}
+
+func TestInitTLSMissingCA(t *testing.T) {
+	b, _ := newTLSTestBot("irc.example.com:6697", tlsSettings{Enabled: true, CAFile: filepath.Join("testdata", "missing.pem")})
+	if err := b.initTLS(); err == nil || !strings.HasPrefix(err.Error(), "irc.tls: ") {
+		t.Errorf("expected an error about irc.tls, got %v", err)
+	}
+}
+
+func TestTLSClientConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings tlsSettings
+	}{
+		{"missing key file", tlsSettings{Enabled: true, CertFile: "client.crt"}},
+		{"missing cert file", tlsSettings{Enabled: true, KeyFile: "client.key"}},
+		{"missing ca file", tlsSettings{Enabled: true, CAFile: "does-not-exist.crt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.settings.clientConfig("127.0.0.1:6697"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -1,75 +1,115 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
-	"net/http"
 	"regexp"
 	"time"
 )
 
+// updateNotifierConfig holds the configuration of the update notifier module.
+type updateNotifierConfig struct {
+	EnableUpdateNotifier bool     `json:"enableUpdateNotifier"`
+	UpdateNotifierMsg    string   `json:"updateNotifierMsg"`
+	UpdateNotifierNames  []string `json:"updateNotifierNames"`
+}
+
 // updateNotifierLatestCommitRegexp contains the regular expression that
 // extracts the latest commit sha1 from the github page of the bot.
 var updateNotifierLatestCommitRegexp *regexp.Regexp
 
-// init initializes the regexp.
+// init initializes the regexp and registers the module.
 func init() {
 	updateNotifierLatestCommitRegexp = regexp.MustCompile("commit-tease-sha.*([0-9a-f]{40})")
+
+	registerModule(&module{
+		name:    "updateNotifier",
+		config:  func(b *bot) interface{} { return &b.config.updateNotifier },
+		enabled: func(b *bot) bool { return b.config.updateNotifier.EnableUpdateNotifier },
+		init:    (*bot).initUpdateNotifier,
+		run:     (*bot).updateNotifierHandler,
+	})
 }
 
 // initUpdateNotifier sets default values for the update notifier.
 func (b *bot) initUpdateNotifier() {
-	if b.IRC.UpdateNotifierMsg == "" {
-		b.IRC.UpdateNotifierMsg = "new version of the bot is available (<version>)"
+	if b.config.updateNotifier.UpdateNotifierMsg == "" {
+		b.config.updateNotifier.UpdateNotifierMsg = "new version of the bot is available (<version>)"
 	}
 }
 
 // updateNotifierHandler downloads the HTML source of the main github page and
 // extracts the latest commit, if the currently running version of the bot
 // isn't equal to the latest commit we'll send a message to all users in the
-// update notifier names array.
-func (b *bot) updateNotifierHandler() {
+// update notifier names array on the default network.
+func (b *bot) updateNotifierHandler(ctx context.Context) {
 	// Return early if we don't have anyone to notify.
-	if len(b.IRC.UpdateNotifierNames) == 0 {
+	b.configMu.RLock()
+	names := len(b.config.updateNotifier.UpdateNotifierNames)
+	b.configMu.RUnlock()
+	if names == 0 {
 		return
 	}
 
 	for {
-		// Let's sleep for an hour before we perform the check.
-		time.Sleep(1 * time.Hour)
-
-		// Download the HTML source of the bot repo.
-		res, err := http.Get("https://github.com/osm/bot")
-		if err != nil {
-			b.logger.Printf("updateNotifier: %v", err)
+		// Let's sleep for an hour before we perform the check, unless
+		// the module is stopped.
+		select {
+		case <-time.After(1 * time.Hour):
+		case <-ctx.Done():
 			return
 		}
 
-		// Read the body.
-		defer res.Body.Close()
-		data, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			b.logger.Printf("updateNotifier: %v", err)
+		if b.checkForUpdate() {
 			return
 		}
+	}
+}
 
-		// Try to extract the latest commit.
-		matches := updateNotifierLatestCommitRegexp.FindStringSubmatch(string(data))
-		if matches == nil {
-			b.logger.Printf("updateNotifier: nothing found")
-			return
-		}
+// checkForUpdate notifies the update notifier names if there's a new
+// version of the bot. True is returned when there's no point in checking
+// again, either because the notification has been sent or because the
+// check failed. The configuration is locked for reading during the check,
+// since a reload might replace the settings.
+func (b *bot) checkForUpdate() bool {
+	b.configMu.RLock()
+	defer b.configMu.RUnlock()
 
-		// The version has changed, so let's notify everyone that needs to
-		// know. We don't want to keep notifying, so we'll end the
-		// goroutine after the notification has been sent.
-		version := matches[1][0:8]
-		if version != VERSION {
-			for _, name := range b.IRC.UpdateNotifierNames {
-				b.privmsgpht(b.IRC.UpdateNotifierMsg, name, map[string]string{
-					"<version>": version,
-				})
-				return
-			}
+	// Download the HTML source of the bot repo.
+	res, err := b.httpGet(b.baseURL("updateNotifier") + "/osm/bot")
+	if err != nil {
+		b.log("updateNotifier").Errorf("updateNotifier: %v", err)
+		return true
+	}
+
+	// Read the body.
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		b.log("updateNotifier").Errorf("updateNotifier: %v", err)
+		return true
+	}
+
+	// Try to extract the latest commit.
+	matches := updateNotifierLatestCommitRegexp.FindStringSubmatch(string(data))
+	if matches == nil {
+		b.log("updateNotifier").Debugf("updateNotifier: nothing found")
+		return true
+	}
+
+	// The version has changed, so let's notify everyone that needs to
+	// know. We don't want to keep notifying, so we'll end the goroutine
+	// after the notification has been sent.
+	version := matches[1][0:8]
+	if version != VERSION {
+		n := b.defaultNetwork()
+		for _, name := range b.config.updateNotifier.UpdateNotifierNames {
+			n.announceph(name, b.config.updateNotifier.UpdateNotifierMsg, map[string]string{
+				"<version>": version,
+			})
+			return true
 		}
 	}
+
+	return false
 }
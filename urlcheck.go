@@ -1,16 +1,35 @@
 package main
 
 import (
-	"database/sql"
 	"regexp"
+	"time"
 
 	"github.com/osm/irc"
 )
 
+// urlCheckConfig holds the configuration of the URL check module.
+type urlCheckConfig struct {
+	EnableURLCheck bool   `json:"enableURLCheck"`
+	URLCheckMsg    string `json:"urlCheckMsg"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureURLCheck,
+		config:  func(b *bot) interface{} { return &b.config.urlCheck },
+		enabled: func(b *bot) bool { return b.config.urlCheck.EnableURLCheck },
+		init:    (*bot).initURLCheckDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).urlCheckHandler},
+		},
+	})
+}
+
 // initURLCheckDefaults sets default values for all settings.
 func (b *bot) initURLCheckDefaults() {
-	if b.IRC.URLCheckMsg == "" {
-		b.IRC.URLCheckMsg = "LOL, <nick> posted <url> on <timestamp>"
+	if b.config.urlCheck.URLCheckMsg == "" {
+		b.config.urlCheck.URLCheckMsg = "LOL, <nick> posted <url> on <timestamp>"
 	}
 }
 
@@ -20,18 +39,17 @@ func (b *bot) initURLCheckDefaults() {
 var urlRegexp = regexp.MustCompile(`(http|ftp|https):\/\/([\w\-_]+(?:(?:\.[\w\-_]+)+))([\w\-\.,@?^=%&amp;:/~\+#]*[\w\-\@?^=%&amp;/~\+#])?`)
 
 // urlCheckHandler extracts and examines all URLs that the bot sees. When the
-// URL has been seen before a humiliating message is sent to the channel.  If
-// the URL hasn't been seen before it is stored in the database.  The bot is
-// written to support one and only one channel, so the channel information is
-// NOT stored in the database.
-func (b *bot) urlCheckHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+// URL has been seen before in the same channel on the same network a
+// humiliating message is sent to the channel. If the URL hasn't been seen
+// before it is stored in the database together with the network and channel.
+func (b *bot) urlCheckHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
 
-	if !a.validChannel {
+	if !n.hasFeature(a.target, featureURLCheck) {
 		return
 	}
 
-	if b.shouldIgnore(m) {
+	if b.shouldIgnore(n, m) {
 		return
 	}
 
@@ -40,35 +58,33 @@ func (b *bot) urlCheckHandler(m *irc.Message) {
 		return
 	}
 
-	var nick, timestamp string
-	err := b.queryRow("SELECT nick, timestamp FROM url_check WHERE url = $1", url).Scan(&nick, &timestamp)
-	if err != nil && err != sql.ErrNoRows {
-		b.logger.Printf("urlCheckHandler: %v", err)
-		b.privmsg(b.DB.Err)
+	seen, ok, err := b.store.urlCheck.find(n.Name, a.target, url)
+	if err != nil {
+		b.log(featureURLCheck).Errorf("urlCheckHandler: %v", err)
+		b.replyDBError(a)
 		return
 	}
 
-	if nick != "" {
-		b.privmsgph(b.IRC.URLCheckMsg, map[string]string{
-			"<nick>":      nick,
+	if ok {
+		a.replyph(b.config.urlCheck.URLCheckMsg, map[string]string{
+			"<nick>":      seen.Nick,
 			"<url>":       url,
-			"<timestamp>": timestamp,
+			"<timestamp>": b.formatTime(seen.Timestamp),
 		})
 		return
 	}
 
-	stmt, err := b.prepare("INSERT INTO url_check (id, timestamp, nick, url) VALUES($1, $2, $3, $4)")
-	if err != nil {
-		b.logger.Printf("urlCheckHandler: %v", err)
-		b.privmsg(b.DB.Err)
-		return
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(newUUID(), newTimestamp(), a.nick, url)
+	err = b.store.urlCheck.insert(urlCheckEntry{
+		ID:        newUUID(),
+		Timestamp: time.Now(),
+		Network:   n.Name,
+		Channel:   a.target,
+		Nick:      a.nick,
+		URL:       url,
+	})
 	if err != nil {
-		b.logger.Printf("urlCheckHandler: %v", err)
-		b.privmsg(b.DB.Err)
+		b.log(featureURLCheck).Errorf("urlCheckHandler: %v", err)
+		b.replyDBError(a)
 		return
 	}
 }
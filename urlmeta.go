@@ -2,24 +2,44 @@ package main
 
 import (
 	"io"
-	"net/http"
 	"strings"
 
 	"github.com/osm/irc"
 	"github.com/osm/mex"
 )
 
+// urlMetaConfig holds the configuration of the URL meta module.
+type urlMetaConfig struct {
+	EnableURLMeta     bool     `json:"enableURLMeta"`
+	URLMetaMsg        string   `json:"urlMetaMsg"`
+	URLMetaURLs       []string `json:"urlMetaURLs"`
+	URLMetaIgnoreURLs []string `json:"urlMetaIgnoreURLs"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureURLMeta,
+		config:  func(b *bot) interface{} { return &b.config.urlMeta },
+		enabled: func(b *bot) bool { return b.config.urlMeta.EnableURLMeta },
+		init:    (*bot).initURLMetaDefaults,
+		handlers: []moduleHandler{
+			{"PRIVMSG", (*bot).urlMetaHandler},
+		},
+	})
+}
+
 // initURLMetaDefaults sets default values for all settings.
 func (b *bot) initURLMetaDefaults() {
-	if b.IRC.URLMetaMsg == "" {
-		b.IRC.URLMetaMsg = "<description||title>"
+	if b.config.urlMeta.URLMetaMsg == "" {
+		b.config.urlMeta.URLMetaMsg = "<description||title>"
 	}
 }
 
-func (b *bot) urlMetaHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
+func (b *bot) urlMetaHandler(n *network, m *irc.Message) {
+	a := b.parseAction(n, m).(*privmsgAction)
 
-	if !a.validChannel {
+	if !n.hasFeature(a.target, featureURLMeta) {
 		return
 	}
 
@@ -35,13 +55,13 @@ func (b *bot) urlMetaHandler(m *irc.Message) {
 	fetchMeta := false
 
 	// If no URLs are specified, we'll fetch meta for all found URLs.
-	if len(b.IRC.URLMetaURLs) == 0 {
+	if len(b.config.urlMeta.URLMetaURLs) == 0 {
 		fetchMeta = true
 	}
 
 	// But if we've got any URLs set, we'll make sure that only those are
 	// processed.
-	for _, u := range b.IRC.URLMetaURLs {
+	for _, u := range b.config.urlMeta.URLMetaURLs {
 		if strings.Contains(url, u) {
 			fetchMeta = true
 			break
@@ -50,7 +70,7 @@ func (b *bot) urlMetaHandler(m *irc.Message) {
 
 	// ... and last if the URL matches any of the ignore URLs we'll
 	// disallow further processing.
-	for _, u := range b.IRC.URLMetaIgnoreURLs {
+	for _, u := range b.config.urlMeta.URLMetaIgnoreURLs {
 		if strings.Contains(url, u) {
 			fetchMeta = false
 			break
@@ -63,7 +83,7 @@ func (b *bot) urlMetaHandler(m *irc.Message) {
 	}
 
 	// Download the URL, we'll return in silence if there's an error.
-	res, err := http.Get(url)
+	res, err := b.httpGet(url)
 	if err != nil {
 		return
 	}
@@ -89,7 +109,7 @@ func (b *bot) urlMetaHandler(m *irc.Message) {
 		dort = title
 	}
 
-	b.privmsgph(b.IRC.URLMetaMsg, map[string]string{
+	a.replyph(b.config.urlMeta.URLMetaMsg, map[string]string{
 		"<description>":        md.Description,
 		"<title>":              md.Title,
 		"<description||title>": dort,
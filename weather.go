@@ -4,64 +4,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-
-	"github.com/osm/irc"
+	"time"
 )
 
-// initWeatherDefaults sets default values for all settings.
-func (b *bot) initWeatherDefaults() {
-	if b.IRC.WeatherCmd == "" {
-		b.IRC.WeatherCmd = "!w"
-	}
-	if b.IRC.WeatherErr == "" {
-		b.IRC.WeatherErr = "unknown city"
-	}
-	if b.IRC.WeatherMsg == "" {
-		b.IRC.WeatherMsg = "<city>, <main>, <description>: <temp>"
-	}
+// weatherConfig holds the configuration of the weather module.
+type weatherConfig struct {
+	EnableWeather bool   `json:"enableWeather"`
+	WeatherAPIKey string `json:"weatherAPIKey"`
+	WeatherCmd    string `json:"weatherCmd"`
+	WeatherErr    string `json:"weatherErr"`
+	WeatherMsg    string `json:"weatherMsg"`
 }
 
-// weatherHandler contains the entry point for if the weather module is
-// enabled. It will run all the messages through the regexp that is defined in
-// the configuration file and pass it to the weather api.  This handler
-// requires a valid API key in the configuration.
-func (b *bot) weatherHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	if !a.validChannel {
-		return
-	}
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureWeather,
+		config:  func(b *bot) interface{} { return &b.config.weather },
+		enabled: func(b *bot) bool { return b.config.weather.EnableWeather },
+		init:    (*bot).initWeatherDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.weather.WeatherCmd,
+				usage:   "<city>",
+				minArgs: 1,
+				maxArgs: 1,
+				feature: featureWeather,
+				fn:      (*bot).weatherCommand,
+			}}
+		},
+	})
+}
 
-	if a.cmd != b.IRC.WeatherCmd {
-		return
+// initWeatherDefaults sets default values for all settings.
+func (b *bot) initWeatherDefaults() {
+	if b.config.weather.WeatherCmd == "" {
+		b.config.weather.WeatherCmd = "w"
 	}
-
-	if b.shouldIgnore(m) {
-		return
+	if b.config.weather.WeatherErr == "" {
+		b.config.weather.WeatherErr = "unknown city"
 	}
-
-	if b.IRC.WeatherAPIKey == "" {
-		b.logger.Printf("weatherHandler: you need to set a weather api key\n")
-		return
+	if b.config.weather.WeatherMsg == "" {
+		b.config.weather.WeatherMsg = "<city>, <main>, <description>: <temp>"
 	}
+}
 
-	if len(a.args) != 1 {
+// weatherCommand looks up the weather of the given city with the weather
+// api. This command requires a valid API key in the configuration.
+func (b *bot) weatherCommand(a *privmsgAction) {
+	if b.config.weather.WeatherAPIKey == "" {
+		b.log(featureWeather).Warnf("weatherCommand: you need to set a weather api key")
 		return
 	}
 
-	res, err := http.Get(fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?appid=%s&q=%s", b.IRC.WeatherAPIKey, a.args[0]))
+	start := time.Now()
+	res, err := b.httpGet(fmt.Sprintf("%s/data/2.5/weather?appid=%s&q=%s", b.baseURL("openweathermap"), b.config.weather.WeatherAPIKey, a.args[0]))
+	b.metrics.observeHTTP("openweathermap", start, res, err)
 	if err != nil {
-		b.logger.Printf("weather: %v", err)
-		b.privmsg(b.IRC.WeatherErr)
+		b.log(featureWeather).Errorf("weather: %v", err)
+		a.replyUrgent(b.config.weather.WeatherErr)
 		return
 	}
 
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		b.logger.Printf("weather: %v", err)
-		b.privmsg(b.IRC.WeatherErr)
+		b.log(featureWeather).Errorf("weather: %v", err)
+		a.replyUrgent(b.config.weather.WeatherErr)
 		return
 	}
 
@@ -79,8 +88,8 @@ func (b *bot) weatherHandler(m *irc.Message) {
 
 	err = json.Unmarshal(data, &w)
 	if err != nil {
-		b.logger.Printf("weather: %v", err)
-		b.privmsg(b.IRC.WeatherErr)
+		b.log(featureWeather).Errorf("weather: %v", err)
+		a.replyUrgent(b.config.weather.WeatherErr)
 		return
 	}
 
@@ -88,11 +97,11 @@ func (b *bot) weatherHandler(m *irc.Message) {
 	// has been trying to be funny and sent an invalid city name. We
 	// don't log these errors.
 	if string(w.Cod) != "200" {
-		b.privmsg(b.IRC.WeatherErr)
+		a.replyUrgent(b.config.weather.WeatherErr)
 		return
 	}
 
-	b.privmsgph(b.IRC.WeatherMsg, map[string]string{
+	a.replyph(b.config.weather.WeatherMsg, map[string]string{
 		"<city>":        w.Name,
 		"<main>":        w.Weather[0].Main,
 		"<description>": w.Weather[0].Description,
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWeather(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/2.5/weather" || r.URL.Query().Get("appid") != "key" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("q") != "stockholm" {
+			fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"cod":200,"name":"Stockholm","main":{"temp":300},"weather":[{"main":"Clear","description":"clear sky"}]}`)
+	}))
+	defer srv.Close()
+
+	tb := newTestBotWithConfig(t, map[string]interface{}{
+		"enableWeather": true,
+		"weatherAPIKey": "key",
+		"weatherErr":    "no weather",
+	}, map[string]interface{}{
+		"httpClient": map[string]interface{}{
+			"baseURLs": map[string]string{"openweathermap": srv.URL},
+		},
+	})
+	defer tb.close()
+	s := tb.server
+
+	s.privmsg("alice", "#bot", "!w stockholm")
+	s.expect("PRIVMSG #bot :Stockholm, Clear, clear sky: 27")
+
+	s.privmsg("alice", "#bot", "!w atlantis")
+	s.expect("PRIVMSG #bot :no weather")
+}
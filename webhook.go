@@ -0,0 +1,600 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The providers that webhooks can be received from.
+const (
+	webhookGitHub = "github"
+	webhookGitea  = "gitea"
+	webhookGitLab = "gitlab"
+)
+
+// The kinds of events that are announced, the events of the providers are
+// mapped to these.
+const (
+	webhookPush        = "push"
+	webhookPullRequest = "pullRequest"
+	webhookIssue       = "issue"
+	webhookStatus      = "status"
+)
+
+// webhookMaxBody is the largest payload that is read, in bytes.
+const webhookMaxBody = 5 << 20
+
+// webhookConfig holds the default messages of the webhook events, they can
+// be overridden per webhook.
+type webhookConfig struct {
+	WebhookMsgPush        string `json:"webhookMsgPush"`
+	WebhookMsgPullRequest string `json:"webhookMsgPullRequest"`
+	WebhookMsgIssue       string `json:"webhookMsgIssue"`
+	WebhookMsgStatus      string `json:"webhookMsgStatus"`
+}
+
+// webhook is a route that receives the events of a Git forge or CI and
+// announces them in a channel.
+type webhook struct {
+	// Route is the path of the webhook.
+	Route string `json:"route"`
+
+	// Provider is github, gitea or gitlab.
+	Provider string `json:"provider"`
+
+	// Secret is the secret that the payloads are signed with, GitLab
+	// doesn't sign the payloads and sends the secret token instead.
+	Secret string `json:"secret"`
+
+	// Network and Channel is where the events are announced, they
+	// default to the default network and its default channel.
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+
+	// Events limits which kinds of events that are announced, all are
+	// announced if it's empty.
+	Events []string `json:"events"`
+
+	// The messages of the events, the default messages are used if
+	// they are empty.
+	MsgPush        string `json:"msgPush"`
+	MsgPullRequest string `json:"msgPullRequest"`
+	MsgIssue       string `json:"msgIssue"`
+	MsgStatus      string `json:"msgStatus"`
+}
+
+// webhookEvent is an event that should be announced, phs holds the values of
+// the placeholders of the message.
+type webhookEvent struct {
+	kind string
+	phs  map[string]string
+}
+
+// initWebhookDefaults sets the default messages of the webhook events.
+func (b *bot) initWebhookDefaults() {
+	if b.HTTP.WebhookMsgPush == "" {
+		b.HTTP.WebhookMsgPush = "[<repo>] <user> pushed <commits> commit(s) to <branch>: <message> <url>"
+	}
+	if b.HTTP.WebhookMsgPullRequest == "" {
+		b.HTTP.WebhookMsgPullRequest = "[<repo>] <user> <action> pull request #<number>: <title> <url>"
+	}
+	if b.HTTP.WebhookMsgIssue == "" {
+		b.HTTP.WebhookMsgIssue = "[<repo>] <user> <action> issue #<number>: <title> <url>"
+	}
+	if b.HTTP.WebhookMsgStatus == "" {
+		b.HTTP.WebhookMsgStatus = "[<repo>] <name> <state> on <branch> (<sha>) <url>"
+	}
+}
+
+// checkWebhookConfig makes sure that the webhooks have a route, a known
+// provider and a secret, and that they are announced on a known network.
+func (b *bot) checkWebhookConfig() configErrors {
+	var errs configErrors
+	if !b.HTTP.EnableWebhooks {
+		return errs
+	}
+
+	routes := make(map[string]bool)
+	for i, h := range b.HTTP.Webhooks {
+		path := fmt.Sprintf("http.webhooks[%d]", i)
+		if h.Route == "" {
+			errs = append(errs, configError{path + ".route", "is missing"})
+		} else if routes[h.Route] {
+			errs = append(errs, configError{path + ".route", fmt.Sprintf("%s is used by more than one webhook", h.Route)})
+		}
+		routes[h.Route] = true
+
+		switch h.Provider {
+		case webhookGitHub, webhookGitea, webhookGitLab:
+		default:
+			errs = append(errs, configError{path + ".provider", fmt.Sprintf("%s is not a provider, use %s, %s or %s", h.Provider, webhookGitHub, webhookGitea, webhookGitLab)})
+		}
+
+		if h.Secret == "" {
+			errs = append(errs, configError{path + ".secret", "is missing"})
+		}
+		if h.Network != "" && b.network(h.Network) == nil {
+			errs = append(errs, configError{path + ".network", fmt.Sprintf("%s is not a network", h.Network)})
+		}
+
+		for j, e := range h.Events {
+			switch e {
+			case webhookPush, webhookPullRequest, webhookIssue, webhookStatus:
+			default:
+				errs = append(errs, configError{fmt.Sprintf("%s.events[%d]", path, j), fmt.Sprintf("%s is not an event, use %s, %s, %s or %s", e, webhookPush, webhookPullRequest, webhookIssue, webhookStatus)})
+			}
+		}
+	}
+
+	return errs
+}
+
+// webhook returns the webhook with the given route, or nil if there is none.
+func (b *bot) webhook(route string) *webhook {
+	for _, h := range b.HTTP.Webhooks {
+		if h.Route == route {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// webhookHandler verifies the signature of the payload and announces the
+// event. Events that aren't announced are answered with 204 as well, so that
+// the providers doesn't report them as failed.
+func (b *bot) webhookHandler(w http.ResponseWriter, r *http.Request, h *webhook) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		b.HTTP.logger.Errorf("webhook: unable to read body, %v", err)
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.verify(r.Header, body) {
+		b.HTTP.logger.Warnf("webhook: invalid signature for %s from %s", h.Route, r.RemoteAddr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	e, err := parseWebhookEvent(h.Provider, r.Header, body)
+	if err != nil {
+		b.HTTP.logger.Warnf("webhook: %s: %v", h.Route, err)
+		http.Error(w, fmt.Sprintf("Invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if e == nil || !h.wants(e.kind) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	n := b.defaultNetwork()
+	if h.Network != "" {
+		n = b.network(h.Network)
+	}
+	channel := h.Channel
+	if channel == "" {
+		channel = n.defaultChannel()
+	}
+
+	n.announceph(channel, b.webhookMessage(h, e.kind), e.phs)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verify returns true if the payload is signed with the secret of the
+// webhook. GitLab doesn't sign the payloads, so the secret token that it
+// sends is compared instead.
+func (h *webhook) verify(header http.Header, body []byte) bool {
+	switch h.Provider {
+	case webhookGitHub:
+		return verifyHMAC(h.Secret, body, strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="))
+	case webhookGitea:
+		return verifyHMAC(h.Secret, body, header.Get("X-Gitea-Signature"))
+	case webhookGitLab:
+		return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(h.Secret)) == 1
+	}
+
+	return false
+}
+
+// signHMAC returns the hex encoded HMAC-SHA256 of the body.
+func signHMAC(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHMAC returns true if signature is the hex encoded HMAC-SHA256 of the
+// body.
+func verifyHMAC(secret string, body []byte, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// wants returns true if the kind of event is announced by the webhook.
+func (h *webhook) wants(kind string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+
+	for _, e := range h.Events {
+		if e == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webhookMessage returns the message of the kind of event, the message of
+// the webhook is used if it's set.
+func (b *bot) webhookMessage(h *webhook, kind string) string {
+	msg, def := "", ""
+	switch kind {
+	case webhookPush:
+		msg, def = h.MsgPush, b.HTTP.WebhookMsgPush
+	case webhookPullRequest:
+		msg, def = h.MsgPullRequest, b.HTTP.WebhookMsgPullRequest
+	case webhookIssue:
+		msg, def = h.MsgIssue, b.HTTP.WebhookMsgIssue
+	case webhookStatus:
+		msg, def = h.MsgStatus, b.HTTP.WebhookMsgStatus
+	}
+
+	if msg == "" {
+		return def
+	}
+	return msg
+}
+
+// parseWebhookEvent parses the payload of the provider, nil is returned for
+// events that aren't announced.
+func parseWebhookEvent(provider string, header http.Header, body []byte) (*webhookEvent, error) {
+	switch provider {
+	case webhookGitHub:
+		return parseForgeEvent(header.Get("X-GitHub-Event"), body)
+	case webhookGitea:
+		return parseForgeEvent(header.Get("X-Gitea-Event"), body)
+	case webhookGitLab:
+		return parseGitLabEvent(header.Get("X-Gitlab-Event"), body)
+	}
+
+	return nil, fmt.Errorf("unknown provider %s", provider)
+}
+
+// firstLine returns the first line of s, it's used for commit messages.
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i != -1 {
+		return s[:i]
+	}
+	return s
+}
+
+// shortSHA returns the abbreviated commit hash.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// zeroSHA is the commit hash that the providers use for a branch that has
+// been created or deleted.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// webhookCommit is a commit of a push event.
+type webhookCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+// forgePayload holds the fields of the GitHub and Gitea payloads that are
+// announced, Gitea uses the same payloads as GitHub with a few additions.
+type forgePayload struct {
+	Action string `json:"action"`
+	Number int    `json:"number"`
+
+	Ref        string          `json:"ref"`
+	After      string          `json:"after"`
+	Compare    string          `json:"compare"`
+	CompareURL string          `json:"compare_url"`
+	Commits    []webhookCommit `json:"commits"`
+	HeadCommit *webhookCommit  `json:"head_commit"`
+
+	Pusher struct {
+		Name  string `json:"name"`
+		Login string `json:"login"`
+	} `json:"pusher"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+
+	PullRequest struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+	Issue struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+	SHA         string `json:"sha"`
+	Branches    []struct {
+		Name string `json:"name"`
+	} `json:"branches"`
+
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		HeadSHA    string `json:"head_sha"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// announcedActions maps the actions of the pull request and issue events
+// that are announced to the word that is used in the message.
+var announcedActions = map[string]string{
+	"opened":   "opened",
+	"closed":   "closed",
+	"reopened": "reopened",
+	"merged":   "merged",
+}
+
+// parseForgeEvent parses the GitHub and Gitea events. Pull requests and
+// issues are only announced when they are opened, closed, reopened or
+// merged, the CI events only when they have finished.
+func parseForgeEvent(event string, body []byte) (*webhookEvent, error) {
+	switch event {
+	case "push", "pull_request", "issues", "status", "workflow_run":
+	default:
+		return nil, nil
+	}
+
+	var p forgePayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	phs := map[string]string{
+		"<repo>": p.Repository.FullName,
+		"<user>": p.Sender.Login,
+	}
+
+	switch event {
+	case "push":
+		if len(p.Commits) == 0 || p.After == zeroSHA {
+			return nil, nil
+		}
+
+		head := p.Commits[len(p.Commits)-1]
+		if p.HeadCommit != nil {
+			head = *p.HeadCommit
+		}
+
+		if p.Pusher.Login != "" {
+			phs["<user>"] = p.Pusher.Login
+		} else if p.Pusher.Name != "" {
+			phs["<user>"] = p.Pusher.Name
+		}
+		phs["<branch>"] = strings.TrimPrefix(p.Ref, "refs/heads/")
+		phs["<commits>"] = strconv.Itoa(len(p.Commits))
+		phs["<message>"] = firstLine(head.Message)
+		phs["<sha>"] = shortSHA(head.ID)
+		phs["<url>"] = p.Compare
+		if p.CompareURL != "" {
+			phs["<url>"] = p.CompareURL
+		}
+		return &webhookEvent{webhookPush, phs}, nil
+
+	case "pull_request":
+		action := p.Action
+		if action == "closed" && p.PullRequest.Merged {
+			action = "merged"
+		}
+		if _, ok := announcedActions[action]; !ok {
+			return nil, nil
+		}
+
+		phs["<action>"] = announcedActions[action]
+		phs["<number>"] = strconv.Itoa(p.Number)
+		phs["<title>"] = firstLine(p.PullRequest.Title)
+		phs["<url>"] = p.PullRequest.HTMLURL
+		return &webhookEvent{webhookPullRequest, phs}, nil
+
+	case "issues":
+		if _, ok := announcedActions[p.Action]; !ok {
+			return nil, nil
+		}
+
+		phs["<action>"] = announcedActions[p.Action]
+		phs["<number>"] = strconv.Itoa(p.Issue.Number)
+		phs["<title>"] = firstLine(p.Issue.Title)
+		phs["<url>"] = p.Issue.HTMLURL
+		return &webhookEvent{webhookIssue, phs}, nil
+
+	case "status":
+		if p.State == "pending" {
+			return nil, nil
+		}
+
+		phs["<name>"] = p.Context
+		phs["<state>"] = p.State
+		phs["<branch>"] = ""
+		if len(p.Branches) > 0 {
+			phs["<branch>"] = p.Branches[0].Name
+		}
+		phs["<sha>"] = shortSHA(p.SHA)
+		phs["<description>"] = firstLine(p.Description)
+		phs["<url>"] = p.TargetURL
+		return &webhookEvent{webhookStatus, phs}, nil
+
+	case "workflow_run":
+		if p.Action != "completed" {
+			return nil, nil
+		}
+
+		phs["<name>"] = p.WorkflowRun.Name
+		phs["<state>"] = p.WorkflowRun.Conclusion
+		phs["<branch>"] = p.WorkflowRun.HeadBranch
+		phs["<sha>"] = shortSHA(p.WorkflowRun.HeadSHA)
+		phs["<description>"] = ""
+		phs["<url>"] = p.WorkflowRun.HTMLURL
+		return &webhookEvent{webhookStatus, phs}, nil
+	}
+
+	return nil, nil
+}
+
+// gitLabPayload holds the fields of the GitLab payloads that are announced.
+type gitLabPayload struct {
+	Ref          string          `json:"ref"`
+	After        string          `json:"after"`
+	UserUsername string          `json:"user_username"`
+	Commits      []webhookCommit `json:"commits"`
+	TotalCommits int             `json:"total_commits_count"`
+
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+
+	ObjectAttributes struct {
+		ID     int    `json:"id"`
+		IID    int    `json:"iid"`
+		Title  string `json:"title"`
+		URL    string `json:"url"`
+		Action string `json:"action"`
+		Ref    string `json:"ref"`
+		SHA    string `json:"sha"`
+		Status string `json:"status"`
+		Name   string `json:"name"`
+	} `json:"object_attributes"`
+}
+
+// gitLabActions maps the GitLab merge request and issue actions that are
+// announced to the word that is used in the message.
+var gitLabActions = map[string]string{
+	"open":   "opened",
+	"close":  "closed",
+	"reopen": "reopened",
+	"merge":  "merged",
+}
+
+// gitLabFinishedStatuses are the pipeline statuses that are announced.
+var gitLabFinishedStatuses = map[string]bool{
+	"success":  true,
+	"failed":   true,
+	"canceled": true,
+}
+
+// parseGitLabEvent parses the GitLab events, see parseForgeEvent for which
+// events that are announced.
+func parseGitLabEvent(event string, body []byte) (*webhookEvent, error) {
+	switch event {
+	case "Push Hook", "Merge Request Hook", "Issue Hook", "Pipeline Hook":
+	default:
+		return nil, nil
+	}
+
+	var p gitLabPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	phs := map[string]string{
+		"<repo>": p.Project.PathWithNamespace,
+		"<user>": p.User.Username,
+	}
+
+	switch event {
+	case "Push Hook":
+		if len(p.Commits) == 0 || p.After == zeroSHA {
+			return nil, nil
+		}
+
+		// The commits are ordered with the newest last.
+		head := p.Commits[len(p.Commits)-1]
+		count := p.TotalCommits
+		if count == 0 {
+			count = len(p.Commits)
+		}
+
+		phs["<user>"] = p.UserUsername
+		phs["<branch>"] = strings.TrimPrefix(p.Ref, "refs/heads/")
+		phs["<commits>"] = strconv.Itoa(count)
+		phs["<message>"] = firstLine(head.Message)
+		phs["<sha>"] = shortSHA(head.ID)
+		phs["<url>"] = head.URL
+		return &webhookEvent{webhookPush, phs}, nil
+
+	case "Merge Request Hook", "Issue Hook":
+		action, ok := gitLabActions[p.ObjectAttributes.Action]
+		if !ok {
+			return nil, nil
+		}
+
+		phs["<action>"] = action
+		phs["<number>"] = strconv.Itoa(p.ObjectAttributes.IID)
+		phs["<title>"] = firstLine(p.ObjectAttributes.Title)
+		phs["<url>"] = p.ObjectAttributes.URL
+
+		kind := webhookIssue
+		if event == "Merge Request Hook" {
+			kind = webhookPullRequest
+		}
+		return &webhookEvent{kind, phs}, nil
+
+	case "Pipeline Hook":
+		if !gitLabFinishedStatuses[p.ObjectAttributes.Status] {
+			return nil, nil
+		}
+
+		name := p.ObjectAttributes.Name
+		if name == "" {
+			name = "pipeline"
+		}
+		url := p.ObjectAttributes.URL
+		if url == "" {
+			url = fmt.Sprintf("%s/-/pipelines/%d", p.Project.WebURL, p.ObjectAttributes.ID)
+		}
+
+		phs["<name>"] = name
+		phs["<state>"] = p.ObjectAttributes.Status
+		phs["<branch>"] = p.ObjectAttributes.Ref
+		phs["<sha>"] = shortSHA(p.ObjectAttributes.SHA)
+		phs["<description>"] = ""
+		phs["<url>"] = url
+		return &webhookEvent{webhookStatus, phs}, nil
+	}
+
+	return nil, nil
+}
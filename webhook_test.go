@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// readWebhookFixture returns the recorded payload in testdata/webhooks.
+func readWebhookFixture(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// newWebhookRequest returns a request with the payload of the fixture that
+// is signed with the secret the way the provider does it.
+func newWebhookRequest(t *testing.T, provider, event, fixture, secret string) *http.Request {
+	body := readWebhookFixture(t, fixture)
+
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	switch provider {
+	case webhookGitHub:
+		r.Header.Set("X-GitHub-Event", event)
+		r.Header.Set("X-Hub-Signature-256", "sha256="+signHMAC(secret, body))
+	case webhookGitea:
+		r.Header.Set("X-Gitea-Event", event)
+		r.Header.Set("X-Gitea-Signature", signHMAC(secret, body))
+	case webhookGitLab:
+		r.Header.Set("X-Gitlab-Event", event)
+		r.Header.Set("X-Gitlab-Token", secret)
+	}
+	return r
+}
+
+// newWebhookTestBot returns a bot with the default webhook messages and a
+// single network, the announcements are left in the send queue.
+func newWebhookTestBot() (*bot, *network) {
+	b := &bot{logger: newLogger(&logOutput{w: ioutil.Discard})}
+	b.HTTP.logger = b.logger
+	b.initWebhookDefaults()
+
+	n := &network{
+		Name:     "test",
+		Nick:     "bot",
+		User:     "bot",
+		Channels: []channelConfig{{Name: "#bot"}},
+		queue:    newSendQueue(),
+	}
+	b.IRC.Networks = []*network{n}
+
+	return b, n
+}
+
+func TestWebhookEvents(t *testing.T) {
+	tests := []struct {
+		provider string
+		event    string
+		fixture  string
+		want     string
+	}{
+		{webhookGitHub, "push", "github_push.json", "[osm/bot] osm pushed 2 commit(s) to master: Update the README https://github.com/osm/bot/compare/6113728f27ae...a10867b14bb7"},
+		{webhookGitHub, "pull_request", "github_pull_request.json", "[osm/bot] osm merged pull request #42: Add a web UI https://github.com/osm/bot/pull/42"},
+		{webhookGitHub, "issues", "github_issues.json", "[osm/bot] reporter opened issue #7: The quiz doesn't stop https://github.com/osm/bot/issues/7"},
+		{webhookGitHub, "status", "github_status.json", "[osm/bot] ci/build failure on master (a10867b) https://ci.example.com/osm/bot/builds/1234"},
+		{webhookGitHub, "workflow_run", "github_workflow_run.json", "[osm/bot] Build success on master (a10867b) https://github.com/osm/bot/actions/runs/30433642"},
+		{webhookGitea, "push", "gitea_push.json", "[osm/bot] osm pushed 1 commit(s) to develop: Add the smhi module https://gitea.example.com/osm/bot/compare/28e1879d029cb852e4844d9c718537df08844e03...bffeb74224043ba2feb48d137756c8a9331c449a"},
+		{webhookGitea, "pull_request", "gitea_pull_request.json", "[osm/bot] contributor opened pull request #3: Fix the flood protection https://gitea.example.com/osm/bot/pulls/3"},
+		{webhookGitea, "issues", "gitea_issues.json", "[osm/bot] osm closed issue #5: Crash on reconnect https://gitea.example.com/osm/bot/issues/5"},
+		{webhookGitea, "status", "gitea_status.json", "[osm/bot] woodpecker/push success on develop (bffeb74) https://ci.example.com/repos/1/pipeline/12"},
+		{webhookGitLab, "Push Hook", "gitlab_push.json", "[osm/bot] osm pushed 4 commit(s) to master: Fix the readme https://gitlab.example.com/osm/bot/-/commit/da1560886d4f094c3e6c9ef40349f7d38b5d27d7"},
+		{webhookGitLab, "Merge Request Hook", "gitlab_merge_request.json", "[osm/bot] osm merged pull request #12: Add the cron module https://gitlab.example.com/osm/bot/-/merge_requests/12"},
+		{webhookGitLab, "Issue Hook", "gitlab_issue.json", "[osm/bot] reporter opened issue #23: The weather command times out https://gitlab.example.com/osm/bot/-/issues/23"},
+		{webhookGitLab, "Pipeline Hook", "gitlab_pipeline.json", "[osm/bot] pipeline failed on master (da15608) https://gitlab.example.com/osm/bot/-/pipelines/31"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			b, n := newWebhookTestBot()
+			h := &webhook{Route: "/webhook", Provider: test.provider, Secret: "secret"}
+
+			w := httptest.NewRecorder()
+			b.webhookHandler(w, newWebhookRequest(t, test.provider, test.event, test.fixture, "secret"), h)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+			}
+
+			msgs := n.queue.queues[priorityBackground]
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(msgs))
+			}
+			if msgs[0].target != "#bot" {
+				t.Errorf("expected the message to be sent to #bot, got %s", msgs[0].target)
+			}
+			if msgs[0].line != test.want {
+				t.Errorf("expected %q, got %q", test.want, msgs[0].line)
+			}
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	tests := []struct {
+		provider string
+		event    string
+		fixture  string
+	}{
+		{webhookGitHub, "push", "github_push.json"},
+		{webhookGitea, "push", "gitea_push.json"},
+		{webhookGitLab, "Push Hook", "gitlab_push.json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.provider, func(t *testing.T) {
+			b, n := newWebhookTestBot()
+			h := &webhook{Route: "/webhook", Provider: test.provider, Secret: "secret"}
+
+			w := httptest.NewRecorder()
+			b.webhookHandler(w, newWebhookRequest(t, test.provider, test.event, test.fixture, "wrong"), h)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected status %d for a wrong secret, got %d", http.StatusUnauthorized, w.Code)
+			}
+
+			r := newWebhookRequest(t, test.provider, test.event, test.fixture, "")
+			r.Header.Del("X-Hub-Signature-256")
+			r.Header.Del("X-Gitea-Signature")
+			r.Header.Del("X-Gitlab-Token")
+			w = httptest.NewRecorder()
+			b.webhookHandler(w, r, h)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected status %d without a signature, got %d", http.StatusUnauthorized, w.Code)
+			}
+
+			if l := n.queue.length(); l != 0 {
+				t.Errorf("expected no messages, got %d", l)
+			}
+		})
+	}
+}
+
+func TestWebhookIgnoredEvents(t *testing.T) {
+	b, n := newWebhookTestBot()
+
+	// The events that aren't announced are still answered with 204.
+	requests := []*http.Request{
+		newWebhookRequest(t, webhookGitHub, "ping", "github_push.json", "secret"),
+		newWebhookRequest(t, webhookGitHub, "push", "github_push.json", "secret"),
+		newWebhookRequest(t, webhookGitLab, "Note Hook", "gitlab_issue.json", "secret"),
+	}
+	hooks := []*webhook{
+		{Route: "/webhook", Provider: webhookGitHub, Secret: "secret"},
+		{Route: "/webhook", Provider: webhookGitHub, Secret: "secret", Events: []string{webhookPullRequest}},
+		{Route: "/webhook", Provider: webhookGitLab, Secret: "secret"},
+	}
+
+	for i, r := range requests {
+		w := httptest.NewRecorder()
+		b.webhookHandler(w, r, hooks[i])
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%d: expected status %d, got %d", i, http.StatusNoContent, w.Code)
+		}
+	}
+
+	if l := n.queue.length(); l != 0 {
+		t.Errorf("expected no messages, got %d", l)
+	}
+}
+
+func TestWebhookMessageOverride(t *testing.T) {
+	b, n := newWebhookTestBot()
+	h := &webhook{Route: "/webhook", Provider: webhookGitHub, Secret: "secret", Channel: "#dev", MsgIssue: "<user>: <title>"}
+
+	w := httptest.NewRecorder()
+	b.webhookHandler(w, newWebhookRequest(t, webhookGitHub, "issues", "github_issues.json", "secret"), h)
+
+	msgs := n.queue.queues[priorityBackground]
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if want := "reporter: The quiz doesn't stop"; msgs[0].line != want || msgs[0].target != "#dev" {
+		t.Errorf("expected %q to #dev, got %q to %s", want, msgs[0].line, msgs[0].target)
+	}
+}
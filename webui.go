@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webUIPerPage is the number of messages per page of the search and nick
+// pages.
+const webUIPerPage = 100
+
+// webUITokenCookie is the cookie that holds the token when the web UI has
+// been opened with a token in the URL.
+const webUITokenCookie = "webui_token"
+
+// webUIDateFormat is the format of the dates in the log table and the URLs.
+const webUIDateFormat = "2006-01-02"
+
+// webUITimeFormat is the format of the times in the log table.
+const webUITimeFormat = "15:04:05"
+
+// webUIPage is the data that is passed to the templates, data holds what's
+// specific to the page.
+type webUIPage struct {
+	Route   string
+	Title   string
+	Network string
+	Channel string
+	Data    interface{}
+}
+
+// Link returns the URL of the page of the web UI with the given query
+// parameters, kv is a list of keys and values. Empty values are left out.
+func (p *webUIPage) Link(page string, kv ...string) string {
+	q := url.Values{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			q.Set(kv[i], kv[i+1])
+		}
+	}
+
+	link := p.Route + "/" + page
+	if len(q) > 0 {
+		link += "?" + q.Encode()
+	}
+	return link
+}
+
+// webUIChannel is a channel on the index page.
+type webUIChannel struct {
+	Network     string
+	Channel     string
+	Messages    int
+	LastMessage string
+}
+
+// webUILine is a message from the log.
+type webUILine struct {
+	Network string
+	Channel string
+	Date    string
+	Time    string
+	Nick    string
+	Message string
+}
+
+// webUIRank is a place in the chattistik ranking.
+type webUIRank struct {
+	Count int
+	Nicks []string
+}
+
+// webUITime formats the time as a date and a time in the timezone of the
+// bot, the zero time is formatted as an empty string.
+func (b *bot) webUITime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.In(b.timezone).Format(webUIDateFormat + " " + webUITimeFormat)
+}
+
+// webUITemplates are the templates of the pages of the web UI.
+var webUITemplates = template.Must(template.New("webui").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+tr:nth-child(even) { background: #f0f0f0; }
+nav a { margin-right: 1em; }
+form { margin: 1em 0; }
+.time { color: #888; white-space: nowrap; }
+</style>
+</head>
+<body>
+<nav>
+<a href="{{.Link ""}}">channels</a>
+{{if .Channel}}<a href="{{.Link "log" "network" .Network "channel" .Channel}}">log</a>
+<a href="{{.Link "search" "network" .Network "channel" .Channel}}">search</a>
+<a href="{{.Link "stats" "network" .Network "channel" .Channel}}">stats</a>{{end}}
+</nav>
+<h1>{{.Title}}</h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "lines"}}<table>
+{{range .Data.Lines}}<tr>
+<td class="time"><a href="{{$.Link "log" "network" .Network "channel" .Channel "date" .Date}}">{{.Date}}</a> {{.Time}}</td>
+<td><a href="{{$.Link "nick" "network" .Network "channel" .Channel "nick" .Nick}}">{{.Nick}}</a></td>
+<td>{{.Message}}</td>
+</tr>
+{{else}}<tr><td>no messages</td></tr>
+{{end}}</table>
+{{end}}
+
+{{define "pager"}}<p>
+{{if .Data.Prev}}<a href="{{.Data.Prev}}">newer</a>{{end}}
+{{if .Data.Next}}<a href="{{.Data.Next}}">older</a>{{end}}
+</p>
+{{end}}
+
+{{define "index"}}{{template "header" .}}<table>
+<tr><th>network</th><th>channel</th><th>messages</th><th>last message</th></tr>
+{{range .Data}}<tr>
+<td>{{.Network}}</td>
+<td><a href="{{$.Link "log" "network" .Network "channel" .Channel}}">{{.Channel}}</a></td>
+<td>{{.Messages}}</td>
+<td class="time">{{.LastMessage}}</td>
+</tr>
+{{else}}<tr><td>nothing has been logged</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "log"}}{{template "header" .}}<p>
+<a href="{{.Link "log" "network" .Network "channel" .Channel "date" .Data.Prev}}">{{.Data.Prev}}</a>
+<a href="{{.Link "log" "network" .Network "channel" .Channel "date" .Data.Next}}">{{.Data.Next}}</a>
+</p>
+<form action="{{.Link "log"}}">
+<input type="hidden" name="network" value="{{.Network}}">
+<input type="hidden" name="channel" value="{{.Channel}}">
+<input type="date" name="date" value="{{.Data.Date}}">
+<input type="submit" value="show">
+</form>
+{{template "lines" .}}{{template "footer" .}}{{end}}
+
+{{define "search"}}{{template "header" .}}<form action="{{.Link "search"}}">
+<input type="hidden" name="network" value="{{.Network}}">
+<input type="hidden" name="channel" value="{{.Channel}}">
+<input type="text" name="q" value="{{.Data.Query}}">
+<input type="submit" value="search">
+</form>
+{{if .Data.Query}}{{template "lines" .}}{{template "pager" .}}{{end}}{{template "footer" .}}{{end}}
+
+{{define "nick"}}{{template "header" .}}<table>
+<tr><th>messages</th><td>{{.Data.Messages}}</td></tr>
+<tr><th>first seen</th><td>{{.Data.First}}</td></tr>
+<tr><th>last seen</th><td>{{.Data.Last}}</td></tr>
+</table>
+<h2>messages</h2>
+{{template "lines" .}}{{template "pager" .}}{{template "footer" .}}{{end}}
+
+{{define "stats"}}{{template "header" .}}<form action="{{.Link "stats"}}">
+<input type="hidden" name="network" value="{{.Network}}">
+<input type="hidden" name="channel" value="{{.Channel}}">
+<input type="date" name="date" value="{{.Data.Date}}">
+<input type="text" name="word" value="{{.Data.Word}}" placeholder="word">
+<input type="submit" value="show">
+</form>
+<table>
+<tr><th>words</th><th>nicks</th></tr>
+{{range .Data.Ranking}}<tr>
+<td>{{.Count}}</td>
+<td>{{range $i, $nick := .Nicks}}{{if $i}}, {{end}}<a href="{{$.Link "nick" "network" $.Network "channel" $.Channel "nick" $nick}}">{{$nick}}</a>{{end}}</td>
+</tr>
+{{else}}<tr><td>no stats</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+`))
+
+// webUIHandler authenticates the request and routes it to the page. The
+// pages are:
+//
+//	<webUIRoute>/       the channels that have been logged
+//	<webUIRoute>/log    the log of a channel, one day at a time
+//	<webUIRoute>/search full-text search of the log
+//	<webUIRoute>/nick   the messages of a nick
+//	<webUIRoute>/stats  the chattistik ranking of a day
+//
+// The channel is given with the network and channel query parameters.
+func (b *bot) webUIHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.webUIAuthorized(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	p := &webUIPage{
+		Route:   b.HTTP.WebUIRoute,
+		Network: q.Get("network"),
+		Channel: q.Get("channel"),
+	}
+
+	// The log and stats pages are per channel, the search and nick
+	// pages are limited to the channel if it's given.
+	page := strings.Trim(strings.TrimPrefix(r.URL.Path, b.HTTP.WebUIRoute), "/")
+	switch page {
+	case "", "search", "nick":
+	case "log", "stats":
+		if p.Network == "" || p.Channel == "" {
+			http.Error(w, "network and channel are required", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var err error
+	switch page {
+	case "":
+		err = b.webUIIndex(p)
+	case "log":
+		err = b.webUILog(p, q.Get("date"))
+	case "search":
+		err = b.webUISearch(p, q.Get("q"), q.Get("page"))
+	case "nick":
+		err = b.webUINick(p, q.Get("nick"), q.Get("page"))
+	case "stats":
+		err = b.webUIStats(p, q.Get("date"), q.Get("word"))
+	}
+	if err, ok := err.(webUIBadRequest); ok {
+		http.Error(w, string(err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		b.metrics.dbErrors.inc()
+		b.HTTP.logger.Errorf("webUIHandler: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	if page == "" {
+		page = "index"
+	}
+
+	// The page is rendered to a buffer first so that a template error
+	// doesn't leave a half written page.
+	var buf bytes.Buffer
+	if err := webUITemplates.ExecuteTemplate(&buf, page, p); err != nil {
+		b.HTTP.logger.Errorf("webUIHandler: %v", err)
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// webUIBadRequest is returned by the pages when the query parameters are
+// invalid.
+type webUIBadRequest string
+
+// Error implements the error interface.
+func (e webUIBadRequest) Error() string {
+	return string(e)
+}
+
+// webUIAuthorized returns true if the request has the credentials of one of
+// the users, or one of the tokens. The token can be given as a bearer token,
+// or as a token query parameter, in which case it's stored in a cookie and
+// the client is redirected to the URL without it. An unauthorized request is
+// answered with 401.
+func (b *bot) webUIAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	q := r.URL.Query()
+	if token := q.Get("token"); token != "" && b.webUIToken(token) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     webUITokenCookie,
+			Value:    token,
+			Path:     b.HTTP.WebUIRoute,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		q.Del("token")
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusSeeOther)
+		return false
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok && b.webUIUser(user, pass) {
+		return true
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && b.webUIToken(strings.TrimPrefix(auth, "Bearer ")) {
+		return true
+	}
+	if c, err := r.Cookie(webUITokenCookie); err == nil && b.webUIToken(c.Value) {
+		return true
+	}
+
+	if len(b.HTTP.WebUIUsers) > 0 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="bot"`)
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// webUIUser returns true if the password is the password of the user.
+func (b *bot) webUIUser(user, pass string) bool {
+	expected, ok := b.HTTP.WebUIUsers[user]
+	if !ok || expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) == 1
+}
+
+// webUIToken returns true if the token is one of the web UI tokens, all
+// tokens are compared so that the time doesn't tell which one matched.
+func (b *bot) webUIToken(token string) bool {
+	found := false
+	for _, t := range b.HTTP.WebUITokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			found = true
+		}
+	}
+
+	return found
+}
+
+// webUIIndex lists the channels that have been logged.
+func (b *bot) webUIIndex(p *webUIPage) error {
+	p.Title = "channels"
+
+	logged, err := b.store.log.channels()
+	if err != nil {
+		return err
+	}
+
+	channels := []webUIChannel{}
+	for _, c := range logged {
+		channels = append(channels, webUIChannel{c.Network, c.Channel, c.Messages, b.webUITime(c.LastMessage)})
+	}
+	p.Data = channels
+
+	return nil
+}
+
+// webUIDate parses the date query parameter, it defaults to today in the
+// timezone of the bot.
+func (b *bot) webUIDate(date string) (time.Time, error) {
+	if date == "" {
+		date = b.today(0)
+	}
+
+	t, err := time.Parse(webUIDateFormat, date)
+	if err != nil {
+		return t, webUIBadRequest("date must be formatted as YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+// webUILines returns the lines of the log entries, the timestamps are split
+// into a date and a time in the timezone of the bot.
+func (b *bot) webUILines(entries []logEntry) []webUILine {
+	var lines []webUILine
+	for _, e := range entries {
+		t := e.Timestamp.In(b.timezone)
+		lines = append(lines, webUILine{e.Network, e.Channel, t.Format(webUIDateFormat), t.Format(webUITimeFormat), e.Nick, e.Message})
+	}
+
+	return lines
+}
+
+// webUILog shows the log of the channel during the given date.
+func (b *bot) webUILog(p *webUIPage, date string) error {
+	t, err := b.webUIDate(date)
+	if err != nil {
+		return err
+	}
+	date = t.Format(webUIDateFormat)
+	p.Title = p.Channel + " " + date
+
+	from, to, err := dayRange(date, b.timezone)
+	if err != nil {
+		return err
+	}
+
+	entries, err := b.store.log.between(p.Network, p.Channel, from, to)
+	if err != nil {
+		return err
+	}
+
+	p.Data = struct {
+		Date, Prev, Next string
+		Lines            []webUILine
+	}{
+		date,
+		t.AddDate(0, 0, -1).Format(webUIDateFormat),
+		t.AddDate(0, 0, 1).Format(webUIDateFormat),
+		b.webUILines(entries),
+	}
+	return nil
+}
+
+// webUIPager holds the links to the newer and older pages of a list of
+// messages.
+type webUIPager struct {
+	Prev, Next string
+}
+
+// webUIPageNumber parses the page query parameter, it defaults to 1.
+func webUIPageNumber(page string) (int, error) {
+	n, err := queryInt(page, 1)
+	if err != nil || n < 1 {
+		return 0, webUIBadRequest("page must be a positive number")
+	}
+	return n, nil
+}
+
+// webUIPaged fetches the messages of the given page with fetch. The links
+// of the pager are created with link.
+func (b *bot) webUIPaged(page int, link func(page int) string, fetch func(limit, offset int) ([]logEntry, error)) ([]webUILine, webUIPager, error) {
+	// One message more than what's shown is fetched, to know whether
+	// there is another page.
+	entries, err := fetch(webUIPerPage+1, (page-1)*webUIPerPage)
+	if err != nil {
+		return nil, webUIPager{}, err
+	}
+	lines := b.webUILines(entries)
+
+	var pager webUIPager
+	if page > 1 {
+		pager.Prev = link(page - 1)
+	}
+	if len(lines) > webUIPerPage {
+		lines = lines[:webUIPerPage]
+		pager.Next = link(page + 1)
+	}
+	return lines, pager, nil
+}
+
+// webUISearch searches the messages of the log for the query, the search is
+// limited to the channel if it's given. The newest messages are shown first.
+func (b *bot) webUISearch(p *webUIPage, query, page string) error {
+	p.Title = "search"
+	data := struct {
+		webUIPager
+		Query string
+		Lines []webUILine
+	}{Query: query}
+	p.Data = &data
+
+	if query == "" {
+		return nil
+	}
+
+	n, err := webUIPageNumber(page)
+	if err != nil {
+		return err
+	}
+
+	link := func(page int) string {
+		return p.Link("search", "network", p.Network, "channel", p.Channel, "q", query, "page", strconv.Itoa(page))
+	}
+	data.Lines, data.webUIPager, err = b.webUIPaged(n, link, func(limit, offset int) ([]logEntry, error) {
+		return b.store.log.search(query, p.Network, p.Channel, limit, offset)
+	})
+	return err
+}
+
+// webUINick shows how many messages the nick has written, when it was first
+// and last seen and its messages with the newest first. The nick is matched
+// case insensitively and only in the channel if it's given.
+func (b *bot) webUINick(p *webUIPage, nick, page string) error {
+	if nick == "" {
+		return webUIBadRequest("nick is required")
+	}
+	p.Title = nick
+
+	n, err := webUIPageNumber(page)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		webUIPager
+		Messages    int
+		First, Last string
+		Lines       []webUILine
+	}{}
+	p.Data = &data
+
+	stats, err := b.store.log.nickStats(nick, p.Network, p.Channel)
+	if err != nil {
+		return err
+	}
+	data.Messages = stats.Messages
+	data.First, data.Last = b.webUITime(stats.First), b.webUITime(stats.Last)
+
+	link := func(page int) string {
+		return p.Link("nick", "network", p.Network, "channel", p.Channel, "nick", nick, "page", strconv.Itoa(page))
+	}
+	data.Lines, data.webUIPager, err = b.webUIPaged(n, link, func(limit, offset int) ([]logEntry, error) {
+		return b.store.log.byNick(nick, p.Network, p.Channel, limit, offset)
+	})
+	return err
+}
+
+// webUIStats shows the chattistik ranking of the channel during the given
+// date, if word is set only that word is counted.
+func (b *bot) webUIStats(p *webUIPage, date, word string) error {
+	t, err := b.webUIDate(date)
+	if err != nil {
+		return err
+	}
+	date = t.Format(webUIDateFormat)
+	p.Title = p.Channel + " " + date
+
+	ranking, err := b.chattistikRanking(p.Network, p.Channel, date, word)
+	if err != nil {
+		return err
+	}
+
+	var ranks []webUIRank
+	for _, r := range ranking {
+		ranks = append(ranks, webUIRank{r.Count, strings.Split(r.Nicks, ", ")})
+	}
+
+	p.Data = struct {
+		Date, Word string
+		Ranking    []webUIRank
+	}{date, word, ranks}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webUIRequest makes a GET request to the web UI with the token as a bearer
+// token.
+func webUIRequest(b *bot, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	b.webUIHandler(w, r)
+	return w
+}
+
+func TestWebUIAuth(t *testing.T) {
+	b := &bot{}
+	b.HTTP.WebUIRoute = "/ui"
+	b.HTTP.WebUIUsers = map[string]string{"admin": "secret", "nobody": ""}
+	b.HTTP.WebUITokens = []string{"", "token"}
+
+	// The page doesn't exist, so an authorized request gets a 404.
+	for _, test := range []struct {
+		name     string
+		user     string
+		pass     string
+		bearer   string
+		cookie   string
+		expected int
+	}{
+		{"nothing", "", "", "", "", http.StatusUnauthorized},
+		{"wrong password", "admin", "wrong", "", "", http.StatusUnauthorized},
+		{"user without password", "nobody", "", "", "", http.StatusUnauthorized},
+		{"password", "admin", "secret", "", "", http.StatusNotFound},
+		{"empty token", "", "", " ", "", http.StatusUnauthorized},
+		{"wrong token", "", "", "wrong", "", http.StatusUnauthorized},
+		{"token", "", "", "token", "", http.StatusNotFound},
+		{"wrong cookie", "", "", "", "wrong", http.StatusUnauthorized},
+		{"cookie", "", "", "", "token", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ui/nothing", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.pass)
+		}
+		if test.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+test.bearer)
+		}
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: webUITokenCookie, Value: test.cookie})
+		}
+		w := httptest.NewRecorder()
+		b.webUIHandler(w, r)
+
+		if w.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="bot"` {
+			t.Errorf("%s: expected a basic auth challenge, got %q", test.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	// A token in the URL is moved to a cookie.
+	w := httptest.NewRecorder()
+	b.webUIHandler(w, httptest.NewRequest(http.MethodGet, "/ui/log?network=test&token=token", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/ui/log?network=test" {
+		t.Errorf("expected a redirect to the URL without the token, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != webUITokenCookie || cookies[0].Value != "token" || !cookies[0].HttpOnly || cookies[0].Path != "/ui" {
+		t.Errorf("expected the token cookie, got %v", cookies)
+	}
+}
+
+func TestCheckConfigWebUICredentials(t *testing.T) {
+	errs := checkTestConfig(t, map[string]interface{}{
+		"http": map[string]interface{}{"enableHTTP": true, "enableWebUI": true},
+	})
+	if paths := errs.paths(); len(paths) != 1 || paths[0] != "http.webUIUsers" {
+		t.Errorf("expected a problem with http.webUIUsers, got %v", errs)
+	}
+}
+
+func TestWebUIPages(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableLogging": true})
+	defer tb.close()
+
+	tb.configMu.Lock()
+	tb.HTTP.WebUITokens = []string{"token"}
+	tb.configMu.Unlock()
+
+	tb.server.privmsg("alice", "#bot", "hello <world>")
+	tb.server.privmsg("bob", "#bot", "coffee please")
+	tb.server.privmsg("alice", "#bot", "more coffee")
+	tb.waitFor("the messages to be logged", func() bool {
+		return tb.queryString("SELECT COUNT(*) FROM log") == "3"
+	})
+
+	today := tb.today(0)
+	for _, test := range []struct {
+		path     string
+		expected int
+		contains []string
+		excludes []string
+	}{
+		{"/ui", http.StatusOK, []string{`<a href="/ui/log?channel=%23bot&amp;network=test">#bot</a>`, "<td>3</td>"}, nil},
+		{"/ui/log?network=test&channel=%23bot", http.StatusOK, []string{"<h1>#bot " + today + "</h1>", "hello &lt;world&gt;", "more coffee"}, nil},
+		{"/ui/log?network=test&channel=%23bot&date=2001-01-01", http.StatusOK, []string{"no messages"}, []string{"coffee"}},
+		{"/ui/log?network=test&channel=%23bot&date=yesterday", http.StatusBadRequest, nil, nil},
+		{"/ui/log?network=test", http.StatusBadRequest, nil, nil},
+		{"/ui/search?q=coffee", http.StatusOK, []string{"coffee please", "more coffee"}, []string{"hello"}},
+		{"/ui/search?q=coffee&network=test&channel=%23other", http.StatusOK, []string{"no messages"}, nil},
+		{"/ui/search?q=coffee&page=0", http.StatusBadRequest, nil, nil},
+		{"/ui/nick?nick=ALICE", http.StatusOK, []string{"<tr><th>messages</th><td>2</td></tr>", "hello &lt;world&gt;"}, []string{"coffee please"}},
+		{"/ui/nick", http.StatusBadRequest, nil, nil},
+		{"/ui/stats?network=test&channel=%23bot", http.StatusOK, []string{"<td>4</td>\n<td><a href=\"/ui/nick?channel=%23bot&amp;network=test&amp;nick=alice\">alice</a></td>"}, nil},
+		{"/ui/stats?network=test&channel=%23bot&word=hello", http.StatusOK, []string{"<td>1</td>\n<td><a href=\"/ui/nick?channel=%23bot&amp;network=test&amp;nick=alice\">alice</a></td>", "<td>0</td>\n<td><a href=\"/ui/nick?channel=%23bot&amp;network=test&amp;nick=bob\">bob</a></td>"}, nil},
+	} {
+		w := webUIRequest(tb.bot, test.path, "token")
+		if w.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d %s", test.path, test.expected, w.Code, w.Body)
+			continue
+		}
+		for _, s := range test.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: expected %q in\n%s", test.path, s, w.Body)
+			}
+		}
+		for _, s := range test.excludes {
+			if strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: expected no %q in\n%s", test.path, s, w.Body)
+			}
+		}
+	}
+}
+
+func TestWebUIPager(t *testing.T) {
+	tb := newTestBot(t, map[string]interface{}{"enableLogging": true})
+	defer tb.close()
+
+	tb.configMu.Lock()
+	tb.HTTP.WebUITokens = []string{"token"}
+	tb.configMu.Unlock()
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i <= webUIPerPage; i++ {
+		err := tb.store.log.insert(logEntry{
+			ID:        newUUID(),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Network:   "test",
+			Channel:   "#bot",
+			Nick:      "alice",
+			Message:   fmt.Sprintf("message %d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The newest messages are shown first, so the first message is the
+	// only one on the second page.
+	w := webUIRequest(tb.bot, "/ui/nick?nick=alice", "token")
+	if !strings.Contains(w.Body.String(), "message 100<") || strings.Contains(w.Body.String(), "message 0<") || !strings.Contains(w.Body.String(), `<a href="/ui/nick?nick=alice&amp;page=2">older</a>`) {
+		t.Errorf("expected the newest messages and a link to the older ones, got\n%s", w.Body)
+	}
+
+	w = webUIRequest(tb.bot, "/ui/nick?nick=alice&page=2", "token")
+	if !strings.Contains(w.Body.String(), "message 0<") || strings.Contains(w.Body.String(), "message 1<") || strings.Contains(w.Body.String(), ">older</a>") || !strings.Contains(w.Body.String(), `<a href="/ui/nick?nick=alice&amp;page=1">newer</a>`) {
+		t.Errorf("expected the oldest message and a link to the newer ones, got\n%s", w.Body)
+	}
+}
@@ -1,36 +1,43 @@
 package main
 
-import (
-	"github.com/osm/irc"
-)
+// weekConfig holds the configuration of the week module.
+type weekConfig struct {
+	EnableWeek bool   `json:"enableWeek"`
+	WeekCmd    string `json:"weekCmd"`
+}
+
+// init registers the module.
+func init() {
+	registerModule(&module{
+		name:    featureWeek,
+		config:  func(b *bot) interface{} { return &b.config.week },
+		enabled: func(b *bot) bool { return b.config.week.EnableWeek },
+		init:    (*bot).initWeekDefaults,
+		commands: func(b *bot) []*botCommand {
+			return []*botCommand{{
+				name:    b.config.week.WeekCmd,
+				usage:   "[date]",
+				maxArgs: 1,
+				feature: featureWeek,
+				fn:      (*bot).weekCommand,
+			}}
+		},
+	})
+}
 
 // initWeekDefaults sets default values for all settings.
 func (b *bot) initWeekDefaults() {
-	if b.IRC.WeekCmd == "" {
-		b.IRC.WeekCmd = "!week"
+	if b.config.week.WeekCmd == "" {
+		b.config.week.WeekCmd = "week"
 	}
 }
 
-// weekCommandHandler handles the commands issued from the IRC channel.
-func (b *bot) weekCommandHandler(m *irc.Message) {
-	a := b.parseAction(m).(*privmsgAction)
-
-	if !a.validChannel {
-		return
-	}
-
-	if a.cmd != b.IRC.WeekCmd {
-		return
-	}
-
-	if b.shouldIgnore(m) {
-		return
-	}
-
+// weekCommand handles the commands issued from the IRC channel.
+func (b *bot) weekCommand(a *privmsgAction) {
 	date := ""
 	if len(a.args) == 1 {
 		date = a.args[0]
 	}
 
-	b.privmsg(getWeek(date))
+	a.reply(getWeek(date))
 }